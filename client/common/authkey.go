@@ -0,0 +1,106 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"io/ioutil"
+	"crypto/rand"
+	"crypto/sha256"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/xnyss/authtree"
+)
+
+// Hash based (quantum safe) authorization key, see CFG.Auth
+
+var (
+	WotsAuthKey *authtree.Key // nil if disabled
+	WotsPublicKey string
+	wotsAuthMutex sync.Mutex
+	wotsAuthWarned bool
+)
+
+const WotsAuthKeyFile = "authkey_wots"
+
+
+// Loads the key from the data folder or creates a new one
+func LoadWotsAuthKey() {
+	if !CFG.Auth.WotsKey {
+		return
+	}
+	if d, _ := ioutil.ReadFile(GocoinHomeDir + WotsAuthKeyFile); d != nil {
+		WotsAuthKey, _ = authtree.Load(d)
+		if WotsAuthKey == nil {
+			println("WARNING: " + WotsAuthKeyFile + " is corrupt - creating a new key")
+		}
+	}
+	if WotsAuthKey == nil || WotsAuthKey.Remaining() == 0 {
+		if er := newWotsAuthKey(); er != nil {
+			// a key that is not stored would be a new one (unknown to the friends) after each restart
+			println("ERROR: Cannot save " + WotsAuthKeyFile + " - " + er.Error() + " - WOTS auth disabled")
+			WotsAuthKey = nil
+			return
+		}
+	}
+	WotsPublicKey = btc.Encodeb58(WotsAuthKey.PublicKey())
+	fmt.Println("Public WOTS auth key:", WotsPublicKey, fmt.Sprint("(", WotsAuthKey.Remaining(), " sessions left)"))
+}
+
+
+// Makes a new key and stores it (the old one stays if it cannot be stored)
+func newWotsAuthKey() error {
+	seeds := make([]byte, 64)
+	rand.Read(seeds)
+	key, er := authtree.New(seeds[:32], seeds[32:], uint8(CFG.Auth.WotsHeight))
+	if er != nil {
+		println("Auth.WotsHeight:", er.Error(), "- using 10")
+		key, _ = authtree.New(seeds[:32], seeds[32:], 10)
+	}
+	if er = ioutil.WriteFile(GocoinHomeDir + WotsAuthKeyFile, key.Bytes(), 0600); er != nil {
+		return er
+	}
+	WotsAuthKey = key
+	wotsAuthWarned = false
+	return nil
+}
+
+
+// The message signed by the peer that wants to get authorized by the node with the given nonce
+func WotsAuthMsg(nonce, pub []byte) []byte {
+	s := sha256.New()
+	s.Write([]byte("wotscoin auth"))
+	s.Write(nonce)
+	s.Write(pub)
+	return s.Sum(nil)
+}
+
+
+// Returns our public key and the signature for the given nonce.
+// The key state is stored before returning, and the key is rotated when exhausted.
+func SignWotsAuth(nonce []byte) (pub, sig []byte, er error) {
+	wotsAuthMutex.Lock()
+	defer wotsAuthMutex.Unlock()
+
+	if WotsAuthKey.Remaining() == 0 {
+		if er = newWotsAuthKey(); er != nil {
+			return // no key to sign with until a new one can be stored
+		}
+		WotsPublicKey = btc.Encodeb58(WotsAuthKey.PublicKey())
+		fmt.Println("WOTS auth key exhausted - new public key:", WotsPublicKey)
+		fmt.Println("Update friends.txt of the nodes that should authorize this one")
+	}
+
+	pub = WotsAuthKey.PublicKey()
+	if sig, er = WotsAuthKey.Sign(WotsAuthMsg(nonce, pub)); er != nil {
+		return
+	}
+	if er = ioutil.WriteFile(GocoinHomeDir + WotsAuthKeyFile, WotsAuthKey.Bytes(), 0600); er != nil {
+		sig = nil // never publish a signature if the new state cannot be stored
+		return
+	}
+
+	if !wotsAuthWarned && WotsAuthKey.Remaining() < WotsAuthKey.Capacity()/10 {
+		fmt.Println("WOTS auth key has", WotsAuthKey.Remaining(), "sessions left - it will be rotated when exhausted")
+		wotsAuthWarned = true
+	}
+	return
+}
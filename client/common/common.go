@@ -19,17 +19,16 @@ import (
 const (
 	ConfigFile = "gocoin.conf"
 	Version    = uint32(70015)
-	Services   = uint64(0x00000009)
 )
 
 var (
+	Services = uint64(0x00000009) // may get extended by the optional features at startup
+
 	LogBuffer = new(bytes.Buffer)
 	Log *log.Logger = log.New(LogBuffer, "", 0)
 
 	BlockChain   *chain.Chain
-	GenesisBlock *btc.Uint256
-	Magic        [4]byte
-	Testnet      bool
+	Params       *btc.ChainParams // set by InitConfig, does not change until restart
 
 	Last TheLastBlock
 
@@ -170,9 +169,9 @@ func RecalcAverageBlockSize() {
 func GetRawTx(BlockHeight uint32, txid *btc.Uint256) (data []byte, er error) {
 	data, er = BlockChain.GetRawTx(BlockHeight, txid)
 	if er != nil {
-		if Testnet {
+		if Params == &btc.TestNet3Params {
 			data = utils.GetTestnetTxFromWeb(txid)
-		} else {
+		} else if Params == &btc.MainNetParams {
 			data = utils.GetTxFromWeb(txid)
 		}
 		if data != nil {
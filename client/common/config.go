@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/lentus/wotscoin"
+	"github.com/lentus/wotscoin/lib/btc"
 	"github.com/lentus/wotscoin/lib/others/sys"
 	"github.com/lentus/wotscoin/lib/utxo"
 	"io/ioutil"
@@ -29,6 +30,7 @@ var (
 	}
 
 	CFG struct { // Options that can come from either command line or common file
+		Network        string // name of the chain params (empty for mainnet or testnet3)
 		Testnet        bool
 		ConnectOnly    string
 		Datadir        string
@@ -61,6 +63,9 @@ var (
 			MaxDownKBps    uint
 			MaxBlockAtOnce uint32
 			MinSegwitCons  uint32
+			Encrypt        bool // opt-in encrypted transport with other wotscoin nodes
+			Proxy          string // SOCKS5 proxy (host:port) for all the outgoing connections, e.g. Tor
+			ServeUpkh      bool // answer remote XNYSS key confirmation queries (getupkh)
 		}
 		TXPool struct {
 			Enabled        bool // Global on/off swicth
@@ -72,6 +77,7 @@ var (
 			MaxRejectCnt   uint
 			SaveOnDisk     bool
 			Debug          bool
+			Packages       bool // evaluate low fee parents together with their children and relay such packages
 		}
 		TXRoute struct {
 			Enabled    bool // Global on/off swicth
@@ -93,6 +99,16 @@ var (
 			UseMapCnt  int
 			AutoLoad   bool
 		}
+		Auth struct {
+			WotsKey     bool // authorize to friends with hash based (quantum safe) key
+			WotsHeight  uint // the key lasts for 2^WotsHeight sessions, then gets rotated
+			LegacyECDSA bool // also send and accept secp256k1 auth (not quantum safe)
+		}
+		Index struct {
+			TxIndex   bool // txid -> block location (getrawtransaction for any tx)
+			AddrIndex bool // address -> history (block explorer backend)
+			BlockFilters bool // BIP157/158 compact block filters for light wallets
+		}
 		Stat struct {
 			HashrateHrs uint
 			MiningHrs   uint
@@ -104,6 +120,10 @@ var (
 			BlckExpireHours uint // zero for never
 			PingPeriodSec   uint // zero to not ping
 		}
+		Events struct { // real-time notifications (WebSocket at WebUI's /events)
+			TCPPort  uint16 // also serve them as JSON lines at 127.0.0.1:TCPPort (zero for off)
+			QueueLen uint   // events waiting for a subscriber before it gets dropped
+		}
 	}
 
 	mutex_cfg sync.Mutex
@@ -115,13 +135,14 @@ type oneAllowedAddr struct {
 
 var WebUIAllowed []oneAllowedAddr
 
-func InitConfig() {
-	// Fill in default values
+// Fills in the default values of all the config options
+func SetDefaultConfig() {
 	CFG.Net.ListenTCP = true
 	CFG.Net.MaxOutCons = 9
 	CFG.Net.MaxInCons = 10
 	CFG.Net.MaxBlockAtOnce = 3
 	CFG.Net.MinSegwitCons = 4
+	CFG.Net.ServeUpkh = true
 
 	CFG.TextUI_Enabled = true
 
@@ -143,6 +164,7 @@ func InitConfig() {
 	CFG.TXPool.MaxRejectMB = 25
 	CFG.TXPool.MaxRejectCnt = 5000
 	CFG.TXPool.SaveOnDisk = true
+	CFG.TXPool.Packages = true
 
 	CFG.TXRoute.Enabled = true
 	CFG.TXRoute.FeePerByte = 0.0
@@ -153,6 +175,10 @@ func InitConfig() {
 	CFG.Memory.CacheOnDisk = true
 	CFG.Memory.MaxDataFileMB = 1000 // max 1GB per single data file
 
+	CFG.Auth.WotsKey = true
+	CFG.Auth.WotsHeight = 10
+	CFG.Auth.LegacyECDSA = false // only for the friends that cannot do WotsKey
+
 	CFG.Stat.HashrateHrs = 12
 	CFG.Stat.MiningHrs = 24
 	CFG.Stat.FeesBlks = 4 * 6   /*last 4 hours*/
@@ -166,7 +192,13 @@ func InitConfig() {
 	CFG.DropPeers.BlckExpireHours = 24 // hours
 	CFG.DropPeers.PingPeriodSec = 15   // seconds
 
+	CFG.Events.QueueLen = 1000
+
 	CFG.LastTrustedBlock = "0000000000000000001f6897d85c5c580308ba393da6b184d8e7de36fcb58a6e" // block #517986
+}
+
+func InitConfig() {
+	SetDefaultConfig()
 
 	cfgfilecontent, e := ioutil.ReadFile(ConfigFile)
 	if e == nil && len(cfgfilecontent) > 0 {
@@ -184,6 +216,7 @@ func InitConfig() {
 	flag.BoolVar(&FLAG.Rescan, "r", false, "Rebuild UTXO database (fixes 'Unknown input TxID' errors)")
 	flag.BoolVar(&FLAG.VolatileUTXO, "v", false, "Use UTXO database in volatile mode (speeds up rebuilding)")
 	flag.BoolVar(&CFG.Testnet, "t", CFG.Testnet, "Use Testnet3")
+	flag.StringVar(&CFG.Network, "net", CFG.Network, "Use this network ("+strings.Join(btc.ChainParamsNames(), ", ")+")")
 	flag.StringVar(&CFG.ConnectOnly, "c", CFG.ConnectOnly, "Connect only to this host and nowhere else")
 	flag.BoolVar(&CFG.Net.ListenTCP, "l", CFG.Net.ListenTCP, "Listen for incoming TCP connections (on default port)")
 	flag.StringVar(&CFG.Datadir, "d", CFG.Datadir, "Specify Gocoin's database root folder")
@@ -210,6 +243,12 @@ func InitConfig() {
 	}
 	flag.Parse()
 
+	Params = btc.GetChainParams(NetworkName())
+	if Params == nil {
+		println("Unknown network", NetworkName(), "- use one of:", strings.Join(btc.ChainParamsNames(), ", "))
+		os.Exit(1)
+	}
+
 	ApplyBalMinVal()
 
 	if !FLAG.NoWallet {
@@ -224,12 +263,19 @@ func InitConfig() {
 	Reset()
 }
 
-func DataSubdir() string {
+// Returns name of the network selected by the config
+func NetworkName() string {
+	if CFG.Network != "" {
+		return CFG.Network
+	}
 	if CFG.Testnet {
-		return "tstnet"
-	} else {
-		return "btcnet"
+		return btc.TestNet3Params.Name
 	}
+	return btc.MainNetParams.Name
+}
+
+func DataSubdir() string {
+	return Params.DataSubdir
 }
 
 func SaveConfig() bool {
@@ -311,11 +357,7 @@ func RPCPort() (res uint32) {
 		res = CFG.RPC.TCPPort
 		return
 	}
-	if CFG.Testnet {
-		res = 18332
-	} else {
-		res = 8332
-	}
+	res = Params.RPCPort
 	return
 }
 
@@ -327,11 +369,7 @@ func DefaultTcpPort() (res uint16) {
 		res = CFG.Net.TCPPort
 		return
 	}
-	if CFG.Testnet {
-		res = 52082
-	} else {
-		res = 52081
-	}
+	res = Params.DefaultPort
 	return
 }
 
@@ -31,7 +31,7 @@ func TxMiner(cbtx *btc.Tx) (string, int) {
 	}
 
 	for _, txo := range cbtx.TxOut {
-		adr := btc.NewAddrFromPkScript(txo.Pk_script, Testnet)
+		adr := Params.NewAddrFromPkScript(txo.Pk_script)
 		if adr!=nil {
 			return adr.String(), -1
 		}
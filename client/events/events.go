@@ -0,0 +1,180 @@
+package events
+
+import (
+	"sync"
+	"time"
+	"encoding/json"
+	"github.com/lentus/wotscoin/client/common"
+)
+
+/*
+Real-time notifications for the integrations that would otherwise poll the WebUI:
+	* WebSocket at WebUI's /events
+	* Raw TCP (one JSON record per line) at 127.0.0.1:Events.TCPPort, if set
+
+Both of them are a stream of JSON encoded Event records. At any time the
+subscriber can send a JSON encoded Filter, to select the types of the events and
+the addresses / long-term hashes it wants to hear about. A new subscriber gets
+all the events, until it sends its filter.
+*/
+
+const (
+	BLOCK    = "block"   // new tip of the chain
+	REORG    = "reorg"   // the tip has moved to another branch (the branch's block events follow)
+	TX_ADD   = "txadd"   // tx accepted to the mempool
+	TX_DEL   = "txdel"   // tx removed from the mempool (see Reason)
+	UPKH_ADD = "upkhadd" // UPKH record advertised by a mined XNYSS signature
+	UPKH_DEL = "upkhdel" // UPKH record consumed (or the block that has advertised it undone)
+	BALANCE  = "balance" // wallet balance of an address has changed
+)
+
+type Event struct {
+	Type string
+	Time int64 // unix time
+
+	Height uint32 `json:",omitempty"`
+	Hash   string `json:",omitempty"` // block hash, txid or UPKH public key hash
+	Reason string `json:",omitempty"` // why the tx has left the mempool (MINED, CONFLICT, REPLACED, LOW_FEE...)
+
+	Fee   uint64 `json:",omitempty"`
+	VSize int    `json:",omitempty"`
+	Local bool   `json:",omitempty"`
+
+	OldHash    string `json:",omitempty"` // tip before the reorg
+	OldHeight  uint32 `json:",omitempty"`
+	ForkHeight uint32 `json:",omitempty"` // the last common block
+
+	Outpoint string `json:",omitempty"` // txid-vout of the output that has changed the balance
+	Value    int64  `json:",omitempty"` // negative when spent
+
+	Addrs []string `json:",omitempty"`
+	LTHs  []string `json:",omitempty"` // long-term hashes (hex)
+}
+
+type Filter struct {
+	Types []string // empty for all of them
+	Addrs []string // empty (along with LTHs) for any
+	LTHs  []string
+}
+
+type Subscriber struct {
+	C chan []byte // JSON encoded events; it gets closed if the subscriber was too slow
+	Name string   // remote address
+
+	types map[string]bool
+	addrs map[string]bool
+	lths  map[string]bool
+}
+
+var (
+	mutex       sync.Mutex
+	subscribers = make(map[*Subscriber]bool)
+)
+
+// Returns a new subscriber, receiving all the events
+func Subscribe(name string) (s *Subscriber) {
+	s = &Subscriber{C: make(chan []byte, common.CFG.Events.QueueLen), Name: name}
+	mutex.Lock()
+	subscribers[s] = true
+	mutex.Unlock()
+	common.CountSafe("EventsSubscribe")
+	return
+}
+
+func (s *Subscriber) Close() {
+	mutex.Lock()
+	if subscribers[s] {
+		delete(subscribers, s)
+		close(s.C)
+	}
+	mutex.Unlock()
+}
+
+func (s *Subscriber) SetFilter(f *Filter) {
+	mutex.Lock()
+	s.types = make(map[string]bool, len(f.Types))
+	for _, t := range f.Types {
+		s.types[t] = true
+	}
+	s.addrs = make(map[string]bool, len(f.Addrs))
+	for _, a := range f.Addrs {
+		s.addrs[a] = true
+	}
+	s.lths = make(map[string]bool, len(f.LTHs))
+	for _, h := range f.LTHs {
+		s.lths[h] = true
+	}
+	mutex.Unlock()
+}
+
+// Make sure to call it with the mutex locked
+func (s *Subscriber) match(ev *Event) bool {
+	if len(s.types) > 0 && !s.types[ev.Type] {
+		return false
+	}
+	if len(s.addrs) == 0 && len(s.lths) == 0 || ev.Type == BLOCK || ev.Type == REORG {
+		return true
+	}
+	for _, a := range ev.Addrs {
+		if s.addrs[a] {
+			return true
+		}
+	}
+	for _, h := range ev.LTHs {
+		if s.lths[h] {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if any of the subscribers wants the given type of events.
+// The producers call it before building the event.
+func Wanted(typ string) (res bool) {
+	mutex.Lock()
+	for s := range subscribers {
+		if len(s.types) == 0 || s.types[typ] {
+			res = true
+			break
+		}
+	}
+	mutex.Unlock()
+	return
+}
+
+// Sends the event to all the subscribers that want it.
+// The ones that do not read their events fast enough get dropped.
+func Publish(ev *Event) {
+	var b []byte
+	if ev.Time == 0 {
+		ev.Time = time.Now().Unix()
+	}
+	mutex.Lock()
+	for s := range subscribers {
+		if !s.match(ev) {
+			continue
+		}
+		if b == nil {
+			b, _ = json.Marshal(ev)
+		}
+		select {
+		case s.C <- b:
+		default:
+			delete(subscribers, s)
+			close(s.C)
+			common.CountSafe("EventsSlowSubscriber")
+		}
+	}
+	mutex.Unlock()
+	if b != nil {
+		common.CountSafe("Events-" + ev.Type)
+	}
+}
+
+// Returns the number of the current subscribers
+func Count() (cnt int) {
+	mutex.Lock()
+	cnt = len(subscribers)
+	mutex.Unlock()
+	return
+}
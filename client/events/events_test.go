@@ -0,0 +1,233 @@
+package events
+
+import (
+	"io"
+	"net"
+	"time"
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"net/http"
+	"crypto/sha1"
+	"encoding/json"
+	"encoding/binary"
+	"encoding/base64"
+	"net/http/httptest"
+	"github.com/lentus/wotscoin/client/common"
+)
+
+func recv(t *testing.T, s *Subscriber) *Event {
+	select {
+	case b := <-s.C:
+		ev := new(Event)
+		if e := json.Unmarshal(b, ev); e != nil {
+			t.Fatal(e.Error())
+		}
+		return ev
+	default:
+		return nil
+	}
+}
+
+func TestFilter(t *testing.T) {
+	common.CFG.Events.QueueLen = 10
+	all := Subscribe("all")
+	defer all.Close()
+	flt := Subscribe("flt")
+	defer flt.Close()
+	flt.SetFilter(&Filter{Types: []string{BLOCK, TX_ADD, UPKH_ADD}, Addrs: []string{"addr1"}, LTHs: []string{"lth1"}})
+
+	if !Wanted(BALANCE) {
+		t.Error("balance events not wanted")
+	}
+	Publish(&Event{Type: BLOCK, Height: 10})
+	Publish(&Event{Type: TX_ADD, Hash: "tx1", Addrs: []string{"addr2", "addr1"}})
+	Publish(&Event{Type: TX_ADD, Hash: "tx2", Addrs: []string{"addr2"}})
+	Publish(&Event{Type: TX_DEL, Hash: "tx1", Addrs: []string{"addr1"}})
+	Publish(&Event{Type: UPKH_ADD, Hash: "pkh", LTHs: []string{"lth1"}})
+
+	var got []string
+	for ev := recv(t, flt); ev != nil; ev = recv(t, flt) {
+		got = append(got, ev.Type+":"+ev.Hash)
+	}
+	if strings.Join(got, ",") != "block:,txadd:tx1,upkhadd:pkh" {
+		t.Error("Filtered events:", got)
+	}
+	for i := 0; i < 5; i++ {
+		if recv(t, all) == nil {
+			t.Error("Missing event", i)
+		}
+	}
+
+	all.Close()
+	if Wanted(BALANCE) || !Wanted(TX_ADD) {
+		t.Error("Wanted() does not follow the filters")
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	common.CFG.Events.QueueLen = 2
+	s := Subscribe("slow")
+	for i := 0; i < 3; i++ {
+		Publish(&Event{Type: BLOCK, Height: uint32(i)})
+	}
+	for range s.C {
+	}
+	if Count() != 0 {
+		t.Error("Slow subscriber not dropped")
+	}
+	s.Close() // must not panic
+}
+
+func wsFrame(op byte, pl []byte) []byte {
+	mask := []byte{1, 2, 3, 4}
+	b := []byte{0x80 | op, 0x80 | byte(len(pl))}
+	b = append(b, mask...)
+	for i := range pl {
+		b = append(b, pl[i]^mask[i&3])
+	}
+	return b
+}
+
+func wsRead(t *testing.T, rd *bufio.Reader) (op byte, pl []byte) {
+	var hdr [2]byte
+	if _, e := io.ReadFull(rd, hdr[:]); e != nil {
+		t.Fatal(e.Error())
+	}
+	if hdr[1] >= 126 {
+		t.Fatal("Unexpected frame length", hdr[1])
+	}
+	pl = make([]byte, hdr[1])
+	if _, e := io.ReadFull(rd, pl); e != nil {
+		t.Fatal(e.Error())
+	}
+	return hdr[0] & 0x0f, pl
+}
+
+func TestWebSocket(t *testing.T) {
+	common.CFG.Events.QueueLen = 10
+	srv := httptest.NewServer(http.HandlerFunc(ServeWebSocket))
+	defer srv.Close()
+
+	c, e := net.Dial("tcp", srv.Listener.Addr().String())
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	c.Write([]byte("GET /events HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	rd := bufio.NewReader(c)
+	resp, e := http.ReadResponse(rd, nil)
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	if resp.StatusCode != 101 || resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Fatal("Bad handshake", resp.Status, resp.Header)
+	}
+
+	c.Write(wsFrame(wsText, []byte(`{"Types":["reorg"]}`)))
+	for i := 0; ; i++ {
+		if i == 100 {
+			t.Fatal("Filter not applied")
+		}
+		if !Wanted(BLOCK) && Wanted(REORG) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	Publish(&Event{Type: BLOCK, Height: 1})
+	Publish(&Event{Type: REORG, Height: 2, OldHeight: 3, ForkHeight: 1})
+	op, pl := wsRead(t, rd)
+	var ev Event
+	if op != wsText || json.Unmarshal(pl, &ev) != nil || ev.Type != REORG || ev.OldHeight != 3 {
+		t.Error("Bad event", op, string(pl))
+	}
+
+	c.Write(wsFrame(wsPing, []byte("hi")))
+	if op, pl = wsRead(t, rd); op != wsPong || !bytes.Equal(pl, []byte("hi")) {
+		t.Error("Bad pong", op, string(pl))
+	}
+
+	c.Write(wsFrame(wsClose, nil))
+	if op, _ = wsRead(t, rd); op != wsClose {
+		t.Error("Bad close", op)
+	}
+	for i := 0; Count() != 0; i++ {
+		if i == 100 {
+			t.Fatal("Subscriber not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebSocketLongFrame(t *testing.T) {
+	// the second fragment says its length is 2^64-3, which wraps with the 5 bytes of the first one
+	d := wsFrame(wsText, []byte("hello"))
+	d[0] &= 0x7f
+	hdr := []byte{wsContinuation, 0x80 | 127, 0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4}
+	binary.BigEndian.PutUint64(hdr[2:10], 1<<64-3)
+	d = append(d, hdr...)
+	c := &wsConn{rd: bufio.NewReader(bytes.NewReader(d))}
+	if _, e := c.ReadMsg(); e == nil || !strings.Contains(e.Error(), "too long") {
+		t.Error("Long frame accepted", e)
+	}
+}
+
+func TestTCPWriteTimeout(t *testing.T) {
+	common.CFG.Events.QueueLen = 10
+	defer func(d time.Duration) { writeTimeout = d }(writeTimeout)
+	writeTimeout = 50 * time.Millisecond
+
+	a, b := net.Pipe() // writes block until the other end reads, which it never does
+	defer b.Close()
+	done := make(chan bool)
+	go func() {
+		serve(&lineConn{Conn: a, rd: bufio.NewScanner(a)}, "deaf")
+		done <- true
+	}()
+	for i := 0; Count() == 0; i++ {
+		if i == 100 {
+			t.Fatal("Subscriber not added")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	Publish(&Event{Type: BLOCK, Height: 1})
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscriber that does not read not dropped")
+	}
+	if Count() != 0 {
+		t.Error("Subscriber still counted")
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(ServeWebSocket))
+	defer srv.Close()
+
+	for origin, status := range map[string]int{"http://evil.example": 403, srv.URL: 101, "": 101} {
+		req, _ := http.NewRequest("GET", srv.URL+"/events", nil)
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatal(e.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Error("Origin", origin, "got", resp.Status)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package events
+
+import (
+	"fmt"
+	"encoding/hex"
+	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/chain"
+	"github.com/lentus/wotscoin/lib/utxo"
+)
+
+// The producers of the events. All of them return at once if nobody is listening.
+
+var lastTip *chain.BlockTreeNode
+
+func addrOf(pkscr []byte) string {
+	if ad := common.Params.NewAddrFromPkScript(pkscr); ad != nil {
+		return ad.String()
+	}
+	return ""
+}
+
+func addAddr(list []string, pkscr []byte) []string {
+	a := addrOf(pkscr)
+	if a == "" {
+		return list
+	}
+	for _, v := range list {
+		if v == a {
+			return list
+		}
+	}
+	return append(list, a)
+}
+
+// Returns the block where the branches of a and b meet
+func forkPoint(a, b *chain.BlockTreeNode) *chain.BlockTreeNode {
+	for a.Height > b.Height {
+		a = a.Parent
+	}
+	for b.Height > a.Height {
+		b = b.Parent
+	}
+	for a != b {
+		a, b = a.Parent, b.Parent
+	}
+	return a
+}
+
+// Call it for each block connected to the active chain (from the chain's thread)
+func NewTip(node *chain.BlockTreeNode) {
+	prev := lastTip
+	lastTip = node
+	if prev != nil && node.Parent != prev && node != prev && Wanted(REORG) {
+		fork := forkPoint(prev, node)
+		Publish(&Event{Type: REORG, Height: node.Height, Hash: node.BlockHash.String(),
+			OldHash: prev.BlockHash.String(), OldHeight: prev.Height, ForkHeight: fork.Height})
+	}
+	if Wanted(BLOCK) {
+		Publish(&Event{Type: BLOCK, Height: node.Height, Hash: node.BlockHash.String()})
+	}
+}
+
+// Call it when the tx gets accepted to the mempool; pos are the outputs it spends
+func TxAccepted(tx *btc.Tx, pos []*btc.TxOut, fee uint64, local bool) {
+	if !Wanted(TX_ADD) {
+		return
+	}
+	ev := &Event{Type: TX_ADD, Hash: tx.Hash.String(), Fee: fee, VSize: tx.VSize(), Local: local}
+	for _, po := range pos {
+		if po != nil {
+			ev.Addrs = addAddr(ev.Addrs, po.Pk_script)
+		}
+	}
+	for _, out := range tx.TxOut {
+		ev.Addrs = addAddr(ev.Addrs, out.Pk_script)
+	}
+	Publish(ev)
+}
+
+// Call it when the tx leaves the mempool
+func TxRemoved(tx *btc.Tx, reason string) {
+	if !Wanted(TX_DEL) {
+		return
+	}
+	ev := &Event{Type: TX_DEL, Hash: tx.Hash.String(), Reason: reason}
+	for _, out := range tx.TxOut {
+		ev.Addrs = addAddr(ev.Addrs, out.Pk_script)
+	}
+	Publish(ev)
+}
+
+// To be used as utxo.CallbackFunctions.NotifyUpkh
+func UpkhNotify(rec *utxo.UpkhRec, added bool) {
+	typ := UPKH_DEL
+	if added {
+		typ = UPKH_ADD
+	}
+	if !Wanted(typ) {
+		return
+	}
+	Publish(&Event{Type: typ, Height: rec.Blockheight, Hash: hex.EncodeToString(rec.PubKeyHash[:]),
+		LTHs: []string{hex.EncodeToString(rec.LongTermHash[:])}})
+}
+
+// Call it from the wallet's utxo callbacks (outs is nil for the new outputs)
+func BalanceChanged(rec *utxo.UtxoRec, outs []bool) {
+	if !Wanted(BALANCE) {
+		return
+	}
+	txid := btc.NewUint256(rec.TxID[:]).String()
+	for vout, out := range rec.Outs {
+		if out == nil || outs != nil && !outs[vout] {
+			continue
+		}
+		a := addrOf(out.PKScr)
+		if a == "" {
+			continue
+		}
+		ev := &Event{Type: BALANCE, Height: rec.InBlock, Outpoint: fmt.Sprint(txid, "-", vout),
+			Value: int64(out.Value), Addrs: []string{a}}
+		if outs != nil {
+			ev.Value = -ev.Value
+		}
+		Publish(ev)
+	}
+}
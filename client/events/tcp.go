@@ -0,0 +1,53 @@
+package events
+
+import (
+	"io"
+	"fmt"
+	"net"
+	"time"
+	"bufio"
+)
+
+// The raw TCP version: one JSON record per line, both ways
+type lineConn struct {
+	net.Conn
+	rd *bufio.Scanner
+}
+
+func (c *lineConn) ReadMsg() ([]byte, error) {
+	if !c.rd.Scan() {
+		if c.rd.Err() != nil {
+			return nil, c.rd.Err()
+		}
+		return nil, io.EOF
+	}
+	return c.rd.Bytes(), nil
+}
+
+func (c *lineConn) WriteMsg(pl []byte) (e error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, e = c.Conn.Write(pl); e == nil {
+		_, e = c.Conn.Write([]byte{'\n'})
+	}
+	return
+}
+
+// Serves the events at 127.0.0.1:port (run it in its own goroutine)
+func StartTCPServer(port uint16) {
+	lis, e := net.Listen("tcp4", fmt.Sprint("127.0.0.1:", port))
+	if e != nil {
+		fmt.Println("Events server:", e.Error())
+		return
+	}
+	fmt.Println("Starting events server at port", port)
+	for {
+		c, e := lis.Accept()
+		if e != nil {
+			fmt.Println("Events server:", e.Error())
+			return
+		}
+		sc := bufio.NewScanner(c)
+		sc.Buffer(make([]byte, 4096), MaxFilterSize)
+		go serve(&lineConn{Conn: c, rd: sc}, c.RemoteAddr().String())
+	}
+}
@@ -0,0 +1,226 @@
+package events
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+	"bufio"
+	"errors"
+	"strings"
+	"net/url"
+	"net/http"
+	"crypto/sha1"
+	"encoding/json"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/lentus/wotscoin/client/common"
+)
+
+/*
+Just enough of RFC 6455 to push the events as text messages and to read the
+filters sent by the client. Fragmented messages, ping and close are handled;
+extensions and subprotocols are not supported.
+*/
+
+// a subscriber that does not read its events (over WebSocket or TCP) gets disconnected
+var writeTimeout = 10 * time.Second
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	MaxFilterSize = 64 << 10 // the longest message that we accept from a subscriber
+
+	wsContinuation = 0x0
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+// a connection of the subscriber, passing one JSON record at a time
+type msgConn interface {
+	ReadMsg() ([]byte, error)
+	WriteMsg([]byte) error
+	Close() error
+}
+
+// Sends the events to the subscriber and applies the filters it sends to us
+func serve(c msgConn, name string) {
+	s := Subscribe(name)
+	go func() {
+		for {
+			msg, er := c.ReadMsg()
+			if er != nil {
+				break
+			}
+			var f Filter
+			if json.Unmarshal(msg, &f) != nil {
+				common.CountSafe("EventsBadFilter")
+				continue
+			}
+			s.SetFilter(&f)
+		}
+		s.Close()
+	}()
+	for b := range s.C {
+		if c.WriteMsg(b) != nil {
+			break
+		}
+	}
+	s.Close()
+	c.Close()
+}
+
+
+type wsConn struct {
+	net.Conn
+	rd *bufio.Reader
+	wr sync.Mutex // control frames are sent from the reading goroutine
+}
+
+func (c *wsConn) writeFrame(op byte, pl []byte) (e error) {
+	var hdr [10]byte
+	hdr[0] = 0x80 | op // FIN
+	n := 2
+	if len(pl) < 126 {
+		hdr[1] = byte(len(pl))
+	} else if len(pl) <= 0xffff {
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:4], uint16(len(pl)))
+		n = 4
+	} else {
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:10], uint64(len(pl)))
+		n = 10
+	}
+	c.wr.Lock()
+	c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, e = c.Conn.Write(hdr[:n]); e == nil {
+		_, e = c.Conn.Write(pl)
+	}
+	c.wr.Unlock()
+	return
+}
+
+func (c *wsConn) WriteMsg(pl []byte) error {
+	return c.writeFrame(wsText, pl)
+}
+
+// Returns the next data message, answering the control frames on the way
+func (c *wsConn) ReadMsg() (msg []byte, e error) {
+	var hdr [14]byte
+	for {
+		if _, e = io.ReadFull(c.rd, hdr[:2]); e != nil {
+			return
+		}
+		fin, op := (hdr[0]&0x80) != 0, hdr[0]&0x0f
+		if (hdr[1] & 0x80) == 0 {
+			e = errors.New("websocket: unmasked frame from the client")
+			return
+		}
+		le := uint64(hdr[1] & 0x7f)
+		if le == 126 {
+			if _, e = io.ReadFull(c.rd, hdr[2:4]); e != nil {
+				return
+			}
+			le = uint64(binary.BigEndian.Uint16(hdr[2:4]))
+		} else if le == 127 {
+			if _, e = io.ReadFull(c.rd, hdr[2:10]); e != nil {
+				return
+			}
+			le = binary.BigEndian.Uint64(hdr[2:10])
+		}
+		if le > MaxFilterSize || le+uint64(len(msg)) > MaxFilterSize { // le alone first, so it cannot wrap
+			e = errors.New("websocket: message too long")
+			return
+		}
+		var mask [4]byte
+		if _, e = io.ReadFull(c.rd, mask[:]); e != nil {
+			return
+		}
+		pl := make([]byte, le)
+		if _, e = io.ReadFull(c.rd, pl); e != nil {
+			return
+		}
+		for i := range pl {
+			pl[i] ^= mask[i&3]
+		}
+
+		switch op {
+		case wsClose:
+			c.writeFrame(wsClose, nil)
+			e = io.EOF
+			return
+		case wsPing:
+			c.writeFrame(wsPong, pl)
+		case wsPong:
+		case wsText, wsContinuation:
+			msg = append(msg, pl...)
+			if fin {
+				return
+			}
+		default:
+			e = errors.New("websocket: unsupported opcode")
+			return
+		}
+	}
+}
+
+
+func headerHas(r *http.Request, key, val string) bool {
+	for _, v := range strings.Split(r.Header.Get(key), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), val) {
+			return true
+		}
+	}
+	return false
+}
+
+// Browsers send the origin of the page that opens the connection, and do not
+// stop other sites from opening one to us. Only the WebUI's own pages may do it.
+// The clients that are not browsers do not send any origin.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, e := url.Parse(origin)
+	return e == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Serves the events over a WebSocket connection (WebUI's /events)
+func ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerHas(r, "Upgrade", "websocket") || !headerHas(r, "Connection", "upgrade") || key == "" {
+		http.Error(w, "WebSocket connection expected", http.StatusBadRequest)
+		return
+	}
+	if !sameOrigin(r) {
+		common.CountSafe("EventsBadOrigin")
+		http.Error(w, "Cross origin WebSocket connection", http.StatusForbidden)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusBadRequest)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Cannot hijack the connection", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, er := hj.Hijack()
+	if er != nil {
+		return
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if rw.Flush() != nil {
+		conn.Close()
+		return
+	}
+	serve(&wsConn{Conn: conn, rd: rw.Reader}, conn.RemoteAddr().String())
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/lentus/wotscoin/lib/utxo"
 	"github.com/lentus/wotscoin/lib/chain"
 	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/client/events"
+	"github.com/lentus/wotscoin/client/network"
 	"github.com/lentus/wotscoin/lib/others/sys"
 )
 
@@ -17,18 +19,13 @@ import (
 func host_init() {
 	common.GocoinHomeDir = common.CFG.Datadir+string(os.PathSeparator)
 
-	common.Testnet = common.CFG.Testnet // So chaging this value would will only affect the behaviour after restart
-	if common.CFG.Testnet { // testnet3
-		common.GenesisBlock = btc.NewUint256FromString("000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943")
-		common.Magic = [4]byte{0x0B,0x11,0x09,0x07}
-		common.GocoinHomeDir += common.DataSubdir() + string(os.PathSeparator)
+	common.GocoinHomeDir += common.DataSubdir() + string(os.PathSeparator)
+	if common.Params.Testnet {
 		common.MaxPeersNeeded = 2000
 	} else {
-		common.GenesisBlock = btc.NewUint256FromString("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f")
-		common.Magic = [4]byte{0xF9,0xBE,0xB4,0xD9}
-		common.GocoinHomeDir += common.DataSubdir() + string(os.PathSeparator)
 		common.MaxPeersNeeded = 5000
 	}
+	fmt.Println("Network:", common.Params.Name)
 
 	// Lock the folder
 	os.MkdirAll(common.GocoinHomeDir, 0770)
@@ -42,6 +39,7 @@ func host_init() {
 	}
 	common.PublicKey = btc.Encodeb58(btc.PublicFromPrivate(common.SecretKey, true))
 	fmt.Println("Public auth key:", common.PublicKey)
+	common.LoadWotsAuthKey()
 
 	__exit := make(chan bool)
 	__done := make(chan bool)
@@ -79,10 +77,14 @@ func host_init() {
 	ext := &chain.NewChanOpts{
 		UTXOVolatileMode : common.FLAG.VolatileUTXO,
 		UndoBlocks : common.FLAG.UndoBlocks,
-		BlockMinedCB : blockMined}
+		BlockMinedCB : blockMined,
+		UTXOCallbacks : utxo.CallbackFunctions{NotifyUpkh : events.UpkhNotify},
+		TxIndex : common.CFG.Index.TxIndex,
+		AddrIndex : common.CFG.Index.AddrIndex,
+		BlockFilters : common.CFG.Index.BlockFilters}
 
 	sta := time.Now()
-	common.BlockChain = chain.NewChainExt(common.GocoinHomeDir, common.GenesisBlock, common.FLAG.Rescan, ext,
+	common.BlockChain = chain.NewChainExt(common.GocoinHomeDir, common.Params, common.FLAG.Rescan, ext,
 		&chain.BlockDBOpts{
 			MaxCachedBlocks : int(common.CFG.Memory.MaxCachedBlks),
 			MaxDataFileSize : uint64(common.CFG.Memory.MaxDataFileMB) << 20,
@@ -94,6 +96,8 @@ func host_init() {
 		os.Exit(1)
 	}
 
+	network.BlockAcceptedCB = reset_save_timer
+
 	common.Last.Block = common.BlockChain.LastBlock()
 	common.Last.Time = time.Unix(int64(common.Last.Block.Timestamp()), 0)
 	if common.Last.Time.After(time.Now()) {
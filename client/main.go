@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/lentus/wotscoin"
 	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/client/events"
 	"github.com/lentus/wotscoin/client/network"
 	"github.com/lentus/wotscoin/client/rpcapi"
 	"github.com/lentus/wotscoin/client/usif"
@@ -11,11 +12,9 @@ import (
 	"github.com/lentus/wotscoin/client/usif/webui"
 	"github.com/lentus/wotscoin/client/wallet"
 	"github.com/lentus/wotscoin/lib/btc"
-	"github.com/lentus/wotscoin/lib/chain"
 	"github.com/lentus/wotscoin/lib/others/peersdb"
 	"github.com/lentus/wotscoin/lib/others/qdb"
 	"github.com/lentus/wotscoin/lib/others/sys"
-	"io/ioutil"
 	"os"
 	"os/signal"
 	"runtime"
@@ -42,220 +41,13 @@ func reset_save_timer() {
 }
 
 func blockMined(bl *btc.Block) {
+	events.NewTip(common.BlockChain.LastBlock())
 	network.BlockMined(bl)
 	if int(bl.LastKnownHeight)-int(bl.Height) < 144 { // do not run it when syncing chain
 		usif.ProcessBlockFees(bl.Height, bl)
 	}
 }
 
-func LocalAcceptBlock(newbl *network.BlockRcvd) (e error) {
-	bl := newbl.Block
-	if common.FLAG.TrustAll || newbl.BlockTreeNode.Trusted {
-		bl.Trusted = true
-	}
-
-	common.BlockChain.Unspent.AbortWriting() // abort saving of UTXO.db
-	common.BlockChain.Blocks.BlockAdd(newbl.BlockTreeNode.Height, bl)
-	newbl.TmQueue = time.Now()
-
-	if newbl.DoInvs {
-		common.Busy()
-		network.NetRouteInv(network.MSG_BLOCK, bl.Hash, newbl.Conn)
-	}
-
-	network.MutexRcv.Lock()
-	bl.LastKnownHeight = network.LastCommitedHeader.Height
-	network.MutexRcv.Unlock()
-	e = common.BlockChain.CommitBlock(bl, newbl.BlockTreeNode)
-
-	if e == nil {
-		// new block accepted
-		newbl.TmAccepted = time.Now()
-
-		newbl.NonWitnessSize = bl.NoWitnessSize
-
-		common.RecalcAverageBlockSize()
-
-		common.Last.Mutex.Lock()
-		common.Last.Time = time.Now()
-		common.Last.Block = common.BlockChain.LastBlock()
-		common.Last.Mutex.Unlock()
-
-		reset_save_timer()
-	} else {
-		//fmt.Println("Warning: AcceptBlock failed. If the block was valid, you may need to rebuild the unspent DB (-r)")
-		new_end := common.BlockChain.LastBlock()
-		common.Last.Mutex.Lock()
-		common.Last.Block = new_end
-		common.Last.Mutex.Unlock()
-		// update network.LastCommitedHeader
-		network.MutexRcv.Lock()
-		if network.LastCommitedHeader != new_end {
-			network.LastCommitedHeader = new_end
-			//println("LastCommitedHeader moved to", network.LastCommitedHeader.Height)
-		}
-		network.DiscardedBlocks[newbl.Hash.BIdx()] = true
-		network.MutexRcv.Unlock()
-	}
-	return
-}
-
-func retry_cached_blocks() bool {
-	var idx int
-	common.CountSafe("RedoCachedBlks")
-	for idx < len(network.CachedBlocks) {
-		newbl := network.CachedBlocks[idx]
-		if CheckParentDiscarded(newbl.BlockTreeNode) {
-			common.CountSafe("DiscardCachedBlock")
-			if newbl.Block == nil {
-				os.Remove(common.TempBlocksDir() + newbl.BlockTreeNode.BlockHash.String())
-			}
-			network.CachedBlocks = append(network.CachedBlocks[:idx], network.CachedBlocks[idx+1:]...)
-			network.CachedBlocksLen.Store(len(network.CachedBlocks))
-			return len(network.CachedBlocks) > 0
-		}
-		if common.BlockChain.HasAllParents(newbl.BlockTreeNode) {
-			common.Busy()
-
-			if newbl.Block == nil {
-				tmpfn := common.TempBlocksDir() + newbl.BlockTreeNode.BlockHash.String()
-				dat, e := ioutil.ReadFile(tmpfn)
-				os.Remove(tmpfn)
-				if e != nil {
-					panic(e.Error())
-				}
-				if newbl.Block, e = btc.NewBlock(dat); e != nil {
-					panic(e.Error())
-				}
-				if e = newbl.Block.BuildTxList(); e != nil {
-					panic(e.Error())
-				}
-				newbl.Block.BlockExtraInfo = *newbl.BlockExtraInfo
-			}
-
-			e := LocalAcceptBlock(newbl)
-			if e != nil {
-				fmt.Println("AcceptBlock2", newbl.BlockTreeNode.BlockHash.String(), "-", e.Error())
-				newbl.Conn.Misbehave("LocalAcceptBl2", 250)
-			}
-			if usif.Exit_now.Get() {
-				return false
-			}
-			// remove it from cache
-			network.CachedBlocks = append(network.CachedBlocks[:idx], network.CachedBlocks[idx+1:]...)
-			network.CachedBlocksLen.Store(len(network.CachedBlocks))
-			return len(network.CachedBlocks) > 0
-		} else {
-			idx++
-		}
-	}
-	return false
-}
-
-// Return true iof the block's parent is on the DiscardedBlocks list
-// Add it to DiscardedBlocks, if returning true
-func CheckParentDiscarded(n *chain.BlockTreeNode) bool {
-	network.MutexRcv.Lock()
-	defer network.MutexRcv.Unlock()
-	if network.DiscardedBlocks[n.Parent.BlockHash.BIdx()] {
-		network.DiscardedBlocks[n.BlockHash.BIdx()] = true
-		return true
-	}
-	return false
-}
-
-// Called from the blockchain thread
-func HandleNetBlock(newbl *network.BlockRcvd) {
-	defer func() {
-		common.CountSafe("MainNetBlock")
-		if common.GetUint32(&common.WalletOnIn) > 0 {
-			common.SetUint32(&common.WalletOnIn, 5) // snooze the timer to 5 seconds from now
-		}
-	}()
-
-	if CheckParentDiscarded(newbl.BlockTreeNode) {
-		common.CountSafe("DiscardFreshBlockA")
-		if newbl.Block == nil {
-			os.Remove(common.TempBlocksDir() + newbl.BlockTreeNode.BlockHash.String())
-		}
-		retryCachedBlocks = len(network.CachedBlocks) > 0
-		return
-	}
-
-	if !common.BlockChain.HasAllParents(newbl.BlockTreeNode) {
-		// it's not linking - keep it for later
-		network.CachedBlocks = append(network.CachedBlocks, newbl)
-		network.CachedBlocksLen.Store(len(network.CachedBlocks))
-		common.CountSafe("BlockPostone")
-		return
-	}
-
-	if newbl.Block == nil {
-		tmpfn := common.TempBlocksDir() + newbl.BlockTreeNode.BlockHash.String()
-		dat, e := ioutil.ReadFile(tmpfn)
-		os.Remove(tmpfn)
-		if e != nil {
-			panic(e.Error())
-		}
-		if newbl.Block, e = btc.NewBlock(dat); e != nil {
-			panic(e.Error())
-		}
-		if e = newbl.Block.BuildTxList(); e != nil {
-			panic(e.Error())
-		}
-		newbl.Block.BlockExtraInfo = *newbl.BlockExtraInfo
-	}
-
-	common.Busy()
-	if e := LocalAcceptBlock(newbl); e != nil {
-		common.CountSafe("DiscardFreshBlockB")
-		fmt.Println("AcceptBlock1", newbl.Block.Hash.String(), "-", e.Error())
-		newbl.Conn.Misbehave("LocalAcceptBl1", 250)
-	} else {
-		//println("block", newbl.Block.Height, "accepted")
-		retryCachedBlocks = retry_cached_blocks()
-	}
-}
-
-func HandleRpcBlock(msg *rpcapi.BlockSubmited) {
-	common.CountSafe("RPCNewBlock")
-
-	network.MutexRcv.Lock()
-	rb := network.ReceivedBlocks[msg.Block.Hash.BIdx()]
-	network.MutexRcv.Unlock()
-	if rb == nil {
-		panic("Block " + msg.Block.Hash.String() + " not in ReceivedBlocks map")
-	}
-
-	common.BlockChain.Unspent.AbortWriting()
-	rb.TmQueue = time.Now()
-
-	e, _, _ := common.BlockChain.CheckBlock(msg.Block)
-	if e == nil {
-		e = common.BlockChain.AcceptBlock(msg.Block)
-		rb.TmAccepted = time.Now()
-	}
-	if e != nil {
-		common.CountSafe("RPCBlockError")
-		msg.Error = e.Error()
-		msg.Done.Done()
-		return
-	}
-
-	network.NetRouteInv(network.MSG_BLOCK, msg.Block.Hash, nil)
-	common.RecalcAverageBlockSize()
-
-	common.CountSafe("RPCBlockOK")
-	println("New mined block", msg.Block.Height, "accepted OK in", rb.TmAccepted.Sub(rb.TmQueue).String())
-
-	common.Last.Mutex.Lock()
-	common.Last.Time = time.Now()
-	common.Last.Block = common.BlockChain.LastBlock()
-	common.Last.Mutex.Unlock()
-
-	msg.Done.Done()
-}
-
 func main() {
 	var ptr *byte
 	if unsafe.Sizeof(ptr) < 8 {
@@ -321,8 +113,17 @@ func main() {
 
 		reset_save_timer() // we wil do one save try after loading, in case if ther was a rescan
 
-		peersdb.Testnet = common.Testnet
+		peersdb.Params = common.Params
 		peersdb.ConnectOnly = common.CFG.ConnectOnly
+		if common.BlockChain.BlockFiltersEnabled() {
+			common.Services |= network.SERVICE_COMPACT_FILTERS
+		}
+		if common.CFG.Net.Encrypt {
+			common.Services |= network.SERVICE_ENCRYPTED
+		}
+		if common.CFG.Net.ServeUpkh {
+			common.Services |= network.SERVICE_UPKH
+		}
 		peersdb.Services = common.Services
 		peersdb.InitPeers(common.GocoinHomeDir)
 		if common.FLAG.UnbanAllPeers {
@@ -367,6 +168,10 @@ func main() {
 			go rpcapi.StartServer(common.RPCPort())
 		}
 
+		if common.CFG.Events.TCPPort != 0 {
+			go events.StartTCPServer(common.CFG.Events.TCPPort)
+		}
+
 		usif.LoadBlockFees()
 
 		wallet.FetchingBalanceTick = func() bool {
@@ -405,7 +210,7 @@ func main() {
 
 			common.CountSafe("MainThreadLoops")
 			for retryCachedBlocks {
-				retryCachedBlocks = retry_cached_blocks()
+				retryCachedBlocks = network.RetryCachedBlocks() && !usif.Exit_now.Get()
 				// We have done one per loop - now do something else if pending...
 				if len(network.NetBlocks) > 0 || len(usif.UiChannel) > 0 {
 					break
@@ -421,11 +226,11 @@ func main() {
 
 			case newbl := <-network.NetBlocks:
 				common.Busy()
-				HandleNetBlock(newbl)
+				retryCachedBlocks = network.HandleNetBlock(newbl) || retryCachedBlocks
 
 			case rpcbl := <-rpcapi.RpcBlocks:
 				common.Busy()
-				HandleRpcBlock(rpcbl)
+				rpcapi.HandleRpcBlock(rpcbl)
 
 			default: // timeout immediatelly if no priority message
 			}
@@ -440,11 +245,11 @@ func main() {
 
 			case newbl := <-network.NetBlocks:
 				common.Busy()
-				HandleNetBlock(newbl)
+				retryCachedBlocks = network.HandleNetBlock(newbl) || retryCachedBlocks
 
 			case rpcbl := <-rpcapi.RpcBlocks:
 				common.Busy()
-				HandleRpcBlock(rpcbl)
+				rpcapi.HandleRpcBlock(rpcbl)
 
 			case rec := <-usif.LocksChan:
 				common.Busy()
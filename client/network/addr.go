@@ -91,6 +91,17 @@ func BestExternalAddr() []byte {
 
 func (c *OneConnection) SendAddr() {
 	pers := peersdb.GetBestPeers(MaxAddrsPerMessage, nil)
+	v2 := c.MutexGetBool(&c.Node.SendAddrV2)
+	if !v2 {
+		// the old addr message can only carry IP addresses
+		ips := pers[:0]
+		for _, p := range pers {
+			if p.Net == 0 {
+				ips = append(ips, p)
+			}
+		}
+		pers = ips
+	}
 	maxtime := uint32(time.Now().Unix()+3600)
 	if len(pers)>0 {
 		buf := new(bytes.Buffer)
@@ -101,9 +112,17 @@ func (c *OneConnection) SendAddr() {
 				pers[i].Time = maxtime-7200
 			}
 			binary.Write(buf, binary.LittleEndian, pers[i].Time)
-			buf.Write(pers[i].NetAddr.Bytes())
+			if v2 {
+				buf.Write(pers[i].NetAddr.BytesV2())
+			} else {
+				buf.Write(pers[i].NetAddr.Bytes())
+			}
+		}
+		if v2 {
+			c.SendRawMsg("addrv2", buf.Bytes())
+		} else {
+			c.SendRawMsg("addr", buf.Bytes())
 		}
-		c.SendRawMsg("addr", buf.Bytes())
 	}
 }
 
@@ -131,32 +150,76 @@ func (c *OneConnection) ParseAddr(pl []byte) {
 			//println("ParseAddr:", n, e)
 			break
 		}
-		a := peersdb.NewPeer(buf[:])
-		if !sys.ValidIp4(a.Ip4[:]) {
-			common.CountSafe("AddrInvalid")
-			/*if c.Misbehave("AddrLocal", 1) {
-				break
-			}*/
-			//print(c.PeerAddr.Ip(), " ", c.Node.Agent, " ", c.Node.Version, " addr local ", a.String(), "\n> ")
-		} else if time.Unix(int64(a.Time), 0).Before(time.Now().Add(time.Hour)) {
-			if time.Now().Before(time.Unix(int64(a.Time), 0).Add(peersdb.ExpirePeerAfter)) {
-				k := qdb.KeyType(a.UniqID())
-				v := peersdb.PeerDB.Get(k)
-				if v != nil {
-					a.Banned = peersdb.NewPeer(v[:]).Banned
-				}
-				a.Time = uint32(time.Now().Add(-5*time.Minute).Unix()) // add new peers as not just alive
-				if a.Time > uint32(time.Now().Unix()) {
-					println("wtf", a.Time, time.Now().Unix())
-				}
-				peersdb.PeerDB.Put(k, a.Bytes())
-			} else {
-				common.CountSafe("AddrStale")
+		if !c.addrRcvd(peersdb.NewPeer(buf[:])) {
+			break
+		}
+	}
+}
+
+
+// Parse network's "addrv2" message (BIP155)
+func (c *OneConnection) ParseAddrV2(pl []byte) {
+	b := bytes.NewReader(pl)
+	cnt, _ := btc.ReadVLen(b)
+	if cnt > 1000 {
+		c.DoS("AddrV2Count")
+		return
+	}
+	for i := 0; i < int(cnt); i++ {
+		var tim uint32
+		e := binary.Read(b, binary.LittleEndian, &tim)
+		if e != nil {
+			common.CountSafe("AddrError")
+			c.DoS("AddrError")
+			break
+		}
+		na, e := btc.NewNetAddrV2(b)
+		if e != nil {
+			common.CountSafe("AddrError")
+			c.DoS("AddrError")
+			break
+		}
+		if na.Net != 0 && !na.IsOnion() {
+			common.CountSafe("AddrV2Unsupported") // we can only connect to IPv4 and Tor v3 addresses
+			continue
+		}
+		a := peersdb.NewEmptyPeer()
+		a.NetAddr = *na
+		a.Time = tim
+		if !c.addrRcvd(a) {
+			break
+		}
+	}
+}
+
+
+// Stores the address received from the peer. Returns false if the peer shall not be processed anymore.
+func (c *OneConnection) addrRcvd(a *peersdb.PeerAddr) bool {
+	if a.Net == 0 && !sys.ValidIp4(a.Ip4[:]) {
+		common.CountSafe("AddrInvalid")
+		/*if c.Misbehave("AddrLocal", 1) {
+			return false
+		}*/
+		//print(c.PeerAddr.Ip(), " ", c.Node.Agent, " ", c.Node.Version, " addr local ", a.String(), "\n> ")
+	} else if time.Unix(int64(a.Time), 0).Before(time.Now().Add(time.Hour)) {
+		if time.Now().Before(time.Unix(int64(a.Time), 0).Add(peersdb.ExpirePeerAfter)) {
+			k := qdb.KeyType(a.UniqID())
+			v := peersdb.PeerDB.Get(k)
+			if v != nil {
+				a.Banned = peersdb.NewPeer(v[:]).Banned
 			}
-		} else {
-			if c.Misbehave("AddrFuture", 50) {
-				break
+			a.Time = uint32(time.Now().Add(-5*time.Minute).Unix()) // add new peers as not just alive
+			if a.Time > uint32(time.Now().Unix()) {
+				println("wtf", a.Time, time.Now().Unix())
 			}
+			peersdb.PeerDB.Put(k, a.Bytes())
+		} else {
+			common.CountSafe("AddrStale")
+		}
+	} else {
+		if c.Misbehave("AddrFuture", 50) {
+			return false
 		}
 	}
+	return true
 }
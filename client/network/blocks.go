@@ -0,0 +1,176 @@
+package network
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"io/ioutil"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/chain"
+	"github.com/lentus/wotscoin/client/common"
+)
+
+// Called from the blockchain thread, after LocalAcceptBlock has accepted a block
+var BlockAcceptedCB func()
+
+func LocalAcceptBlock(newbl *BlockRcvd) (e error) {
+	bl := newbl.Block
+	if common.FLAG.TrustAll || newbl.BlockTreeNode.Trusted {
+		bl.Trusted = true
+	}
+
+	common.BlockChain.Unspent.AbortWriting() // abort saving of UTXO.db
+	common.BlockChain.Blocks.BlockAdd(newbl.BlockTreeNode.Height, bl)
+	newbl.TmQueue = time.Now()
+
+	if newbl.DoInvs {
+		common.Busy()
+		NetRouteInv(MSG_BLOCK, bl.Hash, newbl.Conn)
+	}
+
+	MutexRcv.Lock()
+	bl.LastKnownHeight = LastCommitedHeader.Height
+	MutexRcv.Unlock()
+	e = common.BlockChain.CommitBlock(bl, newbl.BlockTreeNode)
+
+	if e == nil {
+		// new block accepted
+		newbl.TmAccepted = time.Now()
+
+		newbl.NonWitnessSize = bl.NoWitnessSize
+
+		common.RecalcAverageBlockSize()
+
+		common.Last.Mutex.Lock()
+		common.Last.Time = time.Now()
+		common.Last.Block = common.BlockChain.LastBlock()
+		common.Last.Mutex.Unlock()
+
+		if BlockAcceptedCB != nil {
+			BlockAcceptedCB()
+		}
+	} else {
+		//fmt.Println("Warning: AcceptBlock failed. If the block was valid, you may need to rebuild the unspent DB (-r)")
+		new_end := common.BlockChain.LastBlock()
+		common.Last.Mutex.Lock()
+		common.Last.Block = new_end
+		common.Last.Mutex.Unlock()
+		// update LastCommitedHeader
+		MutexRcv.Lock()
+		if LastCommitedHeader != new_end {
+			LastCommitedHeader = new_end
+			//println("LastCommitedHeader moved to", LastCommitedHeader.Height)
+		}
+		DiscardedBlocks[newbl.Hash.BIdx()] = true
+		MutexRcv.Unlock()
+	}
+	return
+}
+
+// Reads the block's data from the temp folder, if it was stored there
+func loadTempBlock(newbl *BlockRcvd) {
+	if newbl.Block != nil {
+		return
+	}
+	tmpfn := common.TempBlocksDir() + newbl.BlockTreeNode.BlockHash.String()
+	dat, e := ioutil.ReadFile(tmpfn)
+	os.Remove(tmpfn)
+	if e != nil {
+		panic(e.Error())
+	}
+	if newbl.Block, e = btc.NewBlock(dat); e != nil {
+		panic(e.Error())
+	}
+	if e = newbl.Block.BuildTxList(); e != nil {
+		panic(e.Error())
+	}
+	newbl.Block.BlockExtraInfo = *newbl.BlockExtraInfo
+}
+
+// Accepts the first of the cached blocks that has all its parents now.
+// Returns true if there may be more of them to accept.
+func RetryCachedBlocks() bool {
+	var idx int
+	common.CountSafe("RedoCachedBlks")
+	for idx < len(CachedBlocks) {
+		newbl := CachedBlocks[idx]
+		if CheckParentDiscarded(newbl.BlockTreeNode) {
+			common.CountSafe("DiscardCachedBlock")
+			if newbl.Block == nil {
+				os.Remove(common.TempBlocksDir() + newbl.BlockTreeNode.BlockHash.String())
+			}
+			CachedBlocks = append(CachedBlocks[:idx], CachedBlocks[idx+1:]...)
+			CachedBlocksLen.Store(len(CachedBlocks))
+			return len(CachedBlocks) > 0
+		}
+		if common.BlockChain.HasAllParents(newbl.BlockTreeNode) {
+			common.Busy()
+			loadTempBlock(newbl)
+
+			e := LocalAcceptBlock(newbl)
+			if e != nil {
+				fmt.Println("AcceptBlock2", newbl.BlockTreeNode.BlockHash.String(), "-", e.Error())
+				newbl.Conn.Misbehave("LocalAcceptBl2", 250)
+			}
+			// remove it from cache
+			CachedBlocks = append(CachedBlocks[:idx], CachedBlocks[idx+1:]...)
+			CachedBlocksLen.Store(len(CachedBlocks))
+			return len(CachedBlocks) > 0
+		} else {
+			idx++
+		}
+	}
+	return false
+}
+
+// Return true iof the block's parent is on the DiscardedBlocks list
+// Add it to DiscardedBlocks, if returning true
+func CheckParentDiscarded(n *chain.BlockTreeNode) bool {
+	MutexRcv.Lock()
+	defer MutexRcv.Unlock()
+	if DiscardedBlocks[n.Parent.BlockHash.BIdx()] {
+		DiscardedBlocks[n.BlockHash.BIdx()] = true
+		return true
+	}
+	return false
+}
+
+// Called from the blockchain thread.
+// Returns true if the cached blocks should be retried (see RetryCachedBlocks).
+func HandleNetBlock(newbl *BlockRcvd) (retry bool) {
+	defer func() {
+		common.CountSafe("MainNetBlock")
+		if common.GetUint32(&common.WalletOnIn) > 0 {
+			common.SetUint32(&common.WalletOnIn, 5) // snooze the timer to 5 seconds from now
+		}
+	}()
+
+	if CheckParentDiscarded(newbl.BlockTreeNode) {
+		common.CountSafe("DiscardFreshBlockA")
+		if newbl.Block == nil {
+			os.Remove(common.TempBlocksDir() + newbl.BlockTreeNode.BlockHash.String())
+		}
+		return len(CachedBlocks) > 0
+	}
+
+	if !common.BlockChain.HasAllParents(newbl.BlockTreeNode) {
+		// it's not linking - keep it for later
+		CachedBlocks = append(CachedBlocks, newbl)
+		CachedBlocksLen.Store(len(CachedBlocks))
+		common.CountSafe("BlockPostone")
+		return
+	}
+
+	loadTempBlock(newbl)
+
+	common.Busy()
+	if e := LocalAcceptBlock(newbl); e != nil {
+		common.CountSafe("DiscardFreshBlockB")
+		fmt.Println("AcceptBlock1", newbl.Block.Hash.String(), "-", e.Error())
+		newbl.Conn.Misbehave("LocalAcceptBl1", 250)
+	} else {
+		//println("block", newbl.Block.Height, "accepted")
+		retry = RetryCachedBlocks()
+	}
+	return
+}
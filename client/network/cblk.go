@@ -401,6 +401,7 @@ func (c *OneConnection) ProcessCmpctBlock(pl []byte) {
 		c.Mutex.Lock()
 		c.counters["NewCBlock"]++
 		c.blocksreceived = append(c.blocksreceived, time.Now())
+		c.X.BlocksFirst++
 		c.Mutex.Unlock()
 		orb := &OneReceivedBlock{TmStart: b2g.Started, TmPreproc: time.Now(), FromConID: c.ConnID, DoInvs: b2g.SendInvs}
 		ReceivedBlocks[bidx] = orb
@@ -465,6 +466,9 @@ func (c *OneConnection) ProcessBlockTxn(pl []byte) {
 	if rb, got := ReceivedBlocks[idx]; got {
 		rb.Cnt++
 		common.CountSafe("BlkTxnSameRcvd")
+		c.Mutex.Lock()
+		c.X.BlocksLate++
+		c.Mutex.Unlock()
 		//fmt.Println(c.ConnID, "BlkTxn size", len(pl), "for", hash.String()[48:],"- already have")
 		return
 	}
@@ -537,6 +541,7 @@ func (c *OneConnection) ProcessBlockTxn(pl []byte) {
 	c.Mutex.Lock()
 	c.counters["NewTBlock"]++
 	c.blocksreceived = append(c.blocksreceived, time.Now())
+	c.X.BlocksFirst++
 	c.Mutex.Unlock()
 	orb := &OneReceivedBlock{TmStart: b2g.Started, TmPreproc: b2g.TmPreproc,
 		TmDownload: c.LastMsgTime, TxMissing: col.Missing, FromConID: c.ConnID, DoInvs: b2g.SendInvs}
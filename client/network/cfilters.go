@@ -0,0 +1,193 @@
+package network
+
+import (
+	"sync"
+	"bytes"
+	"encoding/binary"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/chain"
+	"github.com/lentus/wotscoin/client/common"
+)
+
+// BIP157 - serving compact block filters to light clients
+
+type cfCheckpt struct {
+	node *chain.BlockTreeNode
+	hdr []byte
+}
+
+const (
+	MAX_GETCFILTERS_SIZE = 1000
+	MAX_GETCFHEADERS_SIZE = 2000
+	CFCHECKPT_INTERVAL = 1000
+)
+
+var (
+	// Filter headers at every CFCHECKPT_INTERVAL blocks of the last requested chain,
+	// each entry being an ancestor of the next one
+	cfCheckpts []cfCheckpt
+	cfCheckptsMutex sync.Mutex
+)
+
+
+// Parses getcfilters/getcfheaders payload and returns blocks from start_height till stop_hash
+func (c *OneConnection) cfilterRange(pl []byte, max uint32) (res []*chain.BlockTreeNode, ok bool) {
+	if len(pl) != 1+4+32 {
+		c.DoS("BadGetCF")
+		return
+	}
+	if pl[0] != btc.BASIC_FILTER_TYPE || !common.BlockChain.BlockFiltersEnabled() {
+		common.CountSafe("GetCFUnsupported")
+		return
+	}
+	start := binary.LittleEndian.Uint32(pl[1:5])
+
+	common.BlockChain.BlockIndexAccess.Lock()
+	stop, _ := common.BlockChain.BlockIndex[btc.NewUint256(pl[5:37]).BIdx()]
+	common.BlockChain.BlockIndexAccess.Unlock()
+	if stop == nil {
+		common.CountSafe("GetCFUnknownStop")
+		return
+	}
+	if start > stop.Height || stop.Height-start >= max {
+		c.Misbehave("BadGetCFRange", 1000/10)
+		return
+	}
+
+	res = make([]*chain.BlockTreeNode, stop.Height-start+1)
+	for n := stop; n != nil && n.Height >= start; n = n.Parent {
+		res[n.Height-start] = n
+		if n.Height == 0 {
+			break
+		}
+	}
+	ok = true
+	return
+}
+
+
+func (c *OneConnection) ProcessGetCFilters(pl []byte) {
+	nodes, ok := c.cfilterRange(pl, MAX_GETCFILTERS_SIZE)
+	if !ok {
+		return
+	}
+	for _, n := range nodes {
+		f, _ := common.BlockChain.GetBlockFilter(n.BlockHash)
+		if f == nil {
+			common.CountSafe("GetCFMissing")
+			return
+		}
+		out := new(bytes.Buffer)
+		out.WriteByte(btc.BASIC_FILTER_TYPE)
+		out.Write(n.BlockHash.Hash[:])
+		btc.WriteVlen(out, uint64(len(f)))
+		out.Write(f)
+		c.SendRawMsg("cfilter", out.Bytes())
+	}
+}
+
+
+func (c *OneConnection) ProcessGetCFHeaders(pl []byte) {
+	nodes, ok := c.cfilterRange(pl, MAX_GETCFHEADERS_SIZE)
+	if !ok {
+		return
+	}
+
+	prev := make([]byte, 32)
+	if nodes[0].Parent != nil {
+		if _, prev = common.BlockChain.GetBlockFilter(nodes[0].Parent.BlockHash); prev == nil {
+			common.CountSafe("GetCFMissing")
+			return
+		}
+	}
+
+	out := new(bytes.Buffer)
+	out.WriteByte(btc.BASIC_FILTER_TYPE)
+	out.Write(nodes[len(nodes)-1].BlockHash.Hash[:])
+	out.Write(prev)
+	btc.WriteVlen(out, uint64(len(nodes)))
+	for _, n := range nodes {
+		f, _ := common.BlockChain.GetBlockFilter(n.BlockHash)
+		if f == nil {
+			common.CountSafe("GetCFMissing")
+			return
+		}
+		h := btc.Sha2Sum(f)
+		out.Write(h[:])
+	}
+	c.SendRawMsg("cfheaders", out.Bytes())
+}
+
+
+// Returns the filter headers at every CFCHECKPT_INTERVAL blocks up to stop.
+// Only the checkpoints above the last common one with the cached chain are
+// looked up, so that getcfcheckpt does not walk the entire chain each time.
+func cfCheckpoints(stop *chain.BlockTreeNode) (hdrs [][]byte, ok bool) {
+	cnt := int(stop.Height / CFCHECKPT_INTERVAL)
+	var fresh []cfCheckpt // from the top
+
+	cfCheckptsMutex.Lock()
+	defer cfCheckptsMutex.Unlock()
+	n := stop
+	for i := cnt - 1; i >= 0; i-- {
+		for n.Height > uint32(i+1)*CFCHECKPT_INTERVAL {
+			n = n.Parent
+		}
+		if i < len(cfCheckpts) && cfCheckpts[i].node == n {
+			break
+		}
+		_, hdr := common.BlockChain.GetBlockFilter(n.BlockHash)
+		if hdr == nil {
+			return
+		}
+		fresh = append(fresh, cfCheckpt{node: n, hdr: hdr})
+	}
+
+	if len(fresh) > 0 {
+		cfCheckpts = cfCheckpts[:cnt-len(fresh)]
+		for i := len(fresh) - 1; i >= 0; i-- {
+			cfCheckpts = append(cfCheckpts, fresh[i])
+		}
+	}
+	hdrs = make([][]byte, cnt)
+	for i := range hdrs {
+		hdrs[i] = cfCheckpts[i].hdr
+	}
+	ok = true
+	return
+}
+
+
+func (c *OneConnection) ProcessGetCFCheckpt(pl []byte) {
+	if len(pl) != 1+32 {
+		c.DoS("BadGetCF")
+		return
+	}
+	if pl[0] != btc.BASIC_FILTER_TYPE || !common.BlockChain.BlockFiltersEnabled() {
+		common.CountSafe("GetCFUnsupported")
+		return
+	}
+
+	common.BlockChain.BlockIndexAccess.Lock()
+	stop, _ := common.BlockChain.BlockIndex[btc.NewUint256(pl[1:33]).BIdx()]
+	common.BlockChain.BlockIndexAccess.Unlock()
+	if stop == nil {
+		common.CountSafe("GetCFUnknownStop")
+		return
+	}
+
+	hdrs, ok := cfCheckpoints(stop)
+	if !ok {
+		common.CountSafe("GetCFMissing")
+		return
+	}
+
+	out := new(bytes.Buffer)
+	out.WriteByte(btc.BASIC_FILTER_TYPE)
+	out.Write(stop.BlockHash.Hash[:])
+	btc.WriteVlen(out, uint64(len(hdrs)))
+	for _, h := range hdrs {
+		out.Write(h)
+	}
+	c.SendRawMsg("cfcheckpt", out.Bytes())
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/lentus/wotscoin/lib/btc"
 	"github.com/lentus/wotscoin/client/common"
 	"github.com/lentus/wotscoin/lib/others/peersdb"
+	"github.com/lentus/wotscoin/lib/xnyss/authtree"
 )
 
 
@@ -44,6 +45,7 @@ const (
 	MAX_INV_HISTORY = 500
 
 	SERVICE_SEGWIT = 0x8
+	SERVICE_COMPACT_FILTERS = 0x40 // BIP157
 
 	TxsCounterPeriod = 6*time.Second // how long for one tick
 	TxsCounterBufLen = 60 // how many ticks
@@ -77,6 +79,8 @@ type NetworkNodeStruct struct {
 	DoNotRelayTxs bool
 	ReportedIp4 uint32
 	SendHeaders bool
+	SendAddrV2 bool // BIP155
+	SendPackages bool // wants our "pkgtxns" (see txpool_pkg.go)
 	Nonce [8]byte
 
 	// BIP152:
@@ -119,14 +123,22 @@ type ConnectionStatus struct {
 	IsSpecial bool // Special connections get more debgs and are not being automatically dropped
 	IsGocoin bool
 
+	ViaProxy bool // outgoing connection made through Net.Proxy
+
+	Encrypted bool // both directions use the encrypted transport (see transport.go)
+	SessionID string
+
 	Authorized bool
 	AuthMsgGot uint
+	AuthWotsGot bool
 	AuthAckGot bool
 
 	LastMinFeePerKByte uint64
 
 	PingSentCnt uint64
 	BlocksExpired uint64
+
+	BlocksFirst, BlocksLate uint32 // new blocks that we got from this peer first / after another one
 }
 
 type ConnInfo struct {
@@ -143,6 +155,7 @@ type ConnInfo struct {
 	InvsDone int
 	BlocksReceived int
 	GetMPInProgress bool
+	Score int // reputation from the peer's history
 
 	LocalAddr, RemoteAddr string
 
@@ -159,6 +172,7 @@ type OneConnection struct {
 
 	broken bool // flag that the conenction has been broken / shall be disconnected
 	banit bool // Ban this client after disconnecting
+	banReason string // The first reason given to DoS() or Misbehave() (kept in peersdb.PeerStats)
 	misbehave int // When it reaches 1000, ban it
 
 	net.Conn
@@ -189,6 +203,20 @@ type OneConnection struct {
 	sendBuf [SendBufSize]byte
 	SendBufProd, SendBufCons int
 
+	// Encrypted transport state:
+	enc struct {
+		priv, pub []byte // our ephemeral key, while waiting for "encack"
+		started bool
+		send, recv *encCipher
+		next_send, next_recv *encCipher // to be used after the last plaintext message
+	}
+
+	// Rate limit of the getupkh queries (see upkh.go)
+	upkh struct {
+		budget float64
+		last time.Time
+	}
+
 	// Statistics:
 	PendingInvs []*[36]byte // List of pending INV to send and the mutex protecting access to it
 
@@ -234,6 +262,7 @@ type BCmsg struct {
 func NewConnection(ad *peersdb.PeerAddr) (c *OneConnection) {
 	c = new(OneConnection)
 	c.PeerAddr = ad
+	c.PeerAddr.Score = ad.Stats().Score()
 	c.GetBlockInProgress = make(map[BIDX] *oneBlockDl)
 	c.ConnID = atomic.AddUint32(&LastConnId, 1)
 	c.counters = make(map[string]uint64)
@@ -303,6 +332,7 @@ func (v *OneConnection) GetStats(res *ConnInfo) {
 	res.InvsDone = len(v.InvDone.History)
 	res.BlocksReceived = len(v.blocksreceived)
 	res.GetMPInProgress = len(v.GetMP) != 0
+	res.Score = v.PeerAddr.Score
 
 	v.Mutex.Unlock()
 }
@@ -312,7 +342,7 @@ func (c *OneConnection) SendRawMsg(cmd string, pl []byte) (e error) {
 	c.Mutex.Lock()
 	if !c.broken {
 		// we never allow the buffer to be totally full because then producer would be equal consumer
-		if bytes_left := SendBufSize - c.BytesToSent(); bytes_left <= len(pl) + 24 {
+		if bytes_left := SendBufSize - c.BytesToSent(); bytes_left <= len(pl) + ENC_FRAME_OVERHEAD {
 			c.Mutex.Unlock()
 			/*println(c.PeerAddr.Ip(), c.Node.Version, c.Node.Agent, "Peer Send Buffer Overflow @",
 				cmd, bytes_left, len(pl)+24, c.SendBufProd, c.SendBufCons, c.BytesToSent())*/
@@ -326,21 +356,26 @@ func (c *OneConnection) SendRawMsg(cmd string, pl []byte) (e error) {
 
 		common.CountSafe("sent_"+cmd)
 		common.CountSafeAdd("sbts_"+cmd, uint64(len(pl)))
-		var sbuf [24]byte
-
 		c.X.LastCmdSent = cmd
 		c.X.LastBtsSent = uint32(len(pl))
 
-		binary.LittleEndian.PutUint32(sbuf[0:4], common.Version)
-		copy(sbuf[0:4], common.Magic[:])
-		copy(sbuf[4:16], cmd)
-		binary.LittleEndian.PutUint32(sbuf[16:20], uint32(len(pl)))
+		if c.enc.send != nil {
+			c.append_to_send_buffer(c.enc.send.seal(cmd, pl))
+		} else {
+			var sbuf [24]byte
 
-		sh := btc.Sha2Sum(pl[:])
-		copy(sbuf[20:24], sh[:4])
+			binary.LittleEndian.PutUint32(sbuf[0:4], common.Version)
+			copy(sbuf[0:4], common.Params.Magic[:])
+			copy(sbuf[4:16], cmd)
+			binary.LittleEndian.PutUint32(sbuf[16:20], uint32(len(pl)))
 
-		c.append_to_send_buffer(sbuf[:])
-		c.append_to_send_buffer(pl)
+			sh := btc.Sha2Sum(pl[:])
+			copy(sbuf[20:24], sh[:4])
+
+			c.append_to_send_buffer(sbuf[:])
+			c.append_to_send_buffer(pl)
+			c.encSwitchSend(cmd)
+		}
 
 		if x:=c.BytesToSent(); x>c.X.MaxSentBufSize {
 			c.X.MaxSentBufSize = x
@@ -394,6 +429,9 @@ func (c *OneConnection) DoS(why string) {
 	if c.X.IsSpecial {
 		print("BAN " + c.PeerAddr.Ip() + " (" + c.Node.Agent + ") because " + why + "\n> ")
 	}
+	if !c.banit {
+		c.banReason = why
+	}
 	c.banit = true
 	c.broken = true
 	c.Mutex.Unlock()
@@ -411,6 +449,7 @@ func (c *OneConnection) Misbehave(why string, how_much int) (res bool) {
 		if c.misbehave >= 1000 {
 			common.CountSafe("BanMisbehave")
 			res = true
+			c.banReason = why // banit is not set yet, so it is the first reason (as in DoS)
 			c.banit = true
 			c.broken = true
 			//print("Ban " + c.PeerAddr.Ip() + " (" + c.Node.Agent + ") because " + why + "\n> ")
@@ -421,6 +460,27 @@ func (c *OneConnection) Misbehave(why string, how_much int) (res bool) {
 }
 
 
+// Adds what we have learned about the peer during this connection to its history.
+// Make sure to call it with locked c.Mutex.
+func (c *OneConnection) updatePeerStats() {
+	ping := uint32(c.GetAveragePing())
+	c.PeerAddr.UpdateStats(func(st *peersdb.PeerStats) {
+		st.Conns++
+		st.Misbehave += uint32(c.misbehave)
+		st.BytesServed += c.X.BytesReceived
+		if ping > 0 {
+			if st.PingAvg == 0 {
+				st.PingAvg = ping
+			} else {
+				st.PingAvg = (3*st.PingAvg + ping) / 4
+			}
+		}
+		st.BlocksFirst += c.X.BlocksFirst
+		st.BlocksLate += c.X.BlocksLate
+	})
+}
+
+
 func (c *OneConnection) HandleError(e error) (error) {
 	if nerr, ok := e.(net.Error); ok && nerr.Timeout() {
 		//fmt.Println("Just a timeout - ignore")
@@ -437,6 +497,10 @@ func (c *OneConnection) FetchMessage() (ret *BCmsg, timeout_or_data bool) {
 	var e error
 	var n int
 
+	if c.enc.recv != nil {
+		return c.fetchEncrypted()
+	}
+
 	for c.recv.hdr_len < 24 {
 		n, e = common.SockRead(c.Conn, c.recv.hdr[c.recv.hdr_len:24])
 		if n < 0 {
@@ -455,7 +519,7 @@ func (c *OneConnection) FetchMessage() (ret *BCmsg, timeout_or_data bool) {
 			c.HandleError(e)
 			return // Make sure to exit here, in case of timeout
 		}
-		if c.recv.hdr_len >= 4 && !bytes.Equal(c.recv.hdr[:4], common.Magic[:]) {
+		if c.recv.hdr_len >= 4 && !bytes.Equal(c.recv.hdr[:4], common.Params.Magic[:]) {
 			if c.X.IsSpecial {
 				fmt.Printf("BadMagic from %s %s \n hdr:%s  n:%d\n R: %s %d / S: %s %d\n> ", c.PeerAddr.Ip(), c.Node.Agent,
 					hex.EncodeToString(c.recv.hdr[:c.recv.hdr_len]), n,
@@ -612,6 +676,7 @@ func maxmsgsize(cmd string) uint32 {
 		case "inv": return 3+50000*36 // the spec says "max 50000 entries"
 		case "tx": return 500e3 // max segwit tx size 500KB
 		case "addr": return 3+1000*30 // max 1000 addrs
+		case "addrv2": return 3+1000*(4+9+1+3+btc.BIP155_MAX_ADDR_LEN+2) // max 1000 addrs
 		case "block": return 8e6 // max seg2x block size 8MB
 		case "getblocks": return 4+3+500*32+32 // we allow up to 500 locator hashes
 		case "getdata": return 3+50000*36 // the spec says "max 50000 entries"
@@ -622,6 +687,11 @@ func maxmsgsize(cmd string) uint32 {
 		case "blocktxn": return 8e6 // all txs that can fit withing 1MB block
 		case "notfound": return 3+50000*36 // maximum size of getdata
 		case "getmp": return 5+8*MAX_GETMP_TXS
+		case "getcfilters", "getcfheaders": return 1+4+32
+		case "getcfcheckpt": return 1+32
+		case "getupkh": return 3+btc.MAX_GETUPKH_SIZE*32
+		case "pkgtxns": return 1+MAX_PACKAGE_WEIGHT // raw size of a tx never exceeds its weight
+		case "authwots": return authtree.PubKeyLen+authtree.MaxSigLen
 		default: return 1024 // Any other type of block: maximum 1KB payload limit
 	}
 }
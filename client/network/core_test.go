@@ -0,0 +1,27 @@
+package network
+
+import (
+	"testing"
+)
+
+// The peer's history keeps the first reason it got banned for
+func TestBanReason(t *testing.T) {
+	c := new(OneConnection)
+	if c.Misbehave("First", 999) || c.banReason != "" {
+		t.Error("Banned too early")
+	}
+	if !c.Misbehave("Second", 1) || c.banReason != "Second" {
+		t.Error("Not banned for", c.banReason)
+	}
+	c.DoS("Third")
+	c.Misbehave("Fourth", 1000)
+	if c.banReason != "Second" {
+		t.Error("Ban reason overwritten with", c.banReason)
+	}
+
+	c = new(OneConnection)
+	c.DoS("First")
+	if c.Misbehave("Second", 1000) || c.banReason != "First" {
+		t.Error("Ban reason overwritten with", c.banReason)
+	}
+}
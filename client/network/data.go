@@ -119,6 +119,7 @@ func netBlockReceived(conn *OneConnection, b []byte) {
 		common.CountSafe("BlockSameRcvd")
 		conn.Mutex.Lock()
 		delete(conn.GetBlockInProgress, idx)
+		conn.X.BlocksLate++
 		conn.Mutex.Unlock()
 		MutexRcv.Unlock()
 		return
@@ -195,6 +196,7 @@ func netBlockReceived(conn *OneConnection, b []byte) {
 		orb.TxMissing = -1
 	}
 	conn.blocksreceived = append(conn.blocksreceived, time.Now())
+	conn.X.BlocksFirst++
 	conn.Mutex.Unlock()
 
 	ReceivedBlocks[idx] = orb
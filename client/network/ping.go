@@ -73,6 +73,7 @@ type SortedConnections []struct {
 	TxsCount int
 	MinutesOnline int
 	Special bool
+	Score int // from the peer's history (see peersdb.PeerStats.Score)
 }
 
 
@@ -91,6 +92,7 @@ func GetSortedConnections() (list SortedConnections, any_ping bool, segwit_cnt i
 		tlist[cnt].BlockCount = len(v.blocksreceived)
 		tlist[cnt].TxsCount = v.X.TxsReceived
 		tlist[cnt].Special = v.X.IsSpecial
+		tlist[cnt].Score = v.PeerAddr.Score
 		if v.X.VersionReceived==false || v.X.ConnectedAt.IsZero() {
 			tlist[cnt].MinutesOnline = 0
 		} else {
@@ -161,50 +163,69 @@ func drop_worst_peer() bool {
 		return false
 	}
 
-	for _, v := range list {
+	droppable := func(i int) bool {
+		v := &list[i]
 		if v.MinutesOnline < OnlineImmunityMinutes {
-			continue
+			return false
 		}
 		if v.Special {
-			continue
+			return false
 		}
 		if common.CFG.Net.MinSegwitCons > 0 && segwit_cnt <= int(common.CFG.Net.MinSegwitCons) &&
 			(v.Conn.Node.Services&SERVICE_SEGWIT) != 0 {
-			continue
+			return false
 		}
 		if v.Conn.X.Incomming {
-			if InConsActive+2 > common.GetUint32(&common.CFG.Net.MaxInCons) {
-				common.CountSafe("PeerInDropped")
-				if common.FLAG.Log {
-					f, _ := os.OpenFile("drop_log.txt", os.O_CREATE|os.O_RDWR|os.O_APPEND, 0660);
-					if f!=nil {
-						fmt.Fprintf(f, "%s: Drop incomming id:%d  blks:%d  txs:%d  ping:%d  mins:%d\n",
-							time.Now().Format("2006-01-02 15:04:05"),
-							v.Conn.ConnID, v.BlockCount, v.TxsCount, v.Ping, v.MinutesOnline)
-						f.Close()
-					}
-				}
-				v.Conn.Disconnect("PeerInDropped")
-				return true
-			}
-		} else {
-			if OutConsActive+2 > common.GetUint32(&common.CFG.Net.MaxOutCons) {
-				common.CountSafe("PeerOutDropped")
-				if common.FLAG.Log {
-					f, _ := os.OpenFile("drop_log.txt", os.O_CREATE|os.O_RDWR|os.O_APPEND, 0660);
-					if f!=nil {
-						fmt.Fprintf(f, "%s: Drop outgoing id:%d  blks:%d  txs:%d  ping:%d  mins:%d\n",
-							time.Now().Format("2006-01-02 15:04:05"),
-							v.Conn.ConnID, v.BlockCount, v.TxsCount, v.Ping, v.MinutesOnline)
-						f.Close()
-					}
-				}
-				v.Conn.Disconnect("PeerOutDropped")
-				return true
+			return InConsActive+2 > common.GetUint32(&common.CFG.Net.MaxInCons)
+		}
+		return OutConsActive+2 > common.GetUint32(&common.CFG.Net.MaxOutCons)
+	}
+
+	// The peer with the worst history goes first, if it has a bad one at all...
+	worst := -1
+	for i := range list {
+		if list[i].Score < 0 && droppable(i) && (worst < 0 || list[i].Score < list[worst].Score) {
+			worst = i
+		}
+	}
+	if worst >= 0 {
+		common.CountSafe("PeerDroppedBadScore")
+	} else {
+		// ... otherwise the slowest one
+		for i := range list {
+			if droppable(i) {
+				worst = i
+				break
 			}
 		}
 	}
-	return false
+	if worst < 0 {
+		return false
+	}
+
+	v := list[worst]
+	dir := "outgoing"
+	if v.Conn.X.Incomming {
+		dir = "incomming"
+		common.CountSafe("PeerInDropped")
+	} else {
+		common.CountSafe("PeerOutDropped")
+	}
+	if common.FLAG.Log {
+		f, _ := os.OpenFile("drop_log.txt", os.O_CREATE|os.O_RDWR|os.O_APPEND, 0660);
+		if f!=nil {
+			fmt.Fprintf(f, "%s: Drop %s id:%d  blks:%d  txs:%d  ping:%d  mins:%d  score:%d\n",
+				time.Now().Format("2006-01-02 15:04:05"), dir,
+				v.Conn.ConnID, v.BlockCount, v.TxsCount, v.Ping, v.MinutesOnline, v.Score)
+			f.Close()
+		}
+	}
+	if v.Conn.X.Incomming {
+		v.Conn.Disconnect("PeerInDropped")
+	} else {
+		v.Conn.Disconnect("PeerOutDropped")
+	}
+	return true
 }
 
 
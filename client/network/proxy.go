@@ -0,0 +1,46 @@
+package network
+
+import (
+	"net"
+	"errors"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/lib/others/socks"
+	"github.com/lentus/wotscoin/lib/others/peersdb"
+)
+
+// Returns the SOCKS5 proxy for the outgoing connections (empty if not configured)
+func ProxyAddr() (res string) {
+	common.LockCfg()
+	res = common.CFG.Net.Proxy
+	common.UnlockCfg()
+	return
+}
+
+
+// Onion peers can only be reached through the proxy
+func Reachable(ad *peersdb.PeerAddr) bool {
+	return !ad.IsOnion() || ProxyAddr() != ""
+}
+
+
+// Connects to the peer, through the proxy if there is one
+func dialPeer(ad *peersdb.PeerAddr) (con net.Conn, proxied bool, e error) {
+	proxy := ProxyAddr()
+	if proxy == "" {
+		if ad.IsOnion() {
+			e = errors.New("onion peers need Net.Proxy")
+			return
+		}
+		con, e = net.DialTimeout("tcp4", ad.Ip(), TCPDialTimeout)
+		return
+	}
+	// random credentials make Tor use a separate circuit for each connection
+	var cred [8]byte
+	rand.Read(cred[:])
+	d := &socks.Dialer{Proxy: proxy, User: hex.EncodeToString(cred[:]), Password: "wotscoin", Timeout: TCPDialTimeout}
+	con, e = d.Dial(ad.Ip())
+	proxied = true
+	return
+}
@@ -0,0 +1,444 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+	"crypto/rand"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/chain"
+	"github.com/lentus/wotscoin/client/common"
+)
+
+// Every test starts from wherever the previous one has left the node's chain,
+// so the peers always sync with the node first.
+
+func newSyncedPeer(t *testing.T) (p *simPeer) {
+	p = newSimPeer(t)
+	p.connect()
+	p.sync()
+	return
+}
+
+
+// Returns a new long-term XNYSS key and its 1-of-1 multisig
+func newXnyssKey() (rec *btc.PrivateAddr, ms *btc.MultiSig) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	rec = btc.NewPrivateAddr(key, common.Params.AddrVerPubkey+0x80, true)
+	ms = btc.NewXNYSSMultiSig()
+	ms.PublicKeys = append(ms.PublicKeys, rec.Hash160[:])
+	return
+}
+
+
+// Mines a coinbase paying to the XNYSS multisig and matures it
+func simXnyssFund(t *testing.T, p *simPeer, ms *btc.MultiSig) (fund *btc.Tx) {
+	t.Helper()
+	bl := p.mine(nil, ms.PkScript())
+	hdrs := [][]byte{bl.Raw[:80]}
+	for i := 0; i < chain.COINBASE_MATURITY; i++ {
+		hdrs = append(hdrs, p.mine(nil, nil).Raw[:80])
+	}
+	p.send("headers", headersPayload(hdrs))
+	simWaitFor(t, "node to get the maturing blocks", func() bool {
+		return simNodeTop().BlockHash.Equal(p.top().BlockHash)
+	})
+	fund = bl.Txs[0]
+	return
+}
+
+
+// Builds a transaction spending the first output of prev (locked with rec's multisig ms)
+// to pkscr and signs it. The transaction signals RBF.
+func simXnyssTx(t *testing.T, prev *btc.Tx, rec *btc.PrivateAddr, ms *btc.MultiSig, fee uint64, pkscr []byte) (tx *btc.Tx, children [][]byte) {
+	t.Helper()
+	tx = new(btc.Tx)
+	tx.Version = 1
+	tx.TxIn = []*btc.TxIn{&btc.TxIn{Input: btc.TxPrevOut{Hash: prev.Hash.Hash}, Sequence: 0xfffffffd}}
+	tx.TxOut = []*btc.TxOut{&btc.TxOut{Value: prev.TxOut[0].Value - fee, Pk_script: pkscr}}
+	tx.TxIn[0].ScriptSig = ms.Bytes()
+	tx.SetHash(tx.Serialize())
+
+	hash := tx.SignatureHash(ms.P2SH(), 0, btc.SIGHASH_ALL)
+	sig, er := rec.TreeState.Sign(hash, tx.Hash.Bytes())
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	ms.XnyssSignatures = append(ms.XnyssSignatures[:0], sig)
+	tx.TxIn[0].ScriptSig = ms.Bytes()
+	tx.SetHash(tx.Serialize())
+	children = sig.ChildHashes
+	if len(children) == 0 {
+		t.Fatal("Long-term signature without child keys")
+	}
+	return
+}
+
+
+// Mines a coinbase paying to a new XNYSS key, matures it and builds a signed transaction spending it.
+// Returns the transaction and the long-term key hashes (UPKH records) that its signature advertises.
+func simXnyssSpend(t *testing.T, p *simPeer) (tx *btc.Tx, rec *btc.PrivateAddr, children [][]byte) {
+	t.Helper()
+	rec, ms := newXnyssKey()
+	fund := simXnyssFund(t, p, ms)
+	_, ms2 := newXnyssKey()
+	tx, children = simXnyssTx(t, fund, rec, ms, 1e6, ms2.PkScript())
+	return
+}
+
+
+func upkhPresent(pkh []byte) bool {
+	var h [32]byte
+	copy(h[:], pkh)
+	return common.BlockChain.Unspent.UpkhGet(h) != nil
+}
+
+
+func inMempool(h *btc.Uint256) bool {
+	TxMutex.Lock()
+	defer TxMutex.Unlock()
+	return TransactionsToSend[h.BIdx()] != nil
+}
+
+
+func TestSimCompactBlock(t *testing.T) {
+	a := newSyncedPeer(t)
+	defer a.close()
+	b := newSyncedPeer(t)
+	defer b.close()
+
+	bl := a.mine(nil, nil)
+	a.sendCmpctBlock(bl)
+	simWaitFor(t, "node to accept the block", func() bool {
+		return simNodeTop().BlockHash.Equal(bl.Hash)
+	})
+	simWaitFor(t, "block to reach the other peer", func() bool {
+		return b.top().BlockHash.Equal(bl.Hash)
+	})
+	if !b.gotCmpctBlock(bl.Hash) {
+		t.Error("Block not relayed as cmpctblock")
+	}
+	if a.count("getblocktxn") != 0 || a.count("getdata") != 0 {
+		t.Error("Node asked for data it should have had")
+	}
+}
+
+
+func TestSimXnyssRelay(t *testing.T) {
+	a := newSyncedPeer(t)
+	defer a.close()
+	b := newSyncedPeer(t)
+	defer b.close()
+
+	tx, rec, children := simXnyssSpend(t, a)
+	b.sync()
+	a.sendTx(tx)
+	simWaitFor(t, "tx in the node's mempool", func() bool {
+		return inMempool(&tx.Hash)
+	})
+	simWaitFor(t, "tx inv at the other peer", func() bool {
+		return b.gotTxInv(&tx.Hash)
+	})
+
+	bl := a.mine(nil, nil, tx)
+	a.sendCmpctBlock(bl)
+	simWaitFor(t, "node to accept the block", func() bool {
+		return simNodeTop().BlockHash.Equal(bl.Hash)
+	})
+	if a.count("getblocktxn") != 0 {
+		t.Error("Block not reconstructed from the mempool")
+	}
+	if inMempool(&tx.Hash) {
+		t.Error("Mined tx still in the mempool")
+	}
+	for _, ch := range children {
+		var h [32]byte
+		copy(h[:], ch)
+		if r := common.BlockChain.Unspent.UpkhGet(h); r == nil {
+			t.Error("Missing UPKH record", btc.NewUint256(ch).String())
+		} else if !bytes.Equal(r.LongTermHash[:], rec.Hash160[:]) {
+			t.Error("Bad long-term hash in UPKH record", btc.NewUint256(ch).String())
+		}
+	}
+}
+
+
+func TestSimReorgRemovesUpkh(t *testing.T) {
+	a := newSyncedPeer(t)
+	defer a.close()
+
+	tx, _, children := simXnyssSpend(t, a)
+	bl := a.mine(nil, nil, tx)
+	a.send("headers", headersPayload([][]byte{bl.Raw[:80]}))
+	simWaitFor(t, "node to accept the block", func() bool {
+		return simNodeTop().BlockHash.Equal(bl.Hash)
+	})
+	if !upkhPresent(children[0]) {
+		t.Fatal("UPKH record not created")
+	}
+
+	// Another miner, who has not seen the tx, finds two blocks on the previous one
+	c := newSyncedPeer(t)
+	defer c.close()
+	fork := c.ch.BlockIndex[bl.Hash.BIdx()].Parent
+	b1 := c.mine(fork, nil)
+	b2 := c.mine(c.ch.BlockIndex[b1.Hash.BIdx()], nil)
+	c.send("headers", headersPayload([][]byte{b1.Raw[:80], b2.Raw[:80]}))
+	simWaitFor(t, "node to switch to the longer branch", func() bool {
+		return simNodeTop().BlockHash.Equal(b2.Hash)
+	})
+	for _, ch := range children {
+		if upkhPresent(ch) {
+			t.Error("UPKH record not removed by the reorg", btc.NewUint256(ch).String())
+		}
+	}
+}
+
+
+// Waits until the node has dropped and banned the peer
+func simExpectBan(t *testing.T, p *simPeer) {
+	t.Helper()
+	simWaitFor(t, "node to disconnect the peer", func() bool {
+		return p.isClosed() && !p.isConnected()
+	})
+	if !p.isBanned() {
+		t.Error("Peer not banned")
+	}
+}
+
+
+func TestSimBadHeader(t *testing.T) {
+	p := newSyncedPeer(t)
+	defer p.close()
+
+	// find a nonce that does not meet the target
+	hdr := make([]byte, 80)
+	copy(hdr, p.mine(nil, nil).Raw[:80])
+	for btc.CheckProofOfWork(btc.NewSha2Hash(hdr), p.top().Bits()) {
+		hdr[76]++
+	}
+	p.send("headers", headersPayload([][]byte{hdr}))
+	simExpectBan(t, p)
+}
+
+
+func TestSimGetUpkhFlood(t *testing.T) {
+	p := newSyncedPeer(t)
+	defer p.close()
+
+	hashes := make([][32]byte, btc.MAX_GETUPKH_SIZE)
+	for i := range hashes {
+		rand.Read(hashes[i][:])
+	}
+	pl := btc.GetUpkhBytes(hashes)
+	// the first query is within the budget...
+	p.send("getupkh", pl)
+	simWaitFor(t, "answer to the query within the budget", func() bool {
+		return p.count("upkh") > 0
+	})
+	// ... every next one costs 50 misbehave points
+	for i := 0; i < 20 && !p.isClosed(); i++ {
+		p.send("getupkh", pl)
+	}
+	simExpectBan(t, p)
+}
+
+
+func TestSimGarbageTx(t *testing.T) {
+	p := newSyncedPeer(t)
+	defer p.close()
+
+	garbage := make([]byte, 100)
+	rand.Read(garbage)
+	p.send("tx", garbage)
+	simExpectBan(t, p)
+}
+
+
+// A zero fee parent gets in together with a child that pays for both
+// and the node relays them as a package.
+func TestSimPackageRelay(t *testing.T) {
+	a := newSyncedPeer(t)
+	defer a.close()
+	b := newSyncedPeer(t)
+	defer b.close()
+	b.send("sendpackages", nil)
+
+	rec, ms := newXnyssKey()
+	fund := simXnyssFund(t, a, ms)
+	rec2, ms2 := newXnyssKey()
+	_, ms3 := newXnyssKey()
+	parent, _ := simXnyssTx(t, fund, rec, ms, 0, ms2.PkScript())
+	child, _ := simXnyssTx(t, parent, rec2, ms2, 2e6, ms3.PkScript())
+	b.sync()
+
+	a.send("pkgtxns", packagePayload([]*btc.Tx{parent, child}))
+	simWaitFor(t, "package in the node's mempool", func() bool {
+		return inMempool(&parent.Hash) && inMempool(&child.Hash)
+	})
+	simWaitFor(t, "package at the other peer", func() bool {
+		return b.count("pkgtxns") > 0
+	})
+	if a.count("pkgtxns") != 0 {
+		t.Error("Package sent back to its sender")
+	}
+}
+
+
+// A parent rejected for its low fee gets in once its child arrives
+func TestSimPackageRescue(t *testing.T) {
+	a := newSyncedPeer(t)
+	defer a.close()
+
+	rec, ms := newXnyssKey()
+	fund := simXnyssFund(t, a, ms)
+	rec2, ms2 := newXnyssKey()
+	_, ms3 := newXnyssKey()
+	parent, _ := simXnyssTx(t, fund, rec, ms, 0, ms2.PkScript())
+	child, _ := simXnyssTx(t, parent, rec2, ms2, 2e6, ms3.PkScript())
+
+	a.sendTx(parent)
+	simWaitFor(t, "parent to be rejected", func() bool {
+		TxMutex.Lock()
+		defer TxMutex.Unlock()
+		rej := TransactionsRejected[parent.Hash.BIdx()]
+		return rej != nil && rej.Reason == TX_REJECTED_LOW_FEE
+	})
+	a.sendTx(child)
+	simWaitFor(t, "both txs in the node's mempool", func() bool {
+		return inMempool(&parent.Hash) && inMempool(&child.Hash)
+	})
+}
+
+
+// The same one-time key cannot sign two txs in the mempool (e.g. a wallet restored from a stale backup)
+func TestSimXnyssKeyConflict(t *testing.T) {
+	a := newSyncedPeer(t)
+	defer a.close()
+
+	rec, ms := newXnyssKey()
+	_, ms2 := newXnyssKey()
+	fund1 := simXnyssFund(t, a, ms)
+	fund2 := simXnyssFund(t, a, ms)
+	tx1, _ := simXnyssTx(t, fund1, rec, ms, 1e6, ms2.PkScript())
+	stale := btc.NewPrivateAddr(rec.Key, rec.Version, true)
+	tx2, _ := simXnyssTx(t, fund2, stale, ms, 1e6, ms2.PkScript())
+
+	a.sendTx(tx1)
+	simWaitFor(t, "first tx in the node's mempool", func() bool {
+		return inMempool(&tx1.Hash)
+	})
+	a.sendTx(tx2)
+	simWaitFor(t, "second tx to be rejected", func() bool {
+		TxMutex.Lock()
+		defer TxMutex.Unlock()
+		rej := TransactionsRejected[tx2.Hash.BIdx()]
+		return rej != nil && rej.Reason == TX_REJECTED_XNYSS_KEY
+	})
+
+	// once the first one gets mined, it releases the key
+	bl := a.mine(nil, nil, tx1)
+	a.sendCmpctBlock(bl)
+	simWaitFor(t, "node to accept the block", func() bool {
+		return simNodeTop().BlockHash.Equal(bl.Hash)
+	})
+	TxMutex.Lock()
+	_, used := XnyssKeysUsed[xnyssKeys(tx1)[0]]
+	TxMutex.Unlock()
+	if used {
+		t.Error("Key of the mined tx still marked as used")
+	}
+}
+
+
+// A replacement must be signed with a new node and it orphans the children advertised by the original
+func TestSimXnyssReplace(t *testing.T) {
+	a := newSyncedPeer(t)
+	defer a.close()
+
+	rec, ms := newXnyssKey()
+	rec2, _ := newXnyssKey()
+	ms.PublicKeys = append(ms.PublicKeys, rec2.Hash160[:]) // 1-of-2, like the wallet's backup keys
+	_, ms3 := newXnyssKey()
+	fund := simXnyssFund(t, a, ms)
+
+	tx1, children := simXnyssTx(t, fund, rec, ms, 1e6, ms3.PkScript())
+	a.sendTx(tx1)
+	simWaitFor(t, "tx in the node's mempool", func() bool {
+		return inMempool(&tx1.Hash)
+	})
+
+	// the same (restored) node signing again
+	stale := btc.NewPrivateAddr(rec.Key, rec.Version, true)
+	tx2, _ := simXnyssTx(t, fund, stale, ms, 2e6, ms3.PkScript())
+	a.sendTx(tx2)
+	simWaitFor(t, "replacement reusing the key to be rejected", func() bool {
+		TxMutex.Lock()
+		defer TxMutex.Unlock()
+		rej := TransactionsRejected[tx2.Hash.BIdx()]
+		return rej != nil && rej.Reason == TX_REJECTED_RBF_XNYSS
+	})
+
+	tx3, _ := simXnyssTx(t, fund, rec2, ms, 2e6, ms3.PkScript())
+	a.sendTx(tx3)
+	simWaitFor(t, "replacement in the node's mempool", func() bool {
+		return inMempool(&tx3.Hash) && !inMempool(&tx1.Hash)
+	})
+	TxMutex.Lock()
+	orphaned := TransactionsToSend[tx3.Hash.BIdx()].Orphaned
+	TxMutex.Unlock()
+	if len(orphaned) != len(children) {
+		t.Fatal(len(orphaned), "orphaned children reported, should be", len(children))
+	}
+	for i := range children {
+		if !bytes.Equal(orphaned[i][:], children[i]) {
+			t.Error("Wrong orphaned child", i)
+		}
+	}
+}
+
+
+// A package whose child fails is taken back, with the mempool tx that its parent has replaced
+func TestSimPackageRollback(t *testing.T) {
+	a := newSyncedPeer(t)
+	defer a.close()
+	b := newSyncedPeer(t)
+	defer b.close()
+	b.send("sendpackages", nil)
+
+	rec, ms := newXnyssKey()
+	rec2, _ := newXnyssKey()
+	ms.PublicKeys = append(ms.PublicKeys, rec2.Hash160[:])
+	rec3, ms3 := newXnyssKey()
+	_, ms4 := newXnyssKey()
+	fund := simXnyssFund(t, a, ms)
+
+	tx1, _ := simXnyssTx(t, fund, rec, ms, 1e6, ms3.PkScript())
+	a.sendTx(tx1)
+	simWaitFor(t, "tx in the node's mempool", func() bool {
+		return inMempool(&tx1.Hash)
+	})
+	b.sync()
+	simWaitFor(t, "tx announced to the other peer", func() bool {
+		return b.gotTxInv(&tx1.Hash)
+	})
+
+	parent, _ := simXnyssTx(t, fund, rec2, ms, 2e6, ms3.PkScript())
+	child, _ := simXnyssTx(t, parent, rec3, ms3, 1e6, ms4.PkScript())
+	child.TxOut[0].Value-- // breaks the signature
+	child.SetHash(child.Serialize())
+
+	a.send("pkgtxns", packagePayload([]*btc.Tx{parent, child}))
+	simWaitFor(t, "package to be rolled back", func() bool {
+		TxMutex.Lock()
+		defer TxMutex.Unlock()
+		rej := TransactionsRejected[parent.Hash.BIdx()]
+		return rej != nil && rej.Reason == TX_REJECTED_PACKAGE
+	})
+	if !inMempool(&tx1.Hash) || inMempool(&parent.Hash) || inMempool(&child.Hash) {
+		t.Error("Replaced tx not restored")
+	}
+	if b.gotTxInv(&parent.Hash) || b.count("pkgtxns") != 0 {
+		t.Error("Rolled back package announced")
+	}
+}
@@ -0,0 +1,651 @@
+package network
+
+// In-process network simulation.
+// The node under test is this very package (it keeps its state in globals, so there
+// can only be one per process), running on a regtest chain in a temporary folder.
+// It talks over net.Pipe to scripted peers (simPeer), each having its own data dir
+// and its own chain, which mine blocks, relay txs and misbehave on demand.
+//
+// Limitation: only one real client node runs here. The peers are scripts that speak
+// the protocol, not client nodes, so what two client nodes do to each other (e.g.
+// relaying a tx through a third one) is only tested from the side of the one node.
+
+import (
+	"os"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"bytes"
+	"testing"
+	"io/ioutil"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync/atomic"
+	"encoding/binary"
+	"github.com/dchest/siphash"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/utxo"
+	"github.com/lentus/wotscoin/lib/chain"
+	"github.com/lentus/wotscoin/lib/script"
+	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/lib/others/qdb"
+	"github.com/lentus/wotscoin/lib/others/peersdb"
+	"github.com/lentus/wotscoin/lib/others/lightpeer"
+)
+
+const (
+	simTimeout = 30 * time.Second // how long to wait for any expected state
+	simAgent = "/simpeer:1.0/"
+)
+
+var (
+	simQuit = make(chan bool)
+	simDone = make(chan bool)
+	simPeerCnt uint32 // each peer connects from its own IP
+	simExtraNonce uint32
+)
+
+
+func TestMain(m *testing.M) {
+	dir, _ := ioutil.TempDir("", "simnode")
+	simNodeStart(dir)
+	res := m.Run()
+	simNodeStop()
+	os.RemoveAll(dir)
+	os.Exit(res)
+}
+
+
+// Does what the client's main() does, except for the UI, wallet and the TCP server
+func simNodeStart(dir string) {
+	utxo.UTXO_RECORDS_PREALLOC = 1000 // we run several regtest chains at once
+	utxo.UPKH_RECORDS_PREALLOC = 1000
+	common.SetDefaultConfig()
+	common.CFG.Net.ListenTCP = false // the peers get attached by simPeer.connect()
+	common.CFG.Net.MaxOutCons = 0
+	common.CFG.TXPool.SaveOnDisk = false
+	common.CFG.Memory.CacheOnDisk = false
+	common.Params = &btc.RegTestParams
+	common.GocoinHomeDir = dir + string(os.PathSeparator)
+	common.LockCfg()
+	common.Reset()
+	common.UnlockCfg()
+
+	common.BlockChain = chain.NewChainExt(common.GocoinHomeDir, common.Params, false,
+		&chain.NewChanOpts{BlockMinedCB: BlockMined}, &chain.BlockDBOpts{MaxCachedBlocks: 100})
+	common.Last.Block = common.BlockChain.LastBlock()
+	common.Last.Time = time.Now()
+	common.RecalcAverageBlockSize()
+
+	common.Services |= SERVICE_UPKH
+	peersdb.Params = common.Params
+	peersdb.Services = common.Services
+	peersdb.InitPeers(common.GocoinHomeDir)
+
+	for k, v := range common.BlockChain.BlockIndex {
+		ReceivedBlocks[k] = &OneReceivedBlock{TmStart: time.Unix(int64(v.Timestamp()), 0)}
+	}
+	LastCommitedHeader = common.Last.Block
+	common.SetBool(&common.BlockChainSynchronized, true)
+
+	go simNodeLoop()
+}
+
+
+func simNodeStop() {
+	NetCloseAll()
+	simQuit <- true
+	<-simDone
+	common.BlockChain.Close()
+	peersdb.ClosePeerDB()
+}
+
+
+// The main loop of the client (see client/main.go)
+func simNodeLoop() {
+	var retry bool
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		for retry {
+			retry = RetryCachedBlocks()
+		}
+		select {
+			case newbl := <-NetBlocks:
+				retry = HandleNetBlock(newbl) || retry
+
+			case newtx := <-NetTxs:
+				HandleNetTx(newtx, false)
+
+			case <-tick.C:
+				NetworkTick()
+
+			case <-simQuit:
+				simDone <- true
+				return
+		}
+	}
+}
+
+
+// Returns the node's current top block
+func simNodeTop() *chain.BlockTreeNode {
+	common.Last.Mutex.Lock()
+	defer common.Last.Mutex.Unlock()
+	return common.Last.Block
+}
+
+
+// Fails the test if cond() does not become true within simTimeout
+func simWaitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for sta := time.Now(); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().Sub(sta) > simTimeout {
+			t.Fatal("Timeout waiting for", what)
+		}
+	}
+}
+
+
+// A scripted remote node
+type simPeer struct {
+	t *testing.T
+	dir string
+	ch *chain.Chain
+	mu sync.Mutex // protects the chain and the fields below
+
+	*lightpeer.Peer // our end of the pipe
+	Addr *peersdb.PeerAddr // how the node sees us
+	Conn *OneConnection // the node's end of the pipe
+
+	verack chan bool
+	closed chan bool
+
+	rcvd map[string]int // number of messages received from the node, by command
+	txInvs map[[32]byte]bool
+	cmpctBlocks map[[32]byte]bool
+	txs map[[32]byte][]byte // served to the node upon getdata
+	lastHdr []byte // hash of the last header received in "headers"
+}
+
+
+// Creates a new peer, with its own chain containing only the genesis block
+func newSimPeer(t *testing.T) (p *simPeer) {
+	p = &simPeer{t: t, verack: make(chan bool, 1), closed: make(chan bool)}
+	p.rcvd = make(map[string]int)
+	p.txInvs = make(map[[32]byte]bool)
+	p.cmpctBlocks = make(map[[32]byte]bool)
+	p.txs = make(map[[32]byte][]byte)
+	p.dir, _ = ioutil.TempDir("", "simpeer")
+	p.ch = chain.NewChainExt(p.dir + string(os.PathSeparator), common.Params, false, nil,
+		&chain.BlockDBOpts{MaxCachedBlocks: 100})
+	// NewChainExt() points the script engine at the new UTXO database, so restore it.
+	// The peers do not verify scripts (they trust their blocks), so they don't need it.
+	script.UnspentDB = common.BlockChain.Unspent
+	return
+}
+
+
+// Attaches the peer to the node, the way tcp_server() does it, and does the handshake
+func (p *simPeer) connect() {
+	n := atomic.AddUint32(&simPeerCnt, 1)
+	ad, e := peersdb.NewPeerFromString(fmt.Sprint("10.0.", n>>8, ".", n&0xff), true)
+	if e != nil {
+		p.t.Fatal(e.Error())
+	}
+	node_side, peer_side := net.Pipe()
+
+	conn := NewConnection(ad)
+	conn.X.ConnectedAt = time.Now()
+	conn.X.Incomming = true
+	conn.Conn = node_side
+	Mutex_net.Lock()
+	OpenCons[ad.UniqID()] = conn
+	InConsActive++
+	Mutex_net.Unlock()
+	go func() {
+		conn.Run()
+		Mutex_net.Lock()
+		delete(OpenCons, ad.UniqID())
+		InConsActive--
+		Mutex_net.Unlock()
+	}()
+
+	p.Addr, p.Conn = ad, conn
+	p.Peer = &lightpeer.Peer{Conn: peer_side, Magic: common.Params.Magic}
+	go p.reader()
+
+	p.send("version", p.versionPayload())
+	select {
+		case <-p.verack:
+		case <-p.closed:
+			p.t.Fatal("Node disconnected during the handshake")
+		case <-time.After(simTimeout):
+			p.t.Fatal("No verack from the node")
+	}
+	p.send("sendcmpct", []byte{0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}) // high bandwidth, version 2
+}
+
+
+// Disconnects from the node and removes the peer's data
+func (p *simPeer) close() {
+	if p.Peer != nil {
+		p.Peer.Close()
+		<-p.closed
+	}
+	p.ch.Close()
+	script.UnspentDB = common.BlockChain.Unspent // Close() clears it
+	os.RemoveAll(p.dir)
+}
+
+
+func (p *simPeer) versionPayload() []byte {
+	var nonce [8]byte
+	rand.Read(nonce[:])
+	b := new(bytes.Buffer)
+	binary.Write(b, binary.LittleEndian, uint32(70015))
+	binary.Write(b, binary.LittleEndian, uint64(1|SERVICE_SEGWIT))
+	binary.Write(b, binary.LittleEndian, uint64(time.Now().Unix()))
+	b.Write(make([]byte, 2*26))
+	b.Write(nonce[:])
+	btc.WriteVlen(b, uint64(len(simAgent)))
+	b.Write([]byte(simAgent))
+	binary.Write(b, binary.LittleEndian, p.top().Height)
+	b.WriteByte(1) // relay txs
+	return b.Bytes()
+}
+
+
+func (p *simPeer) send(cmd string, pl []byte) {
+	p.WriteMsg(cmd, pl) // an error means the node has disconnected us - the tests check for it
+}
+
+
+// Processes the messages from the node, until it disconnects
+func (p *simPeer) reader() {
+	defer close(p.closed)
+	for {
+		cmd, pl, e := p.ReadMsg()
+		if e != nil {
+			return
+		}
+		p.mu.Lock()
+		p.rcvd[cmd]++
+		p.mu.Unlock()
+		switch cmd {
+			case "version":
+				p.send("verack", nil)
+
+			case "verack":
+				select {
+					case p.verack <- true:
+					default:
+				}
+
+			case "ping":
+				p.send("pong", pl)
+
+			case "getheaders":
+				p.send("headers", p.headersAfter(pl))
+
+			case "headers":
+				p.gotHeaders(pl)
+
+			case "getdata":
+				p.serveData(pl)
+
+			case "cmpctblock":
+				// we never reconstruct, but always ask for the full block
+				if len(pl) >= 80 {
+					hash := btc.NewSha2Hash(pl[:80])
+					p.mu.Lock()
+					p.cmpctBlocks[hash.Hash] = true
+					p.mu.Unlock()
+					p.getBlocks([]*btc.Uint256{hash})
+				}
+
+			case "block":
+				if er := p.acceptBlock(pl); er != nil {
+					p.t.Error("Block from node rejected:", er.Error())
+				}
+
+			case "inv":
+				cnt, of := btc.VLen(pl)
+				p.mu.Lock()
+				for i := 0; i < cnt && of+36 <= len(pl); i, of = i+1, of+36 {
+					if binary.LittleEndian.Uint32(pl[of:of+4]) == MSG_TX {
+						var h [32]byte
+						copy(h[:], pl[of+4:of+36])
+						p.txInvs[h] = true
+					}
+				}
+				p.mu.Unlock()
+		}
+	}
+}
+
+
+// Returns how many times the node has sent us the given message
+func (p *simPeer) count(cmd string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rcvd[cmd]
+}
+
+
+func (p *simPeer) top() *chain.BlockTreeNode {
+	return p.ch.LastBlock()
+}
+
+
+// Checks if the node has disconnected us
+func (p *simPeer) isClosed() bool {
+	select {
+		case <-p.closed:
+			return true
+		default:
+			return false
+	}
+}
+
+
+// Checks if the node has banned our IP
+func (p *simPeer) isBanned() bool {
+	dbp := peersdb.PeerDB.Get(qdb.KeyType(p.Addr.UniqID()))
+	return dbp != nil && peersdb.NewPeer(dbp).Banned != 0
+}
+
+
+// Checks if the node still keeps our connection
+func (p *simPeer) isConnected() bool {
+	Mutex_net.Lock()
+	defer Mutex_net.Unlock()
+	return OpenCons[p.Addr.UniqID()] != nil
+}
+
+
+func (p *simPeer) locator() []byte {
+	b := new(bytes.Buffer)
+	var hashes [][]byte
+	n := p.top()
+	for step := 1; n != nil; {
+		hashes = append(hashes, n.BlockHash.Hash[:])
+		for i := 0; i < step && n != nil; i++ {
+			n = n.Parent
+		}
+		if len(hashes) > 10 {
+			step *= 2
+		}
+	}
+	binary.Write(b, binary.LittleEndian, uint32(70015))
+	btc.WriteVlen(b, uint64(len(hashes)))
+	for _, h := range hashes {
+		b.Write(h)
+	}
+	b.Write(make([]byte, 32)) // hash_stop
+	return b.Bytes()
+}
+
+
+// Asks the node for all the blocks we don't have and waits until we have its top
+func (p *simPeer) sync() {
+	p.t.Helper()
+	p.send("getheaders", p.locator())
+	simWaitFor(p.t, "peer's sync with the node", func() bool {
+		return p.top().BlockHash.Equal(simNodeTop().BlockHash)
+	})
+}
+
+
+// Serves the node's getheaders from our active branch
+func (p *simPeer) headersAfter(pl []byte) []byte {
+	var hdrs [][]byte
+	h2get, _, er := parseLocatorsPayload(pl)
+	if er == nil {
+		p.mu.Lock()
+		var start *chain.BlockTreeNode
+		for _, h := range h2get {
+			if n := p.ch.BlockIndex[h.BIdx()]; n != nil && p.ch.OnActiveBranch(n) {
+				start = n
+				break
+			}
+		}
+		if start == nil {
+			start = p.ch.BlockTreeRoot
+		}
+		for n := p.top(); n != start && len(hdrs) < 2000; n = n.Parent {
+			hdrs = append([][]byte{n.BlockHeader[:]}, hdrs...)
+		}
+		p.mu.Unlock()
+	}
+	return headersPayload(hdrs)
+}
+
+
+func headersPayload(hdrs [][]byte) []byte {
+	b := new(bytes.Buffer)
+	btc.WriteVlen(b, uint64(len(hdrs)))
+	for _, h := range hdrs {
+		b.Write(h[:80])
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+
+// Asks for the blocks that we don't have yet and for more headers
+func (p *simPeer) gotHeaders(pl []byte) {
+	cnt, of := btc.VLen(pl)
+	var want []*btc.Uint256
+	p.mu.Lock()
+	for i := 0; i < cnt && of+81 <= len(pl); i, of = i+1, of+81 {
+		hash := btc.NewSha2Hash(pl[of:of+80])
+		if _, ok := p.ch.BlockIndex[hash.BIdx()]; !ok {
+			want = append(want, hash)
+		}
+		p.lastHdr = hash.Hash[:]
+	}
+	p.mu.Unlock()
+	p.getBlocks(want)
+	if cnt > 0 {
+		b := new(bytes.Buffer)
+		binary.Write(b, binary.LittleEndian, uint32(70015))
+		btc.WriteVlen(b, 1)
+		b.Write(p.lastHdr)
+		b.Write(make([]byte, 32))
+		p.send("getheaders", b.Bytes())
+	}
+}
+
+
+func (p *simPeer) getBlocks(hashes []*btc.Uint256) {
+	if len(hashes) == 0 {
+		return
+	}
+	b := new(bytes.Buffer)
+	btc.WriteVlen(b, uint64(len(hashes)))
+	for _, h := range hashes {
+		binary.Write(b, binary.LittleEndian, uint32(MSG_WITNESS_BLOCK))
+		b.Write(h.Hash[:])
+	}
+	p.send("getdata", b.Bytes())
+}
+
+
+func (p *simPeer) serveData(pl []byte) {
+	cnt, of := btc.VLen(pl)
+	for i := 0; i < cnt && of+36 <= len(pl); i, of = i+1, of+36 {
+		typ := binary.LittleEndian.Uint32(pl[of:of+4])
+		hash := btc.NewUint256(pl[of+4:of+36])
+		switch typ {
+			case MSG_BLOCK, MSG_WITNESS_BLOCK:
+				if dat, _, er := p.ch.Blocks.BlockGet(hash); er == nil {
+					p.send("block", dat)
+				}
+			case MSG_TX, MSG_WITNESS_TX:
+				p.mu.Lock()
+				raw := p.txs[hash.Hash]
+				p.mu.Unlock()
+				if raw != nil {
+					p.send("tx", raw)
+				}
+		}
+	}
+}
+
+
+// Adds a block to our chain. We trust all the blocks (ours are mined by us and
+// the node has verified its own), so no scripts get verified here.
+func (p *simPeer) acceptBlock(raw []byte) (er error) {
+	var bl *btc.Block
+	if bl, er = btc.NewBlock(raw); er != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.ch.BlockIndex[bl.Hash.BIdx()]; ok {
+		return
+	}
+	p.ch.BlockIndexAccess.Lock()
+	er, _, _ = p.ch.PreCheckBlock(bl)
+	p.ch.BlockIndexAccess.Unlock()
+	if er == nil {
+		if er = p.ch.PostCheckBlock(bl); er == nil {
+			bl.Trusted = true
+			er = p.ch.AcceptBlock(bl)
+		}
+	}
+	return
+}
+
+
+// Mines a block on top of the given parent (our top if nil), with the coinbase
+// paying to pkscr. Returns the new block, already accepted into our chain.
+func (p *simPeer) mine(parent *chain.BlockTreeNode, pkscr []byte, txs ...*btc.Tx) *btc.Block {
+	p.t.Helper()
+	if parent == nil {
+		parent = p.top()
+	}
+	if pkscr == nil {
+		pkscr = []byte{0x51}
+	}
+	height := parent.Height + 1
+
+	// BIP34 height and the BIP141 commitment, like client/rpcapi/generate.go does
+	var hgt [4]byte
+	scr := new(bytes.Buffer)
+	binary.LittleEndian.PutUint32(hgt[:], height)
+	l := 4
+	for l > 1 && hgt[l-1] == 0 && hgt[l-2] < 0x80 {
+		l--
+	}
+	scr.WriteByte(byte(l))
+	scr.Write(hgt[:l])
+	scr.WriteByte(4)
+	binary.Write(scr, binary.LittleEndian, atomic.AddUint32(&simExtraNonce, 1))
+
+	cb := new(btc.Tx)
+	cb.Version = 1
+	cb.TxIn = []*btc.TxIn{&btc.TxIn{Input: btc.TxPrevOut{Vout: 0xffffffff}, ScriptSig: scr.Bytes(), Sequence: 0xffffffff}}
+	cb.TxOut = []*btc.TxOut{&btc.TxOut{Value: btc.GetBlockReward(height), Pk_script: pkscr}}
+	reserved := make([]byte, 32)
+	cb.SegWit = [][][]byte{[][]byte{reserved}}
+	merkle, _ := btc.GetWitnessMerkle(append([]*btc.Tx{cb}, txs...))
+	commit := btc.Sha2Sum(append(merkle, reserved...))
+	cb.TxOut = append(cb.TxOut, &btc.TxOut{Pk_script: append([]byte{0x6a, 0x24, 0xaa, 0x21, 0xa9, 0xed}, commit[:]...)})
+	cb.SetHash(cb.SerializeNew())
+	txs = append([]*btc.Tx{cb}, txs...)
+
+	mtr := make([][32]byte, len(txs), 3*len(txs))
+	for i, tx := range txs {
+		mtr[i] = tx.Hash.Hash
+	}
+	merkle, _ = btc.CalcMerkle(mtr)
+
+	tim := uint32(time.Now().Unix())
+	if tim <= parent.Timestamp() {
+		tim = parent.Timestamp() + 1
+	}
+	hdr := make([]byte, 80)
+	binary.LittleEndian.PutUint32(hdr[0:4], 0x20000000)
+	copy(hdr[4:36], parent.BlockHash.Hash[:])
+	copy(hdr[36:68], merkle)
+	binary.LittleEndian.PutUint32(hdr[68:72], tim)
+	binary.LittleEndian.PutUint32(hdr[72:76], parent.Bits())
+	for nonce := uint32(0); ; nonce++ {
+		binary.LittleEndian.PutUint32(hdr[76:80], nonce)
+		if btc.CheckProofOfWork(btc.NewSha2Hash(hdr), parent.Bits()) {
+			break
+		}
+	}
+
+	buf := bytes.NewBuffer(hdr)
+	btc.WriteVlen(buf, uint64(len(txs)))
+	for _, tx := range txs {
+		buf.Write(tx.Raw)
+	}
+	if er := p.acceptBlock(buf.Bytes()); er != nil {
+		p.t.Fatal("Mined block rejected:", er.Error())
+	}
+	bl, _ := btc.NewBlock(buf.Bytes())
+	bl.BuildTxList()
+	return bl
+}
+
+
+// Mines n empty blocks on our top and announces them with one "headers" message
+func (p *simPeer) mineAndAnnounce(n int) {
+	p.t.Helper()
+	var hdrs [][]byte
+	for ; n > 0; n-- {
+		hdrs = append(hdrs, p.mine(nil, nil).Raw[:80])
+	}
+	p.send("headers", headersPayload(hdrs))
+}
+
+
+// Announces the block as a BIP152 high bandwidth peer does (version 2, coinbase prefilled)
+func (p *simPeer) sendCmpctBlock(bl *btc.Block) {
+	var nonce [8]byte
+	rand.Read(nonce[:])
+	msg := new(bytes.Buffer)
+	msg.Write(bl.Raw[:80])
+	msg.Write(nonce[:])
+	kks := sha256.Sum256(msg.Bytes())
+	k0 := binary.LittleEndian.Uint64(kks[0:8])
+	k1 := binary.LittleEndian.Uint64(kks[8:16])
+	btc.WriteVlen(msg, uint64(len(bl.Txs)-1))
+	for _, tx := range bl.Txs[1:] {
+		var sid [8]byte
+		binary.LittleEndian.PutUint64(sid[:], siphash.Hash(k0, k1, tx.WTxID().Hash[:]))
+		msg.Write(sid[:6])
+	}
+	msg.WriteByte(1) // one prefilled tx...
+	msg.WriteByte(0) // ... the coinbase
+	msg.Write(bl.Txs[0].Raw)
+	p.send("cmpctblock", msg.Bytes())
+}
+
+
+// Sends the tx to the node and serves it later on, if asked
+func (p *simPeer) sendTx(tx *btc.Tx) {
+	p.mu.Lock()
+	p.txs[tx.Hash.Hash] = tx.Raw
+	p.mu.Unlock()
+	p.send("tx", tx.Raw)
+}
+
+
+func (p *simPeer) gotTxInv(h *btc.Uint256) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.txInvs[h.Hash]
+}
+
+
+func (p *simPeer) gotCmpctBlock(h *btc.Uint256) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cmpctBlocks[h.Hash]
+}
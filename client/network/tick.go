@@ -2,12 +2,14 @@ package network
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"github.com/lentus/wotscoin/client/common"
 	"github.com/lentus/wotscoin/lib/btc"
 	"github.com/lentus/wotscoin/lib/others/peersdb"
+	"github.com/lentus/wotscoin/lib/xnyss/authtree"
 	"math/rand"
 	"net"
 	"os"
@@ -22,6 +24,8 @@ var (
 
 	NextConnectFriends time.Time = time.Now()
 	AuthPubkeys        [][]byte
+	AuthWotsPubkeys    [][]byte // hash based keys, see lib/xnyss/authtree
+	AuthReplayGuard    = authtree.NewReplayGuard()
 
 	GetMPInProgressTicket = make(chan bool, 1)
 )
@@ -181,13 +185,14 @@ func DoNetwork(ad *peersdb.PeerAddr) {
 	go func() {
 		var con net.Conn
 		var e error
+		var proxied bool
 		con_done := make(chan bool, 1)
 
-		go func(addr string) {
+		go func() {
 			// we do net.Dial() in paralell routine, so we can abort quickly upon request
-			con, e = net.DialTimeout("tcp4", addr, TCPDialTimeout)
+			con, proxied, e = dialPeer(ad)
 			con_done <- true
-		}(fmt.Sprintf("%d.%d.%d.%d:%d", ad.Ip4[0], ad.Ip4[1], ad.Ip4[2], ad.Ip4[3], ad.Port))
+		}()
 
 		for {
 			select {
@@ -196,6 +201,7 @@ func DoNetwork(ad *peersdb.PeerAddr) {
 					Mutex_net.Lock()
 					conn.Conn = con
 					conn.X.ConnectedAt = time.Now()
+					conn.X.ViaProxy = proxied
 					Mutex_net.Unlock()
 					conn.Run()
 				}
@@ -253,7 +259,7 @@ func tcp_server() {
 					if ok && time.Now().Sub(ti) < HammeringMinReconnect {
 						//println(ad.Ip(), "is hammering within", time.Now().Sub(ti).String())
 						common.CountSafe("BanHammerIn")
-						ad.Ban()
+						ad.Ban("HammerIn")
 						terminate = true
 					}
 
@@ -317,6 +323,7 @@ func ConnectFriends() {
 	defer f.Close()
 
 	AuthPubkeys = nil
+	AuthWotsPubkeys = nil
 	friend_ids := make(map[uint64]bool)
 
 	rd := bufio.NewReader(f)
@@ -349,6 +356,8 @@ func ConnectFriends() {
 			if len(pk) == 33 {
 				AuthPubkeys = append(AuthPubkeys, pk)
 				//println("Using pubkey:", hex.EncodeToString(pk))
+			} else if len(pk) == authtree.PubKeyLen {
+				AuthWotsPubkeys = append(AuthWotsPubkeys, pk)
 			}
 		}
 	}
@@ -466,12 +475,12 @@ func NetworkTick() {
 			if segwit_conns < common.CFG.Net.MinSegwitCons && (ad.Services&SERVICE_SEGWIT) == 0 {
 				return true
 			}
-			return ConnectionActive(ad)
+			return !Reachable(ad) || ConnectionActive(ad)
 		})
 		if len(adrs) == 0 && segwit_conns < common.CFG.Net.MinSegwitCons {
 			// we have only non-segwit peers in the database - take them
 			adrs = peersdb.GetBestPeers(128, func(ad *peersdb.PeerAddr) bool {
-				return ConnectionActive(ad)
+				return !Reachable(ad) || ConnectionActive(ad)
 			})
 		}
 		if len(adrs) == 0 {
@@ -499,6 +508,18 @@ func (c *OneConnection) SendFeeFilter() {
 }
 
 func (c *OneConnection) SendAuth() {
+	if common.WotsAuthKey != nil && c.PeerAddr.Friend {
+		// each signature uses up one leaf of the key, so only do it for friends
+		pub, sig, er := common.SignWotsAuth(c.Node.Nonce[:])
+		if er != nil {
+			println("SendAuth:", er.Error())
+		} else {
+			c.SendRawMsg("authwots", append(pub, sig...))
+		}
+	}
+	if !common.GetBool(&common.CFG.Auth.LegacyECDSA) {
+		return
+	}
 	rnd := make([]byte, 32)
 	copy(rnd, c.Node.Nonce[:])
 	r, s, er := btc.EcdsaSign(common.SecretKey, rnd)
@@ -512,22 +533,59 @@ func (c *OneConnection) SendAuth() {
 	c.SendRawMsg("auth", sig.Bytes())
 }
 
+func (c *OneConnection) authorized() {
+	if !c.X.Authorized {
+		c.X.Authorized = true
+		c.SendRawMsg("authack", nil)
+	}
+}
+
 func (c *OneConnection) AuthRvcd(pl []byte) {
 	if c.X.AuthMsgGot > 0 {
 		c.DoS("AuthMsgCnt") // Only allow one auth message per connection (DoS prevention)
 		return
 	}
 	c.X.AuthMsgGot++
+	if !common.GetBool(&common.CFG.Auth.LegacyECDSA) {
+		common.CountSafe("AuthECDSAIgnored")
+		return
+	}
 	rnd := make([]byte, 32)
 	copy(rnd, nonce[:])
 	for _, pub := range AuthPubkeys {
 		if btc.EcdsaVerify(pub, pl, rnd) {
-			c.X.Authorized = true
-			c.SendRawMsg("authack", nil)
+			c.authorized()
+			return
+		}
+	}
+}
+
+// Hash based authorization: payload is the public key followed by the signature
+func (c *OneConnection) AuthWotsRcvd(pl []byte) {
+	if c.X.AuthWotsGot {
+		c.DoS("AuthMsgCnt")
+		return
+	}
+	c.X.AuthWotsGot = true
+	if len(pl) <= authtree.PubKeyLen {
+		c.DoS("AuthWotsLen")
+		return
+	}
+	pub, sig := pl[:authtree.PubKeyLen], pl[authtree.PubKeyLen:]
+	for _, pk := range AuthWotsPubkeys {
+		if bytes.Equal(pk, pub) {
+			idx, ok := authtree.Verify(pub, common.WotsAuthMsg(nonce[:], pub), sig)
+			if !ok {
+				common.CountSafe("AuthWotsBadSig")
+			} else if !AuthReplayGuard.Use(pub, idx) {
+				common.CountSafe("AuthWotsReplay")
+			} else {
+				c.authorized()
+			}
 			return
 		}
 	}
-	c.X.Authorized = false
+	common.CountSafe("AuthWotsUnknown")
 }
 
 // call it upon receiving "getmpdone" message or when the peer disconnects
@@ -632,6 +690,8 @@ func (c *OneConnection) Run() {
 			}
 			c.X.LastMinFeePerKByte = common.MinFeePerKB()
 
+			c.SendEncInit()
+
 			if c.X.IsGocoin {
 				c.SendAuth()
 			}
@@ -677,6 +737,24 @@ func (c *OneConnection) Run() {
 		case "addr":
 			c.ParseAddr(cmd.pl)
 
+		case "addrv2":
+			c.ParseAddrV2(cmd.pl)
+
+		case "sendaddrv2":
+			c.Mutex.Lock()
+			c.Node.SendAddrV2 = true
+			c.Mutex.Unlock()
+
+		case "sendpackages":
+			c.Mutex.Lock()
+			c.Node.SendPackages = true
+			c.Mutex.Unlock()
+
+		case "pkgtxns":
+			if common.AcceptTx() && common.CFG.TXPool.Packages {
+				c.ParsePkgTxsNet(cmd.pl)
+			}
+
 		case "block": //block received
 			netBlockReceived(c, cmd.pl)
 			c.X.GetBlocksDataNow = true // try to ask for more blocks
@@ -771,6 +849,21 @@ func (c *OneConnection) Run() {
 				c.GetMPNow()
 			}
 
+		case "encinit":
+			c.ProcessEncInit(cmd.pl)
+
+		case "encack":
+			c.ProcessEncAck(cmd.pl)
+
+		case "encon":
+			c.ProcessEncOn()
+
+		case "authwots":
+			c.AuthWotsRcvd(cmd.pl)
+			if c.X.AuthAckGot {
+				c.GetMPNow()
+			}
+
 		case "authack":
 			c.X.AuthAckGot = true
 			c.GetMPNow()
@@ -778,6 +871,18 @@ func (c *OneConnection) Run() {
 		case "getmpdone":
 			c.GetMPDone(cmd.pl)
 
+		case "getcfilters":
+			c.ProcessGetCFilters(cmd.pl)
+
+		case "getcfheaders":
+			c.ProcessGetCFHeaders(cmd.pl)
+
+		case "getcfcheckpt":
+			c.ProcessGetCFCheckpt(cmd.pl)
+
+		case "getupkh":
+			c.ProcessGetUpkh(cmd.pl)
+
 		default:
 		}
 	}
@@ -798,14 +903,17 @@ func (c *OneConnection) Run() {
 	}
 	MutexRcv.Unlock()
 
-	ban := c.banit
+	ban, why := c.banit, c.banReason
+	if c.X.VersionReceived || ban {
+		c.updatePeerStats()
+	}
 	c.Mutex.Unlock()
 
 	if c.PeerAddr.Friend || c.X.Authorized {
 		common.CountSafe(fmt.Sprint("FDisconnect-", ban))
 	} else {
 		if ban {
-			c.PeerAddr.Ban()
+			c.PeerAddr.Ban(why)
 			common.CountSafe("PeersBanned")
 		} else if c.X.Incomming && !c.MutexGetBool(&c.X.IsSpecial) {
 			HammeringMutex.Lock()
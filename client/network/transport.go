@@ -0,0 +1,312 @@
+package network
+
+import (
+	"io"
+	"time"
+	"strings"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/binary"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/chacha20poly1305"
+	"github.com/lentus/wotscoin/client/common"
+)
+
+/*
+	Encrypted transport between wotscoin nodes (opt-in, with Net.Encrypt in the config).
+
+	Nodes that enable it advertise SERVICE_ENCRYPTED. After receiving the version message,
+	the outgoing side sends "encinit" with its ephemeral X25519 key and the peer answers with
+	"encack" carrying its own one. From then on each side encrypts everything it sends after
+	its last plaintext message ("encack" for the incoming side and "encon" for the outgoing
+	one), so the receiver knows exactly where the encrypted stream starts. Peers that do not
+	support it ignore "encinit" and the connection stays on the plaintext (v1) framing.
+
+	The key exchange is not authenticated, so it only stops passive observers. An active
+	man in the middle can run a session with each side, which only shows as different
+	session ids at the two ends, when their operators compare them.
+
+	Encrypted frame (instead of the 24 bytes message header):
+		[0:3] - length of the payload's ciphertext (the payload and the tag), and
+		[3:15] - the 12 bytes command, both encrypted with a ChaCha20 key stream (like the
+		         length in BIP324), so the receiver can check the length against the
+		         command's limit before reading the payload
+		[15:] - ChaCha20-Poly1305 of the payload, with the plain [0:15] as associated data
+*/
+
+const (
+	SERVICE_ENCRYPTED = 1 << 24 // wotscoin specific (from the experimental range)
+
+	ENC_HDR_LEN = 3 + 12
+	ENC_FRAME_OVERHEAD = ENC_HDR_LEN + chacha20poly1305.Overhead
+)
+
+type encCipher struct {
+	aead cipher.AEAD
+	lenc *chacha20.Cipher
+	seq uint64
+}
+
+func newEncCipher(key []byte) (e *encCipher) {
+	e = new(encCipher)
+	e.aead, _ = chacha20poly1305.New(key[0:32])
+	e.lenc, _ = chacha20.NewUnauthenticatedCipher(key[32:64], make([]byte, chacha20.NonceSize))
+	return
+}
+
+func (e *encCipher) nonce() (n []byte) {
+	n = make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(n[4:12], e.seq)
+	e.seq++
+	return
+}
+
+// Returns the encrypted frame for the given message
+func (e *encCipher) seal(cmd string, pl []byte) []byte {
+	var hdr [ENC_HDR_LEN]byte
+	le := uint32(len(pl) + chacha20poly1305.Overhead)
+	hdr[0], hdr[1], hdr[2] = byte(le), byte(le>>8), byte(le>>16)
+	copy(hdr[3:15], cmd)
+	res := make([]byte, ENC_HDR_LEN, len(pl)+ENC_FRAME_OVERHEAD)
+	e.lenc.XORKeyStream(res, hdr[:])
+	return e.aead.Seal(res, e.nonce(), pl, hdr[:])
+}
+
+// Decrypts the frame's header in place, returning the length of the body and the command
+func (e *encCipher) openHdr(hdr []byte) (le uint32, cmd string) {
+	e.lenc.XORKeyStream(hdr[0:ENC_HDR_LEN], hdr[0:ENC_HDR_LEN])
+	le = uint32(hdr[0]) | uint32(hdr[1])<<8 | uint32(hdr[2])<<16
+	cmd = strings.TrimRight(string(hdr[3:15]), "\000")
+	return
+}
+
+// Decrypts the frame's body, checking it together with its (decrypted) header
+func (e *encCipher) open(hdr, body []byte) ([]byte, error) {
+	return e.aead.Open(body[:0], e.nonce(), body, hdr[0:ENC_HDR_LEN])
+}
+
+
+// Derives keys for both directions and the session id (that peers can compare out of band)
+func encKeys(shared, pub_out, pub_in []byte) (out_key, in_key, session []byte) {
+	ikm := append(append(append([]byte{}, shared...), pub_out...), pub_in...)
+	rd := hkdf.New(sha256.New, ikm, append([]byte("wotscoin transport"), common.Params.Magic[:]...), nil)
+	out_key = make([]byte, 64)
+	in_key = make([]byte, 64)
+	session = make([]byte, 32)
+	io.ReadFull(rd, out_key)
+	io.ReadFull(rd, in_key)
+	io.ReadFull(rd, session)
+	return
+}
+
+
+func EncryptionEnabled() bool {
+	return (common.Services & SERVICE_ENCRYPTED) != 0
+}
+
+
+// Called by the outgoing side after receiving the version message
+func (c *OneConnection) SendEncInit() {
+	if c.X.Incomming || !EncryptionEnabled() || (c.Node.Services & SERVICE_ENCRYPTED) == 0 {
+		return
+	}
+	priv := make([]byte, curve25519.ScalarSize)
+	rand.Read(priv)
+	pub, er := curve25519.X25519(priv, curve25519.Basepoint)
+	if er != nil {
+		return
+	}
+	c.Mutex.Lock()
+	c.enc.priv = priv
+	c.enc.pub = pub
+	c.Mutex.Unlock()
+	c.SendRawMsg("encinit", pub)
+}
+
+
+// The incoming side: "encinit" received
+func (c *OneConnection) ProcessEncInit(pl []byte) {
+	if !c.X.Incomming || !EncryptionEnabled() {
+		common.CountSafe("EncInitIgnored")
+		return
+	}
+	if len(pl) != curve25519.PointSize || c.enc.started {
+		c.DoS("EncInitBad")
+		return
+	}
+	priv := make([]byte, curve25519.ScalarSize)
+	rand.Read(priv)
+	pub, er := curve25519.X25519(priv, curve25519.Basepoint)
+	if er != nil {
+		return
+	}
+	shared, er := curve25519.X25519(priv, pl)
+	if er != nil {
+		c.DoS("EncInitKey")
+		return
+	}
+	out_key, in_key, session := encKeys(shared, pl, pub)
+
+	c.Mutex.Lock()
+	c.enc.started = true
+	c.enc.next_send = newEncCipher(in_key)
+	c.enc.next_recv = newEncCipher(out_key)
+	c.X.SessionID = hex.EncodeToString(session)
+	c.Mutex.Unlock()
+	c.SendRawMsg("encack", pub) // the last plaintext message we send
+}
+
+
+// The outgoing side: "encack" received
+func (c *OneConnection) ProcessEncAck(pl []byte) {
+	if c.enc.priv == nil || len(pl) != curve25519.PointSize {
+		c.DoS("EncAckBad")
+		return
+	}
+	shared, er := curve25519.X25519(c.enc.priv, pl)
+	if er != nil {
+		c.DoS("EncAckKey")
+		return
+	}
+	out_key, in_key, session := encKeys(shared, c.enc.pub, pl)
+
+	c.Mutex.Lock()
+	c.enc.priv = nil
+	c.enc.started = true
+	c.enc.recv = newEncCipher(in_key) // everything after "encack" comes encrypted
+	c.enc.next_send = newEncCipher(out_key)
+	c.X.SessionID = hex.EncodeToString(session)
+	c.Mutex.Unlock()
+	c.SendRawMsg("encon", nil) // the last plaintext message we send
+	common.CountSafe("EncSessionOut")
+}
+
+
+// The incoming side: "encon" received - the peer's messages will be encrypted from now on
+func (c *OneConnection) ProcessEncOn() {
+	if c.enc.next_recv == nil {
+		c.DoS("EncOnBad")
+		return
+	}
+	c.Mutex.Lock()
+	c.enc.recv = c.enc.next_recv
+	c.enc.next_recv = nil
+	c.X.Encrypted = true
+	c.Mutex.Unlock()
+	common.CountSafe("EncSessionIn")
+}
+
+
+// Called from SendRawMsg (with the mutex locked) after putting a message into the send buffer
+func (c *OneConnection) encSwitchSend(cmd string) {
+	if c.enc.next_send != nil && (cmd == "encack" || cmd == "encon") {
+		c.enc.send = c.enc.next_send
+		c.enc.next_send = nil
+		if cmd == "encon" {
+			c.X.Encrypted = true
+		}
+	}
+}
+
+
+// FetchMessage for the encrypted transport
+func (c *OneConnection) fetchEncrypted() (ret *BCmsg, timeout_or_data bool) {
+	var e error
+	var n int
+
+	for c.recv.hdr_len < ENC_HDR_LEN {
+		n, e = common.SockRead(c.Conn, c.recv.hdr[c.recv.hdr_len:ENC_HDR_LEN])
+		if n < 0 {
+			n = 0
+		} else {
+			timeout_or_data = true
+		}
+		c.Mutex.Lock()
+		if n > 0 {
+			c.X.BytesReceived += uint64(n)
+			c.X.LastDataGot = time.Now()
+			c.recv.hdr_len += n
+		}
+		if e != nil {
+			c.Mutex.Unlock()
+			c.HandleError(e)
+			return // Make sure to exit here, in case of timeout
+		}
+		if c.broken {
+			c.Mutex.Unlock()
+			return
+		}
+		if c.recv.hdr_len < ENC_HDR_LEN {
+			c.Mutex.Unlock()
+			return
+		}
+		c.recv.pl_len, c.recv.cmd = c.enc.recv.openHdr(c.recv.hdr[:])
+		c.Mutex.Unlock()
+		// checked before the body gets allocated (the header is authenticated with it later)
+		if c.recv.pl_len < chacha20poly1305.Overhead || c.recv.pl_len-chacha20poly1305.Overhead > maxmsgsize(c.recv.cmd) {
+			c.DoS("EncFrameLen")
+			return
+		}
+	}
+
+	if c.recv.dat == nil {
+		c.Mutex.Lock()
+		c.recv.dat = make([]byte, c.recv.pl_len)
+		c.recv.datlen = 0
+		c.Mutex.Unlock()
+	}
+	if c.recv.datlen < c.recv.pl_len {
+		n, e = common.SockRead(c.Conn, c.recv.dat[c.recv.datlen:])
+		if n < 0 {
+			n = 0
+		} else {
+			timeout_or_data = true
+		}
+		if n > 0 {
+			c.Mutex.Lock()
+			c.X.BytesReceived += uint64(n)
+			c.recv.datlen += uint32(n)
+			c.Mutex.Unlock()
+		}
+		if e != nil {
+			c.HandleError(e)
+			return
+		}
+		if c.MutexGetBool(&c.broken) || c.recv.datlen < c.recv.pl_len {
+			return
+		}
+	}
+
+	pl, er := c.enc.recv.open(c.recv.hdr[:], c.recv.dat)
+	if er != nil {
+		c.DoS("EncBadFrame")
+		return
+	}
+
+	ret = new(BCmsg)
+	ret.cmd = c.recv.cmd
+	ret.pl = pl
+
+	c.Mutex.Lock()
+	c.recv.hdr_len = 0
+	c.recv.cmd = ""
+	c.recv.dat = nil
+	c.Mutex.Unlock()
+
+	c.LastMsgTime = time.Now()
+
+	return
+}
+
+
+// Returns the transport name, as shown in the UIs (call it with locked mutex)
+func (c *OneConnection) TransportName() string {
+	if c.X.Encrypted {
+		return "v2"
+	}
+	return "v1"
+}
@@ -0,0 +1,40 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The receiver gets the length and the command before the payload, and a
+// changed header fails the payload's tag
+func TestEncFrame(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 64)
+	snd, rcv := newEncCipher(key), newEncCipher(key)
+
+	for _, cmd := range []string{"ping", "block"} {
+		pl := bytes.Repeat([]byte{1}, 100)
+		fr := snd.seal(cmd, pl)
+		if len(fr) != len(pl)+ENC_FRAME_OVERHEAD {
+			t.Fatal("Frame length", len(fr))
+		}
+		le, c := rcv.openHdr(fr)
+		if c != cmd || int(le) != len(fr)-ENC_HDR_LEN {
+			t.Fatal("Header", c, le)
+		}
+		if res, er := rcv.open(fr, fr[ENC_HDR_LEN:]); er != nil || !bytes.Equal(res, pl) {
+			t.Fatal("Payload", er)
+		}
+	}
+
+	// "tx" turned into "block" by flipping the bits of the encrypted command
+	fr := snd.seal("tx", []byte{1, 2, 3})
+	for i, b := range []byte("block") {
+		fr[3+i] ^= b ^ "tx\000\000\000"[i]
+	}
+	if _, c := rcv.openHdr(fr); c != "block" {
+		t.Fatal("Command", c)
+	}
+	if _, er := rcv.open(fr, fr[ENC_HDR_LEN:]); er == nil {
+		t.Error("Changed header accepted")
+	}
+}
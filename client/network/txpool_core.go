@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/client/events"
 	"github.com/lentus/wotscoin/lib/btc"
 	"github.com/lentus/wotscoin/lib/chain"
 	"github.com/lentus/wotscoin/lib/script"
@@ -19,6 +20,7 @@ const (
 	TX_REJECTED_FORMAT       = 102
 	TX_REJECTED_LEN_MISMATCH = 103
 	TX_REJECTED_EMPTY_INPUT  = 104
+	TX_REJECTED_PACKAGE      = 105
 
 	TX_REJECTED_OVERSPEND = 154
 	TX_REJECTED_BAD_INPUT = 157
@@ -32,6 +34,8 @@ const (
 	TX_REJECTED_RBF_FINAL   = 211
 	TX_REJECTED_RBF_100     = 212
 	TX_REJECTED_REPLACED    = 213
+	TX_REJECTED_XNYSS_KEY   = 214
+	TX_REJECTED_RBF_XNYSS   = 215
 )
 
 var (
@@ -55,6 +59,9 @@ var (
 	// Transactions that are waiting for inputs:
 	WaitingForInputs     map[BIDX]*OneWaitingList = make(map[BIDX]*OneWaitingList)
 	WaitingForInputsSize uint64
+
+	// Hashes of the XNYSS public keys that have signed the txs in TransactionsToSend:
+	XnyssKeysUsed map[[32]byte]BIDX = make(map[[32]byte]BIDX)
 )
 
 type OneTxToSend struct {
@@ -70,6 +77,8 @@ type OneTxToSend struct {
 	SigopsCost  uint64
 	Final       bool // if true RFB will not work on it
 	VerifyTime  time.Duration
+	XnyssKeys   [][32]byte // which records in XnyssKeysUsed this TX added
+	Orphaned    [][32]byte // UPKH children advertised by the txs that this one has replaced (see xnyssOrphans)
 }
 
 type OneTxRejected struct {
@@ -101,6 +110,8 @@ func ReasonToString(reason byte) string {
 		return "LEN_MISMATCH"
 	case TX_REJECTED_EMPTY_INPUT:
 		return "EMPTY_INPUT"
+	case TX_REJECTED_PACKAGE:
+		return "PACKAGE"
 	case TX_REJECTED_OVERSPEND:
 		return "OVERSPEND"
 	case TX_REJECTED_BAD_INPUT:
@@ -121,29 +132,42 @@ func ReasonToString(reason byte) string {
 		return "RBF_100"
 	case TX_REJECTED_REPLACED:
 		return "REPLACED"
+	case TX_REJECTED_XNYSS_KEY:
+		return "XNYSS_KEY"
+	case TX_REJECTED_RBF_XNYSS:
+		return "RBF_XNYSS"
 	}
 	return fmt.Sprint("UNKNOWN_", reason)
 }
 
+// Results of NeedThisTxExt
+const (
+	TX_NEEDED = iota
+	TX_NOT_NEEDED_MEMPOOL
+	TX_NOT_NEEDED_REJECTED
+	TX_NOT_NEEDED_PENDING
+	TX_NOT_NEEDED_MINED
+)
+
 func NeedThisTx(id *btc.Uint256, cb func()) (res bool) {
-	return NeedThisTxExt(id, cb) == 0
+	return NeedThisTxExt(id, cb) == TX_NEEDED
 }
 
 // Return false if we do not want to receive a data for this tx
 func NeedThisTxExt(id *btc.Uint256, cb func()) (why_not int) {
 	TxMutex.Lock()
 	if _, present := TransactionsToSend[id.BIdx()]; present {
-		why_not = 1
+		why_not = TX_NOT_NEEDED_MEMPOOL
 	} else if _, present := TransactionsRejected[id.BIdx()]; present {
-		why_not = 2
+		why_not = TX_NOT_NEEDED_REJECTED
 	} else if _, present := TransactionsPending[id.BIdx()]; present {
-		why_not = 3
+		why_not = TX_NOT_NEEDED_PENDING
 	} else if common.BlockChain.Unspent.TxPresent(id) {
-		why_not = 4
+		why_not = TX_NOT_NEEDED_MINED
 		// This assumes that tx's out #0 has not been spent yet, which may not always be the case, but well...
 		common.CountSafe("TxAlreadyMined")
 	} else {
-		// why_not = 0
+		// why_not = TX_NEEDED
 		if cb != nil {
 			cb()
 		}
@@ -237,6 +261,10 @@ func (c *OneConnection) ParseTxNet(pl []byte) {
 
 // Must be called from the chain's thread
 func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
+	if ntx.pkg != nil {
+		return HandleNetPackage(ntx.pkg) == nil
+	}
+
 	common.CountSafe("HandleNetTx")
 
 	tx := ntx.Tx
@@ -263,6 +291,15 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 		deleteRejected(tx.Hash.BIdx())
 	}
 
+	if !ntx.inpkg && common.CFG.TXPool.Packages {
+		// Parents that we have rejected for low fee may get paid for by this one
+		if parents := lowFeeParents(tx); parents != nil {
+			TxMutex.Unlock()
+			common.CountSafe("TxTryAsPackage")
+			return HandleNetPackage(&OnePackage{Txs: append(parents, tx), conn: ntx.conn, trusted: ntx.trusted}) == nil
+		}
+	}
+
 	pos := make([]*btc.TxOut, len(tx.TxIn))
 	spent := make([]uint64, len(tx.TxIn))
 
@@ -424,7 +461,7 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 
 	// Check for a proper fee
 	fee := totinp - totout
-	if !ntx.local && fee < (uint64(tx.VSize())*common.MinFeePerKB()/1000)  { // do not check minimum fee for locally loaded txs
+	if !ntx.local && !ntx.inpkg && fee < (uint64(tx.VSize())*common.MinFeePerKB()/1000)  { // do not check minimum fee for locally loaded txs
 		RejectTx(ntx.Tx, TX_REJECTED_LOW_FEE)
 		TxMutex.Unlock()
 		common.CountSafe("TxRejectedLowFee")
@@ -448,18 +485,45 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 		}
 	}
 
+	// Only one tx signed by each XNYSS key can get mined
+	xkeys := xnyssKeys(tx)
+	for i, k := range xkeys {
+		var conflict bool
+		if so, ok := XnyssKeysUsed[k]; ok {
+			if rbf_tx_list[TransactionsToSend[so]] {
+				// A replacement must be signed with new nodes - a second signature
+				// of the same one-time key would let others forge the next one.
+				RejectTx(ntx.Tx, TX_REJECTED_RBF_XNYSS)
+				TxMutex.Unlock()
+				common.CountSafe("TxRejectedRBFXnyss")
+				return
+			}
+			conflict = true
+		}
+		for _, k2 := range xkeys[:i] {
+			conflict = conflict || k2 == k
+		}
+		if conflict {
+			RejectTx(ntx.Tx, TX_REJECTED_XNYSS_KEY)
+			TxMutex.Unlock()
+			common.CountSafe("TxRejectedXnyssKey")
+			return
+		}
+	}
+
 	sigops := btc.WITNESS_SCALE_FACTOR * tx.GetLegacySigOpCount()
 
 	if !ntx.trusted { // Verify scripts
 		var wg sync.WaitGroup
 		var ver_err_cnt uint32
+		ver_flags := StandardVerifyFlags()
 
 		prev_dbg_err := script.DBG_ERR
 		script.DBG_ERR = false // keep quiet for incorrect txs
 		for i := range tx.TxIn {
 			wg.Add(1)
 			go func(prv []byte, amount uint64, i int, tx *btc.Tx) {
-				if !script.VerifyTxScript(prv, amount, i, tx, script.STANDARD_VERIFY_FLAGS) {
+				if !script.VerifyTxScript(prv, amount, i, tx, ver_flags) {
 					atomic.AddUint32(&ver_err_cnt, 1)
 				}
 				wg.Done()
@@ -490,17 +554,24 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 		sigops += uint(tx.CountWitnessSigOps(i, pos[i].Pk_script))
 	}
 
+	var orphaned [][32]byte
 	if rbf_tx_list != nil {
+		orphaned = xnyssOrphans(rbf_tx_list, tx)
+		if len(orphaned) > 0 {
+			common.CountSafeAdd("TxRBFOrphanedUpkh", uint64(len(orphaned)))
+		}
 		for ctx, _ := range rbf_tx_list {
 			// we dont remove with children because we have all of them on the list
 			ctx.Delete(false, TX_REJECTED_REPLACED)
+			ntx.replaced = append(ntx.replaced, ctx)
 			common.CountSafe("TxRemovedByRBF")
 		}
 	}
 
 	rec := &OneTxToSend{Spent: spent, Volume: totinp, Local : ntx.local,
 		Fee: fee, Firstseen: time.Now(), Tx: tx, MemInputs: frommem, MemInputCnt: frommemcnt,
-		SigopsCost: uint64(sigops), Final: final, VerifyTime: time.Now().Sub(start_time)}
+		SigopsCost: uint64(sigops), Final: final, VerifyTime: time.Now().Sub(start_time), XnyssKeys: xkeys,
+		Orphaned: orphaned}
 
 	TransactionsToSend[tx.Hash.BIdx()] = rec
 
@@ -518,6 +589,9 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 	for i := range spent {
 		SpentOutputs[spent[i]] = tx.Hash.BIdx()
 	}
+	for _, k := range xkeys {
+		XnyssKeysUsed[k] = tx.Hash.BIdx()
+	}
 
 	wtg := WaitingForInputs[tx.Hash.BIdx()]
 	if wtg != nil {
@@ -526,15 +600,11 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 
 	TxMutex.Unlock()
 	common.CountSafe("TxAccepted")
+	events.TxAccepted(tx, pos, fee, ntx.local)
 
-	if frommem != nil && !common.GetBool(&common.CFG.TXRoute.MemInputs) {
-		// By default Gocoin does not route txs that spend unconfirmed inputs
-		rec.Blocked = TX_REJECTED_NOT_MINED
-		common.CountSafe("TxRouteNotMined")
-	} else if !ntx.trusted && rec.isRoutable() {
-		// do not automatically route loacally loaded txs
-		rec.Invsentcnt += NetRouteInvExt(1, &tx.Hash, ntx.conn, 1000*fee/uint64(len(ntx.Raw)))
-		common.CountSafe("TxRouteOK")
+	if !ntx.inpkg {
+		// the package's txs only get routed once all of them are in
+		rec.route(ntx.conn, ntx.trusted)
 	}
 
 	if ntx.conn != nil {
@@ -548,6 +618,46 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 	return
 }
 
+// Returns the hashes of the child keys that the tx's XNYSS signatures advertise
+// (they become UPKH records when the tx gets mined).
+func xnyssChildren(tx *btc.Tx) (res [][32]byte) {
+	for i := range tx.TxIn {
+		if sig := tx.XnyssSignature(i); sig != nil {
+			for _, ch := range sig.ChildHashes {
+				var h [32]byte
+				copy(h[:], ch)
+				res = append(res, h)
+			}
+		}
+	}
+	return
+}
+
+// Returns the child keys advertised by the replaced txs that the replacement does not advertise.
+// They will not get confirmed, unless one of the replaced txs gets mined after all, so the wallet
+// that has signed them should retire them (see "wallet -bump").
+func xnyssOrphans(replaced map[*OneTxToSend]bool, tx *btc.Tx) (res [][32]byte) {
+	kept := make(map[[32]byte]bool)
+	for _, h := range xnyssChildren(tx) {
+		kept[h] = true
+	}
+	for ctx := range replaced {
+		for _, h := range xnyssChildren(ctx.Tx) {
+			if !kept[h] {
+				res = append(res, h)
+			}
+		}
+	}
+	return
+}
+
+// Returns the script verification flags for the memory pool txs.
+// On top of the standard ones, it includes the flags of BIP9 deployments
+// (i.e. XNYSS) that are active for the next block.
+func StandardVerifyFlags() uint32 {
+	return script.STANDARD_VERIFY_FLAGS | common.BlockChain.DeploymentFlags(common.BlockChain.LastBlock())
+}
+
 func (rec *OneTxToSend) isRoutable() bool {
 	if !common.CFG.TXRoute.Enabled {
 		common.CountSafe("TxRouteDisabled")
@@ -567,6 +677,19 @@ func (rec *OneTxToSend) isRoutable() bool {
 	return true
 }
 
+// Announces the tx that has just been accepted to the peers (unless it cannot be routed)
+func (rec *OneTxToSend) route(conn *OneConnection, trusted bool) {
+	if rec.MemInputs != nil && !common.GetBool(&common.CFG.TXRoute.MemInputs) {
+		// By default Gocoin does not route txs that spend unconfirmed inputs
+		rec.Blocked = TX_REJECTED_NOT_MINED
+		common.CountSafe("TxRouteNotMined")
+	} else if !trusted && rec.isRoutable() {
+		// do not automatically route loacally loaded txs
+		rec.Invsentcnt += NetRouteInvExt(1, &rec.Hash, conn, 1000*rec.Fee/uint64(len(rec.Raw)))
+		common.CountSafe("TxRouteOK")
+	}
+}
+
 func RetryWaitingForInput(wtg *OneWaitingList) {
 	for k, _ := range wtg.Ids {
 		pendtxrcv := &TxRcvd{Tx: TransactionsRejected[k].Tx}
@@ -583,6 +706,12 @@ func RetryWaitingForInput(wtg *OneWaitingList) {
 // Delete all the children as well if with_children is true
 // If reason is not zero, add the deleted txs to the rejected list
 func (tx *OneTxToSend) Delete(with_children bool, reason byte) {
+	tx.DeleteWhy(with_children, reason, ReasonToString(reason))
+}
+
+// Same as Delete, but with the reason given to the event subscribers
+// (for the removals that do not reject the tx: MINED, CONFLICT...)
+func (tx *OneTxToSend) DeleteWhy(with_children bool, reason byte, why string) {
 	if with_children {
 		// remove all the children that are spending from tx
 		var po btc.TxPrevOut
@@ -590,7 +719,7 @@ func (tx *OneTxToSend) Delete(with_children bool, reason byte) {
 		for po.Vout = 0; po.Vout < uint32(len(tx.TxOut)); po.Vout++ {
 			if so, ok := SpentOutputs[po.UIdx()]; ok {
 				if child, ok := TransactionsToSend[so]; ok {
-					child.Delete(true, reason)
+					child.DeleteWhy(true, reason, why)
 				}
 			}
 		}
@@ -599,6 +728,9 @@ func (tx *OneTxToSend) Delete(with_children bool, reason byte) {
 	for i := range tx.Spent {
 		delete(SpentOutputs, tx.Spent[i])
 	}
+	for _, k := range tx.XnyssKeys {
+		delete(XnyssKeysUsed, k)
+	}
 
 	TransactionsToSendSize -= uint64(len(tx.Raw))
 	TransactionsToSendWeight -= uint64(tx.Weight())
@@ -606,6 +738,26 @@ func (tx *OneTxToSend) Delete(with_children bool, reason byte) {
 	if reason != 0 {
 		RejectTx(tx.Tx, reason)
 	}
+	events.TxRemoved(tx.Tx, why)
+}
+
+// Make sure to call it with locked TxMutex
+// Puts back a tx that has been removed with Delete(false, ...),
+// i.e. replaced by a package that is being rolled back.
+func (tx *OneTxToSend) restore() {
+	bidx := tx.Hash.BIdx()
+	deleteRejected(bidx)
+	for i := range tx.Spent {
+		SpentOutputs[tx.Spent[i]] = bidx
+	}
+	for _, k := range tx.XnyssKeys {
+		XnyssKeysUsed[k] = bidx
+	}
+
+	TransactionsToSendSize += uint64(len(tx.Raw))
+	TransactionsToSendWeight += uint64(tx.Weight())
+	TransactionsToSend[bidx] = tx
+	events.TxAccepted(tx.Tx, nil, tx.Fee, tx.Local)
 }
 
 func txChecker(tx *btc.Tx) bool {
@@ -234,6 +234,15 @@ func MempoolLoad2() bool {
 		}
 	}
 
+	// recover XnyssKeysUsed
+	XnyssKeysUsed = make(map[[32]byte]BIDX)
+	for bidx, t2s := range TransactionsToSend {
+		t2s.XnyssKeys = xnyssKeys(t2s.Tx)
+		for _, k := range t2s.XnyssKeys {
+			XnyssKeysUsed[k] = bidx
+		}
+	}
+
 	fmt.Println(len(TransactionsToSend), "transactions taking", TransactionsToSendSize, "Bytes loaded from", MEMPOOL_FILE_NAME2)
 	fmt.Println(cnt1, "transactions use", cnt2, "memory inputs")
 
@@ -245,6 +254,7 @@ fatal_error:
 	TransactionsToSendSize = 0
 	TransactionsToSendWeight = 0
 	SpentOutputs = make(map[uint64]BIDX)
+	XnyssKeysUsed = make(map[[32]byte]BIDX)
 	return false
 }
 
@@ -58,7 +58,15 @@ func tx_mined(tx *btc.Tx) (wtg *OneWaitingList) {
 	if rec, ok := TransactionsToSend[h.BIdx()]; ok {
 		common.CountSafe("TxMinedToSend")
 		rec.UnMarkChildrenForMem()
-		rec.Delete(false, 0)
+		rec.DeleteWhy(false, 0, "MINED")
+	} else if len(XnyssKeysUsed) > 0 {
+		// the XNYSS keys that have signed it cannot sign any of our txs now
+		for _, k := range xnyssKeys(tx) {
+			if rec := TransactionsToSend[XnyssKeysUsed[k]]; rec != nil && rec.Hash.BIdx() == XnyssKeysUsed[k] {
+				common.CountSafe("TxMinedXnyssKey")
+				rec.DeleteWhy(true, 0, "CONFLICT")
+			}
+		}
 	}
 	if mr, ok := TransactionsRejected[h.BIdx()]; ok {
 		if mr.Tx != nil {
@@ -85,7 +93,7 @@ func tx_mined(tx *btc.Tx) (wtg *OneWaitingList) {
 				} else {
 					common.CountSafe("TxMinedOtherSpend")
 				}
-				rec.Delete(true, 0)
+				rec.DeleteWhy(true, 0, "CONFLICT")
 			} else {
 				common.CountSafe("TxMinedSpentERROR")
 				fmt.Println("WTF? Input from ", rec.Tx.Hash.String(), " in mem-spent, but tx not in the mem-pool")
@@ -0,0 +1,328 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/lib/btc"
+)
+
+/*
+	Package relay: a child tx together with its unconfirmed parents, evaluated by the
+	fee rate of the whole set. XNYSS txs are big, so a low fee parent followed by
+	a child that pays for both is quite common.
+
+	sendpackages - no payload, the peer wants to get our packages
+
+	pkgtxns - the package:
+		[vlen] - number of txs (2 to MAX_PACKAGE_COUNT)
+		[...] - the txs; parents first (sorted, so that no tx spends a later one), the child last
+*/
+
+const (
+	MAX_PACKAGE_COUNT  = 25
+	MAX_PACKAGE_WEIGHT = 404000
+)
+
+type OnePackage struct {
+	Txs   []*btc.Tx // the child is the last one
+	Local bool      // submitted by the user (TextUI/RPC)
+
+	// Filled in by HandleNetPackage():
+	New   []bool   // false for the txs that were already in the mempool or mined
+	Fees  []uint64 // fee of each new tx
+	Fee   uint64   // of all the new txs
+	VSize int      // of all the new txs
+	Error error
+
+	conn    *OneConnection
+	trusted bool
+	done    chan bool // for the submitters from outside of the chain's thread
+}
+
+// Returns the hashes of the XNYSS public keys that have signed the tx
+func xnyssKeys(tx *btc.Tx) (res [][32]byte) {
+	for i := range tx.TxIn {
+		if pkh, ok := tx.XnyssPubKeyHash(i); ok {
+			res = append(res, pkh)
+		}
+	}
+	return
+}
+
+// Returns the tx's parents that we have rejected because of a too low fee.
+// Make sure to call it with locked TxMutex.
+func lowFeeParents(tx *btc.Tx) (res []*btc.Tx) {
+	for i := range tx.TxIn {
+		if rej := TransactionsRejected[btc.BIdx(tx.TxIn[i].Input.Hash[:])]; rej != nil &&
+			rej.Reason == TX_REJECTED_LOW_FEE && rej.Tx != nil {
+			var dupa bool
+			for _, p := range res {
+				dupa = dupa || p == rej.Tx
+			}
+			if !dupa {
+				res = append(res, rej.Tx)
+			}
+		}
+	}
+	return
+}
+
+// Checks the limits and the topology: each of the parents must be spent by the child
+func (pkg *OnePackage) checkFormat() error {
+	if len(pkg.Txs) < 2 || len(pkg.Txs) > MAX_PACKAGE_COUNT {
+		return errors.New(fmt.Sprint("Number of txs must be 2 to ", MAX_PACKAGE_COUNT))
+	}
+	var weight int
+	idx := make(map[BIDX]int, len(pkg.Txs))
+	for i, tx := range pkg.Txs {
+		if _, ok := idx[tx.Hash.BIdx()]; ok {
+			return errors.New("Duplicate tx " + tx.Hash.String())
+		}
+		idx[tx.Hash.BIdx()] = i
+		weight += tx.Weight()
+	}
+	if weight > MAX_PACKAGE_WEIGHT {
+		return errors.New(fmt.Sprint("Package weight ", weight, " above ", MAX_PACKAGE_WEIGHT))
+	}
+
+	child := pkg.Txs[len(pkg.Txs)-1]
+	spent := make([]bool, len(pkg.Txs))
+	for _, in := range child.TxIn {
+		if i, ok := idx[btc.BIdx(in.Input.Hash[:])]; ok {
+			spent[i] = true
+		}
+	}
+	for i, tx := range pkg.Txs[:len(pkg.Txs)-1] {
+		if !spent[i] {
+			return errors.New("Tx " + tx.Hash.String() + " is not a parent of the child")
+		}
+		for _, in := range tx.TxIn {
+			if j, ok := idx[btc.BIdx(in.Input.Hash[:])]; ok && j > i {
+				return errors.New("Tx " + tx.Hash.String() + " spends a later one")
+			}
+		}
+	}
+	return nil
+}
+
+// Calculates the fees of the txs that we do not have yet.
+// Make sure to call it with locked TxMutex.
+func (pkg *OnePackage) calcFees() (reason byte, e error) {
+	outs := make(map[BIDX]*btc.Tx, len(pkg.Txs))
+	keys := make(map[[32]byte]bool)
+	pkg.New = make([]bool, len(pkg.Txs))
+	pkg.Fees = make([]uint64, len(pkg.Txs))
+	pkg.Fee, pkg.VSize = 0, 0
+
+	for i, tx := range pkg.Txs {
+		outs[tx.Hash.BIdx()] = tx
+		if _, ok := TransactionsToSend[tx.Hash.BIdx()]; ok || common.BlockChain.Unspent.TxPresent(&tx.Hash) {
+			continue
+		}
+		pkg.New[i] = true
+
+		for _, k := range xnyssKeys(tx) {
+			if keys[k] {
+				return TX_REJECTED_XNYSS_KEY, errors.New("XNYSS key used twice in " + tx.Hash.String())
+			}
+			keys[k] = true
+		}
+
+		var totinp, totout uint64
+		for _, in := range tx.TxIn {
+			var po *btc.TxOut
+			if ptx := outs[btc.BIdx(in.Input.Hash[:])]; ptx != nil {
+				if int(in.Input.Vout) < len(ptx.TxOut) {
+					po = ptx.TxOut[in.Input.Vout]
+				}
+			} else if mtx := TransactionsToSend[btc.BIdx(in.Input.Hash[:])]; mtx != nil {
+				if int(in.Input.Vout) < len(mtx.TxOut) {
+					po = mtx.TxOut[in.Input.Vout]
+				}
+			} else {
+				po = common.BlockChain.Unspent.UnspentGet(&in.Input)
+			}
+			if po == nil {
+				return TX_REJECTED_NO_TXOU, errors.New("Missing input " + in.Input.String())
+			}
+			totinp += po.Value
+		}
+		for _, out := range tx.TxOut {
+			totout += out.Value
+		}
+		if totout > totinp {
+			return TX_REJECTED_OVERSPEND, errors.New("Overspend in " + tx.Hash.String())
+		}
+		pkg.Fees[i] = totinp - totout
+		pkg.Fee += pkg.Fees[i]
+		pkg.VSize += tx.VSize()
+	}
+	return
+}
+
+// Evaluates the txs together: each one of them must be valid, but only the whole
+// package (without the txs that we already have) must pay the minimum fee.
+// Must be called from the chain's thread.
+func HandleNetPackage(pkg *OnePackage) (e error) {
+	common.CountSafe("HandleNetPkg")
+	defer func() {
+		pkg.Error = e
+		if pkg.done != nil {
+			pkg.done <- true
+		}
+	}()
+
+	if e = pkg.checkFormat(); e != nil {
+		common.CountSafe("PkgRejectedFormat")
+		return
+	}
+
+	TxMutex.Lock()
+	reason, e := pkg.calcFees()
+	if e == nil && pkg.Fee < uint64(pkg.VSize)*common.MinFeePerKB()/1000 {
+		reason, e = TX_REJECTED_LOW_FEE, errors.New(fmt.Sprint("Package fee ", pkg.Fee, " too low for ", pkg.VSize, " vbytes"))
+	}
+	if e != nil {
+		if child := pkg.Txs[len(pkg.Txs)-1]; pkg.New[len(pkg.Txs)-1] {
+			RejectTx(child, reason)
+		}
+		TxMutex.Unlock()
+		common.CountSafe("PkgRejected-" + ReasonToString(reason))
+		return
+	}
+	TxMutex.Unlock()
+
+	var accepted []*btc.Tx
+	var evicted []*OneTxToSend
+	for i, tx := range pkg.Txs {
+		if !pkg.New[i] {
+			continue
+		}
+		ntx := &TxRcvd{conn: pkg.conn, Tx: tx, trusted: pkg.trusted, inpkg: true}
+		ok := HandleNetTx(ntx, true)
+		evicted = append(evicted, ntx.replaced...)
+		if !ok {
+			TxMutex.Lock()
+			if rej := TransactionsRejected[tx.Hash.BIdx()]; rej != nil {
+				e = errors.New(tx.Hash.String() + " rejected: " + ReasonToString(rej.Reason))
+			} else {
+				e = errors.New(tx.Hash.String() + " rejected: script verification failed")
+			}
+			// take back the ones we have just added and put back the ones they have replaced
+			for j := len(accepted) - 1; j >= 0; j-- {
+				if rec := TransactionsToSend[accepted[j].Hash.BIdx()]; rec != nil {
+					rec.Delete(true, TX_REJECTED_PACKAGE)
+				}
+			}
+			for _, rec := range evicted {
+				rec.restore()
+			}
+			TxMutex.Unlock()
+			common.CountSafe("PkgRejectedTx")
+			return
+		}
+		accepted = append(accepted, tx)
+	}
+
+	// nothing has been announced so far, as the package might have been rolled back
+	var recs []*OneTxToSend
+	TxMutex.Lock()
+	for _, tx := range accepted {
+		if rec := TransactionsToSend[tx.Hash.BIdx()]; rec != nil {
+			rec.Local = rec.Local || pkg.Local
+			recs = append(recs, rec)
+		}
+	}
+	TxMutex.Unlock()
+	for _, rec := range recs {
+		rec.route(pkg.conn, pkg.trusted)
+	}
+	common.CountSafe("PkgAccepted")
+
+	if len(accepted) > 1 && common.CFG.TXRoute.Enabled {
+		var size int
+		for _, tx := range accepted {
+			size += len(tx.Raw)
+		}
+		NetRoutePackage(accepted, pkg.conn, 1000*pkg.Fee/uint64(size))
+	}
+	return
+}
+
+// Queues the package for the chain's thread and waits for the result
+func SubmitPackage(pkg *OnePackage) error {
+	pkg.done = make(chan bool, 1)
+	NetTxs <- &TxRcvd{Tx: pkg.Txs[len(pkg.Txs)-1], pkg: pkg}
+	<-pkg.done
+	return pkg.Error
+}
+
+func packagePayload(txs []*btc.Tx) []byte {
+	b := new(bytes.Buffer)
+	btc.WriteVlen(b, uint64(len(txs)))
+	for _, tx := range txs {
+		b.Write(tx.Raw)
+	}
+	return b.Bytes()
+}
+
+// Sends the package to all the peers that want it (except fromConn)
+func NetRoutePackage(txs []*btc.Tx, fromConn *OneConnection, fee_spkb uint64) (cnt uint32) {
+	pl := packagePayload(txs)
+	Mutex_net.Lock()
+	for _, v := range OpenCons {
+		if v == fromConn {
+			continue
+		}
+		v.Mutex.Lock()
+		send := v.Node.SendPackages && !v.Node.DoNotRelayTxs && (v.X.MinFeeSPKB <= 0 || uint64(v.X.MinFeeSPKB) <= fee_spkb)
+		v.Mutex.Unlock()
+		if send {
+			v.SendRawMsg("pkgtxns", pl)
+			cnt++
+		}
+	}
+	Mutex_net.Unlock()
+	common.CountSafeAdd("PkgRouted", uint64(cnt))
+	return
+}
+
+// Handle incoming "pkgtxns" msg
+func (c *OneConnection) ParsePkgTxsNet(pl []byte) {
+	// the number of txs always fits in one byte of the var_int
+	if len(pl) == 0 || pl[0] < 2 || pl[0] > MAX_PACKAGE_COUNT {
+		c.DoS("PkgBadCount")
+		return
+	}
+	cnt, of := int(pl[0]), 1
+	txs := make([]*btc.Tx, cnt)
+	for i := range txs {
+		tx, le := btc.NewTx(pl[of:])
+		if tx == nil || len(tx.TxIn) < 1 {
+			c.DoS("PkgTxBroken")
+			return
+		}
+		tx.SetHash(pl[of : of+le])
+		txs[i] = tx
+		of += le
+	}
+	if of != len(pl) {
+		c.DoS("PkgLenMismatch")
+		return
+	}
+
+	pkg := &OnePackage{Txs: txs, conn: c, trusted: c.X.Authorized}
+	if er := pkg.checkFormat(); er != nil {
+		c.Misbehave("PkgBadFormat", 100)
+		return
+	}
+
+	select {
+	case NetTxs <- &TxRcvd{conn: c, Tx: txs[cnt-1], pkg: pkg}:
+		common.CountSafe("PkgQueued")
+	default:
+		common.CountSafe("PkgRejectedFullQ")
+	}
+}
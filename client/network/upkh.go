@@ -0,0 +1,108 @@
+package network
+
+import (
+	"time"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/client/common"
+)
+
+// Answering remote XNYSS key confirmation queries (see lib/btc/upkh_msg.go)
+
+const (
+	SERVICE_UPKH = btc.SERVICE_UPKH
+
+	UPKH_HASHES_PER_SEC = btc.UPKH_HASHES_PER_SEC // how fast the per connection budget of looked up hashes grows
+	UPKH_MAX_BUDGET = btc.MAX_GETUPKH_SIZE
+)
+
+
+// Takes n hashes from the connection's budget. Returns false if the peer asks too much.
+func (c *OneConnection) upkhBudget(n int) bool {
+	now := time.Now()
+	c.upkh.budget += now.Sub(c.upkh.last).Seconds() * UPKH_HASHES_PER_SEC
+	if c.upkh.budget > UPKH_MAX_BUDGET {
+		c.upkh.budget = UPKH_MAX_BUDGET
+	}
+	c.upkh.last = now
+	if float64(n) > c.upkh.budget {
+		return false
+	}
+	c.upkh.budget -= float64(n)
+	return true
+}
+
+
+func (c *OneConnection) ProcessGetUpkh(pl []byte) {
+	if (common.Services & SERVICE_UPKH) == 0 {
+		common.CountSafe("GetUpkhDisabled")
+		return
+	}
+	hashes, er := btc.ParseGetUpkh(pl)
+	if er != nil {
+		c.DoS("BadGetUpkh")
+		return
+	}
+	if !c.upkhBudget(len(hashes)) {
+		common.CountSafe("GetUpkhRateLimit")
+		c.Misbehave("GetUpkhRate", 1000/20)
+		return
+	}
+
+	c.SendRawMsg("upkh", UpkhAnswers(hashes).Bytes())
+}
+
+
+// Looks up the given public key hashes in the UPKH database (also used by the getupkh RPC)
+func UpkhAnswers(hashes [][32]byte) (msg *btc.UpkhMsg) {
+	msg = new(btc.UpkhMsg)
+	common.Last.Mutex.Lock()
+	last := common.Last.Block
+	common.Last.Mutex.Unlock()
+	msg.TipHash = *last.BlockHash
+	msg.TipHeight = last.Height
+
+	msg.Recs = make([]*btc.UpkhAnswer, len(hashes))
+	heights := make(map[uint32]*btc.Uint256)
+	for i := range hashes {
+		r := &btc.UpkhAnswer{PubKeyHash: hashes[i]}
+		if rec := common.BlockChain.Unspent.UpkhGet(hashes[i]); rec != nil && rec.Blockheight <= msg.TipHeight {
+			r.LongTermHash = rec.LongTermHash
+			r.Depth = msg.TipHeight - rec.Blockheight + 1
+			heights[rec.Blockheight] = nil
+			common.CountSafe("GetUpkhFound")
+		}
+		msg.Recs[i] = r
+	}
+	common.CountSafeAdd("GetUpkhHashes", uint64(len(hashes)))
+
+	// The hashes of the blocks that advertised the keys, so that the wallet can
+	// tell when they get orphaned
+	if len(heights) > 0 {
+		left := len(heights)
+		common.BlockChain.BlockIndexAccess.Lock()
+		for n := last; n != nil && left > 0; n = n.Parent {
+			if _, ok := heights[n.Height]; ok {
+				heights[n.Height] = n.BlockHash
+				left--
+			}
+		}
+		common.BlockChain.BlockIndexAccess.Unlock()
+		for _, r := range msg.Recs {
+			if r.Depth > 0 {
+				if h := heights[msg.TipHeight-r.Depth+1]; h != nil {
+					r.BlockHash = *h
+				}
+			}
+		}
+	}
+	return
+}
+
+
+// Answers the unconfirmed.txt of a wallet with the content of its confirmed.txt
+// (all the hashes, also the ones not found, so the wallet can revoke them)
+func UpkhConfirmations(hashes [][32]byte) (f *btc.UpkhFile) {
+	msg := UpkhAnswers(hashes)
+	return &btc.UpkhFile{Kind: btc.UPKH_FILE_CONFIRMED, Network: common.Params.Name,
+		TipHash: msg.TipHash, TipHeight: msg.TipHeight, Recs: msg.Recs}
+}
@@ -33,6 +33,9 @@ type TxRcvd struct {
 	conn *OneConnection
 	*btc.Tx
 	trusted, local bool
+	inpkg bool // part of a package - do not check the fee
+	pkg *OnePackage // if set, Tx is the package's child
+	replaced []*OneTxToSend // set by HandleNetTx to the mempool txs that Tx has replaced
 }
 
 type OneBlockToGet struct {
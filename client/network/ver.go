@@ -87,7 +87,7 @@ func (c *OneConnection) HandleVersion(pl []byte) error {
 		c.Node.Timestamp = binary.LittleEndian.Uint64(pl[12:20])
 		c.Node.ReportedIp4 = binary.BigEndian.Uint32(pl[40:44])
 
-		use_this_ip := sys.ValidIp4(pl[40:44])
+		use_this_ip := sys.ValidIp4(pl[40:44]) && !c.X.ViaProxy // behind a proxy the peer sees the proxy's IP
 
 		if len(pl) >= 86 {
 			le, of := btc.VLen(pl[80:])
@@ -166,6 +166,10 @@ func (c *OneConnection) HandleVersion(pl []byte) error {
 	} else {
 		return errors.New("version message too short")
 	}
+	c.SendRawMsg("sendaddrv2", nil) // BIP155 says it must come before verack
+	if common.CFG.TXPool.Packages {
+		c.SendRawMsg("sendpackages", nil)
+	}
 	c.SendRawMsg("verack", []byte{})
 	return nil
 }
@@ -56,22 +56,12 @@ func SubmitBlock(cmd *RpcCommand, resp *RpcResponse, b []byte) {
 			return
 	}
 
-	bs := new(BlockSubmited)
-
-	bs.Block, er = btc.NewBlock(bd)
+	bs, er := submit_raw_block(bd)
 	if er != nil {
 		resp.Error = RpcError{Code: -4, Message: er.Error()}
 		return
 	}
 
-	network.MutexRcv.Lock()
-	network.ReceivedBlocks[bs.Block.Hash.BIdx()] = &network.OneReceivedBlock{TmStart: time.Now()}
-	network.MutexRcv.Unlock()
-
-	println("new block", bs.Block.Hash.String(), "len", len(bd), "- submitting...")
-	bs.Done.Add(1)
-	RpcBlocks <- bs
-	bs.Done.Wait()
 	if bs.Error != "" {
 		//resp.Error = RpcError{Code: -10, Message: bs.Error}
 		idx := strings.Index(bs.Error, "- RPC_Result:")
@@ -110,3 +100,65 @@ func SubmitBlock(cmd *RpcCommand, resp *RpcResponse, b []byte) {
 }
 
 var last_given_time, last_given_mintime uint32
+
+
+// Called from the blockchain thread, with the block passed by submit_raw_block
+func HandleRpcBlock(msg *BlockSubmited) {
+	common.CountSafe("RPCNewBlock")
+
+	network.MutexRcv.Lock()
+	rb := network.ReceivedBlocks[msg.Block.Hash.BIdx()]
+	network.MutexRcv.Unlock()
+	if rb == nil {
+		panic("Block " + msg.Block.Hash.String() + " not in ReceivedBlocks map")
+	}
+
+	common.BlockChain.Unspent.AbortWriting()
+	rb.TmQueue = time.Now()
+
+	e, _, _ := common.BlockChain.CheckBlock(msg.Block)
+	if e == nil {
+		e = common.BlockChain.AcceptBlock(msg.Block)
+		rb.TmAccepted = time.Now()
+	}
+	if e != nil {
+		common.CountSafe("RPCBlockError")
+		msg.Error = e.Error()
+		msg.Done.Done()
+		return
+	}
+
+	network.NetRouteInv(network.MSG_BLOCK, msg.Block.Hash, nil)
+	common.RecalcAverageBlockSize()
+
+	common.CountSafe("RPCBlockOK")
+	println("New mined block", msg.Block.Height, "accepted OK in", rb.TmAccepted.Sub(rb.TmQueue).String())
+
+	common.Last.Mutex.Lock()
+	common.Last.Time = time.Now()
+	common.Last.Block = common.BlockChain.LastBlock()
+	common.Last.Mutex.Unlock()
+
+	msg.Done.Done()
+}
+
+// Passes the block to the main thread and waits until it gets processed.
+// Check bs.Error for the result of the block's verification.
+func submit_raw_block(bd []byte) (bs *BlockSubmited, er error) {
+	bs = new(BlockSubmited)
+
+	bs.Block, er = btc.NewBlock(bd)
+	if er != nil {
+		return
+	}
+
+	network.MutexRcv.Lock()
+	network.ReceivedBlocks[bs.Block.Hash.BIdx()] = &network.OneReceivedBlock{TmStart: time.Now()}
+	network.MutexRcv.Unlock()
+
+	println("new block", bs.Block.Hash.String(), "len", len(bd), "- submitting...")
+	bs.Done.Add(1)
+	RpcBlocks <- bs
+	bs.Done.Wait()
+	return
+}
@@ -0,0 +1,244 @@
+package rpcapi
+
+import (
+	"fmt"
+	"encoding/hex"
+	"encoding/binary"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/chain"
+	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/client/network"
+)
+
+// Chain and blocks calls, compatible with Bitcoin Core's (plus a few XNYSS fields)
+
+type SoftForkResp struct {
+	Type   string `json:"type"`
+	Active bool   `json:"active"`
+	Bip9   struct {
+		Status    string `json:"status"`
+		Bit       uint8  `json:"bit"`
+		StartTime uint32 `json:"start_time"`
+		Timeout   uint32 `json:"timeout"`
+	} `json:"bip9"`
+}
+
+type BlockchainInfoResp struct {
+	Chain                string                   `json:"chain"`
+	Blocks               uint32                   `json:"blocks"`
+	Headers              uint32                   `json:"headers"`
+	BestBlockHash        string                   `json:"bestblockhash"`
+	Difficulty           float64                  `json:"difficulty"`
+	MedianTime           uint32                   `json:"mediantime"`
+	VerificationProgress float64                  `json:"verificationprogress"`
+	InitialBlockDownload bool                     `json:"initialblockdownload"`
+	Pruned               bool                     `json:"pruned"`
+	SoftForks            map[string]*SoftForkResp `json:"softforks"`
+	UpkhRecords          int                      `json:"upkh_records"` // XNYSS keys advertised, but not used yet
+	Warnings             string                   `json:"warnings"`
+}
+
+type BlockHeaderResp struct {
+	Hash              string  `json:"hash"`
+	Confirmations     int     `json:"confirmations"` // -1 if not on the main chain
+	Height            uint32  `json:"height"`
+	Version           uint32  `json:"version"`
+	VersionHex        string  `json:"versionHex"`
+	MerkleRoot        string  `json:"merkleroot"`
+	Time              uint32  `json:"time"`
+	MedianTime        uint32  `json:"mediantime"`
+	Nonce             uint32  `json:"nonce"`
+	Bits              string  `json:"bits"`
+	Difficulty        float64 `json:"difficulty"`
+	NTx               uint32  `json:"nTx"`
+	PreviousBlockHash string  `json:"previousblockhash,omitempty"`
+	NextBlockHash     string  `json:"nextblockhash,omitempty"`
+}
+
+type BlockResp struct {
+	*BlockHeaderResp
+	Size           int         `json:"size"`
+	StrippedSize   int         `json:"strippedsize"`
+	Weight         uint        `json:"weight"`
+	Tx             interface{} `json:"tx"` // txids, or decoded txs for verbosity 2
+	XnyssInputs    int         `json:"xnyss_inputs"`    // inputs signed with XNYSS one-time keys
+	UpkhAdvertised int         `json:"upkh_advertised"` // child keys advertised by their signatures
+}
+
+
+func chain_name() string {
+	switch common.Params.Name {
+	case "mainnet":
+		return "main"
+	case "testnet3":
+		return "test"
+	}
+	return common.Params.Name
+}
+
+// Returns the node of the block with the given hash (nil if we do not know it)
+func block_node(v interface{}) *chain.BlockTreeNode {
+	str, _ := v.(string)
+	hash := btc.NewUint256FromString(str)
+	if hash == nil {
+		return nil
+	}
+	common.BlockChain.BlockIndexAccess.Lock()
+	n := common.BlockChain.BlockIndex[hash.BIdx()]
+	common.BlockChain.BlockIndexAccess.Unlock()
+	return n
+}
+
+func block_header(n *chain.BlockTreeNode) (res *BlockHeaderResp) {
+	res = &BlockHeaderResp{Hash: n.BlockHash.String(), Height: n.Height, Version: n.BlockVersion(),
+		Time: n.Timestamp(), MedianTime: n.GetMedianTimePast(), Difficulty: btc.GetDifficulty(n.Bits()),
+		NTx: n.TxCount}
+	res.VersionHex = fmt.Sprintf("%08x", res.Version)
+	res.MerkleRoot = btc.NewUint256(n.BlockHeader[36:68]).String()
+	res.Nonce = binary.LittleEndian.Uint32(n.BlockHeader[76:80])
+	res.Bits = fmt.Sprintf("%08x", n.Bits())
+	if n.Parent != nil {
+		res.PreviousBlockHash = n.Parent.BlockHash.String()
+	}
+
+	if !common.BlockChain.OnActiveBranch(n) {
+		res.Confirmations = -1
+		return
+	}
+	res.Confirmations = int(common.BlockChain.LastBlock().Height-n.Height) + 1
+	common.BlockChain.BlockIndexAccess.Lock()
+	for _, c := range n.Childs {
+		if common.BlockChain.OnActiveBranch(c) {
+			res.NextBlockHash = c.BlockHash.String()
+			break
+		}
+	}
+	common.BlockChain.BlockIndexAccess.Unlock()
+	return
+}
+
+
+// RPC: getblockchaininfo
+func GetBlockchainInfo(cmd *RpcCommand, resp *RpcResponse) {
+	last := common.BlockChain.LastBlock()
+	res := &BlockchainInfoResp{Chain: chain_name(), Blocks: last.Height, BestBlockHash: last.BlockHash.String(),
+		Difficulty: btc.GetDifficulty(last.Bits()), MedianTime: last.GetMedianTimePast(),
+		InitialBlockDownload: !common.GetBool(&common.BlockChainSynchronized),
+		SoftForks: make(map[string]*SoftForkResp), UpkhRecords: common.BlockChain.Unspent.UpkhCount()}
+
+	network.MutexRcv.Lock()
+	res.Headers = network.LastCommitedHeader.Height
+	network.MutexRcv.Unlock()
+	if res.Headers > 0 {
+		res.VerificationProgress = float64(res.Blocks) / float64(res.Headers)
+	}
+	if res.VerificationProgress > 1 {
+		res.VerificationProgress = 1
+	}
+
+	for i := range common.BlockChain.Consensus.Deployments {
+		d := &common.BlockChain.Consensus.Deployments[i]
+		if d.Name == "" {
+			continue
+		}
+		state := common.BlockChain.DeploymentState(last, i)
+		sf := &SoftForkResp{Type: "bip9", Active: state == chain.BIP9_ACTIVE}
+		sf.Bip9.Status = chain.BIP9StateToString(state)
+		sf.Bip9.Bit = d.Bit
+		sf.Bip9.StartTime = d.StartTime
+		sf.Bip9.Timeout = d.Timeout
+		res.SoftForks[d.Name] = sf
+	}
+	resp.Result = res
+}
+
+
+// RPC: getbestblockhash
+func GetBestBlockHash(cmd *RpcCommand, resp *RpcResponse) {
+	resp.Result = common.BlockChain.LastBlock().BlockHash.String()
+}
+
+
+// RPC: getblockhash height
+func GetBlockHash(cmd *RpcCommand, resp *RpcResponse) {
+	height := param_int(param(cmd, 0), -1)
+	n := common.BlockChain.LastBlock()
+	if height < 0 || height > int(n.Height) {
+		resp.Error = RpcError{Code: -8, Message: "Block height out of range"}
+		return
+	}
+	common.BlockChain.BlockIndexAccess.Lock()
+	for n.Height > uint32(height) {
+		n = n.Parent
+	}
+	common.BlockChain.BlockIndexAccess.Unlock()
+	resp.Result = n.BlockHash.String()
+}
+
+
+// RPC: getblockheader blockhash [verbose=true]
+func GetBlockHeader(cmd *RpcCommand, resp *RpcResponse) {
+	n := block_node(param(cmd, 0))
+	if n == nil {
+		resp.Error = RpcError{Code: -5, Message: "Block not found"}
+		return
+	}
+	if !param_bool(param(cmd, 1), true) {
+		resp.Result = hex.EncodeToString(n.BlockHeader[:])
+		return
+	}
+	resp.Result = block_header(n)
+}
+
+
+// RPC: getblock blockhash [verbosity=1]
+func GetBlock(cmd *RpcCommand, resp *RpcResponse) {
+	n := block_node(param(cmd, 0))
+	if n == nil {
+		resp.Error = RpcError{Code: -5, Message: "Block not found"}
+		return
+	}
+	raw, _, er := common.BlockChain.Blocks.BlockGet(n.BlockHash)
+	if er != nil {
+		resp.Error = RpcError{Code: -1, Message: "Block not available"}
+		return
+	}
+
+	verbosity := param_int(param(cmd, 1), 1)
+	if verbosity == 0 {
+		resp.Result = hex.EncodeToString(raw)
+		return
+	}
+
+	bl, er := btc.NewBlock(raw)
+	if er == nil {
+		er = bl.BuildTxList()
+	}
+	if er != nil {
+		resp.Error = RpcError{Code: -1, Message: er.Error()}
+		return
+	}
+
+	res := &BlockResp{BlockHeaderResp: block_header(n), Size: len(raw), StrippedSize: bl.NoWitnessSize,
+		Weight: bl.BlockWeight}
+	txids := make([]string, len(bl.Txs))
+	var txs []*TxResp
+	for i, tx := range bl.Txs {
+		txids[i] = tx.Hash.String()
+		for j := range tx.TxIn {
+			if sig := tx.XnyssSignature(j); sig != nil {
+				res.XnyssInputs++
+				res.UpkhAdvertised += len(sig.ChildHashes)
+			}
+		}
+		if verbosity > 1 {
+			txs = append(txs, decode_tx(tx))
+		}
+	}
+	if verbosity > 1 {
+		res.Tx = txs
+	} else {
+		res.Tx = txids
+	}
+	resp.Result = res
+}
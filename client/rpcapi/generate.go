@@ -0,0 +1,177 @@
+package rpcapi
+
+import (
+	"bytes"
+	"errors"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/binary"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/client/common"
+)
+
+// Coinbase outputs go to this script if no address was given (anyone can spend it)
+var GenerateDefaultScript = []byte{0x51}
+
+var generate_extranonce uint32
+
+
+// Mines n blocks with the transactions from the memory pool, paying the
+// rewards to pkscr. Only works on networks without retargeting (regtest).
+// Returns hashes of the blocks that got accepted.
+func GenerateBlocks(n int, pkscr []byte) (hashes []string, e error) {
+	if !common.Params.PowNoRetargeting {
+		e = errors.New("Blocks can only be generated on regtest")
+		return
+	}
+	if pkscr == nil {
+		pkscr = GenerateDefaultScript
+	}
+	for ; n > 0; n-- {
+		var tmpl GetBlockTemplateResp
+		var bd []byte
+		var bs *BlockSubmited
+
+		GetNextBlockTemplate(&tmpl)
+		if bd, e = build_block(&tmpl, pkscr); e != nil {
+			return
+		}
+		if bs, e = submit_raw_block(bd); e != nil {
+			return
+		}
+		if bs.Error != "" {
+			e = errors.New(bs.Error)
+			return
+		}
+		hashes = append(hashes, bs.Block.Hash.String())
+	}
+	return
+}
+
+
+// Builds the coinbase transaction, paying value to pkscr, with witness commitment for txs
+func build_coinbase(height uint32, value uint64, pkscr []byte, txs []*btc.Tx) (cb *btc.Tx) {
+	var hgt [4]byte
+	scr := new(bytes.Buffer)
+
+	// BIP34 height, as expected by CheckBlock
+	binary.LittleEndian.PutUint32(hgt[:], height)
+	l := 4
+	for l > 1 && hgt[l-1] == 0 && hgt[l-2] < 0x80 {
+		l--
+	}
+	scr.WriteByte(byte(l))
+	scr.Write(hgt[:l])
+
+	generate_extranonce++
+	scr.WriteByte(4)
+	binary.Write(scr, binary.LittleEndian, generate_extranonce)
+
+	cb = new(btc.Tx)
+	cb.Version = 1
+	cb.TxIn = []*btc.TxIn{&btc.TxIn{Input: btc.TxPrevOut{Vout: 0xffffffff}, ScriptSig: scr.Bytes(), Sequence: 0xffffffff}}
+	cb.TxOut = []*btc.TxOut{&btc.TxOut{Value: value, Pk_script: pkscr}}
+
+	// BIP141 commitment, with all zero witness reserved value
+	reserved := make([]byte, 32)
+	cb.SegWit = [][][]byte{[][]byte{reserved}}
+	merkle, _ := btc.GetWitnessMerkle(append([]*btc.Tx{cb}, txs...))
+	commit := btc.Sha2Sum(append(merkle, reserved...))
+	cb.TxOut = append(cb.TxOut, &btc.TxOut{Pk_script: append([]byte{0x6a, 0x24, 0xaa, 0x21, 0xa9, 0xed}, commit[:]...)})
+
+	cb.SetHash(cb.SerializeNew())
+	return
+}
+
+
+// Turns the template into a serialized block with a valid proof of work
+func build_block(tmpl *GetBlockTemplateResp, pkscr []byte) (bd []byte, e error) {
+	var txs []*btc.Tx
+	for i := range tmpl.Transactions {
+		raw, er := hex.DecodeString(tmpl.Transactions[i].Data)
+		if er != nil {
+			e = er
+			return
+		}
+		tx, _ := btc.NewTx(raw)
+		if tx == nil {
+			e = errors.New("Cannot decode mempool tx " + tmpl.Transactions[i].Hash)
+			return
+		}
+		tx.SetHash(raw)
+		txs = append(txs, tx)
+	}
+
+	cb := build_coinbase(uint32(tmpl.Height), tmpl.Coinbasevalue, pkscr, txs)
+	txs = append([]*btc.Tx{cb}, txs...)
+
+	mtr := make([][32]byte, len(txs), 3*len(txs))
+	for i, tx := range txs {
+		mtr[i] = tx.Hash.Hash
+	}
+	merkle, _ := btc.CalcMerkle(mtr)
+
+	bits, _ := hex.DecodeString(tmpl.Bits)
+	hdr := make([]byte, 80)
+	binary.LittleEndian.PutUint32(hdr[0:4], tmpl.Version)
+	copy(hdr[4:36], btc.NewUint256FromString(tmpl.PreviousBlockHash).Hash[:])
+	copy(hdr[36:68], merkle)
+	binary.LittleEndian.PutUint32(hdr[68:72], uint32(tmpl.Curtime))
+	binary.LittleEndian.PutUint32(hdr[72:76], binary.BigEndian.Uint32(bits))
+	for nonce := uint32(0); ; nonce++ {
+		binary.LittleEndian.PutUint32(hdr[76:80], nonce)
+		if btc.CheckProofOfWork(btc.NewSha2Hash(hdr), binary.BigEndian.Uint32(bits)) {
+			break
+		}
+		if nonce == 0xffffffff {
+			e = errors.New("Nonce range exhausted")
+			return
+		}
+	}
+
+	buf := bytes.NewBuffer(hdr)
+	btc.WriteVlen(buf, uint64(len(txs)))
+	for _, tx := range txs {
+		buf.Write(tx.Raw)
+	}
+	bd = buf.Bytes()
+	return
+}
+
+
+// RPC: generate nblocks [address]
+func Generate(cmd *RpcCommand, resp *RpcResponse) {
+	var n int64
+	var pkscr []byte
+
+	uu, ok := cmd.Params.([]interface{})
+	if !ok || len(uu) < 1 {
+		resp.Error = RpcError{Code: -1, Message: "expected params: nblocks [address]"}
+		return
+	}
+	if num, ok := uu[0].(json.Number); ok {
+		n, _ = num.Int64()
+	}
+	if n <= 0 {
+		resp.Error = RpcError{Code: -8, Message: "invalid number of blocks"}
+		return
+	}
+	if len(uu) > 1 {
+		str, _ := uu[1].(string)
+		ad, er := btc.NewAddrFromString(str)
+		if er != nil || ad == nil || !common.Params.IsOwnAddr(ad) {
+			resp.Error = RpcError{Code: -5, Message: "Invalid address"}
+			return
+		}
+		pkscr = ad.OutScript()
+	}
+
+	hashes, er := GenerateBlocks(int(n), pkscr)
+	if er != nil {
+		resp.Error = RpcError{Code: -1, Message: er.Error()}
+		if len(hashes) == 0 {
+			return
+		}
+	}
+	resp.Result = hashes
+}
@@ -0,0 +1,100 @@
+package rpcapi
+
+import (
+	"os"
+	"time"
+	"testing"
+	"strings"
+	"io/ioutil"
+	"encoding/json"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/utxo"
+	"github.com/lentus/wotscoin/lib/chain"
+	"github.com/lentus/wotscoin/client/usif"
+	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/client/network"
+)
+
+// The handlers run against a regtest chain in a temporary folder (with the tx index),
+// with the submitted blocks and the UI requests processed as in the client's main loop.
+func TestMain(m *testing.M) {
+	dir, _ := ioutil.TempDir("", "rpcapi")
+	utxo.UTXO_RECORDS_PREALLOC = 1000
+	utxo.UPKH_RECORDS_PREALLOC = 1000
+	common.SetDefaultConfig()
+	common.CFG.TXPool.SaveOnDisk = false
+	common.Params = &btc.RegTestParams
+	common.GocoinHomeDir = dir + string(os.PathSeparator)
+	common.LockCfg()
+	common.Reset()
+	common.UnlockCfg()
+
+	common.BlockChain = chain.NewChainExt(common.GocoinHomeDir, common.Params, false,
+		&chain.NewChanOpts{BlockMinedCB: network.BlockMined, TxIndex: true}, &chain.BlockDBOpts{MaxCachedBlocks: 100})
+	common.Last.Block = common.BlockChain.LastBlock()
+	common.Last.Time = time.Now()
+
+	quit := make(chan bool)
+	go func() {
+		for {
+			select {
+			case bs := <-RpcBlocks:
+				HandleRpcBlock(bs)
+			case cmd := <-usif.UiChannel:
+				cmd.Handler(cmd.Param)
+				cmd.Done.Done()
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	res := m.Run()
+	quit <- true
+	common.BlockChain.Close()
+	os.RemoveAll(dir)
+	os.Exit(res)
+}
+
+// Calls the handler with the parameters given in JSON
+func rpcTestCall(t *testing.T, handler func(*RpcCommand, *RpcResponse), params string) (resp *RpcResponse) {
+	cmd := &RpcCommand{Method: "test"}
+	dec := json.NewDecoder(strings.NewReader(params))
+	dec.UseNumber()
+	if e := dec.Decode(&cmd.Params); e != nil {
+		t.Fatal(e.Error())
+	}
+	resp = new(RpcResponse)
+	handler(cmd, resp)
+	return
+}
+
+func TestGenerate(t *testing.T) {
+	height := common.BlockChain.LastBlock().Height
+	resp := rpcTestCall(t, Generate, `[2]`)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	hashes, _ := resp.Result.([]string)
+	last := common.BlockChain.LastBlock()
+	if len(hashes) != 2 || last.Height != height+2 || hashes[1] != last.BlockHash.String() {
+		t.Fatal("Blocks not mined", hashes, last.Height)
+	}
+
+	// the rewards go to the given address
+	ad := btc.NewAddrFromHash160(make([]byte, 20), common.Params.AddrVerPubkey)
+	if resp = rpcTestCall(t, Generate, `[1, "`+ad.String()+`"]`); resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	bl, _, _ := common.BlockChain.Blocks.BlockGet(common.BlockChain.LastBlock().BlockHash)
+	block, _ := btc.NewBlock(bl)
+	if block == nil || block.BuildTxList() != nil || string(block.Txs[0].TxOut[0].Pk_script) != string(ad.OutScript()) {
+		t.Error("Reward not paid to the address")
+	}
+
+	for _, params := range []string{`[0]`, `[]`, `[1, "bad"]`} {
+		if resp = rpcTestCall(t, Generate, params); resp.Error == nil {
+			t.Error("No error for", params)
+		}
+	}
+}
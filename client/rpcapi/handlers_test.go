@@ -0,0 +1,179 @@
+package rpcapi
+
+import (
+	"testing"
+	"encoding/hex"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/client/network"
+)
+
+// Mines a block on the test chain and returns it
+func mineTestBlock(t *testing.T) (bl *btc.Block) {
+	if resp := rpcTestCall(t, Generate, `[1]`); resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	raw, _, er := common.BlockChain.Blocks.BlockGet(common.BlockChain.LastBlock().BlockHash)
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	if bl, er = btc.NewBlock(raw); er == nil {
+		er = bl.BuildTxList()
+	}
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	return
+}
+
+func rpcErrorCode(resp *RpcResponse) int {
+	if e, ok := resp.Error.(RpcError); ok {
+		return e.Code
+	}
+	return 0
+}
+
+// A tx spending the coinbase of bl, put straight into the mempool (it does not need to be valid)
+func mempoolTestTx(bl *btc.Block) (tx *btc.Tx) {
+	tx = new(btc.Tx)
+	tx.Version = 1
+	tx.TxIn = []*btc.TxIn{&btc.TxIn{Input: btc.TxPrevOut{Hash: bl.Txs[0].Hash.Hash}, Sequence: 0xffffffff}}
+	tx.TxOut = []*btc.TxOut{&btc.TxOut{Value: 1000, Pk_script: []byte{0x51}}}
+	tx.SetHash(tx.Serialize())
+	network.TxMutex.Lock()
+	network.TransactionsToSend[tx.Hash.BIdx()] = &network.OneTxToSend{Tx: tx}
+	network.SpentOutputs[tx.TxIn[0].Input.UIdx()] = tx.Hash.BIdx()
+	network.TxMutex.Unlock()
+	return
+}
+
+func dropTestTx(tx *btc.Tx) {
+	network.TxMutex.Lock()
+	delete(network.TransactionsToSend, tx.Hash.BIdx())
+	delete(network.SpentOutputs, tx.TxIn[0].Input.UIdx())
+	network.TxMutex.Unlock()
+}
+
+func TestGetBlock(t *testing.T) {
+	bl := mineTestBlock(t)
+	hash := `"` + bl.Hash.String() + `"`
+
+	resp := rpcTestCall(t, GetBlock, `[`+hash+`, 0]`)
+	if s, _ := resp.Result.(string); s != hex.EncodeToString(bl.Raw) {
+		t.Error("Bad raw block", resp.Error)
+	}
+
+	resp = rpcTestCall(t, GetBlock, `[`+hash+`]`)
+	res, _ := resp.Result.(*BlockResp)
+	if res == nil {
+		t.Fatal("No block", resp.Error)
+	}
+	if txids, _ := res.Tx.([]string); len(txids) != 1 || txids[0] != bl.Txs[0].Hash.String() ||
+		res.Hash != bl.Hash.String() || res.Size != len(bl.Raw) {
+		t.Error("Bad block", res.Hash, res.Tx)
+	}
+
+	resp = rpcTestCall(t, GetBlock, `[`+hash+`, 2]`)
+	if res, _ = resp.Result.(*BlockResp); res == nil {
+		t.Fatal("No block", resp.Error)
+	}
+	if txs, _ := res.Tx.([]*TxResp); len(txs) != 1 || txs[0].TxID != bl.Txs[0].Hash.String() {
+		t.Error("Bad decoded txs", res.Tx)
+	}
+
+	for _, params := range []string{`["00"]`, `["` + btc.NewUint256(make([]byte, 32)).String() + `"]`} {
+		if resp = rpcTestCall(t, GetBlock, params); rpcErrorCode(resp) != -5 {
+			t.Error("No -5 error for", params, resp.Error)
+		}
+	}
+}
+
+func TestGetRawTransaction(t *testing.T) {
+	bl := mineTestBlock(t)
+	cb := bl.Txs[0]
+	txid := `"` + cb.Hash.String() + `"`
+
+	// from the tx index (Chain.GetRawTx returns it without the witness)
+	resp := rpcTestCall(t, GetRawTransaction, `[`+txid+`]`)
+	if s, _ := resp.Result.(string); s != hex.EncodeToString(cb.Serialize()) {
+		t.Error("Bad raw tx", resp.Result, resp.Error)
+	}
+	resp = rpcTestCall(t, GetRawTransaction, `[`+txid+`, true]`)
+	res, _ := resp.Result.(*RawTxResp)
+	if res == nil || res.TxID != cb.Hash.String() || res.BlockHash != bl.Hash.String() ||
+		res.Height != common.BlockChain.LastBlock().Height {
+		t.Error("Bad verbose tx", resp.Result, resp.Error)
+	}
+
+	// from the mempool
+	tx := mempoolTestTx(bl)
+	defer dropTestTx(tx)
+	resp = rpcTestCall(t, GetRawTransaction, `["`+tx.Hash.String()+`", 1]`)
+	if res, _ = resp.Result.(*RawTxResp); res == nil || res.TxID != tx.Hash.String() || res.BlockHash != "" {
+		t.Error("Bad mempool tx", resp.Result, resp.Error)
+	}
+
+	if resp = rpcTestCall(t, GetRawTransaction, `["`+btc.NewUint256(make([]byte, 32)).String()+`"]`); rpcErrorCode(resp) != -5 {
+		t.Error("No -5 error for unknown tx", resp.Error)
+	}
+	if resp = rpcTestCall(t, GetRawTransaction, `["xyz"]`); rpcErrorCode(resp) != -8 {
+		t.Error("No -8 error for bad txid", resp.Error)
+	}
+}
+
+func TestSendRawTransaction(t *testing.T) {
+	bl := mineTestBlock(t)
+
+	resp := rpcTestCall(t, SendRawTransaction, `["`+hex.EncodeToString(bl.Txs[0].Raw)+`"]`)
+	if rpcErrorCode(resp) != -27 {
+		t.Error("Mined tx not refused", resp.Result, resp.Error)
+	}
+
+	if resp = rpcTestCall(t, SendRawTransaction, `["00ff"]`); rpcErrorCode(resp) != -22 {
+		t.Error("Broken tx not refused", resp.Error)
+	}
+
+	// spending an output that does not exist
+	tx := new(btc.Tx)
+	tx.Version = 1
+	tx.TxIn = []*btc.TxIn{&btc.TxIn{Input: btc.TxPrevOut{Hash: [32]byte{1}}, Sequence: 0xffffffff}}
+	tx.TxOut = []*btc.TxOut{&btc.TxOut{Value: 1000, Pk_script: []byte{0x51}}}
+	raw := tx.Serialize()
+	resp = rpcTestCall(t, SendRawTransaction, `["`+hex.EncodeToString(raw)+`"]`)
+	if e, _ := resp.Error.(RpcError); e.Code != -26 || e.Message != network.ReasonToString(network.TX_REJECTED_NO_TXOU) {
+		t.Error("Bad tx not rejected", resp.Result, resp.Error)
+	}
+}
+
+func TestGetTxOut(t *testing.T) {
+	bl := mineTestBlock(t)
+	cb := bl.Txs[0]
+	txid := `"` + cb.Hash.String() + `"`
+
+	resp := rpcTestCall(t, GetTxOut, `[`+txid+`, 0]`)
+	res, _ := resp.Result.(*TxOutResp)
+	if res == nil || res.Confirmations != 1 || !res.Coinbase || res.Value != float64(cb.TxOut[0].Value)/1e8 ||
+		res.BestBlock != bl.Hash.String() {
+		t.Fatal("Bad unspent output", resp.Result, resp.Error)
+	}
+	if resp = rpcTestCall(t, GetTxOut, `[`+txid+`, 9]`); resp.Result != nil || resp.Error != nil {
+		t.Error("Output that does not exist", resp.Result, resp.Error)
+	}
+	if resp = rpcTestCall(t, GetTxOut, `[`+txid+`]`); rpcErrorCode(resp) != -8 {
+		t.Error("No -8 error without n", resp.Error)
+	}
+
+	// spent in the mempool, unless it is not included
+	tx := mempoolTestTx(bl)
+	defer dropTestTx(tx)
+	if resp = rpcTestCall(t, GetTxOut, `[`+txid+`, 0]`); resp.Result != nil {
+		t.Error("Output spent in the mempool returned")
+	}
+	if resp = rpcTestCall(t, GetTxOut, `[`+txid+`, 0, false]`); resp.Result == nil {
+		t.Error("Output not returned without the mempool")
+	}
+	resp = rpcTestCall(t, GetTxOut, `["`+tx.Hash.String()+`", 0]`)
+	if res, _ = resp.Result.(*TxOutResp); res == nil || res.Confirmations != 0 || res.ScriptPubKey.Hex != "51" {
+		t.Error("Bad mempool output", resp.Result, resp.Error)
+	}
+}
@@ -0,0 +1,121 @@
+package rpcapi
+
+import (
+	"encoding/hex"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/chain"
+	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/client/network"
+)
+
+// Both calls below need the optional indexes (Index.TxIndex and Index.AddrIndex in the config)
+
+type RawTxResp struct {
+	*TxResp
+	BlockHash string `json:"blockhash,omitempty"`
+	Height uint32 `json:"height,omitempty"`
+	Pos uint32 `json:"pos,omitempty"`
+	Confirmations uint32 `json:"confirmations"`
+}
+
+type AddrHistResp struct {
+	TxID string `json:"txid"`
+	Height uint32 `json:"height"`
+	Index uint32 `json:"index"`
+	Value uint64 `json:"value"`
+	Spend bool `json:"spend"`
+	XNYSS bool `json:"xnyss,omitempty"`
+	SpentTxID string `json:"spent_txid,omitempty"`
+	SpentVout uint32 `json:"spent_vout,omitempty"`
+}
+
+
+// RPC: getrawtransaction txid [verbose]
+func GetRawTransaction(cmd *RpcCommand, resp *RpcResponse) {
+	uu, ok := cmd.Params.([]interface{})
+	if !ok || len(uu) < 1 {
+		resp.Error = RpcError{Code: -1, Message: "expected params: txid [verbose]"}
+		return
+	}
+	str, _ := uu[0].(string)
+	txid := btc.NewUint256FromString(str)
+	if txid == nil {
+		resp.Error = RpcError{Code: -8, Message: "invalid txid"}
+		return
+	}
+
+	verbose := param_bool(param(cmd, 1), false)
+
+	// the memory pool does not need the index
+	network.TxMutex.Lock()
+	t2s := network.TransactionsToSend[txid.BIdx()]
+	network.TxMutex.Unlock()
+	if t2s != nil {
+		if verbose {
+			resp.Result = &RawTxResp{TxResp: decode_tx(t2s.Tx)}
+		} else {
+			resp.Result = hex.EncodeToString(t2s.Raw)
+		}
+		return
+	}
+
+	raw, loc, er := common.BlockChain.GetRawTxByID(txid)
+	if er != nil {
+		resp.Error = RpcError{Code: -5, Message: er.Error()}
+		return
+	}
+
+	if !verbose {
+		resp.Result = hex.EncodeToString(raw)
+		return
+	}
+
+	tx, _ := btc.NewTx(raw)
+	if tx == nil {
+		resp.Error = RpcError{Code: -22, Message: "TX decode failed"}
+		return
+	}
+	tx.SetHash(raw)
+	res := &RawTxResp{TxResp: decode_tx(tx)}
+	res.Height = loc.Height
+	res.Pos = loc.Pos
+	if loc.Block != nil {
+		res.BlockHash = loc.Block.BlockHash.String()
+	}
+	res.Confirmations = common.Last.BlockHeight() - loc.Height + 1
+	resp.Result = res
+}
+
+
+// RPC: getaddresshistory address
+func GetAddressHistory(cmd *RpcCommand, resp *RpcResponse) {
+	uu, ok := cmd.Params.([]interface{})
+	if !ok || len(uu) < 1 {
+		resp.Error = RpcError{Code: -1, Message: "expected params: address"}
+		return
+	}
+	str, _ := uu[0].(string)
+	ad, er := btc.NewAddrFromString(str)
+	if er != nil || ad == nil {
+		resp.Error = RpcError{Code: -5, Message: "Invalid address"}
+		return
+	}
+
+	hist, er := common.BlockChain.GetAddrHistory(ad.OutScript())
+	if er != nil {
+		resp.Error = RpcError{Code: -5, Message: er.Error()}
+		return
+	}
+
+	res := make([]*AddrHistResp, len(hist))
+	for i, h := range hist {
+		r := &AddrHistResp{TxID: h.TxID.String(), Height: h.Height, Index: h.Index, Value: h.Value,
+			Spend: h.IsSpend(), XNYSS: (h.Flags&chain.ADDR_HIST_XNYSS) != 0}
+		if r.Spend {
+			r.SpentTxID = btc.NewUint256(h.Spent.Hash[:]).String()
+			r.SpentVout = h.Spent.Vout
+		}
+		res[i] = r
+	}
+	resp.Result = res
+}
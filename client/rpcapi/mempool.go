@@ -0,0 +1,84 @@
+package rpcapi
+
+import (
+	"encoding/hex"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/client/network"
+)
+
+// Memory pool calls, compatible with Bitcoin Core's
+
+type MempoolInfoResp struct {
+	Loaded        bool    `json:"loaded"`
+	Size          int     `json:"size"`
+	Bytes         uint64  `json:"bytes"`
+	Usage         uint64  `json:"usage"`
+	MaxMempool    uint64  `json:"maxmempool"`
+	MempoolMinFee float64 `json:"mempoolminfee"`
+	MinRelayTxFee float64 `json:"minrelaytxfee"`
+	XnyssKeys     int     `json:"xnyss_keys"` // one-time keys used by the txs in the pool
+}
+
+type MempoolEntryResp struct {
+	VSize        int      `json:"vsize"`
+	Weight       int      `json:"weight"`
+	Fee          float64  `json:"fee"`
+	Time         int64    `json:"time"`
+	Depends      []string `json:"depends"`
+	XnyssKeys    []string `json:"xnyss_keys,omitempty"`
+	OrphanedUpkh []string `json:"orphaned_upkh,omitempty"` // UPKH children lost by the txs this one replaced
+}
+
+
+func hashes_hex(lst [][32]byte) (res []string) {
+	for i := range lst {
+		res = append(res, hex.EncodeToString(lst[i][:]))
+	}
+	return
+}
+
+
+// RPC: getmempoolinfo
+func GetMempoolInfo(cmd *RpcCommand, resp *RpcResponse) {
+	res := &MempoolInfoResp{Loaded: true, MaxMempool: common.MaxMempoolSize(),
+		MempoolMinFee: float64(common.MinFeePerKB()) / 1e8, MinRelayTxFee: float64(common.RouteMinFeePerKB()) / 1e8}
+	network.TxMutex.Lock()
+	res.Size = len(network.TransactionsToSend)
+	res.Bytes = network.TransactionsToSendWeight / 4
+	res.Usage = network.TransactionsToSendSize
+	res.XnyssKeys = len(network.XnyssKeysUsed)
+	network.TxMutex.Unlock()
+	resp.Result = res
+}
+
+
+// RPC: getrawmempool [verbose=false]
+func GetRawMempool(cmd *RpcCommand, resp *RpcResponse) {
+	verbose := param_bool(param(cmd, 0), false)
+	network.TxMutex.Lock()
+	defer network.TxMutex.Unlock()
+
+	if !verbose {
+		txids := make([]string, 0, len(network.TransactionsToSend))
+		for _, t2s := range network.TransactionsToSend {
+			txids = append(txids, t2s.Hash.String())
+		}
+		resp.Result = txids
+		return
+	}
+
+	res := make(map[string]*MempoolEntryResp, len(network.TransactionsToSend))
+	for _, t2s := range network.TransactionsToSend {
+		e := &MempoolEntryResp{VSize: t2s.VSize(), Weight: t2s.Weight(), Fee: float64(t2s.Fee) / 1e8,
+			Time: t2s.Firstseen.Unix(), Depends: []string{}, XnyssKeys: hashes_hex(t2s.XnyssKeys),
+			OrphanedUpkh: hashes_hex(t2s.Orphaned)}
+		for i, mem := range t2s.MemInputs {
+			if mem {
+				e.Depends = append(e.Depends, btc.NewUint256(t2s.TxIn[i].Input.Hash[:]).String())
+			}
+		}
+		res[t2s.Hash.String()] = e
+	}
+	resp.Result = res
+}
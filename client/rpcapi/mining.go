@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/chain"
 	"github.com/lentus/wotscoin/client/common"
 	"github.com/lentus/wotscoin/client/network"
 )
@@ -39,6 +40,9 @@ type GetBlockTemplateResp struct {
 	Curtime uint `json:"curtime"`
 	Bits string `json:"bits"`
 	Height uint `json:"height"`
+	Rules []string `json:"rules"`
+	Vbavailable map[string]uint `json:"vbavailable"`
+	Vbrequired uint `json:"vbrequired"`
 }
 
 type RpcGetBlockTemplateResp struct {
@@ -62,7 +66,24 @@ func GetNextBlockTemplate(r *GetBlockTemplateResp) {
 	target := btc.SetCompact(bits).Bytes()
 
 	r.Capabilities = []string{"proposal"}
-	r.Version = 4
+	r.Version = common.BlockChain.ComputeBlockVersion(common.Last.Block)
+	// the rules in force for the next block: the forks buried at their heights and the active deployments
+	r.Rules = []string{}
+	if h := common.BlockChain.Consensus.Enforce_CSV; h != 0 && height >= h {
+		r.Rules = append(r.Rules, "csv")
+	}
+	if h := common.BlockChain.Consensus.Enforce_SEGWIT; h != 0 && height >= h {
+		r.Rules = append(r.Rules, "segwit")
+	}
+	r.Vbavailable = make(map[string]uint)
+	for i, d := range common.BlockChain.Consensus.Deployments {
+		switch common.BlockChain.DeploymentState(common.Last.Block, i) {
+		case chain.BIP9_ACTIVE:
+			r.Rules = append(r.Rules, d.Name)
+		case chain.BIP9_STARTED, chain.BIP9_LOCKED_IN:
+			r.Vbavailable[d.Name] = uint(d.Bit)
+		}
+	}
 	r.PreviousBlockHash = common.Last.Block.BlockHash.String()
 	r.Transactions, r.Coinbasevalue = GetTransactions(height, uint32(r.Mintime))
 	r.Coinbasevalue += btc.GetBlockReward(height)
@@ -0,0 +1,56 @@
+package rpcapi
+
+import (
+	"fmt"
+	"sort"
+	"github.com/lentus/wotscoin/client/network"
+)
+
+// Network calls, compatible with Bitcoin Core's
+
+type PeerInfoResp struct {
+	ID             uint32  `json:"id"`
+	Addr           string  `json:"addr"`
+	AddrLocal      string  `json:"addrlocal,omitempty"`
+	Services       string  `json:"services"`
+	RelayTxes      bool    `json:"relaytxes"`
+	LastSend       int64   `json:"lastsend"`
+	LastRecv       int64   `json:"lastrecv"`
+	BytesSent      uint64  `json:"bytessent"`
+	BytesRecv      uint64  `json:"bytesrecv"`
+	ConnTime       int64   `json:"conntime"`
+	PingTime       float64 `json:"pingtime"`
+	Version        uint32  `json:"version"`
+	SubVer         string  `json:"subver"`
+	Inbound        bool    `json:"inbound"`
+	StartingHeight uint32  `json:"startingheight"`
+	Score          int     `json:"score"`      // reputation from the peer's history
+	Encrypted      bool    `json:"encrypted"`  // uses the encrypted transport
+	Authorized     bool    `json:"authorized"` // authenticated with a WOTS key
+}
+
+
+// RPC: getpeerinfo
+func GetPeerInfo(cmd *RpcCommand, resp *RpcResponse) {
+	network.Mutex_net.Lock()
+	cons := make([]*network.OneConnection, 0, len(network.OpenCons))
+	for _, v := range network.OpenCons {
+		cons = append(cons, v)
+	}
+	network.Mutex_net.Unlock()
+
+	res := make([]*PeerInfoResp, 0, len(cons))
+	for _, v := range cons {
+		var ci network.ConnInfo
+		v.GetStats(&ci)
+		res = append(res, &PeerInfoResp{ID: ci.ID, Addr: ci.RemoteAddr, AddrLocal: ci.LocalAddr,
+			Services: fmt.Sprintf("%016x", ci.Services), RelayTxes: !ci.DoNotRelayTxs,
+			LastSend: ci.LastSent.Unix(), LastRecv: ci.LastDataGot.Unix(),
+			BytesSent: ci.BytesSent, BytesRecv: ci.BytesReceived, ConnTime: ci.ConnectedAt.Unix(),
+			PingTime: float64(ci.AveragePing) / 1e3, Version: ci.Version, SubVer: ci.Agent,
+			Inbound: ci.Incomming, StartingHeight: ci.Height, Score: ci.Score,
+			Encrypted: ci.Encrypted, Authorized: ci.Authorized})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	resp.Result = res
+}
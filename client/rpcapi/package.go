@@ -0,0 +1,65 @@
+package rpcapi
+
+import (
+	"encoding/hex"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/client/network"
+)
+
+type PkgTxResp struct {
+	TxID string `json:"txid"`
+	VSize int `json:"vsize"`
+	Fee float64 `json:"fee"`
+	Known bool `json:"known,omitempty"` // was already in the mempool or mined
+}
+
+type SubmitPackageResp struct {
+	PackageMsg string `json:"package_msg"`
+	TxResults []*PkgTxResp `json:"tx-results"`
+	PackageFeeRate float64 `json:"package-feerate"` // BTC/kvB
+}
+
+
+// RPC: submitpackage ["rawtx_parent", ..., "rawtx_child"]
+func SubmitPackage(cmd *RpcCommand, resp *RpcResponse) {
+	uu, ok := cmd.Params.([]interface{})
+	if ok && len(uu) == 1 {
+		uu, ok = uu[0].([]interface{})
+	}
+	if !ok || len(uu) < 2 {
+		resp.Error = RpcError{Code: -1, Message: "expected params: [rawtxs] (parents first, child last)"}
+		return
+	}
+
+	pkg := &network.OnePackage{Local: true}
+	for _, v := range uu {
+		str, _ := v.(string)
+		raw, er := hex.DecodeString(str)
+		if er != nil {
+			resp.Error = RpcError{Code: -22, Message: "TX decode failed"}
+			return
+		}
+		tx, le := btc.NewTx(raw)
+		if tx == nil || le != len(raw) {
+			resp.Error = RpcError{Code: -22, Message: "TX decode failed"}
+			return
+		}
+		tx.SetHash(raw)
+		pkg.Txs = append(pkg.Txs, tx)
+	}
+
+	if er := network.SubmitPackage(pkg); er != nil {
+		resp.Error = RpcError{Code: -26, Message: er.Error()}
+		return
+	}
+
+	res := &SubmitPackageResp{PackageMsg: "success"}
+	for i, tx := range pkg.Txs {
+		res.TxResults = append(res.TxResults, &PkgTxResp{TxID: tx.Hash.String(), VSize: tx.VSize(),
+			Fee: float64(pkg.Fees[i]) / 1e8, Known: !pkg.New[i]})
+	}
+	if pkg.VSize > 0 {
+		res.PackageFeeRate = float64(pkg.Fee) / 1e8 * 1000 / float64(pkg.VSize)
+	}
+	resp.Result = res
+}
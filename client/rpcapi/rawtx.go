@@ -0,0 +1,269 @@
+package rpcapi
+
+import (
+	"strings"
+	"encoding/hex"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/client/usif"
+	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/client/network"
+)
+
+// Transaction calls, compatible with Bitcoin Core's. Inputs signed with XNYSS
+// one-time keys get the "xnyss" field in the decoded txs.
+
+type ScriptSigResp struct {
+	Asm string `json:"asm"`
+	Hex string `json:"hex"`
+}
+
+type ScriptPubKeyResp struct {
+	Asm     string `json:"asm"`
+	Hex     string `json:"hex"`
+	Type    string `json:"type"`
+	Address string `json:"address,omitempty"`
+}
+
+type XnyssInputResp struct {
+	PubKeyHash   string   `json:"pubkeyhash"` // SHA256 of the one-time public key
+	Children     []string `json:"children"`   // hashes of the keys advertised by the signature
+	LongTermHash string   `json:"longterm_hash,omitempty"` // if the key is a known UPKH record
+	UpkhHeight   uint32   `json:"upkh_height,omitempty"`   // block where it was advertised
+}
+
+type TxVinResp struct {
+	Coinbase    string          `json:"coinbase,omitempty"`
+	TxID        string          `json:"txid,omitempty"`
+	Vout        uint32          `json:"vout"`
+	ScriptSig   *ScriptSigResp  `json:"scriptSig,omitempty"`
+	TxInWitness []string        `json:"txinwitness,omitempty"`
+	Sequence    uint32          `json:"sequence"`
+	Xnyss       *XnyssInputResp `json:"xnyss,omitempty"`
+}
+
+type TxVoutResp struct {
+	Value        float64          `json:"value"`
+	N            int              `json:"n"`
+	ScriptPubKey ScriptPubKeyResp `json:"scriptPubKey"`
+}
+
+type TxResp struct {
+	TxID     string        `json:"txid"`
+	Hash     string        `json:"hash"`
+	Version  uint32        `json:"version"`
+	Size     int           `json:"size"`
+	VSize    int           `json:"vsize"`
+	Weight   int           `json:"weight"`
+	LockTime uint32        `json:"locktime"`
+	Vin      []*TxVinResp  `json:"vin"`
+	Vout     []*TxVoutResp `json:"vout"`
+	Hex      string        `json:"hex"`
+}
+
+type TxOutResp struct {
+	BestBlock     string           `json:"bestblock"`
+	Confirmations uint32           `json:"confirmations"`
+	Value         float64          `json:"value"`
+	ScriptPubKey  ScriptPubKeyResp `json:"scriptPubKey"`
+	Coinbase      bool             `json:"coinbase"`
+}
+
+
+func script_asm(scr []byte) string {
+	out, _ := btc.ScriptToText(scr)
+	return strings.Join(out, " ")
+}
+
+func script_type(scr []byte) string {
+	if len(scr) == 25 && scr[0] == 0x76 && scr[1] == 0xa9 && scr[2] == 0x14 && scr[23] == 0x88 && scr[24] == 0xac {
+		return "pubkeyhash"
+	}
+	if btc.IsP2SH(scr) {
+		return "scripthash"
+	}
+	if ver, prog := btc.IsWitnessProgram(scr); prog != nil {
+		if ver == 0 && len(prog) == 20 {
+			return "witness_v0_keyhash"
+		}
+		if ver == 0 && len(prog) == 32 {
+			return "witness_v0_scripthash"
+		}
+		return "witness_unknown"
+	}
+	if len(scr) > 0 && scr[0] == 0x6a {
+		return "nulldata"
+	}
+	return "nonstandard"
+}
+
+func script_pubkey(scr []byte) (res ScriptPubKeyResp) {
+	res = ScriptPubKeyResp{Asm: script_asm(scr), Hex: hex.EncodeToString(scr), Type: script_type(scr)}
+	if ad := common.Params.NewAddrFromPkScript(scr); ad != nil {
+		res.Address = ad.String()
+	}
+	return
+}
+
+// Returns nil if the input is not signed with an XNYSS one-time key
+func xnyss_input(tx *btc.Tx, i int) (res *XnyssInputResp) {
+	sig := tx.XnyssSignature(i)
+	if sig == nil {
+		return
+	}
+	pkh, ok := tx.XnyssPubKeyHash(i)
+	if !ok {
+		return
+	}
+	res = &XnyssInputResp{PubKeyHash: hex.EncodeToString(pkh[:]), Children: make([]string, len(sig.ChildHashes))}
+	for j, ch := range sig.ChildHashes {
+		res.Children[j] = hex.EncodeToString(ch)
+	}
+	if rec := common.BlockChain.Unspent.UpkhGet(pkh); rec != nil {
+		res.LongTermHash = hex.EncodeToString(rec.LongTermHash[:])
+		res.UpkhHeight = rec.Blockheight
+	}
+	return
+}
+
+func decode_tx(tx *btc.Tx) (res *TxResp) {
+	res = &TxResp{TxID: tx.Hash.String(), Hash: tx.WTxID().String(), Version: tx.Version, Size: len(tx.Raw),
+		VSize: tx.VSize(), Weight: tx.Weight(), LockTime: tx.Lock_time, Hex: hex.EncodeToString(tx.Raw)}
+	for i, in := range tx.TxIn {
+		vin := &TxVinResp{Sequence: in.Sequence}
+		if tx.IsCoinBase() {
+			vin.Coinbase = hex.EncodeToString(in.ScriptSig)
+		} else {
+			vin.TxID = btc.NewUint256(in.Input.Hash[:]).String()
+			vin.Vout = in.Input.Vout
+			vin.ScriptSig = &ScriptSigResp{Asm: script_asm(in.ScriptSig), Hex: hex.EncodeToString(in.ScriptSig)}
+			vin.Xnyss = xnyss_input(tx, i)
+		}
+		if tx.SegWit != nil {
+			for _, w := range tx.SegWit[i] {
+				vin.TxInWitness = append(vin.TxInWitness, hex.EncodeToString(w))
+			}
+		}
+		res.Vin = append(res.Vin, vin)
+	}
+	for i, out := range tx.TxOut {
+		res.Vout = append(res.Vout, &TxVoutResp{Value: float64(out.Value) / 1e8, N: i,
+			ScriptPubKey: script_pubkey(out.Pk_script)})
+	}
+	return
+}
+
+func parse_raw_tx(v interface{}) (tx *btc.Tx, raw []byte) {
+	str, _ := v.(string)
+	raw, er := hex.DecodeString(str)
+	if er != nil {
+		return
+	}
+	tx, le := btc.NewTx(raw)
+	if tx == nil || le != len(raw) {
+		return nil, nil
+	}
+	tx.SetHash(raw)
+	return
+}
+
+
+// RPC: decoderawtransaction hexstring
+func DecodeRawTransaction(cmd *RpcCommand, resp *RpcResponse) {
+	tx, _ := parse_raw_tx(param(cmd, 0))
+	if tx == nil {
+		resp.Error = RpcError{Code: -22, Message: "TX decode failed"}
+		return
+	}
+	resp.Result = decode_tx(tx)
+}
+
+
+// RPC: sendrawtransaction hexstring
+// The tx gets submitted from the main thread, the same way as TextUI's txload
+func SendRawTransaction(cmd *RpcCommand, resp *RpcResponse) {
+	tx, raw := parse_raw_tx(param(cmd, 0))
+	if tx == nil {
+		resp.Error = RpcError{Code: -22, Message: "TX decode failed"}
+		return
+	}
+
+	req := &usif.OneUiReq{}
+	req.Done.Add(1)
+	req.Handler = func(string) {
+		network.RemoveFromRejected(&tx.Hash) // in case we rejected it eariler, to try it again as trusted
+		if network.NeedThisTxExt(&tx.Hash, nil) == network.TX_NOT_NEEDED_MINED {
+			resp.Error = RpcError{Code: -27, Message: "Transaction already in block chain"}
+			return
+		}
+		network.TxMutex.Lock()
+		_, known := network.TransactionsToSend[tx.Hash.BIdx()]
+		network.TxMutex.Unlock()
+		if !known && !network.SubmitLocalTx(tx, raw) {
+			network.TxMutex.Lock()
+			rr := network.TransactionsRejected[tx.Hash.BIdx()]
+			network.TxMutex.Unlock()
+			msg := "Transaction rejected"
+			if rr != nil {
+				msg = network.ReasonToString(rr.Reason)
+			}
+			resp.Error = RpcError{Code: -26, Message: msg}
+			return
+		}
+		network.TxMutex.Lock()
+		if t2s := network.TransactionsToSend[tx.Hash.BIdx()]; t2s != nil {
+			t2s.Local = true
+			network.TxMutex.Unlock()
+			cnt := network.NetRouteInv(1, &tx.Hash, nil)
+			network.TxMutex.Lock()
+			t2s.Invsentcnt += cnt
+		}
+		network.TxMutex.Unlock()
+		resp.Result = tx.Hash.String()
+	}
+	usif.UiChannel <- req
+	req.Done.Wait()
+}
+
+
+// RPC: gettxout txid n [include_mempool=true]
+func GetTxOut(cmd *RpcCommand, resp *RpcResponse) {
+	str, _ := param(cmd, 0).(string)
+	txid := btc.NewUint256FromString(str)
+	vout := param_int(param(cmd, 1), -1)
+	if txid == nil || vout < 0 {
+		resp.Error = RpcError{Code: -8, Message: "expected params: txid n [include_mempool]"}
+		return
+	}
+	mempool := param_bool(param(cmd, 2), true)
+	po := &btc.TxPrevOut{Hash: txid.Hash, Vout: uint32(vout)}
+	last := common.BlockChain.LastBlock()
+	res := &TxOutResp{BestBlock: last.BlockHash.String()}
+
+	if mempool {
+		network.TxMutex.Lock()
+		_, spent := network.SpentOutputs[po.UIdx()]
+		t2s := network.TransactionsToSend[txid.BIdx()]
+		network.TxMutex.Unlock()
+		if spent {
+			return // null result
+		}
+		if t2s != nil {
+			if vout < len(t2s.TxOut) {
+				res.Value = float64(t2s.TxOut[vout].Value) / 1e8
+				res.ScriptPubKey = script_pubkey(t2s.TxOut[vout].Pk_script)
+				resp.Result = res
+			}
+			return
+		}
+	}
+
+	out := common.BlockChain.Unspent.UnspentGet(po)
+	if out == nil {
+		return
+	}
+	res.Confirmations = last.Height - out.BlockHeight + 1
+	res.Value = float64(out.Value) / 1e8
+	res.ScriptPubKey = script_pubkey(out.Pk_script)
+	res.Coinbase = out.WasCoinbase
+	resp.Result = res
+}
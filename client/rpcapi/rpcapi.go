@@ -30,6 +30,39 @@ type RpcCommand struct {
 	Params interface{} `json:"params"`
 }
 
+// Returns the i-th positional parameter of the command (nil if not given)
+func param(cmd *RpcCommand, i int) interface{} {
+	if uu, ok := cmd.Params.([]interface{}); ok && i < len(uu) {
+		return uu[i]
+	}
+	return nil
+}
+
+// Returns the integer value of the parameter (def if not given or not a number)
+func param_int(v interface{}, def int) int {
+	switch x := v.(type) {
+	case json.Number:
+		if i, er := x.Int64(); er == nil {
+			return int(i)
+		}
+	case bool: // verbose flags given as booleans
+		if x {
+			return 1
+		}
+		return 0
+	}
+	return def
+}
+
+// Returns the boolean value of the parameter (numbers other than 0 are true)
+func param_bool(v interface{}, def bool) bool {
+	var d int
+	if def {
+		d = 1
+	}
+	return param_int(v, d) != 0
+}
+
 func process_rpc(b []byte) (out []byte) {
 	ioutil.WriteFile("rpc_cmd.json", b, 0777)
 	ex_cmd := exec.Command("C:\\Tools\\DEV\\Git\\mingw64\\bin\\curl.EXE",
@@ -123,6 +156,54 @@ func my_handler(w http.ResponseWriter, r *http.Request) {
 			//ioutil.WriteFile("submitblock.json", b, 0777)
 			SubmitBlock(&RpcCmd, &resp, b)
 
+		case "generate":
+			Generate(&RpcCmd, &resp)
+
+		case "getrawtransaction":
+			GetRawTransaction(&RpcCmd, &resp)
+
+		case "getaddresshistory":
+			GetAddressHistory(&RpcCmd, &resp)
+
+		case "submitpackage":
+			SubmitPackage(&RpcCmd, &resp)
+
+		case "getblockchaininfo":
+			GetBlockchainInfo(&RpcCmd, &resp)
+
+		case "getbestblockhash":
+			GetBestBlockHash(&RpcCmd, &resp)
+
+		case "getblockhash":
+			GetBlockHash(&RpcCmd, &resp)
+
+		case "getblock":
+			GetBlock(&RpcCmd, &resp)
+
+		case "getblockheader":
+			GetBlockHeader(&RpcCmd, &resp)
+
+		case "sendrawtransaction":
+			SendRawTransaction(&RpcCmd, &resp)
+
+		case "decoderawtransaction":
+			DecodeRawTransaction(&RpcCmd, &resp)
+
+		case "gettxout":
+			GetTxOut(&RpcCmd, &resp)
+
+		case "getmempoolinfo":
+			GetMempoolInfo(&RpcCmd, &resp)
+
+		case "getrawmempool":
+			GetRawMempool(&RpcCmd, &resp)
+
+		case "getpeerinfo":
+			GetPeerInfo(&RpcCmd, &resp)
+
+		case "getupkh":
+			GetUpkh(&RpcCmd, &resp)
+
 		default:
 			fmt.Println("Method:", RpcCmd.Method, len(b))
 			//w.Write(bitcoind_result)
@@ -0,0 +1,64 @@
+package rpcapi
+
+import (
+	"strings"
+	"testing"
+	"encoding/hex"
+	"encoding/json"
+)
+
+func TestParams(t *testing.T) {
+	var cmd RpcCommand
+	dec := json.NewDecoder(strings.NewReader(`{"method":"getblock","params":["00ff", 2, true, 0]}`))
+	dec.UseNumber()
+	if e := dec.Decode(&cmd); e != nil {
+		t.Fatal(e.Error())
+	}
+	if s, _ := param(&cmd, 0).(string); s != "00ff" {
+		t.Error("param 0:", param(&cmd, 0))
+	}
+	if param_int(param(&cmd, 1), -1) != 2 {
+		t.Error("param_int number")
+	}
+	if param_int(param(&cmd, 2), -1) != 1 || !param_bool(param(&cmd, 2), false) {
+		t.Error("bool param")
+	}
+	if param_bool(param(&cmd, 3), true) {
+		t.Error("param_bool zero")
+	}
+	if param(&cmd, 4) != nil || param_int(param(&cmd, 4), 7) != 7 || !param_bool(param(&cmd, 4), true) {
+		t.Error("missing param")
+	}
+}
+
+func TestScriptType(t *testing.T) {
+	var tests = []struct {
+		scr, typ string
+	}{
+		{"76a914000102030405060708090a0b0c0d0e0f1011121388ac", "pubkeyhash"},
+		{"a914000102030405060708090a0b0c0d0e0f1011121387", "scripthash"},
+		{"0014000102030405060708090a0b0c0d0e0f10111213", "witness_v0_keyhash"},
+		{"0020000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", "witness_v0_scripthash"},
+		{"6a0401020304", "nulldata"},
+		{"51", "nonstandard"},
+	}
+	for _, tc := range tests {
+		scr, _ := hex.DecodeString(tc.scr)
+		if res := script_type(scr); res != tc.typ {
+			t.Error(tc.scr, "-", res, "instead of", tc.typ)
+		}
+	}
+}
+
+func TestUpkhHashes(t *testing.T) {
+	h := strings.Repeat("ab", 32)
+	res, er := upkh_hashes([]interface{}{h, strings.Repeat("00", 32)})
+	if er != nil || len(res) != 2 || res[0][31] != 0xab || res[1][0] != 0 {
+		t.Error("valid hashes:", res, er)
+	}
+	for _, v := range []interface{}{nil, h, []interface{}{h[2:]}, []interface{}{h, 5}} {
+		if _, er = upkh_hashes(v); er == nil {
+			t.Error("accepted", v)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package rpcapi
+
+import (
+	"fmt"
+	"errors"
+	"encoding/hex"
+	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/client/network"
+)
+
+// XNYSS key confirmations for the wallet (see wallet -sync)
+
+type UpkhKeyResp struct {
+	PubKeyHash   string `json:"pkh"`
+	LongTermHash string `json:"lth,omitempty"`
+	Depth        uint32 `json:"depth"` // zero if the key is not in the UPKH database
+	Block        string `json:"block,omitempty"` // hash of the block that advertised the key
+}
+
+type UpkhResp struct {
+	Chain     string         `json:"chain"` // name of the chain params, as used by the wallet
+	TipHash   string         `json:"tiphash"`
+	TipHeight uint32         `json:"tipheight"`
+	Keys      []*UpkhKeyResp `json:"keys"`
+}
+
+
+// Parses the list of hex encoded public key hashes
+func upkh_hashes(v interface{}) (res [][32]byte, er error) {
+	lst, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("expected an array of public key hashes")
+	}
+	res = make([][32]byte, len(lst))
+	for i := range lst {
+		s, _ := lst[i].(string)
+		d, e := hex.DecodeString(s)
+		if e != nil || len(d) != 32 {
+			return nil, fmt.Errorf("incorrect public key hash at index %d", i)
+		}
+		copy(res[i][:], d)
+	}
+	return
+}
+
+
+// RPC: getupkh ["pkh",...]
+func GetUpkh(cmd *RpcCommand, resp *RpcResponse) {
+	hashes, er := upkh_hashes(param(cmd, 0))
+	if er != nil {
+		resp.Error = RpcError{Code: -8, Message: er.Error()}
+		return
+	}
+	msg := network.UpkhAnswers(hashes)
+	res := &UpkhResp{Chain: common.Params.Name, TipHash: msg.TipHash.String(), TipHeight: msg.TipHeight,
+		Keys: make([]*UpkhKeyResp, len(msg.Recs))}
+	for i, r := range msg.Recs {
+		res.Keys[i] = &UpkhKeyResp{PubKeyHash: hex.EncodeToString(r.PubKeyHash[:]), Depth: r.Depth}
+		if r.Depth > 0 {
+			res.Keys[i].LongTermHash = hex.EncodeToString(r.LongTermHash[:])
+			res.Keys[i].Block = r.BlockHash.String()
+		}
+	}
+	resp.Result = res
+}
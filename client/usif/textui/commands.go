@@ -9,6 +9,7 @@ import (
 	"github.com/lentus/wotscoin/client/network"
 	"github.com/lentus/wotscoin/client/usif"
 	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/chain"
 	"github.com/lentus/wotscoin/lib/others/peersdb"
 	"github.com/lentus/wotscoin/lib/others/sys"
 	"github.com/lentus/wotscoin/lib/others/qdb"
@@ -21,8 +22,6 @@ import (
 	"strconv"
 	"strings"
 	"time"
-	"encoding/binary"
-	"bytes"
 )
 
 type oneUiCmd struct {
@@ -393,13 +392,22 @@ func show_addresses(par string) {
 			pr := peersdb.NewPeer(v)
 			if pr.Banned != 0 {
 				cnt++
-				fmt.Printf("%4d) %s\n", cnt, pr.String())
+				st := pr.Stats()
+				fmt.Printf("%4d) %s  bans:%d  %s\n", cnt, pr.String(), st.BanCount, st.BanReason)
 			}
 			return 0
 		})
 		if cnt == 0 {
 			fmt.Println("No banned peers in the DB")
 		}
+	} else if par == "stats" {
+		sts := peersdb.GetAllStats()
+		for i, st := range sts {
+			fmt.Printf("%4d) %-22s score:%-4d conns:%-3d bans:%-2d misb:%-4d ping:%-4d blks:%d/%d  %s  %s\n",
+				i+1, st.Addr, st.Score(), st.Conns, st.BanCount, st.Misbehave, st.PingAvg,
+				st.BlocksFirst, st.BlocksFirst+st.BlocksLate, common.BytesToString(st.BytesServed), st.BanReason)
+		}
+		fmt.Println(len(sts), "peer(s) with history, the worst ones first")
 	} else if par != "" {
 		limit, er := strconv.ParseUint(par, 10, 32)
 		if er != nil {
@@ -417,6 +425,7 @@ func show_addresses(par string) {
 	} else {
 		fmt.Println("Use 'peers list' to list them")
 		fmt.Println("Use 'peers ban' to list the benned ones")
+		fmt.Println("Use 'peers stats' to show the history of the peers")
 		fmt.Println("Use 'peers <number>' to show the most recent ones")
 	}
 }
@@ -447,7 +456,7 @@ func unban_peer(par string) {
 		peer := peersdb.NewPeer(v)
 		if peer.Banned != 0 {
 			if ad == nil || peer.Ip() == ad.Ip() {
-				fmt.Println(" -", peer.NetAddr.String())
+				fmt.Println(" -", peer.NetAddr.String(), peer.Stats().BanReason)
 				peer.Banned = 0
 				keys = append(keys, k)
 				vals = append(vals, peer.Bytes())
@@ -500,13 +509,32 @@ func send_inv(par string) {
 
 func analyze_bip9(par string) {
 	all := par == "all"
+	window := common.BlockChain.Consensus.Window
+	last := common.BlockChain.LastBlock()
+	for i, d := range common.BlockChain.Consensus.Deployments {
+		state := common.BlockChain.DeploymentState(last, i)
+		fmt.Printf("Deployment %s (bit %d): %s", d.Name, d.Bit, chain.BIP9StateToString(state))
+		if state == chain.BIP9_STARTED {
+			cnt, blocks := common.BlockChain.DeploymentSignals(last, i)
+			fmt.Printf(",  %d of %d blocks signalling in current period (%d needed)", cnt, blocks,
+				common.BlockChain.BIP9Threshold(&d))
+		}
+		fmt.Println()
+		if d.StartTime == chain.BIP9_ALWAYS_ACTIVE {
+			fmt.Println("  Always active")
+		} else {
+			fmt.Println("  Start:", time.Unix(int64(d.StartTime), 0).Format("2006/01/02 15:04"),
+				"  Timeout:", time.Unix(int64(d.Timeout), 0).Format("2006/01/02 15:04"))
+		}
+	}
+
 	n := common.BlockChain.BlockTreeRoot
 	for n != nil {
 		var i uint
 		start_block := uint(n.Height)
 		start_time := n.Timestamp()
 		bits := make(map[byte]uint32)
-		for i = 0; i < 2016 && n != nil; i++ {
+		for i = 0; i < window && n != nil; i++ {
 			ver := n.BlockVersion()
 			if (ver & 0x20000000) != 0 {
 				for bit := byte(0); bit <= 28; bit++ {
@@ -529,7 +557,7 @@ func analyze_bip9(par string) {
 			}
 			if s != "" {
 				fmt.Println("Period from", time.Unix(int64(start_time), 0).Format("2006/01/02 15:04"),
-					" block #", start_block, "-", start_block+i-1, ":", s, " - active from", start_block+2*2016)
+					" block #", start_block, "-", start_block+i-1, ":", s, " - active from", start_block+2*window)
 			}
 		}
 	}
@@ -554,70 +582,34 @@ func purge_utxo(par string) {
 }
 
 func get_keystate(fn string) {
-	f, err := os.Open(fn)
+	d, err := ioutil.ReadFile(fn)
 	if err != nil {
 		fmt.Println("Failed to open file \"", fn, "\"-", err)
 		return
 	}
-	defer f.Close()
-
-	confirmed, err := os.Create("confirmed.txt")
-	if err != nil {
-		fmt.Println("Failed to create output file confirmed.txt -", err)
-		return
-	}
-	defer confirmed.Close()
-
-	rd := bufio.NewReader(f)
-
-	var amount uint32
-	err = binary.Read(rd, binary.LittleEndian, &amount)
+	uf, err := btc.ReadUpkhFile(d, btc.UPKH_FILE_UNCONFIRMED)
 	if err != nil {
-		fmt.Println("Failed to read amount of entries")
+		fmt.Println("Cannot read", fn, "-", err)
 		return
 	}
-
-	curHeight := common.BlockChain.LastBlock().Height
-
-	buf := new(bytes.Buffer)
-	var pkh [32]byte
-	var count uint32
-	for i := uint32(0); i < amount; i++ {
-		_, err = rd.Read(pkh[:])
-		if err != nil {
-			fmt.Println("Failed to read pubkey hash -", err)
-		}
-
-		rec := common.BlockChain.Unspent.UpkhGet(pkh)
-		if rec == nil {
-			continue
-		}
-
-		buf.Write(rec.LongTermHash[:])
-		buf.Write(rec.PubKeyHash[:])
-		binary.Write(buf, binary.LittleEndian, curHeight-rec.Blockheight+1)
-		count++
-	}
-
-	err = binary.Write(confirmed, binary.LittleEndian, count)
-	if err != nil {
-		fmt.Println("Failed to write amount of entries to confirmed.txt -", err)
+	if uf.Network != common.Params.Name {
+		fmt.Println("The file is for", uf.Network, "network, not for", common.Params.Name)
 		return
 	}
 
-	_, err = confirmed.Write(buf.Bytes())
-	if err != nil {
-		fmt.Println("Failed to write confirmation data to confirmed.txt -", err)
+	cf := network.UpkhConfirmations(uf.Hashes)
+	if err = ioutil.WriteFile("confirmed.txt", cf.Text(), 0600); err != nil {
+		fmt.Println("Failed to create output file confirmed.txt -", err)
 		return
 	}
 
-	fmt.Println("Confirmed", count, "of", amount, "public key hashes")
+	fmt.Println("Confirmed", cf.Found(), "of", len(uf.Hashes), "public key hashes at block", cf.TipHeight, cf.TipHash.String())
 	fmt.Println("Confirmation info was written to the file confirmed.txt")
 }
 
 func init() {
 	newUi("bchain b", true, blchain_stats, "Display blockchain statistics")
-	newUi("bip9", true, analyze_bip9, "Analyze current blockchain for BIP9 bits (add 'all' to see more)")
+	newUi("bip9", true, analyze_bip9, "Show BIP9 deployments and analyze blockchain for BIP9 bits (add 'all' to see more)")
 	newUi("cache", true, show_cached, "Show blocks cached in memory")
 	newUi("configload cl", false, load_config, "Re-load settings from the common file")
 	newUi("configsave cs", false, save_config, "Save current settings to a common file")
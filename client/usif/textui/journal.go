@@ -0,0 +1,97 @@
+package textui
+
+import (
+	"fmt"
+	"strings"
+	"io/ioutil"
+	"encoding/hex"
+	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/lib/btc"
+)
+
+// Looks for the on-chain XNYSS signatures of the wallet's addresses that are
+// missing from its signing journal (journal.txt). Each of them has been made
+// by another copy of the key states (a leaked, cloned or restored one).
+// Needs the address index (Index.AddrIndex in the config).
+func check_journal(par string) {
+	args := strings.Fields(par)
+	if len(args) == 0 {
+		fmt.Println("Specify the wallet's journal.txt, optionally followed by more of its addresses")
+		return
+	}
+	d, er := ioutil.ReadFile(args[0])
+	if er != nil {
+		fmt.Println(er.Error())
+		return
+	}
+	ents, er := btc.ParseJournal(d)
+	if er != nil {
+		fmt.Println("Cannot read", args[0], "-", er.Error())
+		return
+	}
+
+	known := make(map[[32]byte]bool, len(ents))
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, e := range ents {
+		known[e.Node] = true
+		if !seen[e.Address] {
+			seen[e.Address] = true
+			addrs = append(addrs, e.Address)
+		}
+	}
+	for _, a := range args[1:] {
+		if !seen[a] {
+			seen[a] = true
+			addrs = append(addrs, a)
+		}
+	}
+
+	var checked, unknown int
+	for _, a := range addrs {
+		ad, er := btc.NewAddrFromString(a)
+		if er != nil {
+			fmt.Println(a, "-", er.Error())
+			continue
+		}
+		hist, er := common.BlockChain.GetAddrHistory(ad.OutScript())
+		if er != nil {
+			fmt.Println(a, "-", er.Error())
+			return
+		}
+		for _, h := range hist {
+			if !h.IsSpend() {
+				continue
+			}
+			raw, er := common.GetRawTx(h.Height, &h.TxID)
+			if er != nil {
+				fmt.Println(a, "-", er.Error())
+				continue
+			}
+			tx, _ := btc.NewTx(raw)
+			if tx == nil || int(h.Index) >= len(tx.TxIn) {
+				fmt.Println(a, "- cannot decode tx", h.TxID.String())
+				continue
+			}
+			pkh, ok := tx.XnyssPubKeyHash(int(h.Index))
+			if !ok {
+				continue
+			}
+			checked++
+			if !known[pkh] {
+				unknown++
+				fmt.Println("NOT IN THE JOURNAL:", a, "signed input", h.Index, "of", h.TxID.String(),
+					"in block", h.Height, "with node", hex.EncodeToString(pkh[:]))
+			}
+		}
+	}
+
+	fmt.Println("Checked", checked, "XNYSS signatures of", len(addrs), "addresses against", len(ents), "journal entries")
+	if unknown > 0 {
+		fmt.Println("WARNING:", unknown, "signature(s) were not made by this wallet - another copy of its key states is in use!")
+	}
+}
+
+func init() {
+	newUi("journal", true, check_journal, "Look for XNYSS signatures of a wallet missing from its journal.txt (file [address ...])")
+}
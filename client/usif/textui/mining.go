@@ -5,8 +5,10 @@ import (
 	"time"
 	"regexp"
 	"strconv"
+	"strings"
 	"github.com/lentus/wotscoin/lib/btc"
 	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/client/rpcapi"
 )
 
 
@@ -95,6 +97,38 @@ func do_mining(s string) {
 }
 
 
+// Must not be executed in the blockchain thread, as it waits for the blocks to be accepted there
+func generate_blocks(par string) {
+	var pkscr []byte
+	ps := strings.Fields(par)
+	if len(ps) < 1 {
+		fmt.Println("Specify number of blocks and optionally the address to pay the rewards to")
+		return
+	}
+	n, er := strconv.ParseUint(ps[0], 10, 32)
+	if er != nil || n == 0 {
+		fmt.Println("Incorrect number of blocks:", ps[0])
+		return
+	}
+	if len(ps) > 1 {
+		ad, er := btc.NewAddrFromString(ps[1])
+		if er != nil || ad == nil || !common.Params.IsOwnAddr(ad) {
+			fmt.Println("Incorrect address:", ps[1])
+			return
+		}
+		pkscr = ad.OutScript()
+	}
+	hashes, er := rpcapi.GenerateBlocks(int(n), pkscr)
+	for _, h := range hashes {
+		fmt.Println(h)
+	}
+	if er != nil {
+		fmt.Println("Generate failed:", er.Error())
+	}
+}
+
+
 func init() {
+	newUi("generate", false, generate_blocks, "Mine blocks on regtest: <count> [address]")
 	newUi("minerstat m", false, do_mining, "Look for the miner ID in recent blocks (optionally specify number of hours)")
 }
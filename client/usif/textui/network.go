@@ -78,6 +78,14 @@ func node_info(par string) {
 				byte(r.ReportedIp4>>8), byte(r.ReportedIp4))
 			fmt.Println("SendHeaders:", r.SendHeaders)
 		}
+		if r.ViaProxy {
+			fmt.Println("Connected via proxy")
+		}
+		if r.Encrypted {
+			fmt.Println("Transport: v2 (encrypted), session", r.SessionID)
+		} else {
+			fmt.Println("Transport: v1")
+		}
 		fmt.Println("Invs Done:", r.InvsDone)
 		fmt.Println("Last data got:", time.Now().Sub(r.LastDataGot).String())
 		fmt.Println("Last data sent:", time.Now().Sub(r.LastSent).String())
@@ -139,6 +147,7 @@ func net_stats(par string) {
 		} else {
 			fmt.Print(" ->")
 		}
+		fmt.Print(" ", v.TransportName())
 		fmt.Printf(" %21s %5dms %7d : %-16s %7d : %-16s", v.PeerAddr.Ip(),
 			v.GetAveragePing(), v.X.LastBtsRcvd, v.X.LastCmdRcvd, v.X.LastBtsSent, v.X.LastCmdSent)
 		fmt.Printf("%9s %9s", common.BytesToString(v.X.Counters["BytesReceived"]), common.BytesToString(v.X.Counters["BytesSent"]))
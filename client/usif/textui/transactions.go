@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -30,6 +31,23 @@ func load_tx(par string) {
 	fmt.Println(usif.LoadRawTx(buf))
 }
 
+func load_pkg(par string) {
+	fns := strings.Fields(par)
+	if len(fns) < 2 {
+		fmt.Println("Specify names of the transaction files: the parents first, the child last")
+		return
+	}
+	bufs := make([][]byte, len(fns))
+	for i, fn := range fns {
+		var e error
+		if bufs[i], e = ioutil.ReadFile(fn); e != nil {
+			println(e.Error())
+			return
+		}
+	}
+	fmt.Print(usif.LoadRawPackage(bufs))
+}
+
 func send_tx(par string) {
 	txid := btc.NewUint256FromString(par)
 	if txid == nil {
@@ -244,6 +262,7 @@ func get_mempool(par string) {
 
 func init() {
 	newUi("txload tx", true, load_tx, "Load transaction data from the given file, decode it and store in memory")
+	newUi("txpkg pkg", true, load_pkg, "Load a package from the given files (parents first, child last) and submit it to memory pool")
 	newUi("txsend stx", true, send_tx, "Broadcast transaction from memory pool (identified by a given <txid>)")
 	newUi("tx1send stx1", true, send1_tx, "Broadcast transaction to a single random peer (identified by a given <txid>)")
 	newUi("txsendall stxa", true, send_all_tx, "Broadcast all the transactions (what you see after ltx)")
@@ -149,14 +149,14 @@ func all_addrs(par string) {
 		switch best[i].Typ {
 			case 0:
 				copy(pkscr_p2kh[3:23], best[i].Key)
-				ad = btc.NewAddrFromPkScript(pkscr_p2kh[:], common.CFG.Testnet)
+				ad = common.Params.NewAddrFromPkScript(pkscr_p2kh[:])
 			case 1:
 				copy(pkscr_p2sk[2:22], best[i].Key)
-				ad = btc.NewAddrFromPkScript(pkscr_p2sk[:], common.CFG.Testnet)
+				ad = common.Params.NewAddrFromPkScript(pkscr_p2sk[:])
 			case 2:
 				ad = new(btc.BtcAddr)
 				ad.SegwitProg = new(btc.SegwitProg)
-				ad.SegwitProg.HRP = btc.GetSegwitHRP(common.CFG.Testnet)
+				ad.SegwitProg.HRP = common.Params.Bech32HRP
 				ad.SegwitProg.Program = best[i].Key
 		}
 		fmt.Println(i+1, ad.String(), btc.UintToBtc(best[i].rec.Value), "BTC in", best[i].rec.Count(), "inputs")
@@ -60,7 +60,8 @@ func DecodeTxSops(tx *btc.Tx) (s string, missinginp bool, totinp, totout uint64,
 			}
 		}
 		if po != nil {
-			ok := script.VerifyTxScript(po.Pk_script, po.Value, i, tx, script.VER_P2SH|script.VER_DERSIG|script.VER_CLTV)
+			ok := script.VerifyTxScript(po.Pk_script, po.Value, i, tx, script.VER_P2SH|script.VER_DERSIG|script.VER_CLTV|
+				common.BlockChain.DeploymentFlags(common.BlockChain.LastBlock()))
 			if !ok {
 				s += fmt.Sprintln("\nERROR: The transacion does not have a valid signature.")
 				e = errors.New("Invalid signature")
@@ -68,7 +69,7 @@ func DecodeTxSops(tx *btc.Tx) (s string, missinginp bool, totinp, totout uint64,
 			totinp += po.Value
 
 			ads := "???"
-			if ad := btc.NewAddrFromPkScript(po.Pk_script, common.Testnet); ad != nil {
+			if ad := common.Params.NewAddrFromPkScript(po.Pk_script); ad != nil {
 				ads = ad.String()
 			}
 			s += fmt.Sprintf(" %15.8f BTC @ %s", float64(po.Value)/1e8, ads)
@@ -94,7 +95,7 @@ func DecodeTxSops(tx *btc.Tx) (s string, missinginp bool, totinp, totout uint64,
 	s += fmt.Sprintln(len(tx.TxOut), "Output(s):")
 	for i := range tx.TxOut {
 		totout += tx.TxOut[i].Value
-		adr := btc.NewAddrFromPkScript(tx.TxOut[i].Pk_script, common.Testnet)
+		adr := common.Params.NewAddrFromPkScript(tx.TxOut[i].Pk_script)
 		if adr != nil {
 			s += fmt.Sprintf(" %15.8f BTC to adr %s\n", float64(tx.TxOut[i].Value)/1e8, adr.String())
 		} else {
@@ -160,10 +161,17 @@ func LoadRawTx(buf []byte) (s string) {
 	}
 
 	network.TxMutex.Lock()
-	_, ok := network.TransactionsToSend[tx.Hash.BIdx()]
+	t2s, ok := network.TransactionsToSend[tx.Hash.BIdx()]
 	network.TxMutex.Unlock()
 	if ok {
 		s += fmt.Sprintln("Transaction added to the memory pool. You can broadcast it now.")
+		if len(t2s.Orphaned) > 0 {
+			s += fmt.Sprintln("It has replaced transaction(s) whose signatures advertised", len(t2s.Orphaned),
+				"child keys that will not get confirmed now:")
+			for _, h := range t2s.Orphaned {
+				s += fmt.Sprintln("  ", hex.EncodeToString(h[:]))
+			}
+		}
 	} else {
 		s += fmt.Sprintln("Transaction not rejected, but also not accepted - very strange!")
 	}
@@ -171,6 +179,40 @@ func LoadRawTx(buf []byte) (s string) {
 	return
 }
 
+// Decodes the txs (parents first, the child last) and submits them as a package
+func LoadRawPackage(bufs [][]byte) (s string) {
+	pkg := &network.OnePackage{Local: true}
+	for _, buf := range bufs {
+		txd, er := hex.DecodeString(string(buf))
+		if er != nil {
+			txd = buf
+		}
+		tx, le := btc.NewTx(txd)
+		if tx == nil || le != len(txd) {
+			s += fmt.Sprintln("Could not decode transaction file or it has some extra data")
+			return
+		}
+		tx.SetHash(txd)
+		pkg.Txs = append(pkg.Txs, tx)
+	}
+
+	if e := network.HandleNetPackage(pkg); e != nil {
+		s += fmt.Sprintln("Package rejected:", e.Error())
+		return
+	}
+	for i, tx := range pkg.Txs {
+		if pkg.New[i] {
+			s += fmt.Sprintf("%s  added with fee %d SPB\n", tx.Hash.String(), pkg.Fees[i]/uint64(tx.VSize()))
+		} else {
+			s += fmt.Sprintf("%s  already known\n", tx.Hash.String())
+		}
+	}
+	if pkg.VSize > 0 {
+		s += fmt.Sprintf("Package fee %d SPB. It has been sent to the peers that accept packages.\n", pkg.Fee/uint64(pkg.VSize))
+	}
+	return
+}
+
 func SendInvToRandomPeer(typ uint32, h *btc.Uint256) {
 	common.CountSafe(fmt.Sprint("NetSendOneInv", typ))
 
@@ -0,0 +1,110 @@
+package webui
+
+import (
+	"net/http"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/chain"
+	"github.com/lentus/wotscoin/client/common"
+)
+
+// Block explorer backend - these need Index.TxIndex / Index.AddrIndex in the config
+
+// /tx.json?id=<txid>
+func json_tx(w http.ResponseWriter, r *http.Request) {
+	if !ipchecker(r) {
+		return
+	}
+
+	type one_tx struct {
+		TxID      string
+		Hex       string
+		Height    uint32
+		Pos       uint32
+		BlockHash string
+		Error     string `json:",omitempty"`
+	}
+
+	res := new(one_tx)
+	if len(r.Form["id"]) == 0 {
+		res.Error = "No id given"
+	} else if txid := btc.NewUint256FromString(r.Form["id"][0]); txid == nil {
+		res.Error = "Bad txid"
+	} else if raw, loc, er := common.BlockChain.GetRawTxByID(txid); er != nil {
+		res.Error = er.Error()
+	} else {
+		res.TxID = txid.String()
+		res.Hex = hex.EncodeToString(raw)
+		res.Height = loc.Height
+		res.Pos = loc.Pos
+		if loc.Block != nil {
+			res.BlockHash = loc.Block.BlockHash.String()
+		}
+	}
+
+	bx, er := json.Marshal(res)
+	if er == nil {
+		w.Header()["Content-Type"] = []string{"application/json"}
+		w.Write(bx)
+	} else {
+		println(er.Error())
+	}
+}
+
+
+// /addrhist.json?addr=<address>
+func json_addrhist(w http.ResponseWriter, r *http.Request) {
+	if !ipchecker(r) {
+		return
+	}
+
+	type one_rec struct {
+		TxID      string
+		Height    uint32
+		Index     uint32
+		Value     uint64
+		Spend     bool
+		XNYSS     bool
+		SpentTxID string `json:",omitempty"`
+		SpentVout uint32
+	}
+
+	var out struct {
+		Address string
+		Balance uint64
+		History []*one_rec
+		Error   string `json:",omitempty"`
+	}
+
+	if len(r.Form["addr"]) == 0 {
+		out.Error = "No addr given"
+	} else if ad, er := btc.NewAddrFromString(r.Form["addr"][0]); er != nil {
+		out.Error = er.Error()
+	} else if hist, er := common.BlockChain.GetAddrHistory(ad.OutScript()); er != nil {
+		out.Error = er.Error()
+	} else {
+		out.Address = ad.String()
+		out.History = make([]*one_rec, len(hist))
+		for i, h := range hist {
+			rec := &one_rec{TxID: h.TxID.String(), Height: h.Height, Index: h.Index, Value: h.Value,
+				Spend: h.IsSpend(), XNYSS: (h.Flags&chain.ADDR_HIST_XNYSS) != 0}
+			if rec.Spend {
+				rec.SpentTxID = btc.NewUint256(h.Spent.Hash[:]).String()
+				rec.SpentVout = h.Spent.Vout
+				out.Balance -= h.Value
+			} else {
+				out.Balance += h.Value
+			}
+			out.History[i] = rec
+		}
+	}
+
+	bx, er := json.Marshal(&out)
+	if er == nil {
+		w.Header()["Content-Type"] = []string{"application/json"}
+		w.Write(bx)
+	} else {
+		println(er.Error())
+	}
+}
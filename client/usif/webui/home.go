@@ -43,6 +43,7 @@ func p_home(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s = strings.Replace(s, "<!--PUB_AUTH_KEY-->", common.PublicKey, 1)
+	s = strings.Replace(s, "<!--PUB_WOTS_AUTH_KEY-->", common.WotsPublicKey, 1)
 
 	write_html_head(w, r)
 	w.Write([]byte(s))
@@ -11,7 +11,9 @@ import (
 	"runtime/debug"
 	"github.com/lentus/wotscoin/lib/btc"
 	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/client/events"
 	"github.com/lentus/wotscoin/client/network"
+	"github.com/lentus/wotscoin/lib/others/peersdb"
 )
 
 
@@ -114,6 +116,58 @@ func json_peerst(w http.ResponseWriter, r *http.Request) {
 }
 
 
+func json_peerstats(w http.ResponseWriter, r *http.Request) {
+	if !ipchecker(r) {
+		return
+	}
+
+	type one_stat_rec struct {
+		*peersdb.PeerStats
+		Score int
+	}
+
+	sts := peersdb.GetAllStats()
+	out := make([]one_stat_rec, len(sts))
+	for i, st := range sts {
+		out[i].PeerStats = st
+		out[i].Score = st.Score()
+	}
+
+	bx, er := json.Marshal(out)
+	if er == nil {
+		w.Header()["Content-Type"] = []string{"application/json"}
+		w.Write(bx)
+	} else {
+		println(er.Error())
+	}
+}
+
+
+func csv_peerstats(w http.ResponseWriter, r *http.Request) {
+	if !ipchecker(r) {
+		return
+	}
+
+	w.Header()["Content-Type"] = []string{"text/csv"}
+	w.Header()["Content-Disposition"] = []string{"attachment; filename=peerstats.csv"}
+	fmt.Fprintln(w, "Addr,Score,LastSeen,Conns,BanCount,LastBan,BanReason,Misbehave,BytesServed,PingAvg,BlocksFirst,BlocksLate")
+	for _, st := range peersdb.GetAllStats() {
+		fmt.Fprintf(w, "%s,%d,%d,%d,%d,%d,%s,%d,%d,%d,%d,%d\n", st.Addr, st.Score(), st.LastSeen,
+			st.Conns, st.BanCount, st.LastBan, strconv.Quote(st.BanReason), st.Misbehave, st.BytesServed,
+			st.PingAvg, st.BlocksFirst, st.BlocksLate)
+	}
+}
+
+
+// WebSocket stream of the real-time events (see client/events)
+func ws_events(w http.ResponseWriter, r *http.Request) {
+	if !ipchecker(r) {
+		return
+	}
+	events.ServeWebSocket(w, r)
+}
+
+
 func json_bwidth(w http.ResponseWriter, r *http.Request) {
 	if !ipchecker(r) {
 		return
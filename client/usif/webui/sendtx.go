@@ -70,7 +70,7 @@ func dl_payment(w http.ResponseWriter, r *http.Request) {
 					if er==nil {
 						var po = btc.TxPrevOut{Hash:hash.Hash, Vout:uint32(vout)}
 						if res := common.BlockChain.Unspent.UnspentGet(&po); res != nil {
-							addr := btc.NewAddrFromPkScript(res.Pk_script, common.Testnet)
+							addr := common.Params.NewAddrFromPkScript(res.Pk_script)
 
 							unsp := &utxo.OneUnspentTx{TxPrevOut:po, Value:res.Value,
 								MinedAt:res.BlockHeight, Coinbase:res.WasCoinbase, BtcAddr:addr}
@@ -121,7 +121,7 @@ func dl_payment(w http.ResponseWriter, r *http.Request) {
 						}
 						pay_cmd += addr.String() + "=" + btc.UintToBtc(am)
 
-						outs, er := btc.NewSpendOutputs(addr, am, common.CFG.Testnet)
+						outs, er := btc.NewSpendOutputs(addr, am, common.Params.Testnet)
 						if er != nil {
 							err = er.Error()
 							goto error
@@ -153,7 +153,17 @@ func dl_payment(w http.ResponseWriter, r *http.Request) {
 			goto error
 		}
 
-		pay_cmd += " -fee " + r.Form["txfee"][0]
+		if len(r.Form["autofee"])==1 && len(r.Form["feerate"])==1 {
+			// let the wallet calculate the fee for the actual size of the signed tx
+			if spb, er := strconv.ParseFloat(r.Form["feerate"][0], 64); er==nil && spb>0 {
+				pay_cmd += fmt.Sprint(" -feerate ", spb)
+			} else {
+				err = "Incorrect fee rate: " + r.Form["feerate"][0]
+				goto error
+			}
+		} else {
+			pay_cmd += " -fee " + r.Form["txfee"][0]
+		}
 		spentsofar += am
 
 		if len(r.Form["change"][0])>1 {
@@ -168,7 +178,7 @@ func dl_payment(w http.ResponseWriter, r *http.Request) {
 
 		if totalinput > spentsofar {
 			// Add change output
-			outs, er := btc.NewSpendOutputs(change_addr, totalinput - spentsofar, common.CFG.Testnet)
+			outs, er := btc.NewSpendOutputs(change_addr, totalinput - spentsofar, common.Params.Testnet)
 			if er != nil {
 				err = er.Error()
 				goto error
@@ -237,6 +247,14 @@ func p_snd(w http.ResponseWriter, r *http.Request) {
 
 	s := load_template("send.html")
 
+	// the length of the scriptSig spending a default XNYSS multisig (3 long-term keys), with its var_int
+	ms := btc.NewXNYSSMultiSig()
+	for i := 0; i < 3; i++ {
+		ms.PublicKeys = append(ms.PublicKeys, make([]byte, 20))
+	}
+	s = strings.Replace(s, "/*_XNYSS_SIG_SIZE_*/", fmt.Sprint("var xnyss_sig_size = ",
+		len(ms.XnyssSignedScript(common.Params.XNYSSBranches)) + 2), 1)
+
 	write_html_head(w, r)
 	w.Write([]byte(s))
 	write_html_tail(w)
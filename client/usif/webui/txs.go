@@ -92,7 +92,7 @@ func output_tx_xml(w http.ResponseWriter, tx *btc.Tx) {
 			po = common.BlockChain.Unspent.UnspentGet(&tx.TxIn[i].Input)
 		}
 		if po != nil {
-			ok := script.VerifyTxScript(po.Pk_script, po.Value, i, tx, script.STANDARD_VERIFY_FLAGS)
+			ok := script.VerifyTxScript(po.Pk_script, po.Value, i, tx, network.StandardVerifyFlags())
 			if !ok {
 				w.Write([]byte("<status>Script FAILED</status>"))
 			} else {
@@ -100,7 +100,7 @@ func output_tx_xml(w http.ResponseWriter, tx *btc.Tx) {
 			}
 			fmt.Fprint(w, "<value>", po.Value, "</value>")
 			fmt.Fprint(w, "<pkscript>", hex.EncodeToString(po.Pk_script), "</pkscript>")
-			if ad := btc.NewAddrFromPkScript(po.Pk_script, common.Testnet); ad != nil {
+			if ad := common.Params.NewAddrFromPkScript(po.Pk_script); ad != nil {
 				fmt.Fprint(w, "<addr>", ad.String(), "</addr>")
 			}
 			fmt.Fprint(w, "<block>", po.BlockHeight, "</block>")
@@ -131,7 +131,7 @@ func output_tx_xml(w http.ResponseWriter, tx *btc.Tx) {
 	for i := range tx.TxOut {
 		w.Write([]byte("<output>"))
 		fmt.Fprint(w, "<value>", tx.TxOut[i].Value, "</value>")
-		adr := btc.NewAddrFromPkScript(tx.TxOut[i].Pk_script, common.Testnet)
+		adr := common.Params.NewAddrFromPkScript(tx.TxOut[i].Pk_script)
 		if adr != nil {
 			fmt.Fprint(w, "<addr>", adr.String(), "</addr>")
 		} else {
@@ -453,6 +453,12 @@ func raw_tx(w http.ResponseWriter, r *http.Request) {
 	if tx, ok := network.TransactionsToSend[txid.BIdx()]; ok {
 		s, _, _, _, _ := usif.DecodeTx(tx.Tx)
 		w.Write([]byte(s))
+	} else if raw, loc, er := common.BlockChain.GetRawTxByID(txid); er == nil {
+		tx, _ := btc.NewTx(raw)
+		tx.SetHash(raw)
+		fmt.Fprintln(w, "Confirmed in block", loc.Height, "at position", loc.Pos)
+		s, _, _, _, _ := usif.DecodeTx(tx)
+		w.Write([]byte(s))
 	} else {
 		fmt.Fprintln(w, "Not found")
 	}
@@ -0,0 +1,69 @@
+package webui
+
+import (
+	"net/http"
+	"io/ioutil"
+	"encoding/json"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/client/network"
+)
+
+// XNYSS key confirmations for an air-gapped wallet (like the TextUI's "confirm"),
+// shown as an animated QR code
+
+const upkhQrFrameSize = 200 // smaller frames make QR codes that scan easier from a screen
+
+func p_upkh(w http.ResponseWriter, r *http.Request) {
+	if !ipchecker(r) {
+		return
+	}
+	s := load_template("upkh.html")
+	write_html_head(w, r)
+	w.Write([]byte(s))
+	write_html_tail(w)
+}
+
+
+func json_upkh(w http.ResponseWriter, r *http.Request) {
+	if !ipchecker(r) {
+		return
+	}
+
+	var out struct {
+		Error string `json:",omitempty"`
+		Asked int
+		Confirmed int
+		TipHeight uint32
+		TipHash string
+		Frames []string
+	}
+
+	var d []byte
+	r.ParseMultipartForm(2e6)
+	if fil, _, _ := r.FormFile("unconffile"); fil != nil {
+		d, _ = ioutil.ReadAll(fil)
+		fil.Close()
+	} else if len(r.Form["unconf"]) == 1 {
+		d = []byte(r.Form["unconf"][0])
+	}
+
+	if uf, er := btc.ReadUpkhFile(d, btc.UPKH_FILE_UNCONFIRMED); er != nil {
+		out.Error = er.Error()
+	} else if uf.Network != common.Params.Name {
+		out.Error = "The file is for " + uf.Network + " network, not for " + common.Params.Name
+	} else {
+		cf := network.UpkhConfirmations(uf.Hashes)
+		out.Asked, out.Confirmed = len(uf.Hashes), cf.Found()
+		out.TipHeight, out.TipHash = cf.TipHeight, cf.TipHash.String()
+		out.Frames = cf.Frames(upkhQrFrameSize)
+	}
+
+	bx, er := json.Marshal(out)
+	if er == nil {
+		w.Header()["Content-Type"] = []string{"application/json"}
+		w.Write(bx)
+	} else {
+		println(er.Error())
+	}
+}
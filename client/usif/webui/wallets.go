@@ -51,10 +51,10 @@ func getaddrtype(aa *btc.BtcAddr) string {
 	if aa.SegwitProg != nil && aa.SegwitProg.Version == 0 && len(aa.SegwitProg.Program)==20 {
 		return "P2WPKH"
 	}
-	if aa.Version == btc.AddrVerPubkey(common.Testnet) {
+	if aa.Version == common.Params.AddrVerPubkey {
 		return "P2PKH"
 	}
-	if aa.Version == btc.AddrVerScript(common.Testnet) {
+	if aa.Version == common.Params.AddrVerScript {
 		return "P2SH"
 	}
 	return "unknown"
@@ -175,12 +175,12 @@ func json_balance(w http.ResponseWriter, r *http.Request) {
 		}
 
 		/* For P2KH addr, we wlso check its segwit's P2SH-P2WPKH and Native P2WPKH */
-		if aa.SegwitProg == nil && aa.Version == btc.AddrVerPubkey(common.Testnet) {
+		if aa.SegwitProg == nil && aa.Version == common.Params.AddrVerPubkey {
 			p2kh := aa.Hash160
 
 			// P2SH SegWit if applicable
 			h160 := btc.Rimp160AfterSha256(append([]byte{0,20}, p2kh[:]...))
-			aa = btc.NewAddrFromHash160(h160[:], btc.AddrVerScript(common.Testnet))
+			aa = btc.NewAddrFromHash160(h160[:], common.Params.AddrVerScript)
 			newrec.SegWitAddr = aa.String()
 			unsp = wallet.GetAllUnspent(aa)
 			if len(unsp) > 0 {
@@ -218,7 +218,7 @@ func json_balance(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// Native SegWit if applicable
-			aa = btc.NewAddrFromPkScript(append([]byte{0,20}, p2kh[:]...), common.Testnet)
+			aa = common.Params.NewAddrFromPkScript(append([]byte{0,20}, p2kh[:]...))
 			newrec.SegWitNativeAddr = aa.String()
 			unsp = wallet.GetAllUnspent(aa)
 			if len(unsp) > 0 {
@@ -348,19 +348,19 @@ func dl_balance(w http.ResponseWriter, r *http.Request) {
 			}
 
 			/* Segwit P2WPKH: */
-			if aa.SegwitProg == nil && aa.Version == btc.AddrVerPubkey(common.Testnet) {
+			if aa.SegwitProg == nil && aa.Version == common.Params.AddrVerPubkey {
 				p2kh := aa.Hash160
 
 				// P2SH SegWit if applicable
 				h160 := btc.Rimp160AfterSha256(append([]byte{0,20}, aa.Hash160[:]...))
-				aa = btc.NewAddrFromHash160(h160[:], btc.AddrVerScript(common.Testnet))
+				aa = btc.NewAddrFromHash160(h160[:], common.Params.AddrVerScript)
 				newrecs = wallet.GetAllUnspent(aa)
 				if len(newrecs) > 0 {
 					thisbal = append(thisbal, newrecs...)
 				}
 
 				// Native SegWit if applicable
-				aa = btc.NewAddrFromPkScript(append([]byte{0,20}, p2kh[:]...), common.Testnet)
+				aa = common.Params.NewAddrFromPkScript(append([]byte{0,20}, p2kh[:]...))
 				newrecs = wallet.GetAllUnspent(aa)
 				if len(newrecs) > 0 {
 					thisbal = append(thisbal, newrecs...)
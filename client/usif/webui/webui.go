@@ -119,7 +119,7 @@ func write_html_head(w http.ResponseWriter, r *http.Request) {
 		s = strings.Replace(s, "{HELPURL}", "help", 1)
 	}
 	s = strings.Replace(s, "{VERSION}", gocoin.Version, 1)
-	if common.Testnet {
+	if common.Params.Testnet {
 		s = strings.Replace(s, "{TESTNET}", " Testnet ", 1)
 	} else {
 		s = strings.Replace(s, "{TESTNET}", "", 1)
@@ -177,6 +177,7 @@ func ServerThread(iface string) {
 	http.HandleFunc("/blocks", p_blocks)
 	http.HandleFunc("/miners", p_miners)
 	http.HandleFunc("/counts", p_counts)
+	http.HandleFunc("/upkh", p_upkh)
 	http.HandleFunc("/cfg", p_cfg)
 	http.HandleFunc("/help", p_help)
 
@@ -184,6 +185,8 @@ func ServerThread(iface string) {
 	http.HandleFunc("/txsre.xml", xml_txsre)
 	http.HandleFunc("/txw4i.xml", xml_txw4i)
 	http.HandleFunc("/raw_tx", raw_tx)
+	http.HandleFunc("/tx.json", json_tx)
+	http.HandleFunc("/addrhist.json", json_addrhist)
 
 	http.HandleFunc("/", p_home)
 	http.HandleFunc("/status.json", json_status)
@@ -194,6 +197,9 @@ func ServerThread(iface string) {
 	http.HandleFunc("/netcon.json", json_netcon)
 	http.HandleFunc("/blocks.json", json_blocks)
 	http.HandleFunc("/peerst.json", json_peerst)
+	http.HandleFunc("/peerstats.json", json_peerstats)
+	http.HandleFunc("/peerstats.csv", csv_peerstats)
+	http.HandleFunc("/events", ws_events)
 	http.HandleFunc("/bwchar.json", json_bwchar)
 	http.HandleFunc("/mempool_stats.json", json_mempool_stats)
 	http.HandleFunc("/mempool_fees.json", json_mempool_fees)
@@ -201,6 +207,7 @@ func ServerThread(iface string) {
 	http.HandleFunc("/miners.json", json_miners)
 	http.HandleFunc("/blfees.json", json_blfees)
 	http.HandleFunc("/walsta.json", json_wallet_status)
+	http.HandleFunc("/upkh.json", json_upkh)
 
 	http.HandleFunc("/mempool_fees.txt", txt_mempool_fees)
 
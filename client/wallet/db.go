@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/lentus/wotscoin/client/common"
+	"github.com/lentus/wotscoin/client/events"
 	"github.com/lentus/wotscoin/lib/btc"
 	"github.com/lentus/wotscoin/lib/utxo"
 )
@@ -146,7 +147,7 @@ func all_del_utxos(tx *utxo.UtxoRec, outs []bool) {
 		}
 
 		if rec == nil {
-			println("balance rec not found for", btc.NewAddrFromPkScript(out.PKScr, common.CFG.Testnet).String(),
+			println("balance rec not found for", common.Params.NewAddrFromPkScript(out.PKScr).String(),
 				btc.NewUint256(tx.TxID[:]).String(), vout, btc.UintToBtc(out.Value))
 			continue
 		}
@@ -155,7 +156,7 @@ func all_del_utxos(tx *utxo.UtxoRec, outs []bool) {
 
 		if rec.unspMap != nil {
 			if _, ok := rec.unspMap[nr]; !ok {
-				println("unspent rec not in map for", btc.NewAddrFromPkScript(out.PKScr, common.CFG.Testnet).String())
+				println("unspent rec not in map for", common.Params.NewAddrFromPkScript(out.PKScr).String())
 				continue
 			}
 			delete(rec.unspMap, nr)
@@ -182,7 +183,7 @@ func all_del_utxos(tx *utxo.UtxoRec, outs []bool) {
 			}
 		}
 		if i == len(rec.unsp) {
-			println("unspent rec not in list for", btc.NewAddrFromPkScript(out.PKScr, common.CFG.Testnet).String())
+			println("unspent rec not in list for", common.Params.NewAddrFromPkScript(out.PKScr).String())
 			continue
 		}
 		if len(rec.unsp) == 1 {
@@ -206,11 +207,13 @@ func all_del_utxos(tx *utxo.UtxoRec, outs []bool) {
 // This is called while accepting the block (from the chain's thread)
 func TxNotifyAdd(tx *utxo.UtxoRec) {
 	NewUTXO(tx)
+	events.BalanceChanged(tx, nil)
 }
 
 // This is called while accepting the block (from the chain's thread)
 func TxNotifyDel(tx *utxo.UtxoRec, outs []bool) {
 	all_del_utxos(tx, outs)
+	events.BalanceChanged(tx, outs)
 }
 
 // Call the cb function for each unspent record
@@ -251,9 +254,9 @@ func GetAllUnspent(aa *btc.BtcAddr) (thisbal utxo.AllUnspentTx) {
 		default:
 			return
 		}
-	} else if aa.Version == btc.AddrVerPubkey(common.Testnet) {
+	} else if aa.Version == common.Params.AddrVerPubkey {
 		rec = AllBalancesP2KH[aa.Hash160]
-	} else if aa.Version == btc.AddrVerScript(common.Testnet) {
+	} else if aa.Version == common.Params.AddrVerScript {
 		rec = AllBalancesP2SH[aa.Hash160]
 	} else {
 		return
@@ -335,7 +338,7 @@ func PrintStat() {
 					if qr, vout := v.GetRec(); qr != nil {
 						if oo := qr.Outs[vout]; oo != nil {
 							if oo.Value > 100e8 {
-								ad := btc.NewAddrFromPkScript(oo.PKScr, common.Testnet)
+								ad := common.Params.NewAddrFromPkScript(oo.PKScr)
 								if ad != nil {
 									println(btc.UintToBtc(oo.Value), "@", ad.String(), "from tx", btc.NewUint256(qr.TxID[:]).String(), vout)
 								}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"bytes"
 	"errors"
-	"strings"
 	"math/big"
 	"encoding/hex"
 	"github.com/lentus/wotscoin/lib/others/bech32"
@@ -36,8 +35,8 @@ type SegwitProg struct {
 
 
 func NewAddrFromString(hs string) (a *BtcAddr, e error) {
-	if strings.HasPrefix(hs, "bc1") || strings.HasPrefix(hs, "tb1") {
-		var sw = &SegwitProg{HRP:hs[:2]}
+	if hrp := segwitHRPOf(hs); hrp != "" {
+		var sw = &SegwitProg{HRP:hrp}
 		sw.Version, sw.Program = bech32.SegwitDecode(sw.HRP, hs)
 		if sw.Program != nil {
 			a = &BtcAddr{SegwitProg:sw}
@@ -91,54 +90,17 @@ func NewAddrFromPubkey(in []byte, ver byte) (a *BtcAddr) {
 
 
 func AddrVerPubkey(testnet bool) byte {
-	if testnet {
-		return 111
-	} else {
-		return 0
-	}
+	return legacyParams(testnet).AddrVerPubkey
 }
 
 
 func AddrVerScript(testnet bool) byte {
-	if testnet {
-		return 196
-	} else {
-		return 5
-	}
+	return legacyParams(testnet).AddrVerScript
 }
 
 
 func NewAddrFromPkScript(scr []byte, testnet bool) (*BtcAddr) {
-	// check segwit bech32:
-	if len(scr)==0 {
-		return nil
-	}
-
-	if version, program := IsWitnessProgram(scr); program != nil {
-		sw := &SegwitProg{HRP:GetSegwitHRP(testnet), Version:version, Program:program}
-
-		str := bech32.SegwitEncode(sw.HRP, version, program)
-		if str == "" {
-			return nil
-		}
-
-		ad := new(BtcAddr)
-		ad.Enc58str = str
-		ad.SegwitProg = sw
-
-		return ad
-	}
-
-	if len(scr)==25 && scr[0]==0x76 && scr[1]==0xa9 && scr[2]==0x14 && scr[23]==0x88 && scr[24]==0xac {
-		return NewAddrFromHash160(scr[3:23], AddrVerPubkey(testnet))
-	} else if len(scr)==67 && scr[0]==0x41 && scr[66]==0xac {
-		return NewAddrFromPubkey(scr[1:66], AddrVerPubkey(testnet))
-	} else if len(scr)==35 && scr[0]==0x21 && scr[34]==0xac {
-		return NewAddrFromPubkey(scr[1:34], AddrVerPubkey(testnet))
-	} else if len(scr)==23 && scr[0]==0xa9 && scr[1]==0x14 && scr[22]==0x87 {
-		return NewAddrFromHash160(scr[2:22], AddrVerScript(testnet))
-	}
-	return nil
+	return legacyParams(testnet).NewAddrFromPkScript(scr)
 }
 
 
@@ -238,7 +200,7 @@ func (a *BtcAddr) OutScript() (res []byte) {
 		res[0] = 0x00 // OP_0
 		res[1] = byte(len(a.SegwitProg.Program))
 		copy(res[2:], a.SegwitProg.Program)
-	} else if a.Version==AddrVerPubkey(false) || a.Version==AddrVerPubkey(true) || a.Version==48 /*Litecoin*/ {
+	} else if isAddrVerPubkey(a.Version) {
 		res = make([]byte, 25)
 		res[0] = 0x76
 		res[1] = 0xa9
@@ -246,7 +208,7 @@ func (a *BtcAddr) OutScript() (res []byte) {
 		copy(res[3:23], a.Hash160[:])
 		res[23] = 0x88
 		res[24] = 0xac
-	} else if a.Version==AddrVerScript(false) || a.Version==AddrVerScript(true) {
+	} else if isAddrVerScript(a.Version) {
 		res = make([]byte, 23)
 		res[0] = 0xa9
 		res[1] = 20
@@ -326,9 +288,5 @@ func (sw *SegwitProg) String() (res string) {
 }
 
 func GetSegwitHRP(testnet bool) string {
-	if testnet {
-		return "tb"
-	} else {
-		return "bc"
-	}
+	return legacyParams(testnet).Bech32HRP
 }
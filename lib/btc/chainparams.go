@@ -0,0 +1,412 @@
+package btc
+
+import (
+	"errors"
+	"strings"
+	"math/big"
+	"encoding/hex"
+	"encoding/binary"
+)
+
+// A soft fork deployed with BIP9 versionbits signalling
+type BIP9Deployment struct {
+	Name      string
+	Bit       uint8
+	StartTime uint32 // Median time past from which the bit is being counted (0xffffffff for always active)
+	Timeout   uint32 // Median time past at which the deployment fails, unless locked in
+	Threshold uint32 // Blocks per window needed to lock in (zero to use ChainParams.BIP9Threshold)
+}
+
+// Everything that makes one network different from another
+type ChainParams struct {
+	Name string
+	Testnet bool // use testnet HD key prefixes and the testnet min-difficulty rule
+
+	Magic [4]byte
+	DefaultPort uint16
+	RPCPort uint32
+	DataSubdir string
+	DNSSeeds []string
+
+	// The genesis block header
+	GenesisHash string
+	GenesisMerkle string
+	GenesisTime uint32
+	GenesisBits uint32
+	GenesisNonce uint32
+	GenesisCoinbase string // hex encoded, the only transaction of the genesis block
+
+	PowLimit string // hex encoded big endian value of the highest allowed target
+	PowNoRetargeting bool // difficulty never changes (regtest)
+
+	AddrVerPubkey byte // P2PKH address version (private keys use it plus 0x80)
+	AddrVerScript byte // P2SH address version
+	Bech32HRP string
+	MessageMagic string
+
+	BIP34Height uint32
+	BIP65Height uint32
+	BIP66Height uint32
+	BIP91Height uint32
+	CSVHeight uint32 // if non zero CSV verifications will be enforced from this block onwards
+	SegwitHeight uint32 // if non zero SegWit verifications will be enforced from this block onwards
+
+	BIP9Window uint32
+	BIP9Threshold uint32
+	Deployments []BIP9Deployment
+
+	XNYSSBranches int
+	XNYSSConfirmsRequired uint8
+}
+
+
+// Coinbase of the genesis block shared with Bitcoin, paying to Satoshi's key
+const bitcoinGenesisCoinbase = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+var (
+	// The original network, that shares its genesis block with Bitcoin
+	MainNetParams = ChainParams{
+		Name: "mainnet",
+		Magic: [4]byte{0xF9,0xBE,0xB4,0xD9},
+		DefaultPort: 52081,
+		RPCPort: 8332,
+		DataSubdir: "btcnet",
+		DNSSeeds: []string{"dnsseed.wlinde.com"},
+		GenesisHash: "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+		GenesisMerkle: "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b",
+		GenesisTime: 1231006505,
+		GenesisBits: 0x1d00ffff,
+		GenesisNonce: 2083236893,
+		GenesisCoinbase: bitcoinGenesisCoinbase,
+		PowLimit: "00000000FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		AddrVerPubkey: 0,
+		AddrVerScript: 5,
+		Bech32HRP: "bc",
+		MessageMagic: MessageMagic,
+		BIP34Height: 227931,
+		BIP65Height: 388381,
+		BIP66Height: 363725,
+		BIP91Height: 477120,
+		CSVHeight: 419328,
+		SegwitHeight: 481824, // https://www.reddit.com/r/Bitcoin/comments/6okd1n/bip91_lock_in_is_guaranteed_as_of_block_476768/
+		BIP9Window: 2016,
+		BIP9Threshold: 1916,
+		Deployments: []BIP9Deployment{
+			{Name: "xnyss", Bit: 5, StartTime: 1798761600 /*2027-01-01*/, Timeout: 1830297600 /*2028-01-01*/},
+		},
+		XNYSSBranches: 3,
+		XNYSSConfirmsRequired: 1,
+	}
+
+	TestNet3Params = ChainParams{
+		Name: "testnet3",
+		Testnet: true,
+		Magic: [4]byte{0x0B,0x11,0x09,0x07},
+		DefaultPort: 52082,
+		RPCPort: 18332,
+		DataSubdir: "tstnet",
+		DNSSeeds: []string{"dnsseed.wlinde.com"},
+		GenesisHash: "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943",
+		GenesisMerkle: "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b",
+		GenesisTime: 1296688602,
+		GenesisBits: 0x1d00ffff,
+		GenesisNonce: 414098458,
+		GenesisCoinbase: bitcoinGenesisCoinbase,
+		PowLimit: "00000000FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		AddrVerPubkey: 111,
+		AddrVerScript: 196,
+		Bech32HRP: "tb",
+		MessageMagic: MessageMagic,
+		BIP34Height: 21111,
+		BIP65Height: 581885,
+		BIP66Height: 330776,
+		CSVHeight: 770112,
+		SegwitHeight: 834624,
+		BIP9Window: 2016,
+		BIP9Threshold: 1512,
+		Deployments: []BIP9Deployment{
+			{Name: "xnyss", Bit: 5, StartTime: 1793491200 /*2026-11-01*/, Timeout: 1825027200 /*2027-11-01*/},
+		},
+		XNYSSBranches: 3,
+		XNYSSConfirmsRequired: 1,
+	}
+
+	// Standalone wotscoin network, with all the soft forks active from the start.
+	// The genesis coinbase has no spendable outputs; its signature script says:
+	// "wotscoin genesis - hash based signatures for a post-quantum era"
+	WotscoinParams = ChainParams{
+		Name: "wotscoin",
+		Magic: [4]byte{0x77,0x6f,0x74,0x73},
+		DefaultPort: 52091,
+		RPCPort: 8352,
+		DataSubdir: "wotsnet",
+		GenesisHash: "0000092ce7e0cf54bbf16bdcd3d5d9e1a1fd3fd9948bb42a2c5b284fb52da77b",
+		GenesisMerkle: "a02826626972765d88e6ba5d61df59e0007ee56ebf0dde5b54f1d6456d5bf2bc",
+		GenesisTime: 1780000000,
+		GenesisBits: 0x1e0fffff,
+		GenesisNonce: 630044,
+		GenesisCoinbase: "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4704ffff0f1e01043f776f7473636f696e2067656e65736973202d2068617368206261736564207369676e61747572657320666f72206120706f73742d7175616e74756d20657261ffffffff010000000000000000016a00000000",
+		PowLimit: "00000FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		AddrVerPubkey: 71, // V...
+		AddrVerScript: 73, // W...
+		Bech32HRP: "wc",
+		MessageMagic: "Wotscoin Signed Message:\n",
+		BIP34Height: 1,
+		BIP65Height: 1,
+		BIP66Height: 1,
+		CSVHeight: 1,
+		SegwitHeight: 1,
+		BIP9Window: 2016,
+		BIP9Threshold: 1916,
+		Deployments: []BIP9Deployment{
+			{Name: "xnyss", Bit: 5, StartTime: 0xffffffff},
+		},
+		XNYSSBranches: 3,
+		XNYSSConfirmsRequired: 1,
+	}
+
+	// Public test network for the wotscoin chain, with the testnet min-difficulty rule.
+	// Genesis coinbase signature script says: "wotstest genesis - public test network"
+	WotsTestParams = ChainParams{
+		Name: "wotstest",
+		Testnet: true,
+		Magic: [4]byte{0x77,0x6f,0x74,0x74},
+		DefaultPort: 52092,
+		RPCPort: 18352,
+		DataSubdir: "wotstest",
+		GenesisHash: "000002c346c43cb84bce7361267590171eb5e4ac505e6ac8be14083f837f66fe",
+		GenesisMerkle: "b1280fbb7275f36a6f028ced759b9931b6a0e973abdb9df97f6de8439c55c8b7",
+		GenesisTime: 1780000000,
+		GenesisBits: 0x1e0fffff,
+		GenesisNonce: 1829987,
+		GenesisCoinbase: "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff2e04ffff0f1e010426776f7473746573742067656e65736973202d207075626c69632074657374206e6574776f726bffffffff010000000000000000016a00000000",
+		PowLimit: "00000FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		AddrVerPubkey: 125, // s...
+		AddrVerScript: 135, // w...
+		Bech32HRP: "wt",
+		MessageMagic: "Wotscoin Signed Message:\n",
+		BIP34Height: 1,
+		BIP65Height: 1,
+		BIP66Height: 1,
+		CSVHeight: 1,
+		SegwitHeight: 1,
+		BIP9Window: 2016,
+		BIP9Threshold: 1512,
+		Deployments: []BIP9Deployment{
+			{Name: "xnyss", Bit: 5, StartTime: 0xffffffff},
+		},
+		XNYSSBranches: 3,
+		XNYSSConfirmsRequired: 1,
+	}
+
+	// Local test network, where blocks are mined on demand with the "generate" command
+	RegTestParams = ChainParams{
+		Name: "regtest",
+		Testnet: true,
+		Magic: [4]byte{0xFA,0xBF,0xB5,0xDA},
+		DefaultPort: 52083,
+		RPCPort: 18443,
+		DataSubdir: "regtest",
+		GenesisHash: "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206",
+		GenesisMerkle: "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b",
+		GenesisTime: 1296688602,
+		GenesisBits: 0x207fffff,
+		GenesisNonce: 2,
+		GenesisCoinbase: bitcoinGenesisCoinbase,
+		PowLimit: "7FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		PowNoRetargeting: true,
+		AddrVerPubkey: 111,
+		AddrVerScript: 196,
+		Bech32HRP: "bcrt",
+		MessageMagic: MessageMagic,
+		BIP34Height: 1,
+		BIP65Height: 1,
+		BIP66Height: 1,
+		CSVHeight: 1,
+		SegwitHeight: 1,
+		BIP9Window: 144,
+		BIP9Threshold: 108,
+		Deployments: []BIP9Deployment{
+			{Name: "xnyss", Bit: 5, StartTime: 0xffffffff},
+		},
+		XNYSSBranches: 3,
+		XNYSSConfirmsRequired: 1,
+	}
+
+	chainParamsList = []*ChainParams{&MainNetParams, &TestNet3Params, &WotscoinParams, &WotsTestParams, &RegTestParams}
+)
+
+
+// Makes the given network known to GetChainParams and the address functions.
+// Call it from init(), as the list is not protected by any mutex.
+func RegisterChainParams(p *ChainParams) error {
+	if GetChainParams(p.Name) != nil {
+		return errors.New("Chain params " + p.Name + " already registered")
+	}
+	chainParamsList = append(chainParamsList, p)
+	return nil
+}
+
+
+// Returns the params of the network with the given name (nil if not known)
+func GetChainParams(name string) *ChainParams {
+	for _, p := range chainParamsList {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+
+// Returns the params of the network using the given magic bytes (nil if not known)
+func GetChainParamsByMagic(magic [4]byte) *ChainParams {
+	for _, p := range chainParamsList {
+		if p.Magic == magic {
+			return p
+		}
+	}
+	return nil
+}
+
+
+// Returns names of all the known networks
+func ChainParamsNames() (res []string) {
+	for _, p := range chainParamsList {
+		res = append(res, p.Name)
+	}
+	return
+}
+
+
+// Used by the functions that still take the testnet bool
+func legacyParams(testnet bool) *ChainParams {
+	if testnet {
+		return &TestNet3Params
+	}
+	return &MainNetParams
+}
+
+
+// Returns the 80 bytes long header of the genesis block
+func (p *ChainParams) GenesisHeader() (hdr []byte) {
+	hdr = make([]byte, 80)
+	binary.LittleEndian.PutUint32(hdr[0:4], 1)
+	copy(hdr[36:68], NewUint256FromString(p.GenesisMerkle).Hash[:])
+	binary.LittleEndian.PutUint32(hdr[68:72], p.GenesisTime)
+	binary.LittleEndian.PutUint32(hdr[72:76], p.GenesisBits)
+	binary.LittleEndian.PutUint32(hdr[76:80], p.GenesisNonce)
+	return
+}
+
+
+// Returns the raw genesis block
+func (p *ChainParams) GenesisBlock() (raw []byte) {
+	cb, _ := hex.DecodeString(p.GenesisCoinbase)
+	raw = append(p.GenesisHeader(), 1)
+	return append(raw, cb...)
+}
+
+
+func (p *ChainParams) Genesis() *Uint256 {
+	return NewUint256FromString(p.GenesisHash)
+}
+
+
+// Returns version byte of private keys for this network
+func (p *ChainParams) AddrVerSecret() byte {
+	return p.AddrVerPubkey + 0x80
+}
+
+
+// Returns the prefix of extended (HD) keys
+func (p *ChainParams) HDKeyPrefix(private bool) uint32 {
+	return HDKeyPrefix(private, p.Testnet)
+}
+
+
+// Returns address of the given pk_script, or nil if the script is not standard
+func (p *ChainParams) NewAddrFromPkScript(scr []byte) (*BtcAddr) {
+	if len(scr)==0 {
+		return nil
+	}
+
+	// check segwit bech32:
+	if version, program := IsWitnessProgram(scr); program != nil {
+		sw := &SegwitProg{HRP:p.Bech32HRP, Version:version, Program:program}
+
+		str := sw.String()
+		if str == "" {
+			return nil
+		}
+
+		ad := new(BtcAddr)
+		ad.Enc58str = str
+		ad.SegwitProg = sw
+
+		return ad
+	}
+
+	if len(scr)==25 && scr[0]==0x76 && scr[1]==0xa9 && scr[2]==0x14 && scr[23]==0x88 && scr[24]==0xac {
+		return NewAddrFromHash160(scr[3:23], p.AddrVerPubkey)
+	} else if len(scr)==67 && scr[0]==0x41 && scr[66]==0xac {
+		return NewAddrFromPubkey(scr[1:66], p.AddrVerPubkey)
+	} else if len(scr)==35 && scr[0]==0x21 && scr[34]==0xac {
+		return NewAddrFromPubkey(scr[1:34], p.AddrVerPubkey)
+	} else if len(scr)==23 && scr[0]==0xa9 && scr[1]==0x14 && scr[22]==0x87 {
+		return NewAddrFromHash160(scr[2:22], p.AddrVerScript)
+	}
+	return nil
+}
+
+
+// Returns true if the address belongs to this network
+func (p *ChainParams) IsOwnAddr(a *BtcAddr) bool {
+	if a.SegwitProg != nil {
+		return a.SegwitProg.HRP == p.Bech32HRP
+	}
+	return a.Version == p.AddrVerPubkey || a.Version == p.AddrVerScript
+}
+
+
+// Returns the known bech32 prefix, that the given string starts with
+func segwitHRPOf(s string) string {
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 {
+		return ""
+	}
+	hrp := strings.ToLower(s[:pos])
+	for _, p := range chainParamsList {
+		if p.Bech32HRP == hrp {
+			return hrp
+		}
+	}
+	return ""
+}
+
+
+func isAddrVerPubkey(ver byte) bool {
+	for _, p := range chainParamsList {
+		if p.AddrVerPubkey == ver {
+			return true
+		}
+	}
+	return false
+}
+
+
+func isAddrVerScript(ver byte) bool {
+	for _, p := range chainParamsList {
+		if p.AddrVerScript == ver {
+			return true
+		}
+	}
+	return false
+}
+
+
+// Returns the highest allowed proof of work target
+func (p *ChainParams) PowLimitValue() (res *big.Int) {
+	res, _ = new(big.Int).SetString(p.PowLimit, 16)
+	return
+}
@@ -0,0 +1,55 @@
+package btc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChainParamsGenesis(t *testing.T) {
+	for _, name := range ChainParamsNames() {
+		p := GetChainParams(name)
+		h := NewSha2Hash(p.GenesisHeader())
+		if !h.Equal(p.Genesis()) {
+			t.Error(name, "genesis header hashes to", h.String())
+		}
+		if GetChainParamsByMagic(p.Magic) != p {
+			t.Error(name, "magic not unique")
+		}
+		if SetCompact(p.GenesisBits).Cmp(p.PowLimitValue()) > 0 {
+			t.Error(name, "genesis bits above pow limit")
+		}
+		bl, e := NewBlock(p.GenesisBlock())
+		if e != nil || bl.BuildTxList() != nil || len(bl.Txs) != 1 || !bl.MerkleRootMatch() || !bl.Hash.Equal(p.Genesis()) {
+			t.Error(name, "genesis coinbase does not match the header")
+		}
+	}
+}
+
+func TestChainParamsAddr(t *testing.T) {
+	h160 := bytes.Repeat([]byte{0xab}, 20)
+	for _, p := range []*ChainParams{&WotscoinParams, &WotsTestParams} {
+		scrs := [][]byte{
+			append(append([]byte{0x76, 0xa9, 0x14}, h160...), 0x88, 0xac),
+			append(append([]byte{0xa9, 0x14}, h160...), 0x87),
+			append([]byte{0x00, 0x14}, h160...),
+		}
+		for _, scr := range scrs {
+			ad := p.NewAddrFromPkScript(scr)
+			if ad == nil || !p.IsOwnAddr(ad) {
+				t.Error(p.Name, "cannot make address")
+				continue
+			}
+			ad2, e := NewAddrFromString(ad.String())
+			if e != nil || ad2 == nil {
+				t.Error(p.Name, "cannot decode", ad.String(), e)
+				continue
+			}
+			if !bytes.Equal(ad2.OutScript(), scr) {
+				t.Error(p.Name, "script mismatch for", ad.String())
+			}
+		}
+	}
+	if ad := WotscoinParams.NewAddrFromPkScript(append(append([]byte{0xa9, 0x14}, h160...), 0x87)); ad.String()[0] != 'W' {
+		t.Error("Unexpected wotscoin P2SH address", ad.String())
+	}
+}
@@ -0,0 +1,183 @@
+package btc
+
+import (
+	"bytes"
+	"sort"
+	"errors"
+	"math/bits"
+	"encoding/binary"
+	"github.com/dchest/siphash"
+)
+
+// Golomb-coded sets, as used by BIP158 compact block filters
+
+const (
+	BASIC_FILTER_TYPE = 0
+	BASIC_FILTER_P = 19
+	BASIC_FILTER_M = 784931
+)
+
+
+// Returns siphash keys for the filter of the given block (hash in internal byte order)
+func GCSFilterKey(blockhash []byte) (k0, k1 uint64) {
+	k0 = binary.LittleEndian.Uint64(blockhash[0:8])
+	k1 = binary.LittleEndian.Uint64(blockhash[8:16])
+	return
+}
+
+
+// Maps the items into the [0, f) range and returns them sorted
+func gcsHashedSet(k0, k1 uint64, f uint64, items [][]byte) (res []uint64) {
+	res = make([]uint64, len(items))
+	for i := range items {
+		res[i], _ = bits.Mul64(siphash.Hash(k0, k1, items[i]), f)
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a] < res[b] })
+	return
+}
+
+
+type bitWriter struct {
+	buf []byte
+	nbits uint8 // bits used in the last byte
+}
+
+func (w *bitWriter) writeBit(b bool) {
+	if w.nbits == 0 {
+		w.buf = append(w.buf, 0)
+		w.nbits = 8
+	}
+	w.nbits--
+	if b {
+		w.buf[len(w.buf)-1] |= 1 << w.nbits
+	}
+}
+
+func (w *bitWriter) writeBits(v uint64, n uint8) {
+	for n > 0 {
+		n--
+		w.writeBit(((v >> n) & 1) != 0)
+	}
+}
+
+
+type bitReader struct {
+	buf []byte
+	pos uint // bit position
+}
+
+func (r *bitReader) readBit() (b bool, e error) {
+	if r.pos >= uint(len(r.buf))*8 {
+		e = errors.New("GCS filter truncated")
+		return
+	}
+	b = (r.buf[r.pos>>3] & (0x80 >> (r.pos & 7))) != 0
+	r.pos++
+	return
+}
+
+func (r *bitReader) readBits(n uint8) (v uint64, e error) {
+	var b bool
+	for ; n > 0; n-- {
+		if b, e = r.readBit(); e != nil {
+			return
+		}
+		v <<= 1
+		if b {
+			v |= 1
+		}
+	}
+	return
+}
+
+
+// Builds a GCS filter with the given parameters. Duplicate items are ignored.
+func BuildGCSFilter(k0, k1 uint64, p uint8, m uint64, items [][]byte) []byte {
+	uniq := make(map[string]bool, len(items))
+	var set [][]byte
+	for _, it := range items {
+		if !uniq[string(it)] {
+			uniq[string(it)] = true
+			set = append(set, it)
+		}
+	}
+
+	out := new(bytes.Buffer)
+	WriteVlen(out, uint64(len(set)))
+	w := new(bitWriter)
+	var last uint64
+	for _, v := range gcsHashedSet(k0, k1, uint64(len(set))*m, set) {
+		delta := v - last
+		last = v
+		for q := delta >> p; q > 0; q-- {
+			w.writeBit(true)
+		}
+		w.writeBit(false)
+		w.writeBits(delta, p)
+	}
+	out.Write(w.buf)
+	return out.Bytes()
+}
+
+
+// Returns true if any of the items is (likely) in the filter
+func MatchGCSFilter(filter []byte, k0, k1 uint64, p uint8, m uint64, items [][]byte) (bool, error) {
+	if len(filter) == 0 {
+		return false, errors.New("GCS filter too short")
+	}
+	n, le := VLen(filter)
+	if n == 0 || len(items) == 0 {
+		return false, nil
+	}
+	r := &bitReader{buf: filter[le:]}
+	queries := gcsHashedSet(k0, k1, uint64(n)*m, items)
+	var val uint64
+	qi := 0
+	for i := 0; i < n; i++ {
+		var q uint64
+		for {
+			b, e := r.readBit()
+			if e != nil {
+				return false, e
+			}
+			if !b {
+				break
+			}
+			q++
+		}
+		rem, e := r.readBits(p)
+		if e != nil {
+			return false, e
+		}
+		val += q<<p | rem
+		for queries[qi] < val {
+			if qi++; qi == len(queries) {
+				return false, nil
+			}
+		}
+		if queries[qi] == val {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+
+// Builds a basic (BIP158) filter for the given block hash
+func BuildBasicFilter(blockhash []byte, items [][]byte) []byte {
+	k0, k1 := GCSFilterKey(blockhash)
+	return BuildGCSFilter(k0, k1, BASIC_FILTER_P, BASIC_FILTER_M, items)
+}
+
+func MatchBasicFilter(filter []byte, blockhash []byte, items [][]byte) (bool, error) {
+	k0, k1 := GCSFilterKey(blockhash)
+	return MatchGCSFilter(filter, k0, k1, BASIC_FILTER_P, BASIC_FILTER_M, items)
+}
+
+
+// Returns the filter header, that commits to the filter and the previous header
+func GCSFilterHeader(filter []byte, prev []byte) (res [32]byte) {
+	h := Sha2Sum(filter)
+	ShaHash(append(h[:], prev...), res[:])
+	return
+}
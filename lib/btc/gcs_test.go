@@ -0,0 +1,47 @@
+package btc
+
+import (
+	"bytes"
+	"testing"
+	"encoding/hex"
+)
+
+func TestBasicFilterVector(t *testing.T) {
+	// BIP158 test vector: testnet3 genesis block
+	scr, _ := hex.DecodeString("4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+	bh := TestNet3Params.Genesis()
+	f := BuildBasicFilter(bh.Hash[:], [][]byte{scr})
+	if hex.EncodeToString(f) != "019dfca8" {
+		t.Error("Bad filter", hex.EncodeToString(f))
+	}
+	hdr := GCSFilterHeader(f, make([]byte, 32))
+	if NewUint256(hdr[:]).String() != "21584579b7eb08997773e5aeff3a7f932700042d0ed2a6129012b7d7ae81b750" {
+		t.Error("Bad filter header", NewUint256(hdr[:]).String())
+	}
+}
+
+func TestGCSMatch(t *testing.T) {
+	var items [][]byte
+	for i := 0; i < 300; i++ {
+		items = append(items, bytes.Repeat([]byte{byte(i), byte(i>>8)}, 12))
+	}
+	key := bytes.Repeat([]byte{0x5a}, 32)
+	f := BuildBasicFilter(key, items)
+	for _, it := range items {
+		if ok, e := MatchBasicFilter(f, key, [][]byte{[]byte("no such item"), it}); !ok || e != nil {
+			t.Fatal("Item not matched", hex.EncodeToString(it), e)
+		}
+	}
+	var fp int
+	for i := 0; i < 1000; i++ {
+		if ok, _ := MatchBasicFilter(f, key, [][]byte{bytes.Repeat([]byte{byte(i), byte(i>>8), 1}, 8)}); ok {
+			fp++
+		}
+	}
+	if fp > 2 {
+		t.Error("Too many false positives", fp)
+	}
+	if ok, _ := MatchBasicFilter(BuildBasicFilter(key, nil), key, items); ok {
+		t.Error("Empty filter matched")
+	}
+}
@@ -45,9 +45,20 @@ func Rimp160AfterSha256(b []byte) (out [20]byte) {
 // This function is used to sign and verify messages using the bitcoin standard.
 // The second paramater must point to a 32-bytes buffer, where hash will be stored.
 func HashFromMessage(msg []byte, out []byte) {
+	hashFromMessage(MessageMagic, msg, out)
+}
+
+
+// Same as HashFromMessage, but using the network's message magic
+func (p *ChainParams) HashFromMessage(msg []byte, out []byte) {
+	hashFromMessage(p.MessageMagic, msg, out)
+}
+
+
+func hashFromMessage(magic string, msg []byte, out []byte) {
 	b := new(bytes.Buffer)
-	WriteVlen(b, uint64(len(MessageMagic)))
-	b.Write([]byte(MessageMagic))
+	WriteVlen(b, uint64(len(magic)))
+	b.Write([]byte(magic))
 	WriteVlen(b, uint64(len(msg)))
 	b.Write(msg)
 	ShaHash(b.Bytes(), out)
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"bytes"
 	"errors"
+	"crypto/sha256"
 	"github.com/lentus/wotscoin/lib/xnyss"
 )
 
@@ -181,6 +182,19 @@ func (ms *MultiSig) Bytes() []byte {
 	return buf.Bytes()
 }
 
+// Returns the scriptSig spending the multisig with one zero filled XNYSS
+// signature, advertising the given number of child keys (for size estimates)
+func (ms *MultiSig) XnyssSignedScript(children int) []byte {
+	sig := &xnyss.Signature{SigBytes: make([]byte, xnyss.SigLen), PubSeed: make([]byte, 32)}
+	for i := 0; i < children; i++ {
+		sig.ChildHashes = append(sig.ChildHashes, make([]byte, 32))
+	}
+	tmp := *ms
+	tmp.Signatures = nil
+	tmp.XnyssSignatures = []*xnyss.Signature{sig}
+	return tmp.Bytes()
+}
+
 func (ms *MultiSig) PkScript() (pkscr []byte) {
 	pkscr = make([]byte, 23)
 	pkscr[0] = 0xa9
@@ -191,7 +205,50 @@ func (ms *MultiSig) PkScript() (pkscr []byte) {
 }
 
 func (ms *MultiSig) BtcAddr(testnet bool) *BtcAddr {
+	return ms.AddrVer(AddrVerScript(testnet))
+}
+
+// Returns P2SH address with the given version byte
+func (ms *MultiSig) AddrVer(ver byte) *BtcAddr {
 	var h [20]byte
 	RimpHash(ms.P2SH(), h[:])
-	return NewAddrFromHash160(h[:], AddrVerScript(testnet))
+	return NewAddrFromHash160(h[:], ver)
+}
+
+// Returns the XNYSS signature of the given input, or nil if the input does not spend
+// an XNYSS multisig. The signature's message is set, so that its public key can be recovered.
+func (tx *Tx) XnyssSignature(in int) *xnyss.Signature {
+	scr := tx.TxIn[in].ScriptSig
+	if len(scr) < 2 || scr[len(scr)-1] != OP_CHECKXNYSSMULTISIG {
+		return nil
+	}
+	_, sigb, le, er := GetOpcode(scr[1:])
+	if er != nil || len(sigb) == 0 {
+		return nil
+	}
+	_, p2sh, _, er := GetOpcode(scr[1+le:])
+	if er != nil {
+		return nil
+	}
+	sig, er := xnyss.NewSignature(sigb[:len(sigb)-1], tx.SignatureHash(p2sh, in, int32(sigb[len(sigb)-1])))
+	if er != nil {
+		return nil
+	}
+	return sig
+}
+
+// Returns SHA256 of the XNYSS public key that has signed the given input (ok is false if
+// the input does not spend an XNYSS multisig). Each such key can only sign once, so no
+// two transactions in a block can have the same one (see chain.commitTxs).
+func (tx *Tx) XnyssPubKeyHash(in int) (pkh [32]byte, ok bool) {
+	sig := tx.XnyssSignature(in)
+	if sig == nil {
+		return
+	}
+	pub, er := sig.PublicKey()
+	if er != nil {
+		return
+	}
+	pkh, ok = sha256.Sum256(pub), true
+	return
 }
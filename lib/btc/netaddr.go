@@ -1,8 +1,26 @@
 package btc
 
 import (
+	"io"
+	"bytes"
 	"fmt"
+	"errors"
+	"strings"
+	"encoding/base32"
 	"encoding/binary"
+	"golang.org/x/crypto/sha3"
+)
+
+// BIP155 network IDs
+const (
+	BIP155_IPV4 = 1
+	BIP155_IPV6 = 2
+	BIP155_TORV2 = 3
+	BIP155_TORV3 = 4
+	BIP155_I2P = 5
+	BIP155_CJDNS = 6
+
+	BIP155_MAX_ADDR_LEN = 512
 )
 
 type NetAddr struct {
@@ -10,6 +28,10 @@ type NetAddr struct {
 	Ip6 [12]byte
 	Ip4 [4]byte
 	Port uint16
+
+	// BIP155 network and address, for addresses that do not fit into Ip6/Ip4 (Net is zero otherwise)
+	Net byte
+	Addr []byte
 }
 
 func NewNetAddr(b []byte) (na *NetAddr) {
@@ -35,6 +57,132 @@ func (a *NetAddr) Bytes() (res []byte) {
 }
 
 
+// Reads BIP155 (addrv2) address, without the time field.
+// IPv4 addresses are returned with Net set to zero, like the ones from the old addr message.
+func NewNetAddrV2(rd io.Reader) (na *NetAddr, e error) {
+	var b [2]byte
+	na = new(NetAddr)
+	if na.Services, e = ReadVLen(rd); e != nil {
+		return
+	}
+	if _, e = io.ReadFull(rd, b[:1]); e != nil {
+		return
+	}
+	na.Net = b[0]
+	le, e := ReadVLen(rd)
+	if e != nil {
+		return
+	}
+	if le > BIP155_MAX_ADDR_LEN {
+		e = errors.New("BIP155 address too long")
+		return
+	}
+	na.Addr = make([]byte, int(le))
+	if _, e = io.ReadFull(rd, na.Addr); e != nil {
+		return
+	}
+	if _, e = io.ReadFull(rd, b[:2]); e != nil {
+		return
+	}
+	na.Port = binary.BigEndian.Uint16(b[:2])
+
+	if exp := bip155AddrLen(na.Net); exp != 0 && exp != len(na.Addr) {
+		e = errors.New("BIP155 address length mismatch")
+		return
+	}
+	if na.Net == BIP155_IPV4 {
+		na.Ip6[10], na.Ip6[11] = 0xff, 0xff
+		copy(na.Ip4[:], na.Addr)
+		na.Net = 0
+		na.Addr = nil
+	}
+	return
+}
+
+func bip155AddrLen(net byte) int {
+	switch net {
+		case BIP155_IPV4: return 4
+		case BIP155_IPV6: return 16
+		case BIP155_TORV2: return 10
+		case BIP155_TORV3: return 32
+		case BIP155_I2P: return 32
+		case BIP155_CJDNS: return 16
+	}
+	return 0
+}
+
+
+// Returns BIP155 (addrv2) serialization, without the time field.
+func (a *NetAddr) BytesV2() []byte {
+	addr := a.Addr
+	net := a.Net
+	if net == 0 {
+		net = BIP155_IPV4
+		addr = a.Ip4[:]
+	}
+	buf := new(bytes.Buffer)
+	WriteVlen(buf, a.Services)
+	buf.WriteByte(net)
+	WriteVlen(buf, uint64(len(addr)))
+	buf.Write(addr)
+	binary.Write(buf, binary.BigEndian, a.Port)
+	return buf.Bytes()
+}
+
+
+func (a *NetAddr) IsOnion() bool {
+	return a.Net == BIP155_TORV3
+}
+
+
+// Returns the IPv4 address or the .onion host name
+func (a *NetAddr) Host() string {
+	if a.IsOnion() {
+		return OnionHost(a.Addr)
+	}
+	return fmt.Sprintf("%d.%d.%d.%d", a.Ip4[0], a.Ip4[1], a.Ip4[2], a.Ip4[3])
+}
+
+
 func (a *NetAddr) String() string {
-	return fmt.Sprintf("%d.%d.%d.%d:%d", a.Ip4[0], a.Ip4[1], a.Ip4[2], a.Ip4[3], a.Port)
+	return fmt.Sprint(a.Host(), ":", a.Port)
+}
+
+
+var onionEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func onionChecksum(pubkey []byte) []byte {
+	h := sha3.New256()
+	h.Write([]byte(".onion checksum"))
+	h.Write(pubkey)
+	h.Write([]byte{3})
+	return h.Sum(nil)[:2]
+}
+
+// Returns Tor v3 host name for the given 32 bytes public key
+func OnionHost(pubkey []byte) string {
+	b := append(append(append([]byte{}, pubkey...), onionChecksum(pubkey)...), 3)
+	return strings.ToLower(onionEncoding.EncodeToString(b)) + ".onion"
+}
+
+// Decodes Tor v3 host name into the 32 bytes public key
+func OnionPubkey(host string) (pubkey []byte, e error) {
+	if !strings.HasSuffix(host, ".onion") {
+		e = errors.New("not an onion address")
+		return
+	}
+	b, e := onionEncoding.DecodeString(strings.ToUpper(strings.TrimSuffix(host, ".onion")))
+	if e != nil {
+		return
+	}
+	if len(b) != 32+2+1 || b[34] != 3 {
+		e = errors.New("unsupported onion address version")
+		return
+	}
+	if chk := onionChecksum(b[:32]); chk[0] != b[32] || chk[1] != b[33] {
+		e = errors.New("onion address checksum mismatch")
+		return
+	}
+	pubkey = b[:32]
+	return
 }
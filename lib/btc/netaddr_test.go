@@ -0,0 +1,52 @@
+package btc
+
+import (
+	"bytes"
+	"testing"
+	"encoding/hex"
+)
+
+func TestOnionHost(t *testing.T) {
+	const host = "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion"
+	pk, e := OnionPubkey(host)
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	if hex.EncodeToString(pk) != "79bcc625184b05194975c28b66b66b0469f7f6556fb1ac3189a79b40dda32f1f" {
+		t.Error("Bad pubkey", hex.EncodeToString(pk))
+	}
+	if OnionHost(pk) != host {
+		t.Error("Bad host name", OnionHost(pk))
+	}
+	if _, e = OnionPubkey("pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryc.onion"); e == nil {
+		t.Error("Checksum error not detected")
+	}
+}
+
+func TestNetAddrV2(t *testing.T) {
+	pk, _ := OnionPubkey("pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion")
+	onion := &NetAddr{Services: 0x409, Net: BIP155_TORV3, Addr: pk, Port: 8333}
+	ip4 := &NetAddr{Services: 1, Port: 18333}
+	ip4.Ip6[10], ip4.Ip6[11] = 0xff, 0xff
+	copy(ip4.Ip4[:], []byte{1, 2, 3, 4})
+
+	for _, a := range []*NetAddr{onion, ip4} {
+		b := a.BytesV2()
+		na, e := NewNetAddrV2(bytes.NewReader(b))
+		if e != nil {
+			t.Fatal(e.Error())
+		}
+		if na.String() != a.String() || na.Services != a.Services || !bytes.Equal(na.Bytes(), a.Bytes()) {
+			t.Error("Mismatch", na.String(), a.String())
+		}
+	}
+	if ip4.BytesV2()[1] != BIP155_IPV4 {
+		t.Error("IPv4 not serialized as BIP155_IPV4")
+	}
+
+	b := onion.BytesV2()
+	b[4] = 31 // length does not match the network
+	if _, e := NewNetAddrV2(bytes.NewReader(b)); e == nil {
+		t.Error("Length mismatch not detected")
+	}
+}
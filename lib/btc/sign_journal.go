@@ -0,0 +1,88 @@
+package btc
+
+import (
+	"fmt"
+	"errors"
+	"strings"
+	"strconv"
+	"encoding/hex"
+)
+
+/*
+	The signing journal of the XNYSS wallet (journal.txt) - an append-only text
+	file, with a line written (and synced) before each signature leaves the wallet:
+		<time> <txid> <address> <node> <message> <hash>
+
+	time - unix time of the signature
+	txid - the transaction being signed (its hash before the signatures)
+	address - the address whose key signs
+	node - SHA256 of the public key of the W-OTS+ node that signs (as in the
+		UPKH database, and in the signature of the transaction's input)
+	message - the signed hash
+	hash - double SHA256 of the previous line's hash (32 zero bytes for the
+		first line) followed by this line up to its last space
+
+	The hashes chain the lines, so a line damaged, lost or reordered by accident
+	(e.g. a bad copy or a partial restore) is detected, but for the ones at the
+	end. The hashes are not keyed, so they do not protect against deliberate
+	changes - anyone can recompute them. The lines that start with # are comments.
+*/
+
+const SIGN_JOURNAL_HEADER = "# XNYSS signing journal, see lib/btc/sign_journal.go\n"
+
+type JournalEntry struct {
+	Time    int64
+	TxID    Uint256
+	Address string
+	Node    [32]byte
+	Message []byte
+	Hash    [32]byte // of the line, chained to the previous one
+}
+
+
+// Returns the line of the entry, chained to the hash of the previous one (and sets its Hash)
+func (e *JournalEntry) Line(prev [32]byte) string {
+	s := fmt.Sprintf("%d %s %s %s %s", e.Time, e.TxID.String(), e.Address,
+		hex.EncodeToString(e.Node[:]), hex.EncodeToString(e.Message))
+	e.Hash = Sha2Sum(append(prev[:], s...))
+	return s + " " + hex.EncodeToString(e.Hash[:]) + "\n"
+}
+
+
+// Parses the content of the journal, checking the chain of its hashes
+func ParseJournal(d []byte) (res []*JournalEntry, e error) {
+	var prev [32]byte
+	for no, l := range strings.Split(string(d), "\n") {
+		if l = strings.TrimSpace(l); l == "" || l[0] == '#' {
+			continue
+		}
+		ll := strings.Split(l, " ")
+		if len(ll) != 6 {
+			return nil, fmt.Errorf("line %d: bad format", no+1)
+		}
+		ent := &JournalEntry{Address: ll[2]}
+		var node, hash []byte
+		ent.Time, e = strconv.ParseInt(ll[0], 10, 64)
+		txid := NewUint256FromString(ll[1])
+		if e == nil {
+			node, e = hex.DecodeString(ll[3])
+		}
+		if e == nil {
+			ent.Message, e = hex.DecodeString(ll[4])
+		}
+		if e == nil {
+			hash, e = hex.DecodeString(ll[5])
+		}
+		if e != nil || txid == nil || len(node) != 32 || len(hash) != 32 {
+			return nil, fmt.Errorf("line %d: bad format", no+1)
+		}
+		ent.TxID = *txid
+		copy(ent.Node[:], node)
+		if ent.Line(prev) != l+"\n" {
+			return nil, errors.New("line " + strconv.Itoa(no+1) + ": broken chain of hashes (the journal has been changed)")
+		}
+		prev = ent.Hash
+		res = append(res, ent)
+	}
+	return
+}
@@ -0,0 +1,37 @@
+package btc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSignJournal(t *testing.T) {
+	var prev [32]byte
+	var lines []string
+	for i := 0; i < 3; i++ {
+		e := &JournalEntry{Time: 1500000000 + int64(i), Address: "3Journa1Test", Message: make([]byte, 32)}
+		e.TxID.Hash[0], e.Node[31] = byte(i), byte(i)
+		lines = append(lines, e.Line(prev))
+		prev = e.Hash
+	}
+	d := SIGN_JOURNAL_HEADER + strings.Join(lines, "")
+	res, e := ParseJournal([]byte(d))
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	if len(res) != 3 || res[2].Node[31] != 2 || res[2].TxID.Hash[0] != 2 || res[2].Hash != prev || res[1].Time != 1500000001 {
+		t.Error("Bad entries")
+	}
+
+	// a line removed or changed
+	if _, e = ParseJournal([]byte(lines[0] + lines[2])); e == nil || !strings.Contains(e.Error(), "line 2") {
+		t.Error("Removed line not detected:", e)
+	}
+	bad := strings.Replace(lines[1], "3Journa1Test", "3Journa1Tesx", 1)
+	if _, e = ParseJournal([]byte(lines[0] + bad + lines[2])); e == nil {
+		t.Error("Changed line not detected")
+	}
+	if _, e = ParseJournal([]byte(lines[0] + "1 2 3\n")); e == nil {
+		t.Error("Bad line not detected")
+	}
+}
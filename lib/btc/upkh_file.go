@@ -0,0 +1,253 @@
+package btc
+
+import (
+	"fmt"
+	"bytes"
+	"errors"
+	"strings"
+	"strconv"
+	"encoding/hex"
+	"encoding/base64"
+	"encoding/binary"
+)
+
+/*
+	XNYSS key confirmation files, carried between an (air-gapped) wallet and a
+	client: unconfirmed.txt (written by wallet -unconfirmed) and confirmed.txt
+	(written by the client's "confirm" command, read by wallet -confirm).
+
+	The content is split into frames, one per line, so it can be typed in,
+	checked by eye, or shown as an animated sequence of QR codes:
+		xnyss:<version>:<kind>:<id>:<frame>/<frames>:<data>:<checksum>
+
+	kind - "u" for the unconfirmed hashes, "c" for the confirmations
+	id - first 4 bytes of the double SHA256 of the whole content (hex), so the
+		frames of different files do not get mixed
+	data - the frame's part of the content (base64url, without padding)
+	checksum - first 4 bytes of the double SHA256 of the frame up to its last colon (hex)
+
+	The frames can come in any order, also repeated (as scanned from an animated
+	QR code). The content:
+		[1] - length of the network name, followed by the name (e.g. "wotscoin")
+		confirmations only:
+			[32] - hash of the block the confirmations were made against (the client's tip)
+			[4] - its height
+		[vlen] - number of records
+		unconfirmed: [32*n] - public key hashes of the nodes
+		confirmed: [88*n] - long-term hash (20), public key hash (32), depth (4) and
+			hash of the block that advertised the key (32)
+
+	The confirmations include all the hashes asked about, with zero depth (and
+	block) for the ones not found, so the wallet can revoke the confirmations
+	of nodes whose advertising transaction got reorganised out of the chain.
+	There is no other version of the format: the older binary files (count and
+	records, without a network, tip, blocks or checksum) are refused.
+*/
+
+const (
+	UPKH_FILE_VERSION = 1
+	UPKH_FILE_RECORD_LEN = UPKH_ANSWER_LEN // confirmed record, the fields of the upkh message in another order
+	UPKH_FILE_PREFIX = "xnyss"
+
+	UPKH_FILE_UNCONFIRMED = 'u'
+	UPKH_FILE_CONFIRMED = 'c'
+
+	UPKH_FRAME_SIZE = 400 // default data characters per frame (makes QR codes that still scan well)
+	UPKH_MAX_FRAMES = 10000
+)
+
+type UpkhFile struct {
+	Kind byte
+	Network string
+	TipHash Uint256 // the confirmations only
+	TipHeight uint32
+	Hashes [][32]byte // unconfirmed nodes
+	Recs []*UpkhAnswer // confirmed nodes
+}
+
+
+func upkh_checksum(b []byte) string {
+	h := Sha2Sum(b)
+	return hex.EncodeToString(h[:4])
+}
+
+
+// Returns the content of the file
+func (f *UpkhFile) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(byte(len(f.Network)))
+	buf.WriteString(f.Network)
+	if f.Kind == UPKH_FILE_CONFIRMED {
+		buf.Write(f.TipHash.Hash[:])
+		binary.Write(buf, binary.LittleEndian, f.TipHeight)
+		WriteVlen(buf, uint64(len(f.Recs)))
+		for _, r := range f.Recs {
+			buf.Write(r.LongTermHash[:])
+			buf.Write(r.PubKeyHash[:])
+			binary.Write(buf, binary.LittleEndian, r.Depth)
+			buf.Write(r.BlockHash.Hash[:])
+		}
+	} else {
+		WriteVlen(buf, uint64(len(f.Hashes)))
+		for i := range f.Hashes {
+			buf.Write(f.Hashes[i][:])
+		}
+	}
+	return buf.Bytes()
+}
+
+
+// Splits the file into frames of up to size data characters each
+func (f *UpkhFile) Frames(size int) (res []string) {
+	if size < 4 {
+		size = UPKH_FRAME_SIZE
+	}
+	d := f.Bytes()
+	id := upkh_checksum(d)
+	chunk := size / 4 * 3 // bytes that make size base64 characters
+	cnt := (len(d) + chunk - 1) / chunk
+	for i := 0; i < cnt; i++ {
+		end := (i + 1) * chunk
+		if end > len(d) {
+			end = len(d)
+		}
+		fr := fmt.Sprintf("%s:%d:%c:%s:%d/%d:%s", UPKH_FILE_PREFIX, UPKH_FILE_VERSION, f.Kind, id,
+			i+1, cnt, base64.RawURLEncoding.EncodeToString(d[i*chunk:end]))
+		res = append(res, fr+":"+upkh_checksum([]byte(fr)))
+	}
+	return
+}
+
+
+// Returns the file in the text format, with frames of the default size
+func (f *UpkhFile) Text() []byte {
+	return []byte(strings.Join(f.Frames(UPKH_FRAME_SIZE), "\n") + "\n")
+}
+
+
+// Returns the number of the confirmation records with a non-zero depth
+func (f *UpkhFile) Found() (n int) {
+	for _, r := range f.Recs {
+		if r.Depth > 0 {
+			n++
+		}
+	}
+	return
+}
+
+
+// Parses the content of the file
+func parseUpkhContent(kind byte, d []byte) (f *UpkhFile, e error) {
+	f = &UpkhFile{Kind: kind}
+	if len(d) < 1 || len(d) < 1+int(d[0]) {
+		return nil, errors.New("content too short")
+	}
+	f.Network = string(d[1 : 1+d[0]])
+	d = d[1+d[0]:]
+	reclen := 32
+	if kind == UPKH_FILE_CONFIRMED {
+		if len(d) < 36 {
+			return nil, errors.New("content too short")
+		}
+		copy(f.TipHash.Hash[:], d[0:32])
+		f.TipHeight = binary.LittleEndian.Uint32(d[32:36])
+		d = d[36:]
+		reclen = UPKH_FILE_RECORD_LEN
+	}
+	cnt, le := vlenChecked(d)
+	// the count is checked against the content before multiplying, so it cannot overflow
+	if le == 0 || cnt < 0 || cnt > (len(d)-le)/reclen || len(d) != le+reclen*cnt {
+		return nil, errors.New("bad number of records")
+	}
+	d = d[le:]
+	if kind == UPKH_FILE_CONFIRMED {
+		f.Recs = make([]*UpkhAnswer, cnt)
+		for i := range f.Recs {
+			r := new(UpkhAnswer)
+			copy(r.LongTermHash[:], d[0:20])
+			copy(r.PubKeyHash[:], d[20:52])
+			r.Depth = binary.LittleEndian.Uint32(d[52:56])
+			copy(r.BlockHash.Hash[:], d[56:88])
+			f.Recs[i] = r
+			d = d[reclen:]
+		}
+	} else {
+		f.Hashes = make([][32]byte, cnt)
+		for i := range f.Hashes {
+			copy(f.Hashes[i][:], d[32*i:])
+		}
+	}
+	return
+}
+
+
+// Puts the file together from its frames (in any order, repeated ones are fine)
+func ParseUpkhFrames(frames []string) (f *UpkhFile, e error) {
+	var kind byte
+	var id string
+	var parts [][]byte
+	var got int
+	for _, fr := range frames {
+		if fr = strings.TrimSpace(fr); fr == "" {
+			continue
+		}
+		ll := strings.Split(fr, ":")
+		if len(ll) != 7 || ll[0] != UPKH_FILE_PREFIX {
+			return nil, errors.New("not a confirmation file frame: " + fr)
+		}
+		if ll[1] != strconv.Itoa(UPKH_FILE_VERSION) {
+			return nil, errors.New("unsupported version " + ll[1] + " of the confirmation file")
+		}
+		if upkh_checksum([]byte(fr[:strings.LastIndex(fr, ":")])) != ll[6] {
+			return nil, errors.New("checksum mismatch in frame " + ll[4])
+		}
+		var no, cnt int
+		if n, _ := fmt.Sscanf(ll[4], "%d/%d", &no, &cnt); n != 2 || no < 1 || no > cnt || cnt > UPKH_MAX_FRAMES {
+			return nil, errors.New("bad frame number " + ll[4])
+		}
+		if len(ll[2]) != 1 || ll[2][0] != UPKH_FILE_UNCONFIRMED && ll[2][0] != UPKH_FILE_CONFIRMED {
+			return nil, errors.New("unknown kind of the confirmation file " + ll[2])
+		}
+		if parts == nil {
+			kind, id = ll[2][0], ll[3]
+			parts = make([][]byte, cnt)
+		} else if ll[2][0] != kind || ll[3] != id || cnt != len(parts) {
+			return nil, errors.New("frame " + ll[4] + " belongs to another file")
+		}
+		if parts[no-1] == nil {
+			if parts[no-1], e = base64.RawURLEncoding.DecodeString(ll[5]); e != nil {
+				return nil, errors.New("frame " + ll[4] + ": " + e.Error())
+			}
+			got++
+		}
+	}
+	if parts == nil {
+		return nil, errors.New("no frames")
+	}
+	if got < len(parts) {
+		var missing []string
+		for i := range parts {
+			if parts[i] == nil {
+				missing = append(missing, strconv.Itoa(i+1))
+			}
+		}
+		return nil, fmt.Errorf("missing frame(s) %s of %d", strings.Join(missing, ", "), len(parts))
+	}
+	d := bytes.Join(parts, nil)
+	if upkh_checksum(d) != id {
+		return nil, errors.New("checksum mismatch of the content")
+	}
+	return parseUpkhContent(kind, d)
+}
+
+
+// Parses the content of a confirmation file of the given kind
+func ReadUpkhFile(d []byte, kind byte) (f *UpkhFile, e error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(d), []byte(UPKH_FILE_PREFIX+":")) {
+		return nil, errors.New("not a confirmation file (the old binary ones are not supported - make it again)")
+	}
+	if f, e = ParseUpkhFrames(strings.Split(string(d), "\n")); e == nil && f.Kind != kind {
+		return nil, fmt.Errorf("expected a file of kind %c, got %c", kind, f.Kind)
+	}
+	return
+}
@@ -0,0 +1,118 @@
+package btc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"encoding/binary"
+)
+
+func test_upkh_files() (u, c *UpkhFile) {
+	u = &UpkhFile{Kind: UPKH_FILE_UNCONFIRMED, Network: "wotscoin"}
+	c = &UpkhFile{Kind: UPKH_FILE_CONFIRMED, Network: "wotscoin", TipHeight: 777}
+	c.TipHash.Hash[0] = 0x77
+	for i := 0; i < 20; i++ {
+		var h [32]byte
+		h[0], h[31] = byte(i), 0xee
+		u.Hashes = append(u.Hashes, h)
+		r := &UpkhAnswer{PubKeyHash: h, Depth: uint32(i + 1)}
+		r.LongTermHash[19] = byte(i)
+		r.BlockHash.Hash[0] = byte(i)
+		c.Recs = append(c.Recs, r)
+	}
+	return
+}
+
+func TestUpkhFile(t *testing.T) {
+	u, c := test_upkh_files()
+	for _, f := range []*UpkhFile{u, c} {
+		frames := f.Frames(100)
+		if len(frames) < 3 {
+			t.Fatal("Not split into frames", len(frames))
+		}
+		for _, fr := range frames {
+			if len(fr) > 100+40 {
+				t.Error("Frame too long", len(fr))
+			}
+		}
+		// scanned in another order, with some repeated
+		scanned := append([]string{frames[len(frames)-1], ""}, frames...)
+		scanned = append(scanned, frames[0])
+		res, e := ParseUpkhFrames(scanned)
+		if e != nil {
+			t.Fatal(e.Error())
+		}
+		if !bytes.Equal(res.Bytes(), f.Bytes()) || res.Network != "wotscoin" || res.Kind != f.Kind {
+			t.Error("Content mismatch", string(f.Kind))
+		}
+
+		res, e = ReadUpkhFile(f.Text(), f.Kind)
+		if e != nil || !bytes.Equal(res.Bytes(), f.Bytes()) {
+			t.Error("Text format:", e)
+		}
+		if _, e = ReadUpkhFile(f.Text(), UPKH_FILE_UNCONFIRMED+UPKH_FILE_CONFIRMED-f.Kind); e == nil {
+			t.Error("Wrong kind not detected")
+		}
+
+		if _, e = ParseUpkhFrames(frames[1:]); e == nil || !strings.Contains(e.Error(), "missing frame(s) 1 of") {
+			t.Error("Missing frame not detected:", e)
+		}
+		bad := append([]string{}, frames...)
+		bad[1] = strings.Replace(bad[1], bad[1][40:41], string(bad[1][40]^1), 1)
+		if _, e = ParseUpkhFrames(bad); e == nil {
+			t.Error("Corrupted frame not detected")
+		}
+	}
+	if c.TipHeight != 777 || c.Recs[5].Depth != 6 || c.Recs[5].LongTermHash[19] != 5 || c.Recs[5].BlockHash.Hash[0] != 5 {
+		t.Error("Bad records")
+	}
+
+	// frames of two different files
+	u2, _ := test_upkh_files()
+	u2.Hashes = u2.Hashes[1:]
+	if _, e := ParseUpkhFrames(append(u.Frames(100)[:1], u2.Frames(100)[1:]...)); e == nil {
+		t.Error("Mixed files not detected")
+	}
+}
+
+func TestUpkhFileVersion(t *testing.T) {
+	_, c := test_upkh_files()
+	fr := c.Frames(UPKH_FRAME_SIZE)[0]
+	fr = strings.Replace(fr[:strings.LastIndex(fr, ":")], "xnyss:1:", "xnyss:2:", 1)
+	if _, e := ParseUpkhFrames([]string{fr + ":" + upkh_checksum([]byte(fr))}); e == nil || !strings.Contains(e.Error(), "unsupported version") {
+		t.Error("Other version not detected:", e)
+	}
+}
+
+func TestUpkhFileBinary(t *testing.T) {
+	_, c := test_upkh_files()
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, uint32(len(c.Recs)))
+	for _, r := range c.Recs {
+		buf.Write(r.LongTermHash[:])
+		buf.Write(r.PubKeyHash[:])
+		binary.Write(buf, binary.LittleEndian, r.Depth)
+	}
+	if _, e := ReadUpkhFile(buf.Bytes(), UPKH_FILE_CONFIRMED); e == nil {
+		t.Error("Old binary file not refused")
+	}
+}
+
+func TestUpkhFileBadCount(t *testing.T) {
+	// counts that overflow when multiplied by the record length
+	for _, cnt := range []uint64{1<<59 + 1, 1<<63 - 1, 0x2e8ba2e8ba2e8ba3} {
+		for _, kind := range []byte{UPKH_FILE_UNCONFIRMED, UPKH_FILE_CONFIRMED} {
+			d := []byte{8, 'w', 'o', 't', 's', 'c', 'o', 'i', 'n'}
+			if kind == UPKH_FILE_CONFIRMED {
+				d = append(d, make([]byte, 36)...)
+			}
+			vl := make([]byte, 9)
+			vl[0] = 0xff
+			binary.LittleEndian.PutUint64(vl[1:], cnt)
+			d = append(append(d, vl...), make([]byte, 64)...)
+			if _, e := parseUpkhContent(kind, d); e == nil {
+				t.Errorf("Count %d of kind %c not refused", cnt, kind)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package btc
+
+import (
+	"bytes"
+	"errors"
+	"encoding/binary"
+)
+
+/*
+	Remote XNYSS key confirmation queries (wotscoin specific P2P messages).
+
+	getupkh - list of public key hashes to look up in the UPKH database:
+		[vlen] - number of hashes (up to MAX_GETUPKH_SIZE)
+		[32*n] - the hashes
+
+	upkh - the answer (one record per requested hash, in the same order):
+		[0:32] - hash of the node's last block
+		[32:36] - height of the node's last block
+		[vlen] - number of records
+		[88*n] - records: public key hash (32), long-term hash (20), depth (4) and
+			hash of the block that advertised the key (32)
+
+	The depth is the number of confirmations of the transaction that advertised
+	the key (as written to confirmed.txt by the "confirm" TextUI command), or
+	zero (with a zero block hash) if the key is not in the UPKH database.
+*/
+
+const (
+	SERVICE_UPKH = 1 << 25 // wotscoin specific (from the experimental range)
+
+	MAX_GETUPKH_SIZE = 1000
+	UPKH_HASHES_PER_SEC = 100 // nodes answer at least that many hashes per second (after the first MAX_GETUPKH_SIZE)
+	UPKH_ANSWER_LEN = 32 + 20 + 4 + 32
+)
+
+type UpkhAnswer struct {
+	PubKeyHash [32]byte
+	LongTermHash [20]byte
+	Depth uint32 // zero if the key is unknown
+	BlockHash Uint256 // the block that advertised the key
+}
+
+type UpkhMsg struct {
+	TipHash Uint256
+	TipHeight uint32
+	Recs []*UpkhAnswer
+}
+
+
+// Same as VLen, but returns zero var_int_siz if the buffer is too short
+func vlenChecked(b []byte) (le int, var_int_siz int) {
+	if len(b) == 0 {
+		return
+	}
+	switch b[0] {
+		case 0xfd: var_int_siz = 3
+		case 0xfe: var_int_siz = 5
+		case 0xff: var_int_siz = 9
+		default: var_int_siz = 1
+	}
+	if len(b) < var_int_siz {
+		return 0, 0
+	}
+	return VLen(b)
+}
+
+
+// Returns the payload of a getupkh message
+func GetUpkhBytes(hashes [][32]byte) []byte {
+	buf := new(bytes.Buffer)
+	WriteVlen(buf, uint64(len(hashes)))
+	for i := range hashes {
+		buf.Write(hashes[i][:])
+	}
+	return buf.Bytes()
+}
+
+
+// Parses the payload of a getupkh message
+func ParseGetUpkh(pl []byte) (res [][32]byte, e error) {
+	cnt, le := vlenChecked(pl)
+	if le == 0 || cnt < 0 || cnt > MAX_GETUPKH_SIZE || len(pl) != le+32*cnt {
+		e = errors.New("getupkh: bad payload")
+		return
+	}
+	res = make([][32]byte, cnt)
+	for i := range res {
+		copy(res[i][:], pl[le+32*i:])
+	}
+	return
+}
+
+
+func (m *UpkhMsg) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(m.TipHash.Hash[:])
+	binary.Write(buf, binary.LittleEndian, m.TipHeight)
+	WriteVlen(buf, uint64(len(m.Recs)))
+	for _, r := range m.Recs {
+		buf.Write(r.PubKeyHash[:])
+		buf.Write(r.LongTermHash[:])
+		binary.Write(buf, binary.LittleEndian, r.Depth)
+		buf.Write(r.BlockHash.Hash[:])
+	}
+	return buf.Bytes()
+}
+
+
+// Parses the payload of an upkh message
+func NewUpkhMsg(pl []byte) (m *UpkhMsg, e error) {
+	if len(pl) < 36 {
+		e = errors.New("upkh: payload too short")
+		return
+	}
+	cnt, le := vlenChecked(pl[36:])
+	if le == 0 || cnt < 0 || cnt > MAX_GETUPKH_SIZE || len(pl) != 36+le+UPKH_ANSWER_LEN*cnt {
+		e = errors.New("upkh: bad payload")
+		return
+	}
+	m = new(UpkhMsg)
+	copy(m.TipHash.Hash[:], pl[0:32])
+	m.TipHeight = binary.LittleEndian.Uint32(pl[32:36])
+	m.Recs = make([]*UpkhAnswer, cnt)
+	for i, d := 0, pl[36+le:]; i < cnt; i, d = i+1, d[UPKH_ANSWER_LEN:] {
+		r := new(UpkhAnswer)
+		copy(r.PubKeyHash[:], d[0:32])
+		copy(r.LongTermHash[:], d[32:52])
+		r.Depth = binary.LittleEndian.Uint32(d[52:56])
+		copy(r.BlockHash.Hash[:], d[56:88])
+		m.Recs[i] = r
+	}
+	return
+}
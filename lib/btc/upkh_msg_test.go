@@ -0,0 +1,74 @@
+package btc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGetUpkh(t *testing.T) {
+	hashes := make([][32]byte, 300)
+	for i := range hashes {
+		hashes[i][0], hashes[i][31] = byte(i), byte(i>>8)
+	}
+	pl := GetUpkhBytes(hashes)
+	res, e := ParseGetUpkh(pl)
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	if len(res) != len(hashes) {
+		t.Fatal("Bad number of hashes", len(res))
+	}
+	for i := range res {
+		if res[i] != hashes[i] {
+			t.Error("Hash mismatch at", i)
+		}
+	}
+
+	if _, e = ParseGetUpkh(pl[:len(pl)-1]); e == nil {
+		t.Error("Truncated payload not detected")
+	}
+	if _, e = ParseGetUpkh([]byte{0xfd, 0x01}); e == nil {
+		t.Error("Truncated count not detected")
+	}
+	if _, e = ParseGetUpkh(nil); e == nil {
+		t.Error("Empty payload not detected")
+	}
+	if _, e = ParseGetUpkh(GetUpkhBytes(make([][32]byte, MAX_GETUPKH_SIZE+1))); e == nil {
+		t.Error("Too many hashes not detected")
+	}
+}
+
+func TestUpkhMsg(t *testing.T) {
+	m := &UpkhMsg{TipHeight: 1234}
+	m.TipHash.Hash[5] = 0x55
+	for i := 0; i < 3; i++ {
+		r := &UpkhAnswer{Depth: uint32(i)}
+		r.PubKeyHash[0] = byte(i + 1)
+		if i > 0 {
+			r.LongTermHash[19] = byte(i + 10)
+			r.BlockHash.Hash[31] = byte(i + 20)
+		}
+		m.Recs = append(m.Recs, r)
+	}
+	pl := m.Bytes()
+	if len(pl) != 32+4+1+3*UPKH_ANSWER_LEN {
+		t.Fatal("Bad payload length", len(pl))
+	}
+	m2, e := NewUpkhMsg(pl)
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	if !bytes.Equal(m2.Bytes(), pl) {
+		t.Error("Payload mismatch after decoding")
+	}
+	if m2.TipHeight != 1234 || m2.Recs[2].Depth != 2 || m2.Recs[2].LongTermHash[19] != 12 ||
+		m2.Recs[2].BlockHash.Hash[31] != 22 {
+		t.Error("Bad decoded values")
+	}
+	if _, e = NewUpkhMsg(pl[:len(pl)-1]); e == nil {
+		t.Error("Truncated payload not detected")
+	}
+	if _, e = NewUpkhMsg(pl[:36]); e == nil {
+		t.Error("Missing count not detected")
+	}
+}
@@ -107,6 +107,7 @@ func (ch *Chain) PreCheckBlock(bl *btc.Block) (er error, dos bool, maybelater bo
 }
 
 
+// Do not call this function with ch.BlockIndexAccess locked
 func (ch *Chain) ApplyBlockFlags(bl *btc.Block) {
 	if bl.BlockTime() >= BIP16SwitchTime {
 		bl.VerifyFlags = script.VER_P2SH
@@ -130,6 +131,13 @@ func (ch *Chain) ApplyBlockFlags(bl *btc.Block) {
 		bl.VerifyFlags |= script.VER_WITNESS | script.VER_NULLDUMMY
 	}
 
+	// BIP9 deployments (i.e. XNYSS)
+	ch.BlockIndexAccess.Lock()
+	prev := ch.BlockIndex[btc.NewUint256(bl.ParentHash()).BIdx()]
+	ch.BlockIndexAccess.Unlock()
+	if prev != nil {
+		bl.VerifyFlags |= ch.DeploymentFlags(prev)
+	}
 }
 
 
@@ -4,10 +4,10 @@ import (
 	"fmt"
 	"sync"
 	"math/big"
-	"encoding/binary"
 	"github.com/lentus/wotscoin/lib/btc"
 	"github.com/lentus/wotscoin/lib/utxo"
 	"github.com/lentus/wotscoin/lib/script"
+	"github.com/lentus/wotscoin/lib/others/qdb"
 )
 
 
@@ -22,6 +22,7 @@ type Chain struct {
 	blockTreeEnd *BlockTreeNode
 	blockTreeAccess sync.Mutex
 	Genesis *btc.Uint256
+	Params *btc.ChainParams
 
 	BlockIndexAccess sync.Mutex
 	BlockIndex map[[btc.Uint256IdxLen]byte] *BlockTreeNode
@@ -41,7 +42,15 @@ type Chain struct {
 		BIP66Height uint32
 		BIP91Height uint32
 		S2XHeight uint32
+		Deployments []BIP9Deployment // BIP9 soft forks, indexed by DEPLOYMENT_* values
 	}
+
+	bip9Access sync.Mutex
+	bip9Cache []map[*BlockTreeNode]int
+
+	txIndex *qdb.DB // optional, see chain_index.go
+	addrIndex *qdb.DB
+	filters *qdb.DB
 }
 
 type NewChanOpts struct {
@@ -49,13 +58,17 @@ type NewChanOpts struct {
 	UndoBlocks uint // undo this many blocks when opening the chain
 	UTXOCallbacks utxo.CallbackFunctions
 	BlockMinedCB func(*btc.Block) // used to remove mined txs from memory pool
+	TxIndex bool // maintain txid -> block location index
+	AddrIndex bool // maintain output script -> history index
+	BlockFilters bool // build compact block filters (see chain_filters.go)
 }
 
 
 // This is the very first function one should call in order to use this package
-func NewChainExt(dbrootdir string, genesis *btc.Uint256, rescan bool, opts *NewChanOpts, bdbopts *BlockDBOpts) (ch *Chain) {
+func NewChainExt(dbrootdir string, params *btc.ChainParams, rescan bool, opts *NewChanOpts, bdbopts *BlockDBOpts) (ch *Chain) {
 	ch = new(Chain)
-	ch.Genesis = genesis
+	ch.Params = params
+	ch.Genesis = params.Genesis()
 
 	if opts == nil {
 		opts = &NewChanOpts{}
@@ -63,25 +76,17 @@ func NewChainExt(dbrootdir string, genesis *btc.Uint256, rescan bool, opts *NewC
 
 	ch.CB = *opts
 
-	ch.Consensus.GensisTimestamp = 1231006505
-	ch.Consensus.MaxPOWBits = 0x1d00ffff
-	ch.Consensus.MaxPOWValue, _ = new(big.Int).SetString("00000000FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF", 16)
-	if ch.testnet() {
-		ch.Consensus.BIP34Height = 21111
-		ch.Consensus.BIP65Height = 581885
-		ch.Consensus.BIP66Height = 330776
-		ch.Consensus.Enforce_CSV = 770112
-		ch.Consensus.Enforce_SEGWIT = 834624
-		ch.Consensus.BIP9_Treshold = 1512
-	} else {
-		ch.Consensus.BIP34Height = 227931
-		ch.Consensus.BIP65Height = 388381
-		ch.Consensus.BIP66Height = 363725
-		ch.Consensus.Enforce_CSV = 419328
-		ch.Consensus.Enforce_SEGWIT = 481824 // https://www.reddit.com/r/Bitcoin/comments/6okd1n/bip91_lock_in_is_guaranteed_as_of_block_476768/
-		ch.Consensus.BIP91Height = 477120
-		ch.Consensus.BIP9_Treshold = 1916
-	}
+	ch.Consensus.GensisTimestamp = params.GenesisTime
+	ch.Consensus.MaxPOWBits = params.GenesisBits
+	ch.Consensus.MaxPOWValue = params.PowLimitValue()
+	ch.Consensus.BIP34Height = params.BIP34Height
+	ch.Consensus.BIP65Height = params.BIP65Height
+	ch.Consensus.BIP66Height = params.BIP66Height
+	ch.Consensus.BIP91Height = params.BIP91Height
+	ch.Consensus.Enforce_CSV = params.CSVHeight
+	ch.Consensus.Enforce_SEGWIT = params.SegwitHeight
+	ch.Consensus.BIP9_Treshold = params.BIP9Threshold
+	ch.setDeployments(params)
 
 	ch.Blocks = NewBlockDBExt(dbrootdir, bdbopts)
 
@@ -95,6 +100,9 @@ func NewChainExt(dbrootdir string, genesis *btc.Uint256, rescan bool, opts *NewC
 		return
 	}
 
+	ch.openIndexes(dbrootdir, rescan)
+	ch.openFilters(dbrootdir, rescan)
+
 	ch.loadBlockIndex()
 	if AbortNow {
 		return
@@ -129,14 +137,9 @@ func NewChainExt(dbrootdir string, genesis *btc.Uint256, rescan bool, opts *NewC
 }
 
 
-// Calculate an imaginary header of the genesis block (for Timestamp() and Bits() functions from chain_tree.go)
+// Set the header of the genesis block (for Timestamp() and Bits() functions from chain_tree.go)
 func (ch *Chain) RebuildGenesisHeader() {
-	binary.LittleEndian.PutUint32(ch.BlockTreeRoot.BlockHeader[0:4], 1) // Version
-	// [4:36] - prev_block
-	// [36:68] - merkle_root
-	binary.LittleEndian.PutUint32(ch.BlockTreeRoot.BlockHeader[68:72], ch.Consensus.GensisTimestamp) // Timestamp
-	binary.LittleEndian.PutUint32(ch.BlockTreeRoot.BlockHeader[72:76], ch.Consensus.MaxPOWBits) // Bits
-	// [76:80] - nonce
+	copy(ch.BlockTreeRoot.BlockHeader[:], ch.Params.GenesisHeader())
 }
 
 
@@ -144,6 +147,7 @@ func (ch *Chain) RebuildGenesisHeader() {
 // when your client is idle, to defragment databases.
 func (ch *Chain) Idle() bool {
 	ch.Blocks.Idle()
+	ch.syncIndexes()
 	return ch.Unspent.Idle()
 }
 
@@ -167,12 +171,14 @@ func (ch *Chain) Close() {
 	// Remove the UnspentDB reference in the script package
 	script.UnspentDB = nil
 	ch.Unspent.Close()
+	ch.closeIndexes()
+	ch.closeFilters()
 }
 
 
-// Returns true if we are on Testnet3 chain
+// Returns true if the testnet specific rules apply
 func (ch *Chain) testnet() bool {
-	return ch.Genesis.Hash[0]==0x43 // it's simple, but works
+	return ch.Params.Testnet
 }
 
 
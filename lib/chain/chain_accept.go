@@ -77,11 +77,14 @@ func (ch *Chain) CommitBlock(bl *btc.Block, cur *BlockTreeNode) (e error) {
 			cur.Parent.delChild(cur)
 			delete(ch.BlockIndex, cur.BlockHash.BIdx())
 			ch.BlockIndexAccess.Unlock()
+			ch.bip9Forget(cur)
 		} else {
 			cur.SigopsCost = sigopscost
 			// ProcessBlockTransactions succeeded, so save the block as "trusted".
 			bl.Trusted = true
 			ch.Blocks.BlockAdd(cur.Height, bl)
+			ch.indexBlock(bl, cur.Height)
+			ch.filterBlock(bl, changes)
 			// Apply the block's trabnsactions to the unspent database:
 			ch.Unspent.CommitBlockTxs(changes, bl.Hash.Hash[:])
 			ch.SetLast(cur) // Advance the head
@@ -226,9 +229,10 @@ func (ch *Chain) commitTxs(bl *btc.Block, changes *utxo.BlockChanges) (sigopscos
 				if btc.IsP2SH(tout.Pk_script) {
 					sigopscost += uint32(btc.WITNESS_SCALE_FACTOR * btc.GetP2SHSigOpCount(bl.Txs[i].TxIn[j].ScriptSig))
 
-					// Retrieve public key hash for XNYSS multisig scripts
+					// Retrieve public key hash for XNYSS multisig scripts (only once XNYSS is active)
 					scriptSig := bl.Txs[i].TxIn[j].ScriptSig
-					if scriptSig[len(scriptSig)-1] == btc.OP_CHECKXNYSSMULTISIG {
+					if (bl.VerifyFlags&script.VER_XNYSS) != 0 && len(scriptSig) > 0 &&
+						scriptSig[len(scriptSig)-1] == btc.OP_CHECKXNYSSMULTISIG {
 						fmt.Println("Found an XNYSS signature, handling changes...")
 						_, sigBytes, le, err := btc.GetOpcode(scriptSig[1:])
 						if err != nil {
@@ -0,0 +1,236 @@
+package chain
+
+import (
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/script"
+)
+
+// BIP9 deployment states
+const (
+	BIP9_DEFINED = iota
+	BIP9_STARTED
+	BIP9_LOCKED_IN
+	BIP9_ACTIVE
+	BIP9_FAILED
+)
+
+const (
+	BIP9_TOP_MASK = 0xE0000000
+	BIP9_TOP_BITS = 0x20000000
+
+	// Use it as StartTime to have a deployment active from the genesis block
+	BIP9_ALWAYS_ACTIVE = 0xffffffff
+)
+
+// Indexes into Chain.Consensus.Deployments
+const (
+	DEPLOYMENT_XNYSS = iota
+	MAX_DEPLOYMENTS
+)
+
+type BIP9Deployment struct {
+	btc.BIP9Deployment
+	VerifyFlags uint32 // Script verification flags enforced after activation
+}
+
+// Script verification flags of the deployments known to this package, by name
+var deploymentFlags = map[string]uint32{
+	"xnyss": script.VER_XNYSS,
+}
+
+// Indexes of the known deployments in Chain.Consensus.Deployments
+var deploymentIndex = map[string]int{
+	"xnyss": DEPLOYMENT_XNYSS,
+}
+
+func BIP9StateToString(state int) string {
+	switch state {
+	case BIP9_DEFINED:
+		return "defined"
+	case BIP9_STARTED:
+		return "started"
+	case BIP9_LOCKED_IN:
+		return "locked_in"
+	case BIP9_ACTIVE:
+		return "active"
+	case BIP9_FAILED:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// Sets up the deployments from the chain params.
+// A known deployment that is missing in the params never gets activated.
+func (ch *Chain) setDeployments(params *btc.ChainParams) {
+	ch.Consensus.Window = uint(params.BIP9Window)
+	ch.Consensus.Deployments = make([]BIP9Deployment, MAX_DEPLOYMENTS)
+	for name, idx := range deploymentIndex {
+		ch.Consensus.Deployments[idx].Name = name
+		ch.Consensus.Deployments[idx].VerifyFlags = deploymentFlags[name]
+	}
+	for i := range params.Deployments {
+		d := BIP9Deployment{BIP9Deployment: params.Deployments[i], VerifyFlags: deploymentFlags[params.Deployments[i].Name]}
+		if idx, ok := deploymentIndex[d.Name]; ok {
+			ch.Consensus.Deployments[idx] = d
+		} else {
+			ch.Consensus.Deployments = append(ch.Consensus.Deployments, d)
+		}
+	}
+}
+
+// Returns the number of signalling blocks per window needed to lock in the deployment
+func (ch *Chain) BIP9Threshold(d *BIP9Deployment) uint32 {
+	if d.Threshold != 0 {
+		return d.Threshold
+	}
+	return ch.Consensus.BIP9_Treshold
+}
+
+// Returns the ancestor of n at the given height (nil for negative heights)
+func (n *BlockTreeNode) ancestor(height int) *BlockTreeNode {
+	if height < 0 {
+		return nil
+	}
+	for n != nil && int(n.Height) > height {
+		n = n.Parent
+	}
+	return n
+}
+
+// Returns true if the block's version signals for the given bit
+func BIP9Signals(ver uint32, bit uint8) bool {
+	return (ver&BIP9_TOP_MASK) == BIP9_TOP_BITS && (ver&(uint32(1)<<bit)) != 0
+}
+
+// Returns the state of the deployment for the block following prev.
+// The state only changes at window boundaries, so the results are cached per
+// the last node of each window.
+func (ch *Chain) DeploymentState(prev *BlockTreeNode, idx int) (state int) {
+	d := &ch.Consensus.Deployments[idx]
+	window := int(ch.Consensus.Window)
+
+	if d.StartTime == BIP9_ALWAYS_ACTIVE {
+		return BIP9_ACTIVE
+	}
+
+	// Go back to the last block of the previous window
+	if prev != nil {
+		prev = prev.ancestor(int(prev.Height) - (int(prev.Height)+1)%window)
+	}
+
+	ch.bip9Access.Lock()
+	defer ch.bip9Access.Unlock()
+
+	if ch.bip9Cache == nil {
+		ch.bip9Cache = make([]map[*BlockTreeNode]int, len(ch.Consensus.Deployments))
+	}
+	cache := ch.bip9Cache[idx]
+	if cache == nil {
+		cache = make(map[*BlockTreeNode]int)
+		ch.bip9Cache[idx] = cache
+	}
+
+	// Walk backwards until we find a known state
+	var todo []*BlockTreeNode
+	for {
+		var ok bool
+		if state, ok = cache[prev]; ok {
+			break
+		}
+		if prev == nil || prev.GetMedianTimePast() < d.StartTime {
+			state = BIP9_DEFINED
+			cache[prev] = state
+			break
+		}
+		todo = append(todo, prev)
+		prev = prev.ancestor(int(prev.Height) - window)
+	}
+
+	// And now forward, applying the state transitions
+	for i := len(todo) - 1; i >= 0; i-- {
+		prev = todo[i]
+		switch state {
+		case BIP9_DEFINED:
+			if mtp := prev.GetMedianTimePast(); mtp >= d.Timeout {
+				state = BIP9_FAILED
+			} else if mtp >= d.StartTime {
+				state = BIP9_STARTED
+			}
+		case BIP9_STARTED:
+			if prev.GetMedianTimePast() >= d.Timeout {
+				state = BIP9_FAILED
+				break
+			}
+			var cnt uint32
+			n := prev
+			for j := 0; j < window && n != nil; j++ {
+				if BIP9Signals(n.BlockVersion(), d.Bit) {
+					cnt++
+				}
+				n = n.Parent
+			}
+			if cnt >= ch.BIP9Threshold(d) {
+				state = BIP9_LOCKED_IN
+			}
+		case BIP9_LOCKED_IN:
+			state = BIP9_ACTIVE
+		}
+		cache[prev] = state
+	}
+	return
+}
+
+// Drops the cached states of the node, when it gets removed from the block tree,
+// so that the cache does not keep the nodes of the dropped branches
+func (ch *Chain) bip9Forget(n *BlockTreeNode) {
+	ch.bip9Access.Lock()
+	for _, cache := range ch.bip9Cache {
+		delete(cache, n)
+	}
+	ch.bip9Access.Unlock()
+}
+
+// Returns the number of blocks signalling for the deployment in the current
+// (not yet finished) window, that prev is part of, and the window's length so far
+func (ch *Chain) DeploymentSignals(prev *BlockTreeNode, idx int) (cnt, blocks uint32) {
+	d := &ch.Consensus.Deployments[idx]
+	for n := prev; n != nil; n = n.Parent {
+		if BIP9Signals(n.BlockVersion(), d.Bit) {
+			cnt++
+		}
+		blocks++
+		if n.Height%uint32(ch.Consensus.Window) == 0 {
+			break
+		}
+	}
+	return
+}
+
+// Returns true if the deployment is active for the block following prev
+func (ch *Chain) DeploymentActive(prev *BlockTreeNode, idx int) bool {
+	return ch.DeploymentState(prev, idx) == BIP9_ACTIVE
+}
+
+// Returns the script verification flags of all the deployments that are
+// active for the block following prev
+func (ch *Chain) DeploymentFlags(prev *BlockTreeNode) (flags uint32) {
+	for i := range ch.Consensus.Deployments {
+		if ch.DeploymentActive(prev, i) {
+			flags |= ch.Consensus.Deployments[i].VerifyFlags
+		}
+	}
+	return
+}
+
+// Returns version that a new block mined on top of prev should have, with the bits
+// set for all the deployments that are in the started or locked in state
+func (ch *Chain) ComputeBlockVersion(prev *BlockTreeNode) (ver uint32) {
+	ver = BIP9_TOP_BITS
+	for i := range ch.Consensus.Deployments {
+		state := ch.DeploymentState(prev, i)
+		if state == BIP9_STARTED || state == BIP9_LOCKED_IN {
+			ver |= uint32(1) << ch.Consensus.Deployments[i].Bit
+		}
+	}
+	return
+}
@@ -0,0 +1,119 @@
+package chain
+
+import (
+	"testing"
+	"encoding/binary"
+	"github.com/lentus/wotscoin/lib/btc"
+)
+
+const bip9TestStart = 1500000000
+
+// Builds a chain of cnt blocks, with versions returned by the given function
+func bip9TestChain(cnt int, version func(height int) uint32) (nodes []*BlockTreeNode) {
+	var prev *BlockTreeNode
+	for h := 0; h < cnt; h++ {
+		n := &BlockTreeNode{Height: uint32(h), Parent: prev}
+		binary.LittleEndian.PutUint32(n.BlockHeader[0:4], version(h))
+		binary.LittleEndian.PutUint32(n.BlockHeader[68:72], uint32(bip9TestStart+h*600))
+		nodes = append(nodes, n)
+		prev = n
+	}
+	return
+}
+
+func bip9TestChainParams(start, timeout uint32) (ch *Chain) {
+	ch = new(Chain)
+	ch.Consensus.Window = 10
+	ch.Consensus.BIP9_Treshold = 8
+	ch.Consensus.Deployments = []BIP9Deployment{{BIP9Deployment: btc.BIP9Deployment{Name: "test", Bit: 5, StartTime: start, Timeout: timeout}}}
+	return
+}
+
+func TestBIP9Activation(t *testing.T) {
+	nodes := bip9TestChain(60, func(h int) uint32 {
+		if h >= 20 {
+			return BIP9_TOP_BITS | 1<<5
+		}
+		return 4
+	})
+	ch := bip9TestChainParams(bip9TestStart+10*600, bip9TestStart+1000*600)
+
+	exp := []struct {
+		height int
+		state  int
+	}{
+		{5, BIP9_DEFINED}, {18, BIP9_DEFINED}, {19, BIP9_STARTED}, {28, BIP9_STARTED},
+		{29, BIP9_LOCKED_IN}, {38, BIP9_LOCKED_IN}, {39, BIP9_ACTIVE}, {59, BIP9_ACTIVE},
+	}
+	for _, e := range exp {
+		if res := ch.DeploymentState(nodes[e.height], 0); res != e.state {
+			t.Error("State after block", e.height, "is", BIP9StateToString(res), "- expected", BIP9StateToString(e.state))
+		}
+	}
+
+	// ask again, this time answers should come from the cache
+	if !ch.DeploymentActive(nodes[45], 0) || ch.DeploymentActive(nodes[35], 0) {
+		t.Error("Unexpected cached state")
+	}
+}
+
+func TestBIP9Timeout(t *testing.T) {
+	nodes := bip9TestChain(60, func(h int) uint32 {
+		if h%2 == 0 {
+			return BIP9_TOP_BITS | 1<<5
+		}
+		return BIP9_TOP_BITS
+	})
+	ch := bip9TestChainParams(bip9TestStart+10*600, bip9TestStart+30*600)
+
+	if res := ch.DeploymentState(nodes[29], 0); res != BIP9_STARTED {
+		t.Error("Expected started, got", BIP9StateToString(res))
+	}
+	if res := ch.DeploymentState(nodes[59], 0); res != BIP9_FAILED {
+		t.Error("Expected failed, got", BIP9StateToString(res))
+	}
+	if ver := ch.ComputeBlockVersion(nodes[59]); ver != BIP9_TOP_BITS {
+		t.Errorf("Unexpected block version %08x", ver)
+	}
+	if ver := ch.ComputeBlockVersion(nodes[29]); ver != BIP9_TOP_BITS|1<<5 {
+		t.Errorf("Unexpected block version %08x", ver)
+	}
+}
+
+func TestBIP9AlwaysActive(t *testing.T) {
+	nodes := bip9TestChain(3, func(h int) uint32 { return 4 })
+	ch := bip9TestChainParams(BIP9_ALWAYS_ACTIVE, 0)
+	ch.Consensus.Deployments[0].VerifyFlags = 1 << 16
+	if ch.DeploymentFlags(nodes[0]) != 1<<16 {
+		t.Error("Deployment flags not applied")
+	}
+}
+
+// The deployment must be able to get active on the networks that use BIP9 for it
+func TestBIP9NetworkParams(t *testing.T) {
+	for _, params := range []*btc.ChainParams{&btc.MainNetParams, &btc.TestNet3Params} {
+		ch := new(Chain)
+		ch.Consensus.BIP9_Treshold = params.BIP9Threshold
+		ch.setDeployments(params)
+		d := &ch.Consensus.Deployments[DEPLOYMENT_XNYSS]
+		if d.StartTime == BIP9_ALWAYS_ACTIVE {
+			continue
+		}
+
+		// all the blocks signal, from the start time on, and get active before the timeout
+		start := d.StartTime
+		if start+uint32(3*ch.Consensus.Window*600) >= d.Timeout {
+			t.Fatal(params.Name, "- the deployment times out before it can get active")
+		}
+		var prev *BlockTreeNode
+		for h := 0; h < 3*int(ch.Consensus.Window); h++ {
+			n := &BlockTreeNode{Height: uint32(h), Parent: prev}
+			binary.LittleEndian.PutUint32(n.BlockHeader[0:4], BIP9_TOP_BITS|1<<d.Bit)
+			binary.LittleEndian.PutUint32(n.BlockHeader[68:72], start+uint32(h)*600)
+			prev = n
+		}
+		if res := ch.DeploymentState(prev, DEPLOYMENT_XNYSS); res != BIP9_ACTIVE {
+			t.Error(params.Name, "- xnyss is", BIP9StateToString(res), "after", 3*ch.Consensus.Window, "signalling blocks")
+		}
+	}
+}
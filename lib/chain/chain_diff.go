@@ -17,6 +17,10 @@ func (ch *Chain) GetNextWorkRequired(lst *BlockTreeNode, ts uint32) (res uint32)
 		return ch.Consensus.MaxPOWBits
 	}
 
+	if ch.Params.PowNoRetargeting {
+		return lst.Bits()
+	}
+
 	if ((lst.Height+1) % targetInterval) != 0 {
 		// Special difficulty rule for testnet:
 		if ch.testnet() {
@@ -0,0 +1,140 @@
+package chain
+
+import (
+	"os"
+	"bytes"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/utxo"
+	"github.com/lentus/wotscoin/lib/others/qdb"
+)
+
+/*
+	Compact block filters (BIP158 basic type), kept in cfilters/ next to the blocks database.
+	Key is the first 8 bytes of the block hash, the value is:
+		[0:32] - block hash
+		[32:64] - filter header
+		[64:] - the filter
+
+	Besides the BIP158 elements (output scripts and scripts of the spent outputs), each
+	filter also includes the UPKH keys advertised by XNYSS signatures in the block, so
+	the filters only differ from BIP158 ones for blocks with XNYSS signatures.
+	The genesis block is not stored, so its filter is built from ChainParams.GenesisBlock().
+*/
+
+func (ch *Chain) openFilters(dbrootdir string, rescan bool) {
+	if !ch.CB.BlockFilters {
+		return
+	}
+	if rescan {
+		os.RemoveAll(dbrootdir + "cfilters")
+	}
+	ch.filters, _ = qdb.NewDB(dbrootdir + "cfilters", false)
+	f := ch.genesisFilter()
+	if old, _ := ch.GetBlockFilter(ch.Genesis); old != nil && !bytes.Equal(old, f) {
+		// made by a version that left the genesis filter empty - all the headers are wrong
+		ch.filters.Close()
+		os.RemoveAll(dbrootdir + "cfilters")
+		ch.filters, _ = qdb.NewDB(dbrootdir + "cfilters", false)
+	}
+	if ch.filters.Count() == 0 {
+		hdr := btc.GCSFilterHeader(f, make([]byte, 32))
+		ch.putFilter(ch.Genesis.Hash[:], hdr[:], f)
+		if ch.Unspent.LastBlockHeight > 0 {
+			println("WARNING: block filters are only built for the entire chain after a rescan")
+		}
+	}
+}
+
+
+// Returns the BIP158 filter of the genesis block
+func (ch *Chain) genesisFilter() []byte {
+	bl, e := btc.NewBlock(ch.Params.GenesisBlock())
+	if e == nil {
+		e = bl.BuildTxList()
+	}
+	if e != nil {
+		panic("Bad genesis block: " + e.Error())
+	}
+	return btc.BuildBasicFilter(ch.Genesis.Hash[:], ch.filterItems(bl, nil))
+}
+
+
+func (ch *Chain) closeFilters() {
+	if ch.filters != nil {
+		ch.filters.Close()
+		ch.filters = nil
+	}
+}
+
+
+func (ch *Chain) BlockFiltersEnabled() bool {
+	return ch.filters != nil
+}
+
+
+func (ch *Chain) putFilter(hash, hdr, f []byte) {
+	rec := make([]byte, 64+len(f))
+	copy(rec[0:32], hash)
+	copy(rec[32:64], hdr)
+	copy(rec[64:], f)
+	ch.filters.Put(idxKey(hash), rec)
+}
+
+
+// Returns the filter and the filter header of the given block (nil if unknown)
+func (ch *Chain) GetBlockFilter(hash *btc.Uint256) (filter []byte, hdr []byte) {
+	if ch.filters == nil {
+		return
+	}
+	v := ch.filters.Get(idxKey(hash.Hash[:]))
+	if len(v) < 64 || !hash.Equal(btc.NewUint256(v[0:32])) {
+		return
+	}
+	filter = append([]byte{}, v[64:]...)
+	hdr = append([]byte{}, v[32:64]...)
+	return
+}
+
+
+// Builds and stores the filter of the block.
+// Must be called before the block is applied to the unspent database.
+func (ch *Chain) filterBlock(bl *btc.Block, changes *utxo.BlockChanges) {
+	if ch.filters == nil {
+		return
+	}
+	_, prev := ch.GetBlockFilter(btc.NewUint256(bl.ParentHash()))
+	if prev == nil {
+		return // the filter header chain is broken - needs a rescan
+	}
+
+	f := btc.BuildBasicFilter(bl.Hash.Hash[:], ch.filterItems(bl, changes))
+	hdr := btc.GCSFilterHeader(f, prev)
+	ch.putFilter(bl.Hash.Hash[:], hdr[:], f)
+}
+
+
+// Returns the elements the block's filter is made of (changes may be nil)
+func (ch *Chain) filterItems(bl *btc.Block, changes *utxo.BlockChanges) (items [][]byte) {
+	blOuts := make(map[[32]byte][]*btc.TxOut, len(bl.Txs))
+	for i, tx := range bl.Txs {
+		if i > 0 {
+			for _, inp := range tx.TxIn {
+				if prv := ch.blockSpentOut(&inp.Input, blOuts); prv != nil && len(prv.Pk_script) > 0 {
+					items = append(items, prv.Pk_script)
+				}
+			}
+		}
+		for _, out := range tx.TxOut {
+			if len(out.Pk_script) > 0 && out.Pk_script[0] != 0x6a { // skip OP_RETURN
+				items = append(items, out.Pk_script)
+			}
+		}
+		blOuts[tx.Hash.Hash] = tx.TxOut
+	}
+	if changes != nil {
+		for _, rec := range changes.AddUpkhList {
+			items = append(items, rec.PubKeyHash[:])
+		}
+	}
+	return
+}
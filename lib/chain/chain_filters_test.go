@@ -0,0 +1,73 @@
+package chain
+
+import (
+	"os"
+	"bytes"
+	"testing"
+	"io/ioutil"
+	"encoding/hex"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/utxo"
+	"github.com/lentus/wotscoin/lib/others/qdb"
+)
+
+func TestBlockFilters(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "chainflt")
+	defer os.RemoveAll(dir)
+
+	ch := new(Chain)
+	ch.filters, _ = qdb.NewDB(dir+"/cfilters", false)
+	defer ch.closeFilters()
+
+	parent := btc.NewUint256(bytes.Repeat([]byte{0x11}, 32))
+	prevhdr := bytes.Repeat([]byte{0x22}, 32)
+	ch.putFilter(parent.Hash[:], prevhdr, btc.BuildBasicFilter(parent.Hash[:], nil))
+
+	p2sh := append(append([]byte{0xa9, 0x14}, bytes.Repeat([]byte{1}, 20)...), 0x87)
+	p2pkh := append(append([]byte{0x76, 0xa9, 0x14}, bytes.Repeat([]byte{2}, 20)...), 0x88, 0xac)
+	bl := indexTestBlock(p2sh, p2pkh)
+	bl.Raw = make([]byte, 80)
+	copy(bl.Raw[4:36], parent.Hash[:])
+	bl.Hash = btc.NewSha2Hash(bl.Raw)
+
+	changes := new(utxo.BlockChanges)
+	upkh := new(utxo.UpkhRec)
+	copy(upkh.PubKeyHash[:], bytes.Repeat([]byte{0x33}, 32))
+	changes.AddUpkhList = []*utxo.UpkhRec{upkh}
+
+	ch.filterBlock(bl, changes)
+	f, hdr := ch.GetBlockFilter(bl.Hash)
+	if f == nil {
+		t.Fatal("Filter not stored")
+	}
+	exp := btc.GCSFilterHeader(f, prevhdr)
+	if !bytes.Equal(hdr, exp[:]) {
+		t.Error("Filter header does not commit to the previous one")
+	}
+	for _, it := range [][]byte{p2sh, p2pkh, upkh.PubKeyHash[:]} {
+		if ok, _ := btc.MatchBasicFilter(f, bl.Hash.Hash[:], [][]byte{it}); !ok {
+			t.Error("Element not in filter")
+		}
+	}
+
+	// unknown parent - the header chain cannot be continued
+	bl.Raw[4] ^= 0xff
+	bl.Hash = btc.NewSha2Hash(bl.Raw)
+	ch.filterBlock(bl, changes)
+	if f, _ = ch.GetBlockFilter(bl.Hash); f != nil {
+		t.Error("Filter built without the parent's header")
+	}
+}
+
+func TestGenesisFilter(t *testing.T) {
+	// BIP158 test vector: testnet3 genesis block
+	ch := &Chain{Params: &btc.TestNet3Params, Genesis: btc.TestNet3Params.Genesis()}
+	if f := ch.genesisFilter(); hex.EncodeToString(f) != "019dfca8" {
+		t.Error("Bad testnet3 genesis filter", hex.EncodeToString(f))
+	}
+	// the wotscoin genesis coinbase only has an OP_RETURN output
+	ch = &Chain{Params: &btc.WotscoinParams, Genesis: btc.WotscoinParams.Genesis()}
+	if f := ch.genesisFilter(); hex.EncodeToString(f) != "00" {
+		t.Error("Bad wotscoin genesis filter", hex.EncodeToString(f))
+	}
+}
@@ -0,0 +1,392 @@
+package chain
+
+import (
+	"os"
+	"bytes"
+	"errors"
+	"encoding/binary"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/others/qdb"
+)
+
+/*
+	Optional indexes, maintained while committing blocks and unwound with UndoLastBlock:
+
+	txindex/ - key is the first 8 bytes of a TxID, the value is a list of 40 byte records:
+		[0:32] - TxID
+		[32:36] - block height
+		[36:40] - position of the transaction within the block
+
+	addrindex/ - the history of each output script is kept in chunks, one per block
+	that the script appears in, so indexing a block only adds records:
+	key is the first 8 bytes of sha256(pk_script), the value is a list of 36 byte heads:
+		[0:32] - sha256(pk_script)
+		[32:36] - number of chunks
+	chunk n is at the first 8 bytes of sha256(sha256(pk_script) + n as 4 bytes LE),
+	its value is a list of the history records (ADDR_HIST_REC_LEN bytes each):
+		[0:32] - sha256(pk_script)
+		[32:64] - TxID of the transaction that funds or spends the output
+		[64:68] - block height
+		[68:72] - output index (for funding) or input index (for spending)
+		[72:80] - value
+		[80] - flags (ADDR_HIST_*)
+		[81:117] - spent outpoint (only meaningful with ADDR_HIST_SPENT)
+*/
+
+const (
+	TX_LOC_REC_LEN = 40
+	ADDR_HIST_REC_LEN = 117
+	ADDR_HEAD_REC_LEN = 36
+
+	ADDR_HIST_SPENT = 0x01 // the record describes an input spending the output
+	ADDR_HIST_XNYSS = 0x02 // the output was spent with an XNYSS signature
+)
+
+type TxLocation struct {
+	TxID btc.Uint256
+	Height uint32
+	Pos uint32 // position of the transaction within the block
+	Block *BlockTreeNode
+}
+
+type AddrHistRec struct {
+	TxID btc.Uint256
+	Height uint32
+	Index uint32 // output index, or input index for spending records
+	Value uint64
+	Flags byte
+	Spent btc.TxPrevOut // the output that got spent (only for spending records)
+}
+
+func (r *AddrHistRec) IsSpend() bool {
+	return (r.Flags&ADDR_HIST_SPENT) != 0
+}
+
+
+func idxKey(h []byte) qdb.KeyType {
+	return qdb.KeyType(binary.LittleEndian.Uint64(h[:8]))
+}
+
+func addrChunkKey(sh []byte, n uint32) qdb.KeyType {
+	var b [36]byte
+	copy(b[:32], sh)
+	binary.LittleEndian.PutUint32(b[32:], n)
+	h := btc.Sha2Sum(b[:])
+	return idxKey(h[:])
+}
+
+// Returns the number of history chunks of the script with the given hash
+func (ch *Chain) addrChunks(sh []byte) uint32 {
+	v := ch.addrIndex.Get(idxKey(sh))
+	for i := 0; i+ADDR_HEAD_REC_LEN <= len(v); i += ADDR_HEAD_REC_LEN {
+		if bytes.Equal(v[i:i+32], sh) {
+			return binary.LittleEndian.Uint32(v[i+32:i+36])
+		}
+	}
+	return 0
+}
+
+// Sets the number of history chunks of the script with the given hash
+func (ch *Chain) setAddrChunks(sh []byte, cnt uint32) {
+	k := idxKey(sh)
+	v := ch.addrIndex.Get(k)
+	nv := make([]byte, 0, len(v)+ADDR_HEAD_REC_LEN)
+	for i := 0; i+ADDR_HEAD_REC_LEN <= len(v); i += ADDR_HEAD_REC_LEN {
+		if !bytes.Equal(v[i:i+32], sh) {
+			nv = append(nv, v[i:i+ADDR_HEAD_REC_LEN]...)
+		}
+	}
+	if cnt > 0 {
+		var rec [ADDR_HEAD_REC_LEN]byte
+		copy(rec[:32], sh)
+		binary.LittleEndian.PutUint32(rec[32:], cnt)
+		nv = append(nv, rec[:]...)
+	}
+	if len(nv) == 0 {
+		ch.addrIndex.Del(k)
+	} else {
+		ch.addrIndex.Put(k, nv)
+	}
+}
+
+
+// Opens (or creates) the indexes requested in the options.
+// If rescan is set, the existing indexes are removed first.
+func (ch *Chain) openIndexes(dbrootdir string, rescan bool) {
+	if ch.CB.TxIndex {
+		if rescan {
+			os.RemoveAll(dbrootdir + "txindex")
+		}
+		ch.txIndex, _ = qdb.NewDB(dbrootdir + "txindex", false)
+	}
+	if ch.CB.AddrIndex {
+		if rescan {
+			os.RemoveAll(dbrootdir + "addrindex")
+		}
+		ch.addrIndex, _ = qdb.NewDB(dbrootdir + "addrindex", false)
+	}
+	if (ch.txIndex != nil && ch.txIndex.Count() == 0 || ch.addrIndex != nil && ch.addrIndex.Count() == 0) &&
+		ch.Unspent.LastBlockHeight > 0 {
+		println("WARNING: new index only covers blocks from now on (rescan to index the entire chain)")
+	}
+}
+
+
+func (ch *Chain) closeIndexes() {
+	if ch.txIndex != nil {
+		ch.txIndex.Close()
+		ch.txIndex = nil
+	}
+	if ch.addrIndex != nil {
+		ch.addrIndex.Close()
+		ch.addrIndex = nil
+	}
+}
+
+
+// Writes pending index (and filter) records to disk
+func (ch *Chain) syncIndexes() {
+	if ch.txIndex != nil {
+		ch.txIndex.Sync()
+	}
+	if ch.addrIndex != nil {
+		ch.addrIndex.Sync()
+	}
+	if ch.filters != nil {
+		ch.filters.Sync()
+	}
+}
+
+
+func (ch *Chain) TxIndexEnabled() bool {
+	return ch.txIndex != nil
+}
+
+func (ch *Chain) AddrIndexEnabled() bool {
+	return ch.addrIndex != nil
+}
+
+
+// Adds the block's transactions to the indexes.
+// Must be called before the block is applied to the unspent database,
+// as the outputs spent by the block are still fetched from there.
+func (ch *Chain) indexBlock(bl *btc.Block, height uint32) {
+	if ch.txIndex != nil {
+		for i, tx := range bl.Txs {
+			rec := make([]byte, TX_LOC_REC_LEN)
+			copy(rec[0:32], tx.Hash.Hash[:])
+			binary.LittleEndian.PutUint32(rec[32:36], height)
+			binary.LittleEndian.PutUint32(rec[36:40], uint32(i))
+			k := idxKey(tx.Hash.Hash[:])
+			ch.txIndex.Put(k, append(append([]byte{}, ch.txIndex.Get(k)...), rec...))
+		}
+	}
+
+	if ch.addrIndex != nil {
+		recs := make(map[[32]byte][]byte)
+		add := func(pkscr []byte, txid []byte, idx uint32, value uint64, flags byte, spent *btc.TxPrevOut) {
+			sh := btc.Sha2Sum(pkscr)
+			rec := make([]byte, ADDR_HIST_REC_LEN)
+			copy(rec[0:32], sh[:])
+			copy(rec[32:64], txid)
+			binary.LittleEndian.PutUint32(rec[64:68], height)
+			binary.LittleEndian.PutUint32(rec[68:72], idx)
+			binary.LittleEndian.PutUint64(rec[72:80], value)
+			rec[80] = flags
+			if spent != nil {
+				copy(rec[81:113], spent.Hash[:])
+				binary.LittleEndian.PutUint32(rec[113:117], spent.Vout)
+			}
+			recs[sh] = append(recs[sh], rec...)
+		}
+
+		blOuts := make(map[[32]byte][]*btc.TxOut, len(bl.Txs))
+		for i, tx := range bl.Txs {
+			if i > 0 {
+				for j, inp := range tx.TxIn {
+					prv := ch.blockSpentOut(&inp.Input, blOuts)
+					if prv == nil {
+						continue // should not happen for a valid block
+					}
+					flags := byte(ADDR_HIST_SPENT)
+					if btc.IsP2SH(prv.Pk_script) && len(inp.ScriptSig) > 0 &&
+						inp.ScriptSig[len(inp.ScriptSig)-1] == btc.OP_CHECKXNYSSMULTISIG {
+						flags |= ADDR_HIST_XNYSS
+					}
+					add(prv.Pk_script, tx.Hash.Hash[:], uint32(j), prv.Value, flags, &inp.Input)
+				}
+			}
+			for j, out := range tx.TxOut {
+				if len(out.Pk_script) > 0 && out.Pk_script[0] != 0x6a { // skip OP_RETURN
+					add(out.Pk_script, tx.Hash.Hash[:], uint32(j), out.Value, 0, nil)
+				}
+			}
+			blOuts[tx.Hash.Hash] = tx.TxOut
+		}
+
+		// a new chunk for each script
+		for sh, v := range recs {
+			cnt := ch.addrChunks(sh[:])
+			ch.addrIndex.Put(addrChunkKey(sh[:], cnt), v)
+			ch.setAddrChunks(sh[:], cnt+1)
+		}
+	}
+}
+
+
+// Returns the output spent by the given input, looking first at the outputs of the same block
+func (ch *Chain) blockSpentOut(inp *btc.TxPrevOut, blOuts map[[32]byte][]*btc.TxOut) *btc.TxOut {
+	if outs, ok := blOuts[inp.Hash]; ok {
+		if inp.Vout < uint32(len(outs)) {
+			return outs[inp.Vout]
+		}
+		return nil
+	}
+	return ch.Unspent.UnspentGet(inp)
+}
+
+
+// Removes the block's records from the indexes.
+// Must be called after the block has been reverted in the unspent database.
+func (ch *Chain) unindexBlock(bl *btc.Block, height uint32) {
+	if ch.txIndex != nil {
+		for _, tx := range bl.Txs {
+			k := idxKey(tx.Hash.Hash[:])
+			ch.delIndexRecs(ch.txIndex, k, TX_LOC_REC_LEN, func(rec []byte) bool {
+				return bytes.Equal(rec[0:32], tx.Hash.Hash[:]) && binary.LittleEndian.Uint32(rec[32:36]) == height
+			})
+		}
+	}
+
+	if ch.addrIndex != nil {
+		keys := make(map[[32]byte]bool)
+		blOuts := make(map[[32]byte][]*btc.TxOut, len(bl.Txs))
+		for i, tx := range bl.Txs {
+			if i > 0 {
+				for _, inp := range tx.TxIn {
+					if prv := ch.blockSpentOut(&inp.Input, blOuts); prv != nil {
+						keys[btc.Sha2Sum(prv.Pk_script)] = true
+					}
+				}
+			}
+			for _, out := range tx.TxOut {
+				keys[btc.Sha2Sum(out.Pk_script)] = true
+			}
+			blOuts[tx.Hash.Hash] = tx.TxOut
+		}
+		// Blocks are undone from the top, so the block's chunk is the last one
+		for sh := range keys {
+			cnt := ch.addrChunks(sh[:])
+			if cnt == 0 {
+				continue
+			}
+			k := addrChunkKey(sh[:], cnt-1)
+			if v := ch.addrIndex.Get(k); len(v) >= ADDR_HIST_REC_LEN && binary.LittleEndian.Uint32(v[64:68]) == height {
+				ch.addrIndex.Del(k)
+				ch.setAddrChunks(sh[:], cnt-1)
+			}
+		}
+	}
+}
+
+
+func (ch *Chain) delIndexRecs(db *qdb.DB, k qdb.KeyType, reclen int, del func([]byte) bool) {
+	v := db.Get(k)
+	if v == nil {
+		return
+	}
+	nv := make([]byte, 0, len(v))
+	for i := 0; i+reclen <= len(v); i += reclen {
+		if !del(v[i:i+reclen]) {
+			nv = append(nv, v[i:i+reclen]...)
+		}
+	}
+	if len(nv) == len(v) {
+		return
+	}
+	if len(nv) == 0 {
+		db.Del(k)
+	} else {
+		db.Put(k, nv)
+	}
+}
+
+
+// Returns the location of a confirmed transaction, or nil if it was not found in the tx index
+func (ch *Chain) GetTxLocation(txid *btc.Uint256) (loc *TxLocation, er error) {
+	if ch.txIndex == nil {
+		er = errors.New("Transaction index is not enabled")
+		return
+	}
+	v := ch.txIndex.Get(idxKey(txid.Hash[:]))
+	for i := 0; i+TX_LOC_REC_LEN <= len(v); i += TX_LOC_REC_LEN {
+		if bytes.Equal(v[i:i+32], txid.Hash[:]) {
+			loc = new(TxLocation)
+			copy(loc.TxID.Hash[:], v[i:i+32])
+			loc.Height = binary.LittleEndian.Uint32(v[i+32:i+36])
+			loc.Pos = binary.LittleEndian.Uint32(v[i+36:i+40])
+			// A duplicate TxID (pre BIP30) would be found at the latest height
+		}
+	}
+	if loc != nil {
+		loc.Block = ch.mainBlockAt(loc.Height)
+	}
+	return
+}
+
+
+// Returns the block at the given height in the main chain
+func (ch *Chain) mainBlockAt(height uint32) (n *BlockTreeNode) {
+	ch.BlockIndexAccess.Lock()
+	for n = ch.LastBlock(); n != nil && n.Height > height; n = n.Parent {
+	}
+	ch.BlockIndexAccess.Unlock()
+	return
+}
+
+
+// Returns a confirmed transaction, using the tx index to find its block
+func (ch *Chain) GetRawTxByID(txid *btc.Uint256) (data []byte, loc *TxLocation, er error) {
+	loc, er = ch.GetTxLocation(txid)
+	if er != nil {
+		return
+	}
+	if loc == nil {
+		er = errors.New("Transaction not found in the index")
+		return
+	}
+	data, er = ch.GetRawTx(loc.Height, txid)
+	return
+}
+
+
+// Returns the history of the given output script, sorted by block height
+func (ch *Chain) GetAddrHistory(pkscr []byte) (res []*AddrHistRec, er error) {
+	if ch.addrIndex == nil {
+		er = errors.New("Address index is not enabled")
+		return
+	}
+	sh := btc.Sha2Sum(pkscr)
+	var v []byte
+	for n, cnt := uint32(0), ch.addrChunks(sh[:]); n < cnt; n++ {
+		v = append(v, ch.addrIndex.Get(addrChunkKey(sh[:], n))...)
+	}
+	for i := 0; i+ADDR_HIST_REC_LEN <= len(v); i += ADDR_HIST_REC_LEN {
+		rec := v[i:i+ADDR_HIST_REC_LEN]
+		if !bytes.Equal(rec[0:32], sh[:]) {
+			continue
+		}
+		r := new(AddrHistRec)
+		copy(r.TxID.Hash[:], rec[32:64])
+		r.Height = binary.LittleEndian.Uint32(rec[64:68])
+		r.Index = binary.LittleEndian.Uint32(rec[68:72])
+		r.Value = binary.LittleEndian.Uint64(rec[72:80])
+		r.Flags = rec[80]
+		if r.IsSpend() {
+			copy(r.Spent.Hash[:], rec[81:113])
+			r.Spent.Vout = binary.LittleEndian.Uint32(rec[113:117])
+		}
+		res = append(res, r)
+	}
+	return
+}
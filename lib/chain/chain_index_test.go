@@ -0,0 +1,87 @@
+package chain
+
+import (
+	"os"
+	"bytes"
+	"testing"
+	"io/ioutil"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/others/qdb"
+)
+
+// Builds a block with a coinbase and a tx spending its first output
+func indexTestBlock(scr1, scr2 []byte) (bl *btc.Block) {
+	cb := new(btc.Tx)
+	cb.Version = 1
+	cb.TxIn = []*btc.TxIn{&btc.TxIn{Input: btc.TxPrevOut{Vout: 0xffffffff}, ScriptSig: []byte{1, 1}, Sequence: 0xffffffff}}
+	cb.TxOut = []*btc.TxOut{&btc.TxOut{Value: 5000, Pk_script: scr1}}
+	cb.SetHash(cb.Serialize())
+
+	tx := new(btc.Tx)
+	tx.Version = 1
+	tx.TxIn = []*btc.TxIn{&btc.TxIn{Input: btc.TxPrevOut{Hash: cb.Hash.Hash, Vout: 0},
+		ScriptSig: []byte{btc.OP_CHECKXNYSSMULTISIG}, Sequence: 0xffffffff}}
+	tx.TxOut = []*btc.TxOut{&btc.TxOut{Value: 4000, Pk_script: scr2}}
+	tx.SetHash(tx.Serialize())
+
+	bl = new(btc.Block)
+	bl.Txs = []*btc.Tx{cb, tx}
+	return
+}
+
+func TestIndexes(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "chainidx")
+	defer os.RemoveAll(dir)
+
+	ch := new(Chain)
+	ch.txIndex, _ = qdb.NewDB(dir+"/txindex", false)
+	ch.addrIndex, _ = qdb.NewDB(dir+"/addrindex", false)
+	defer ch.closeIndexes()
+
+	p2sh := append(append([]byte{0xa9, 0x14}, bytes.Repeat([]byte{1}, 20)...), 0x87)
+	p2pkh := append(append([]byte{0x76, 0xa9, 0x14}, bytes.Repeat([]byte{2}, 20)...), 0x88, 0xac)
+	bl := indexTestBlock(p2sh, p2pkh)
+	ch.indexBlock(bl, 7)
+
+	loc, er := ch.GetTxLocation(&bl.Txs[1].Hash)
+	if er != nil || loc == nil || loc.Height != 7 || loc.Pos != 1 {
+		t.Fatal("Bad tx location", loc, er)
+	}
+
+	hist, _ := ch.GetAddrHistory(p2sh)
+	if len(hist) != 2 {
+		t.Fatal("Expected 2 history records, got", len(hist))
+	}
+	if hist[0].IsSpend() || hist[0].Value != 5000 {
+		t.Error("Bad funding record")
+	}
+	if !hist[1].IsSpend() || (hist[1].Flags&ADDR_HIST_XNYSS) == 0 || hist[1].Spent.Hash != bl.Txs[0].Hash.Hash {
+		t.Error("Bad XNYSS spending record")
+	}
+	if hist, _ = ch.GetAddrHistory(p2pkh); len(hist) != 1 || hist[0].Value != 4000 {
+		t.Error("Bad P2PKH history")
+	}
+
+	// the next block adds a chunk to the histories, undoing it removes the chunk
+	bl2 := indexTestBlock(p2pkh, p2sh)
+	ch.indexBlock(bl2, 8)
+	sh := btc.Sha2Sum(p2sh)
+	if hist, _ = ch.GetAddrHistory(p2sh); len(hist) != 3 || hist[2].Height != 8 || ch.addrChunks(sh[:]) != 2 {
+		t.Fatal("Bad history after the second block", len(hist))
+	}
+	if hist, _ = ch.GetAddrHistory(p2pkh); len(hist) != 3 {
+		t.Error("Bad P2PKH history after the second block", len(hist))
+	}
+	ch.unindexBlock(bl2, 8)
+	if hist, _ = ch.GetAddrHistory(p2sh); len(hist) != 2 || ch.addrChunks(sh[:]) != 1 {
+		t.Fatal("Second block not unwound", len(hist))
+	}
+
+	ch.unindexBlock(bl, 7)
+	if loc, _ = ch.GetTxLocation(&bl.Txs[0].Hash); loc != nil {
+		t.Error("Tx still indexed after undo")
+	}
+	if hist, _ = ch.GetAddrHistory(p2sh); len(hist) != 0 || ch.addrIndex.Count() != 0 {
+		t.Error("Address history not unwound")
+	}
+}
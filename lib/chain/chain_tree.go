@@ -100,6 +100,8 @@ func (ch *Chain) ParseTillBlock(end *BlockTreeNode) {
 			ch.Blocks.BlockTrusted(bl.Hash.Hash[:])
 		}
 
+		ch.indexBlock(bl, nxt.Height)
+		ch.filterBlock(bl, changes)
 		ch.Unspent.CommitBlockTxs(changes, bl.Hash.Hash[:])
 
 		ch.SetLast(nxt)
@@ -275,6 +277,7 @@ func (ch *Chain) UndoLastBlock() {
 	bl.BuildTxList()
 
 	ch.Unspent.UndoBlockTxs(bl, last.Parent.BlockHash.Hash[:])
+	ch.unindexBlock(bl, last.Height)
 	ch.SetLast(last.Parent)
 }
 
@@ -287,6 +290,7 @@ func (cur *BlockTreeNode) delAllChildren(ch *Chain, deleteCallback func(*btc.Uin
 		}
 		cur.Childs[i].delAllChildren(ch, deleteCallback)
 		delete(ch.BlockIndex, cur.Childs[i].BlockHash.BIdx())
+		ch.bip9Forget(cur.Childs[i])
 		ch.Blocks.BlockInvalid(cur.BlockHash.Hash[:])
 	}
 	cur.Childs = nil
@@ -298,6 +302,7 @@ func (ch *Chain) DeleteBranch(cur *BlockTreeNode, deleteCallback func(*btc.Uint2
 	ch.Blocks.BlockInvalid(cur.BlockHash.Hash[:])
 	ch.BlockIndexAccess.Lock()
 	delete(ch.BlockIndex, cur.BlockHash.BIdx())
+	ch.bip9Forget(cur)
 	cur.Parent.delChild(cur)
 	cur.delAllChildren(ch, deleteCallback)
 	ch.BlockIndexAccess.Unlock()
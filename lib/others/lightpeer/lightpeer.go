@@ -0,0 +1,231 @@
+// Minimal P2P client for the programs that have no UTXO set (e.g. wallets),
+// which only need to ask a full node a few questions, like the state of
+// their XNYSS keys (getupkh). It only speaks the plaintext (v1) framing.
+package lightpeer
+
+import (
+	"io"
+	"net"
+	"time"
+	"bytes"
+	"errors"
+	"strings"
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/lentus/wotscoin/lib/btc"
+)
+
+const (
+	ProtoVersion = 70015
+	UserAgent = "/wotscoin-light:1.0/"
+
+	MaxPayload = 4e6 // we do not expect any big messages
+)
+
+var (
+	DialTimeout = 10 * time.Second
+	ReplyTimeout = 30 * time.Second
+)
+
+type Peer struct {
+	net.Conn
+	Magic [4]byte
+
+	// From the peer's version message:
+	Version uint32
+	Services uint64
+	Height uint32
+	Agent string
+}
+
+
+// Connects to the node and does the version handshake
+func Dial(addr string, magic [4]byte) (p *Peer, e error) {
+	var con net.Conn
+	if con, e = net.DialTimeout("tcp", addr, DialTimeout); e != nil {
+		return
+	}
+	if p, e = NewPeer(con, magic); e != nil {
+		con.Close()
+	}
+	return
+}
+
+
+// Does the version handshake over an already established connection (e.g. through a proxy)
+func NewPeer(con net.Conn, magic [4]byte) (p *Peer, e error) {
+	p = &Peer{Conn: con, Magic: magic}
+	if e = p.WriteMsg("version", versionPayload()); e != nil {
+		return
+	}
+	var cmd string
+	var pl []byte
+	var ver_got, verack_got bool
+	for !ver_got || !verack_got {
+		if cmd, pl, e = p.readReply(); e != nil {
+			return
+		}
+		switch cmd {
+			case "version":
+				if e = p.parseVersion(pl); e != nil {
+					return
+				}
+				ver_got = true
+				if e = p.WriteMsg("verack", nil); e != nil {
+					return
+				}
+			case "verack":
+				verack_got = true
+		}
+	}
+	// BIP133 - we are not interested in any transactions
+	e = p.WriteMsg("feefilter", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f})
+	return
+}
+
+
+func versionPayload() []byte {
+	var nonce [8]byte
+	rand.Read(nonce[:])
+	b := new(bytes.Buffer)
+	binary.Write(b, binary.LittleEndian, uint32(ProtoVersion))
+	binary.Write(b, binary.LittleEndian, uint64(0)) // no services
+	binary.Write(b, binary.LittleEndian, uint64(time.Now().Unix()))
+	b.Write(make([]byte, 2*26)) // addr_recv and addr_from
+	b.Write(nonce[:])
+	btc.WriteVlen(b, uint64(len(UserAgent)))
+	b.Write([]byte(UserAgent))
+	binary.Write(b, binary.LittleEndian, uint32(0)) // start height
+	// no "relay" byte - nodes drop the peers that don't want txs (SPV), so we send feefilter instead
+	return b.Bytes()
+}
+
+
+func (p *Peer) parseVersion(pl []byte) error {
+	if len(pl) < 80 {
+		return errors.New("version message too short")
+	}
+	p.Version = binary.LittleEndian.Uint32(pl[0:4])
+	p.Services = binary.LittleEndian.Uint64(pl[4:12])
+	if len(pl) > 80 {
+		le, of := btc.VLen(pl[80:])
+		if of > 0 && 80+of+le+4 <= len(pl) {
+			p.Agent = string(pl[80+of:80+of+le])
+			p.Height = binary.LittleEndian.Uint32(pl[80+of+le:])
+		}
+	}
+	return nil
+}
+
+
+func (p *Peer) WriteMsg(cmd string, pl []byte) (e error) {
+	msg := make([]byte, 24+len(pl))
+	copy(msg[0:4], p.Magic[:])
+	copy(msg[4:16], cmd)
+	binary.LittleEndian.PutUint32(msg[16:20], uint32(len(pl)))
+	sh := btc.Sha2Sum(pl)
+	copy(msg[20:24], sh[:4])
+	copy(msg[24:], pl)
+	_, e = p.Conn.Write(msg)
+	return
+}
+
+
+// Reads the next message from the peer
+func (p *Peer) ReadMsg() (cmd string, pl []byte, e error) {
+	var hdr [24]byte
+	if _, e = io.ReadFull(p.Conn, hdr[:]); e != nil {
+		return
+	}
+	if !bytes.Equal(hdr[0:4], p.Magic[:]) {
+		e = errors.New("bad network magic")
+		return
+	}
+	le := binary.LittleEndian.Uint32(hdr[16:20])
+	if le > MaxPayload {
+		e = errors.New("message too big")
+		return
+	}
+	pl = make([]byte, le)
+	if _, e = io.ReadFull(p.Conn, pl); e != nil {
+		return
+	}
+	if sh := btc.Sha2Sum(pl); !bytes.Equal(sh[:4], hdr[20:24]) {
+		e = errors.New("message checksum error")
+		return
+	}
+	cmd = strings.TrimRight(string(hdr[4:16]), "\000")
+	return
+}
+
+
+// Reads the next message (within ReplyTimeout), answering pings on the way
+func (p *Peer) readReply() (cmd string, pl []byte, e error) {
+	p.Conn.SetReadDeadline(time.Now().Add(ReplyTimeout))
+	defer p.Conn.SetReadDeadline(time.Time{})
+	for {
+		if cmd, pl, e = p.ReadMsg(); e != nil {
+			return
+		}
+		if cmd != "ping" {
+			return
+		}
+		if e = p.WriteMsg("pong", pl); e != nil {
+			return
+		}
+	}
+}
+
+
+// Looks up the given public key hashes in the peer's UPKH database.
+// Asks in chunks of btc.MAX_GETUPKH_SIZE, not faster than the peer allows.
+func (p *Peer) GetUpkh(hashes [][32]byte) (res *btc.UpkhMsg, e error) {
+	if (p.Services & btc.SERVICE_UPKH) == 0 {
+		e = errors.New("the peer does not answer getupkh queries")
+		return
+	}
+	res = new(btc.UpkhMsg)
+	for len(hashes) > 0 {
+		n := len(hashes)
+		if n > btc.MAX_GETUPKH_SIZE {
+			n = btc.MAX_GETUPKH_SIZE
+		}
+		if len(res.Recs) > 0 {
+			time.Sleep(time.Duration(n) * time.Second / btc.UPKH_HASHES_PER_SEC)
+		}
+		if e = p.WriteMsg("getupkh", btc.GetUpkhBytes(hashes[:n])); e != nil {
+			return
+		}
+		var msg *btc.UpkhMsg
+		if msg, e = p.waitUpkh(); e != nil {
+			return
+		}
+		if len(msg.Recs) != n {
+			e = errors.New("unexpected number of records in upkh")
+			return
+		}
+		for i := range msg.Recs {
+			if msg.Recs[i].PubKeyHash != hashes[i] {
+				e = errors.New("unexpected record in upkh")
+				return
+			}
+		}
+		res.TipHash, res.TipHeight = msg.TipHash, msg.TipHeight
+		res.Recs = append(res.Recs, msg.Recs...)
+		hashes = hashes[n:]
+	}
+	return
+}
+
+
+func (p *Peer) waitUpkh() (*btc.UpkhMsg, error) {
+	for {
+		cmd, pl, e := p.readReply()
+		if e != nil {
+			return nil, e
+		}
+		if cmd == "upkh" {
+			return btc.NewUpkhMsg(pl)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package lightpeer
+
+import (
+	"net"
+	"bytes"
+	"testing"
+	"encoding/binary"
+	"github.com/lentus/wotscoin/lib/btc"
+)
+
+var testMagic = [4]byte{0x77, 0x6f, 0x74, 0x73}
+
+// Returns both ends of a local TCP connection (net.Pipe has no buffering)
+func testConn(t *testing.T) (cli, srv net.Conn) {
+	lis, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	defer lis.Close()
+	if cli, e = net.Dial("tcp", lis.Addr().String()); e != nil {
+		t.Fatal(e.Error())
+	}
+	if srv, e = lis.Accept(); e != nil {
+		t.Fatal(e.Error())
+	}
+	return
+}
+
+// A stand-in full node, knowing only the keys with the first byte set to 1
+func testNode(t *testing.T, con net.Conn, services uint64, pongs chan []byte) {
+	p := &Peer{Conn: con, Magic: testMagic}
+	defer con.Close()
+	for {
+		cmd, pl, e := p.ReadMsg()
+		if e != nil {
+			return
+		}
+		switch cmd {
+			case "version":
+				b := new(bytes.Buffer)
+				binary.Write(b, binary.LittleEndian, uint32(70015))
+				binary.Write(b, binary.LittleEndian, services)
+				b.Write(make([]byte, 8+2*26+8))
+				btc.WriteVlen(b, 8)
+				b.Write([]byte("/tester/"))
+				binary.Write(b, binary.LittleEndian, uint32(777))
+				p.WriteMsg("version", b.Bytes())
+				p.WriteMsg("sendheaders", nil)
+				p.WriteMsg("verack", nil)
+				p.WriteMsg("ping", []byte{1, 2, 3, 4, 5, 6, 7, 8})
+			case "pong":
+				pongs <- pl
+			case "getupkh":
+				hashes, e := btc.ParseGetUpkh(pl)
+				if e != nil {
+					t.Error(e.Error())
+					return
+				}
+				m := &btc.UpkhMsg{TipHeight: 777}
+				for i := range hashes {
+					r := &btc.UpkhAnswer{PubKeyHash: hashes[i]}
+					if hashes[i][0] == 1 {
+						copy(r.LongTermHash[:], hashes[i][1:21])
+						r.Depth = 3
+					}
+					m.Recs = append(m.Recs, r)
+				}
+				p.WriteMsg("ping", []byte{8, 7, 6, 5, 4, 3, 2, 1})
+				p.WriteMsg("upkh", m.Bytes())
+		}
+	}
+}
+
+func TestGetUpkh(t *testing.T) {
+	cli, srv := testConn(t)
+	pongs := make(chan []byte, 10)
+	go testNode(t, srv, btc.SERVICE_UPKH|1, pongs)
+
+	p, e := NewPeer(cli, testMagic)
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	defer p.Close()
+	if p.Agent != "/tester/" || p.Height != 777 || p.Services != btc.SERVICE_UPKH|1 {
+		t.Error("Bad version data", p.Agent, p.Height, p.Services)
+	}
+
+	hashes := make([][32]byte, btc.MAX_GETUPKH_SIZE+1) // two queries
+	for i := range hashes {
+		hashes[i][0] = byte(i & 1)
+		hashes[i][5] = byte(i)
+		hashes[i][31] = byte(i >> 8)
+	}
+	res, e := p.GetUpkh(hashes)
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	if res.TipHeight != 777 || len(res.Recs) != len(hashes) {
+		t.Fatal("Bad answer", res.TipHeight, len(res.Recs))
+	}
+	for i, r := range res.Recs {
+		if r.PubKeyHash != hashes[i] {
+			t.Error("Hash mismatch at", i)
+		}
+		if (i&1) == 1 && (r.Depth != 3 || r.LongTermHash[4] != byte(i)) || (i&1) == 0 && r.Depth != 0 {
+			t.Error("Bad record at", i, r.Depth)
+		}
+	}
+	if pl := <-pongs; !bytes.Equal(pl, []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Error("Bad pong", pl)
+	}
+}
+
+func TestNoService(t *testing.T) {
+	cli, srv := testConn(t)
+	go testNode(t, srv, 1, make(chan []byte, 10))
+
+	p, e := NewPeer(cli, testMagic)
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	defer p.Close()
+	if _, e = p.GetUpkh(make([][32]byte, 1)); e == nil {
+		t.Error("Missing service bit not detected")
+	}
+}
+
+func TestBadMagic(t *testing.T) {
+	cli, srv := testConn(t)
+	go testNode(t, srv, 1, make(chan []byte, 10))
+
+	if _, e := NewPeer(cli, [4]byte{1, 2, 3, 4}); e == nil {
+		t.Error("Wrong network not detected")
+	}
+	cli.Close()
+}
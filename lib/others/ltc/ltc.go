@@ -1,7 +1,6 @@
 package ltc
 
 import (
-	"bytes"
 	"github.com/lentus/wotscoin/lib/btc"
 	"github.com/lentus/wotscoin/lib/others/utils"
 	"github.com/lentus/wotscoin/lib/utxo"
@@ -9,30 +8,49 @@ import (
 
 const LTC_ADDR_VERSION = 48
 
+// Litecoin network, as seen by the wallet and the tools.
+// Consensus rules are not filled in, as the node cannot follow this chain.
+var LitecoinParams = btc.ChainParams{
+	Name: "litecoin",
+	Magic: [4]byte{0xFB,0xC0,0xB6,0xDB},
+	DefaultPort: 9333,
+	RPCPort: 9332,
+	DataSubdir: "ltcnet",
+	GenesisHash: "12a765e31ffd4059bada1e25190f6e98c99d9714d334efa41a195a7e7e04bfe2",
+	GenesisMerkle: "97ddfbbae6be97fd6cdf3e7ca13232a3afff2353e29badfab7f73011edd4ced9",
+	GenesisTime: 1317972665,
+	GenesisBits: 0x1e0ffff0,
+	GenesisNonce: 2084524493,
+	PowLimit: "00000FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+	AddrVerPubkey: LTC_ADDR_VERSION,
+	AddrVerScript: 5, // identical to bitcoin
+	Bech32HRP: "ltc",
+	MessageMagic: "Litecoin Signed Message:\n",
+	XNYSSBranches: 3,
+	XNYSSConfirmsRequired: 1,
+}
+
+func init() {
+	btc.RegisterChainParams(&LitecoinParams)
+}
+
 // LTC signing uses different seed string
 func HashFromMessage(msg []byte, out []byte) {
-	const MessageMagic = "Litecoin Signed Message:\n"
-	b := new(bytes.Buffer)
-	btc.WriteVlen(b, uint64(len(MessageMagic)))
-	b.Write([]byte(MessageMagic))
-	btc.WriteVlen(b, uint64(len(msg)))
-	b.Write(msg)
-	btc.ShaHash(b.Bytes(), out)
+	LitecoinParams.HashFromMessage(msg, out)
 }
 
 func AddrVerPubkey(testnet bool) byte {
 	if !testnet {
-		return LTC_ADDR_VERSION
+		return LitecoinParams.AddrVerPubkey
 	}
 	return btc.AddrVerPubkey(testnet)
 }
 
 func NewAddrFromPkScript(scr []byte, testnet bool) (ad *btc.BtcAddr) {
-	ad = btc.NewAddrFromPkScript(scr, testnet)
-	if ad != nil && ad.Version == btc.AddrVerPubkey(false) {
-		ad.Version = LTC_ADDR_VERSION
+	if !testnet {
+		return LitecoinParams.NewAddrFromPkScript(scr)
 	}
-	return
+	return btc.NewAddrFromPkScript(scr, testnet)
 }
 
 func GetUnspent(addr *btc.BtcAddr) (res utxo.AllUnspentTx) {
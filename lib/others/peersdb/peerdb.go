@@ -11,6 +11,7 @@ import (
 	"strings"
 	"strconv"
 	"encoding/binary"
+	"github.com/lentus/wotscoin/lib/btc"
 	"github.com/lentus/wotscoin/lib/others/qdb"
 	"github.com/lentus/wotscoin/lib/others/sys"
 	"github.com/lentus/wotscoin/lib/others/utils"
@@ -26,7 +27,7 @@ var (
 	proxyPeer *PeerAddr // when this is not nil we should only connect to this single node
 	peerdb_mutex sync.Mutex
 
-	Testnet bool
+	Params *btc.ChainParams = &btc.MainNetParams
 	ConnectOnly string
 	Services uint64 = 1
 )
@@ -37,14 +38,11 @@ type PeerAddr struct {
 	// The fields below don't get saved, but are used internaly
 	Manual bool  // Manually connected (from UI)
 	Friend bool  // Connected from friends.txt
+	Score int    // Reputation from the peer's history (see PeerStats.Score)
 }
 
 func DefaultTcpPort() uint16 {
-	if Testnet {
-		return 52082
-	} else {
-		return 52081
-	}
+	return Params.DefaultPort
 }
 
 func NewEmptyPeer() (p *PeerAddr) {
@@ -79,6 +77,17 @@ func NewAddrFromString(ipstr string, force_default_port bool) (p *PeerAddr, e er
 		}
 		ipstr = ipstr[:x] // remove port number
 	}
+	if strings.HasSuffix(ipstr, ".onion") {
+		var pk []byte
+		if pk, e = btc.OnionPubkey(ipstr); e == nil {
+			p = NewEmptyPeer()
+			p.Services = Services
+			p.Net = btc.BIP155_TORV3
+			p.Addr = pk
+			p.Port = port
+		}
+		return
+	}
 	ip := net.ParseIP(ipstr)
 	if ip != nil && len(ip)==16 {
 		p = NewEmptyPeer()
@@ -135,6 +144,9 @@ func ExpirePeers() {
 		}
 		PeerDB.Defrag(false)
 	}
+	if StatsDB != nil {
+		expireStats()
+	}
 	peerdb_mutex.Unlock()
 }
 
@@ -148,9 +160,14 @@ func (p *PeerAddr) Save() {
 }
 
 
-func (p *PeerAddr) Ban() {
+func (p *PeerAddr) Ban(why string) {
 	p.Banned = uint32(time.Now().Unix())
 	p.Save()
+	p.UpdateStats(func(st *PeerStats) {
+		st.BanCount++
+		st.LastBan = p.Banned
+		st.BanReason = why
+	})
 }
 
 
@@ -170,8 +187,9 @@ func (p *PeerAddr) Dead() {
 }
 
 
+// Returns host:port of the peer (can be an onion address)
 func (p *PeerAddr) Ip() (string) {
-	return fmt.Sprintf("%d.%d.%d.%d:%d", p.Ip4[0], p.Ip4[1], p.Ip4[2], p.Ip4[3], p.Port)
+	return p.NetAddr.String()
 }
 
 
@@ -194,8 +212,9 @@ func (mp manyPeers) Len() int {
 	return len(mp)
 }
 
+// The most recently seen first, but a peer with a better history can be older
 func (mp manyPeers) Less(i, j int) bool {
-	return mp[i].Time > mp[j].Time
+	return int64(mp[i].Time)+int64(mp[i].Score)*RankSecondsPerPoint > int64(mp[j].Time)+int64(mp[j].Score)*RankSecondsPerPoint
 }
 
 func (mp manyPeers) Swap(i, j int) {
@@ -203,7 +222,7 @@ func (mp manyPeers) Swap(i, j int) {
 }
 
 
-// Fetch a given number of best (most recenty seen) peers.
+// Fetch a given number of best (most recenty seen, with the best history) peers.
 func GetBestPeers(limit uint, isConnected func(*PeerAddr)bool) (res manyPeers) {
 	if proxyPeer!=nil {
 		if isConnected==nil || !isConnected(proxyPeer) {
@@ -215,8 +234,9 @@ func GetBestPeers(limit uint, isConnected func(*PeerAddr)bool) (res manyPeers) {
 	tmp := make(manyPeers, 0)
 	PeerDB.Browse(func(k qdb.KeyType, v []byte) uint32 {
 		ad := NewPeer(v)
-		if ad.Banned==0 && sys.ValidIp4(ad.Ip4[:]) && !sys.IsIPBlocked(ad.Ip4[:]) {
+		if ad.Banned==0 && (ad.IsOnion() || ad.Net==0 && sys.ValidIp4(ad.Ip4[:]) && !sys.IsIPBlocked(ad.Ip4[:])) {
 			if isConnected==nil || !isConnected(ad) {
+				ad.Score = ad.Stats().Score()
 				tmp = append(tmp, ad)
 			}
 		}
@@ -261,41 +281,34 @@ func initSeeds(seeds []string, port uint16) {
 // shall be called from the main thread
 func InitPeers(dir string) {
 	PeerDB, _ = qdb.NewDB(dir+"peers3", true)
+	StatsDB, _ = qdb.NewDB(dir+"peerstats", true)
 
 	if ConnectOnly != "" {
 		x := strings.Index(ConnectOnly, ":")
 		if x == -1 {
 			ConnectOnly = fmt.Sprint(ConnectOnly, ":", DefaultTcpPort())
 		}
-		oa, e := net.ResolveTCPAddr("tcp4", ConnectOnly)
-		if e != nil {
-			println(e.Error(), ConnectOnly)
-			os.Exit(1)
+		if strings.Contains(ConnectOnly, ".onion:") {
+			var e error
+			if proxyPeer, e = NewAddrFromString(ConnectOnly, false); e != nil {
+				println(e.Error(), ConnectOnly)
+				os.Exit(1)
+			}
+		} else {
+			oa, e := net.ResolveTCPAddr("tcp4", ConnectOnly)
+			if e != nil {
+				println(e.Error(), ConnectOnly)
+				os.Exit(1)
+			}
+			proxyPeer = NewEmptyPeer()
+			proxyPeer.Services = Services
+			copy(proxyPeer.Ip4[:], oa.IP[12:16])
+			proxyPeer.Port = uint16(oa.Port)
 		}
-		proxyPeer = NewEmptyPeer()
-		proxyPeer.Services = Services
-		copy(proxyPeer.Ip4[:], oa.IP[12:16])
-		proxyPeer.Port = uint16(oa.Port)
-		fmt.Printf("Connect to bitcoin network via %d.%d.%d.%d:%d\n",
-			proxyPeer.Ip4[0], proxyPeer.Ip4[1], proxyPeer.Ip4[2], proxyPeer.Ip4[3], proxyPeer.Port)
+		fmt.Println("Connect to bitcoin network via", proxyPeer.Ip())
 	} else {
 		go func() {
-			if !Testnet {
-				initSeeds([]string{
-					/*"seed.bitcoin.sipa.be",
-					"dnsseed.bluematt.me",
-					"seed.bitcoinstats.com",
-					"seed.bitnodes.io",
-					"bitseed.xf2.org",*/
-					"dnsseed.wlinde.com",
-					}, 52081)
-			} else {
-				initSeeds([]string{
-					//"testnet-seed.bitcoin.petertodd.org",
-					//"testnet-seed.bluematt.me",
-					"dnsseed.wlinde.com",
-					}, 52082)
-			}
+			initSeeds(Params.DNSSeeds, Params.DefaultPort)
 		}()
 	}
 }
@@ -309,4 +322,10 @@ func ClosePeerDB() {
 		PeerDB.Close()
 		PeerDB = nil
 	}
+	if StatsDB!=nil {
+		StatsDB.Sync()
+		StatsDB.Defrag(true)
+		StatsDB.Close()
+		StatsDB = nil
+	}
 }
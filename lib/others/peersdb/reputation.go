@@ -0,0 +1,171 @@
+package peersdb
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"bytes"
+	"encoding/binary"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/others/qdb"
+)
+
+// The history of each peer is kept in its own database (with the same keys as PeerDB),
+// because the peer records get expired a day after we have last heard of the peer.
+
+const (
+	ExpireStatsAfter = (30*24*time.Hour)
+	RankSecondsPerPoint = 60 // in GetBestPeers one point of Score() is worth this much of the peer's age
+)
+
+var (
+	StatsDB *qdb.DB
+	stats_mutex sync.Mutex
+)
+
+type PeerStats struct {
+	Addr        string // host:port
+	LastSeen    uint32 // unix time of the last update
+	Conns       uint32 // number of the connections that have ended
+	BanCount    uint32
+	LastBan     uint32 // unix time
+	BanReason   string // why it was banned last time
+	Misbehave   uint32 // misbehave points collected over all the connections
+	BytesServed uint64 // bytes received from the peer
+	PingAvg     uint32 // moving average of the connections' median pings (ms)
+	BlocksFirst uint32 // new blocks that we have got from this peer first
+	BlocksLate  uint32 // blocks that it has delivered after another peer
+}
+
+/*
+Serialized stats record (all values are LSB):
+ [0] - version (1)
+ [1:5] - LastSeen
+ [5:9] - Conns
+ [9:13] - BanCount
+ [13:17] - LastBan
+ [17:21] - Misbehave
+ [21:29] - BytesServed
+ [29:33] - PingAvg
+ [33:37] - BlocksFirst
+ [37:41] - BlocksLate
+ [41:] - var_str Addr, var_str BanReason
+*/
+
+func NewPeerStats(v []byte) (st *PeerStats) {
+	st = new(PeerStats)
+	if len(v) < 41 || v[0] != 1 {
+		return
+	}
+	st.LastSeen = binary.LittleEndian.Uint32(v[1:5])
+	st.Conns = binary.LittleEndian.Uint32(v[5:9])
+	st.BanCount = binary.LittleEndian.Uint32(v[9:13])
+	st.LastBan = binary.LittleEndian.Uint32(v[13:17])
+	st.Misbehave = binary.LittleEndian.Uint32(v[17:21])
+	st.BytesServed = binary.LittleEndian.Uint64(v[21:29])
+	st.PingAvg = binary.LittleEndian.Uint32(v[29:33])
+	st.BlocksFirst = binary.LittleEndian.Uint32(v[33:37])
+	st.BlocksLate = binary.LittleEndian.Uint32(v[37:41])
+	rd := bytes.NewReader(v[41:])
+	st.Addr, _ = btc.ReadString(rd)
+	st.BanReason, _ = btc.ReadString(rd)
+	return
+}
+
+func (st *PeerStats) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	var b [41]byte
+	b[0] = 1
+	binary.LittleEndian.PutUint32(b[1:5], st.LastSeen)
+	binary.LittleEndian.PutUint32(b[5:9], st.Conns)
+	binary.LittleEndian.PutUint32(b[9:13], st.BanCount)
+	binary.LittleEndian.PutUint32(b[13:17], st.LastBan)
+	binary.LittleEndian.PutUint32(b[17:21], st.Misbehave)
+	binary.LittleEndian.PutUint64(b[21:29], st.BytesServed)
+	binary.LittleEndian.PutUint32(b[29:33], st.PingAvg)
+	binary.LittleEndian.PutUint32(b[33:37], st.BlocksFirst)
+	binary.LittleEndian.PutUint32(b[37:41], st.BlocksLate)
+	buf.Write(b[:])
+	btc.WriteVlen(buf, uint64(len(st.Addr)))
+	buf.Write([]byte(st.Addr))
+	btc.WriteVlen(buf, uint64(len(st.BanReason)))
+	buf.Write([]byte(st.BanReason))
+	return buf.Bytes()
+}
+
+// Returns the peer's reputation: negative for the peers that have been banned or
+// misbehaving, up to 90 for the fast ones that deliver new blocks first.
+func (st *PeerStats) Score() (score int) {
+	score -= 100 * int(st.BanCount)
+	score -= int(st.Misbehave / 10)
+	if tot := st.BlocksFirst + st.BlocksLate; tot > 0 {
+		score += int(50 * st.BlocksFirst / tot)
+	}
+	if st.PingAvg > 0 && st.PingAvg < 1000 {
+		score += int(1000 - st.PingAvg) / 50
+	}
+	if mb := st.BytesServed >> 20; mb >= 200 {
+		score += 20
+	} else {
+		score += int(mb / 10)
+	}
+	return
+}
+
+
+// Returns the history of the peer (an empty one if we do not know it)
+func (p *PeerAddr) Stats() (st *PeerStats) {
+	if StatsDB == nil {
+		return new(PeerStats)
+	}
+	return NewPeerStats(StatsDB.Get(qdb.KeyType(p.UniqID())))
+}
+
+
+// Updates the history of the peer with the given function
+func (p *PeerAddr) UpdateStats(fn func(*PeerStats)) {
+	if StatsDB == nil {
+		return
+	}
+	stats_mutex.Lock()
+	st := p.Stats()
+	fn(st)
+	st.Addr = p.Ip()
+	st.LastSeen = uint32(time.Now().Unix())
+	StatsDB.Put(qdb.KeyType(p.UniqID()), st.Bytes())
+	stats_mutex.Unlock()
+}
+
+
+// Returns the history of all the peers that we know, the worst ones first
+func GetAllStats() (res []*PeerStats) {
+	if StatsDB == nil {
+		return
+	}
+	StatsDB.Browse(func(k qdb.KeyType, v []byte) uint32 {
+		res = append(res, NewPeerStats(v))
+		return 0
+	})
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Score() < res[j].Score()
+	})
+	return
+}
+
+
+func expireStats() {
+	var todel []qdb.KeyType
+	now := time.Now()
+	StatsDB.Browse(func(k qdb.KeyType, v []byte) uint32 {
+		if now.After(time.Unix(int64(NewPeerStats(v).LastSeen), 0).Add(ExpireStatsAfter)) {
+			todel = append(todel, k) // we cannot call Del() from here
+		}
+		return 0
+	})
+	if len(todel) > 0 {
+		for _, k := range todel {
+			StatsDB.Del(k)
+		}
+		StatsDB.Defrag(false)
+	}
+}
@@ -0,0 +1,32 @@
+package peersdb
+
+import (
+	"testing"
+)
+
+func TestPeerStats_Bytes(t *testing.T) {
+	st := &PeerStats{Addr: "1.2.3.4:8333", LastSeen: 1500000000, Conns: 7, BanCount: 2, LastBan: 1499999999,
+		BanReason: "BadCmpctBlock", Misbehave: 1234, BytesServed: 1 << 33, PingAvg: 150, BlocksFirst: 10, BlocksLate: 30}
+	res := NewPeerStats(st.Bytes())
+	if *res != *st {
+		t.Error("Mismatch", *res, *st)
+	}
+
+	if res = NewPeerStats([]byte{2, 0, 0}); *res != (PeerStats{}) {
+		t.Error("Unknown version not ignored")
+	}
+}
+
+func TestPeerStats_Score(t *testing.T) {
+	if sc := new(PeerStats).Score(); sc != 0 {
+		t.Error("Score of an unknown peer", sc)
+	}
+	good := &PeerStats{PingAvg: 100, BlocksFirst: 10, BytesServed: 500 << 20}
+	if sc := good.Score(); sc != 50+18+20 {
+		t.Error("Score of a good peer", sc)
+	}
+	good.BanCount = 1
+	if sc := good.Score(); sc >= 0 {
+		t.Error("Banned peer with a positive score", sc)
+	}
+}
@@ -0,0 +1,152 @@
+// Minimal SOCKS5 client (RFC 1928 with RFC 1929 authentication), used to reach
+// the peers through Tor or any other proxy. Host names (e.g. .onion addresses)
+// are passed to the proxy unresolved.
+package socks
+
+import (
+	"io"
+	"net"
+	"time"
+	"errors"
+	"strconv"
+)
+
+const (
+	VERSION = 5
+	CMD_CONNECT = 1
+
+	ATYP_IPV4 = 1
+	ATYP_DOMAIN = 3
+	ATYP_IPV6 = 4
+
+	AUTH_NONE = 0
+	AUTH_USERPASS = 2
+	AUTH_NO_ACCEPTABLE = 0xff
+)
+
+var replyErrors = []string{"succeeded", "general SOCKS server failure", "connection not allowed by ruleset",
+	"network unreachable", "host unreachable", "connection refused", "TTL expired",
+	"command not supported", "address type not supported"}
+
+type Dialer struct {
+	Proxy string // host:port of the SOCKS5 server
+	User, Password string // optional - Tor uses different credentials to isolate the circuits
+	Timeout time.Duration
+}
+
+
+// Connects to addr (host:port) through the proxy
+func (d *Dialer) Dial(addr string) (con net.Conn, e error) {
+	host, portstr, e := net.SplitHostPort(addr)
+	if e != nil {
+		return
+	}
+	port, e := strconv.ParseUint(portstr, 10, 16)
+	if e != nil {
+		return
+	}
+	if len(host) > 255 {
+		e = errors.New("socks: host name too long")
+		return
+	}
+
+	con, e = net.DialTimeout("tcp", d.Proxy, d.Timeout)
+	if e != nil {
+		return
+	}
+	if d.Timeout > 0 {
+		con.SetDeadline(time.Now().Add(d.Timeout))
+	}
+	if e = d.handshake(con, host, uint16(port)); e != nil {
+		con.Close()
+		con = nil
+		return
+	}
+	con.SetDeadline(time.Time{})
+	return
+}
+
+
+func (d *Dialer) handshake(con net.Conn, host string, port uint16) (e error) {
+	var buf [4]byte
+
+	// Method selection
+	if d.User != "" {
+		_, e = con.Write([]byte{VERSION, 2, AUTH_NONE, AUTH_USERPASS})
+	} else {
+		_, e = con.Write([]byte{VERSION, 1, AUTH_NONE})
+	}
+	if e != nil {
+		return
+	}
+	if _, e = io.ReadFull(con, buf[:2]); e != nil {
+		return
+	}
+	if buf[0] != VERSION {
+		return errors.New("socks: unexpected protocol version")
+	}
+	switch buf[1] {
+		case AUTH_NONE:
+		case AUTH_USERPASS:
+			if d.User == "" || len(d.User) > 255 || len(d.Password) > 255 {
+				return errors.New("socks: proxy requires authentication")
+			}
+			req := []byte{1, byte(len(d.User))}
+			req = append(req, d.User...)
+			req = append(req, byte(len(d.Password)))
+			req = append(req, d.Password...)
+			if _, e = con.Write(req); e != nil {
+				return
+			}
+			if _, e = io.ReadFull(con, buf[:2]); e != nil {
+				return
+			}
+			if buf[1] != 0 {
+				return errors.New("socks: authentication failed")
+			}
+		default:
+			return errors.New("socks: no acceptable authentication method")
+	}
+
+	// Connect request
+	req := []byte{VERSION, CMD_CONNECT, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(append(req, ATYP_IPV4), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, ATYP_IPV6), ip.To16()...)
+	} else {
+		req = append(append(req, ATYP_DOMAIN, byte(len(host))), host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, e = con.Write(req); e != nil {
+		return
+	}
+
+	// Reply
+	if _, e = io.ReadFull(con, buf[:4]); e != nil {
+		return
+	}
+	if buf[0] != VERSION {
+		return errors.New("socks: unexpected protocol version")
+	}
+	if buf[1] != 0 {
+		if int(buf[1]) < len(replyErrors) {
+			return errors.New("socks: " + replyErrors[buf[1]])
+		}
+		return errors.New("socks: unknown error " + strconv.Itoa(int(buf[1])))
+	}
+	var skip int
+	switch buf[3] {
+		case ATYP_IPV4: skip = 4
+		case ATYP_IPV6: skip = 16
+		case ATYP_DOMAIN:
+			if _, e = io.ReadFull(con, buf[:1]); e != nil {
+				return
+			}
+			skip = int(buf[0])
+		default:
+			return errors.New("socks: unexpected address type")
+	}
+	_, e = io.ReadFull(con, make([]byte, skip+2)) // bound address and port - not needed
+	return
+}
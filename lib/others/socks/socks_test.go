@@ -0,0 +1,193 @@
+package socks
+
+import (
+	"io"
+	"net"
+	"time"
+	"bytes"
+	"testing"
+	"strings"
+	"strconv"
+	"encoding/binary"
+)
+
+// A stand-in SOCKS5 server, connecting all the requests to target (or failing with reply code)
+type testProxy struct {
+	lis net.Listener
+	target string
+	reply byte
+	user, pass string // require authentication if set
+	hosts chan string // requested host:port
+}
+
+func newTestProxy(t *testing.T, target string) (p *testProxy) {
+	var e error
+	p = &testProxy{target: target, hosts: make(chan string, 10)}
+	if p.lis, e = net.Listen("tcp", "127.0.0.1:0"); e != nil {
+		t.Fatal(e.Error())
+	}
+	go func() {
+		for {
+			c, e := p.lis.Accept()
+			if e != nil {
+				return
+			}
+			go p.serve(c)
+		}
+	}()
+	return
+}
+
+func (p *testProxy) serve(c net.Conn) {
+	defer c.Close()
+	var buf [256]byte
+	if _, e := io.ReadFull(c, buf[:2]); e != nil || buf[0] != VERSION {
+		return
+	}
+	methods := make([]byte, buf[1])
+	io.ReadFull(c, methods)
+	method := byte(AUTH_NONE)
+	if p.user != "" {
+		method = AUTH_NO_ACCEPTABLE
+		if bytes.IndexByte(methods, AUTH_USERPASS) != -1 {
+			method = AUTH_USERPASS
+		}
+	}
+	c.Write([]byte{VERSION, method})
+	if method == AUTH_NO_ACCEPTABLE {
+		return
+	}
+	if method == AUTH_USERPASS {
+		io.ReadFull(c, buf[:2])
+		user := make([]byte, buf[1])
+		io.ReadFull(c, user)
+		io.ReadFull(c, buf[:1])
+		pass := make([]byte, buf[0])
+		io.ReadFull(c, pass)
+		if string(user) != p.user || string(pass) != p.pass {
+			c.Write([]byte{1, 1})
+			return
+		}
+		c.Write([]byte{1, 0})
+	}
+
+	io.ReadFull(c, buf[:4])
+	var host string
+	switch buf[3] {
+		case ATYP_IPV4:
+			io.ReadFull(c, buf[:4])
+			host = net.IP(buf[:4]).String()
+		case ATYP_IPV6:
+			io.ReadFull(c, buf[:16])
+			host = net.IP(buf[:16]).String()
+		case ATYP_DOMAIN:
+			io.ReadFull(c, buf[:1])
+			dom := make([]byte, buf[0])
+			io.ReadFull(c, dom)
+			host = string(dom)
+	}
+	io.ReadFull(c, buf[:2])
+	p.hosts <- net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+
+	if p.reply != 0 {
+		c.Write([]byte{VERSION, p.reply, 0, ATYP_IPV4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	t, e := net.Dial("tcp", p.target)
+	if e != nil {
+		c.Write([]byte{VERSION, 5, 0, ATYP_IPV4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer t.Close()
+	c.Write([]byte{VERSION, 0, 0, ATYP_DOMAIN, 3, 'a', 'b', 'c', 0, 1})
+	go io.Copy(t, c)
+	io.Copy(c, t)
+}
+
+func echoServer(t *testing.T) net.Listener {
+	lis, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	go func() {
+		for {
+			c, e := lis.Accept()
+			if e != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return lis
+}
+
+func testEcho(t *testing.T, con net.Conn) {
+	msg := []byte("version")
+	con.Write(msg)
+	res := make([]byte, len(msg))
+	con.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, e := io.ReadFull(con, res); e != nil || !bytes.Equal(res, msg) {
+		t.Error("Echo through the proxy failed", e)
+	}
+}
+
+func TestDial(t *testing.T) {
+	echo := echoServer(t)
+	defer echo.Close()
+	p := newTestProxy(t, echo.Addr().String())
+	defer p.lis.Close()
+
+	for _, addr := range []string{"10.1.2.3:8333", "[2001:db8::1]:8333",
+		"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion:8333"} {
+		d := &Dialer{Proxy: p.lis.Addr().String(), Timeout: 5 * time.Second}
+		con, e := d.Dial(addr)
+		if e != nil {
+			t.Fatal(addr, e.Error())
+		}
+		if h := <-p.hosts; h != addr {
+			t.Error("Proxy got", h, "instead of", addr)
+		}
+		testEcho(t, con)
+		con.Close()
+	}
+}
+
+func TestAuth(t *testing.T) {
+	echo := echoServer(t)
+	defer echo.Close()
+	p := newTestProxy(t, echo.Addr().String())
+	defer p.lis.Close()
+	p.user, p.pass = "abc", "def"
+
+	d := &Dialer{Proxy: p.lis.Addr().String(), User: "abc", Password: "def", Timeout: 5 * time.Second}
+	con, e := d.Dial("10.1.2.3:8333")
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	testEcho(t, con)
+	con.Close()
+
+	d.Password = "xyz"
+	if _, e = d.Dial("10.1.2.3:8333"); e == nil || !strings.Contains(e.Error(), "authentication failed") {
+		t.Error("Wrong password accepted", e)
+	}
+
+	d.User = ""
+	if _, e = d.Dial("10.1.2.3:8333"); e == nil {
+		t.Error("Connected without authentication")
+	}
+}
+
+func TestReplyError(t *testing.T) {
+	p := newTestProxy(t, "")
+	defer p.lis.Close()
+	p.reply = 5
+
+	d := &Dialer{Proxy: p.lis.Addr().String(), Timeout: 5 * time.Second}
+	if _, e := d.Dial("10.1.2.3:8333"); e == nil || !strings.Contains(e.Error(), "connection refused") {
+		t.Error("Expected connection refused", e)
+	}
+}
@@ -24,6 +24,8 @@ Serialized peer record (all values are LSB unless specified otherwise):
  [24:28] - IPv4 (network order)
  [28:30] - TCP port (big endian)
  [30:34] - OPTIONAL: if present, unix timestamp of when the peer was banned
+ [34] - OPTIONAL: BIP155 network ID, for addresses that do not fit into IPv6/IPv4 (e.g. Tor)
+ [35:] - the BIP155 address
 */
 
 
@@ -40,13 +42,22 @@ func NewPeer(v []byte) (p *OnePeer) {
 	p.Port = binary.BigEndian.Uint16(v[28:30])
 	if len(v)>=34 {
 		p.Banned = binary.LittleEndian.Uint32(v[30:34])
+		if len(v)>35 {
+			p.Net = v[34]
+			p.Addr = append([]byte{}, v[35:]...)
+		}
 	}
 	return
 }
 
 
 func (p *OnePeer) Bytes() (res []byte) {
-	if p.Banned != 0 {
+	if p.Net != 0 {
+		res = make([]byte, 35+len(p.Addr))
+		binary.LittleEndian.PutUint32(res[30:34], p.Banned)
+		res[34] = p.Net
+		copy(res[35:], p.Addr)
+	} else if p.Banned != 0 {
 		res = make([]byte, 34)
 		binary.LittleEndian.PutUint32(res[30:34], p.Banned)
 	} else {
@@ -63,8 +74,13 @@ func (p *OnePeer) Bytes() (res []byte) {
 
 func (p *OnePeer) UniqID() (uint64) {
 	h := crc64.New(crctab)
-	h.Write(p.Ip6[:])
-	h.Write(p.Ip4[:])
+	if p.Net != 0 {
+		h.Write([]byte{p.Net})
+		h.Write(p.Addr)
+	} else {
+		h.Write(p.Ip6[:])
+		h.Write(p.Ip4[:])
+	}
 	h.Write([]byte{byte(p.Port>>8),byte(p.Port)})
 	return h.Sum64()
 }
@@ -45,6 +45,7 @@ const (
 	VER_MINIMALIF      = 1 << 13
 	VER_NULLFAIL       = 1 << 14
 	VER_WITNESS_PUBKEY = 1 << 15 // WITNESS_PUBKEYTYPE
+	VER_XNYSS          = 1 << 16 // OP_NOP1 works as OP_CHECKXNYSSMULTISIG
 
 	STANDARD_VERIFY_FLAGS = VER_P2SH | VER_STRICTENC | VER_DERSIG | VER_LOW_S |
 		VER_NULLDUMMY | VER_MINDATA | VER_BLOCK_OPS | VER_CLEANSTACK | VER_CLTV | VER_CSV |
@@ -1003,7 +1004,7 @@ func evalScript(p []byte, amount uint64, stack *scrStack, tx *btc.Tx, inp int, v
 					stack.pushBool(fSuccess)
 				}
 
-			case opcode == 0xae || opcode == 0xaf || opcode == 0xb0: //OP_CHECKMULTISIG || OP_CHECKMULTISIGVERIFY || OP_CHECKXNYSSMULTISIG
+			case opcode == 0xae || opcode == 0xaf || opcode == 0xb0 && (ver_flags&VER_XNYSS) != 0: //OP_CHECKMULTISIG || OP_CHECKMULTISIGVERIFY || OP_CHECKXNYSSMULTISIG
 				//fmt.Println("OP_CHECKMULTISIG ...")
 				//stack.print()
 				if stack.size() < 1 {
@@ -1343,7 +1344,7 @@ func evalScript(p []byte, amount uint64, stack *scrStack, tx *btc.Tx, inp int, v
 					return false
 				}
 
-			case opcode == 0xb0 || opcode >= 0xb3 && opcode <= 0xb9: //OP_NOP1 || OP_NOP4..OP_NOP10
+			case opcode == 0xb0 || opcode >= 0xb3 && opcode <= 0xb9: //OP_NOP1 (without VER_XNYSS) || OP_NOP4..OP_NOP10
 				if (ver_flags & VER_BLOCK_OPS) != 0 {
 					return false
 				}
@@ -204,6 +204,8 @@ func decode_flags(s string) (fl uint32, e error) {
 				fl |= VER_NULLFAIL
 			case "WITNESS_PUBKEYTYPE":
 				fl |= VER_WITNESS_PUBKEY
+			case "XNYSS":
+				fl |= VER_XNYSS
 			default:
 				e = errors.New("Unsupported flag "+ss[i])
 				return
@@ -13,12 +13,11 @@ import (
 	"time"
 )
 
-const (
-	UTXO_RECORDS_PREALLOC = 25e6
-	UPKH_RECORDS_PREALLOC = 10e6
-)
-
 var (
+	// Initial capacities of the maps (lower them to run several small chains in one process)
+	UTXO_RECORDS_PREALLOC int = 25e6
+	UPKH_RECORDS_PREALLOC int = 10e6
+
 	UTXO_WRITING_TIME_TARGET = 4 * time.Minute // Take it easy with flushing UTXO.db onto disk
 )
 
@@ -29,6 +28,9 @@ type CallbackFunctions struct {
 	// output is being added or removed. When being removed, btc.TxOut is nil.
 	NotifyTxAdd func(*UtxoRec)
 	NotifyTxDel func(*UtxoRec, []bool)
+	// If NotifyUpkh is set, it will be called each time an UPKH record is
+	// being added (advertised) or removed (consumed, or the block undone).
+	NotifyUpkh func(rec *UpkhRec, added bool)
 }
 
 type UpkhUndoRec struct {
@@ -421,6 +423,9 @@ func (db *UnspentDB) CommitBlockTxs(changes *BlockChanges, blhash []byte) (e err
 }
 
 func (db *UnspentDB) undoBlockUpkhs(dat []byte) {
+	if len(dat) == 0 {
+		return // no XNYSS signatures in the block
+	}
 	le, n := btc.VLen(dat)
 	offset := le+n
 
@@ -433,6 +438,9 @@ func (db *UnspentDB) undoBlockUpkhs(dat []byte) {
 		db.upkhMutex.Lock()
 		db.upkhMap[ind] = undoRec.MapBytes()
 		db.upkhMutex.Unlock()
+		if db.CB.NotifyUpkh != nil {
+			db.CB.NotifyUpkh(undoRec, true)
+		}
 	}
 
 	for offset < len(dat) {
@@ -441,8 +449,12 @@ func (db *UnspentDB) undoBlockUpkhs(dat []byte) {
 		offset += 32
 
 		db.upkhMutex.Lock()
+		v := db.upkhMap[indDel]
 		delete(db.upkhMap, indDel)
 		db.upkhMutex.Unlock()
+		if v != nil && db.CB.NotifyUpkh != nil {
+			db.CB.NotifyUpkh(LoadUpkhRec(indDel, v), false)
+		}
 	}
 }
 
@@ -592,6 +604,14 @@ func (db *UnspentDB) UpkhGet(pkh [32]byte) (res *UpkhRec) {
 	return res
 }
 
+// Returns the number of the UPKH records
+func (db *UnspentDB) UpkhCount() (cnt int) {
+	db.upkhMutex.RLock()
+	cnt = len(db.upkhMap)
+	db.upkhMutex.RUnlock()
+	return
+}
+
 // Returns true if gived TXID is in UTXO
 func (db *UnspentDB) TxPresent(id *btc.Uint256) (res bool) {
 	var ind UtxoKeyType
@@ -653,7 +673,9 @@ func (db *UnspentDB) commit(changes *BlockChanges) {
 		db.upkhMutex.Lock()
 		db.upkhMap[ind] = malloc_and_copy(b)
 		db.upkhMutex.Unlock()
-
+		if db.CB.NotifyUpkh != nil {
+			db.CB.NotifyUpkh(rec, true)
+		}
 	}
 	for k, v := range changes.DeledTxs {
 		db.del(k[:], v)
@@ -663,8 +685,15 @@ func (db *UnspentDB) commit(changes *BlockChanges) {
 		copy(ind[:], changes.DeleteUpkhs[i][:])
 
 		db.upkhMutex.Lock()
+		var rec *UpkhRec
+		if v := db.upkhMap[ind]; v != nil && db.CB.NotifyUpkh != nil {
+			rec = LoadUpkhRec(ind, v)
+		}
 		delete(db.upkhMap, ind)
 		db.upkhMutex.Unlock()
+		if rec != nil {
+			db.CB.NotifyUpkh(rec, false)
+		}
 	}
 }
 
@@ -0,0 +1,209 @@
+// Implements a stateful multi-use key for peer authentication: a Merkle tree
+// of WOTS+ one-time keys, where each signature uses the next unused leaf. The
+// public key (public seed and Merkle root) stays the same until all leaves are
+// used, after which a new key has to be generated. Note that the Key struct is
+// not thread safe and that its state must be stored after each signature.
+package authtree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/lentus/wotscoin/lib/xnyss/wotsp"
+)
+
+const (
+	MsgLen      = wotsp.MsgLen
+	PubKeyLen   = 64 // public seed followed by the Merkle root
+	MaxHeight   = 20
+	MaxSigLen   = 4 + wotsp.SigLen + 32*MaxHeight
+	keyBytesLen = 32 + 32 + 1 + 4
+)
+
+var (
+	ErrInvalidMsgLen = errors.New("invalid message length (must be 32 bytes)")
+	ErrInvalidHeight = errors.New("invalid tree height")
+	ErrKeyExhausted  = errors.New("all one-time keys have been used")
+	ErrInvalidKey    = errors.New("invalid key data")
+)
+
+type Key struct {
+	seed    []byte
+	pubSeed []byte
+	height  uint8
+	next    uint32   // index of the first unused leaf
+	nodes   [][]byte // the tree, nodes[1] is the root and leaves start at 1<<height
+}
+
+// Creates a new key with 2^height one-time keys from the given secret and public seeds.
+func New(seed, pubSeed []byte, height uint8) (*Key, error) {
+	if height > MaxHeight {
+		return nil, ErrInvalidHeight
+	}
+	k := &Key{
+		seed:    make([]byte, 32),
+		pubSeed: make([]byte, 32),
+		height:  height,
+	}
+	copy(k.seed, seed)
+	copy(k.pubSeed, pubSeed)
+	k.build()
+	return k, nil
+}
+
+// Restores a key from the output of Bytes().
+func Load(b []byte) (*Key, error) {
+	if len(b) != keyBytesLen {
+		return nil, ErrInvalidKey
+	}
+	k, err := New(b[0:32], b[32:64], b[64])
+	if err != nil {
+		return nil, err
+	}
+	k.next = binary.BigEndian.Uint32(b[65:69])
+	return k, nil
+}
+
+// Returns the secret state of the key, to be stored after each signature.
+func (k *Key) Bytes() []byte {
+	buf := &bytes.Buffer{}
+	buf.Write(k.seed)
+	buf.Write(k.pubSeed)
+	buf.WriteByte(k.height)
+	binary.Write(buf, binary.BigEndian, k.next)
+	return buf.Bytes()
+}
+
+// Returns the public key, that does not change while there are unused leaves.
+func (k *Key) PublicKey() []byte {
+	return append(append([]byte{}, k.pubSeed...), k.nodes[1]...)
+}
+
+// Returns the number of signatures that can still be made with this key.
+func (k *Key) Remaining() int {
+	return (1 << k.height) - int(k.next)
+}
+
+// Returns the total number of signatures that can be made with this key.
+func (k *Key) Capacity() int {
+	return 1 << k.height
+}
+
+func (k *Key) leafSeed(idx uint32) []byte {
+	s := sha256.New()
+	s.Write(k.seed)
+	binary.Write(s, binary.BigEndian, idx)
+	return s.Sum(nil)
+}
+
+func leafAddress(idx uint32) *wotsp.Address {
+	adrs := new(wotsp.Address)
+	adrs.SetOTS(idx)
+	return adrs
+}
+
+func leafHash(pk []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(pk)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+func (k *Key) build() {
+	cnt := uint32(1) << k.height
+	k.nodes = make([][]byte, 2*cnt)
+	for i := uint32(0); i < cnt; i++ {
+		k.nodes[cnt+i] = leafHash(wotsp.GenPublicKey(k.leafSeed(i), k.pubSeed, leafAddress(i)))
+	}
+	for i := cnt - 1; i > 0; i-- {
+		k.nodes[i] = nodeHash(k.nodes[2*i], k.nodes[2*i+1])
+	}
+}
+
+// Signs the 32 byte message with the next unused leaf. The returned signature
+// consists of the leaf index, the WOTS+ signature and the authentication path.
+// The caller must store the new state (Bytes()) before publishing the signature.
+func (k *Key) Sign(msg []byte) ([]byte, error) {
+	if len(msg) != MsgLen {
+		return nil, ErrInvalidMsgLen
+	}
+	if k.Remaining() <= 0 {
+		return nil, ErrKeyExhausted
+	}
+	idx := k.next
+	k.next++
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, idx)
+	buf.Write(wotsp.Sign(msg, k.leafSeed(idx), k.pubSeed, leafAddress(idx)))
+	for i := (uint32(1) << k.height) + idx; i > 1; i >>= 1 {
+		buf.Write(k.nodes[i^1])
+	}
+	return buf.Bytes(), nil
+}
+
+// Checks the signature against the public key. Returns the index of the
+// used leaf, which the verifier should not accept again (see ReplayGuard).
+func Verify(pub, msg, sig []byte) (idx uint32, ok bool) {
+	if len(pub) != PubKeyLen || len(msg) != MsgLen || len(sig) < 4+wotsp.SigLen {
+		return
+	}
+	path := sig[4+wotsp.SigLen:]
+	if len(path)%32 != 0 || len(path)/32 > MaxHeight {
+		return
+	}
+	height := uint(len(path) / 32)
+	idx = binary.BigEndian.Uint32(sig[0:4])
+	if uint64(idx) >= uint64(1)<<height {
+		return
+	}
+
+	h := leafHash(wotsp.PkFromSig(sig[4:4+wotsp.SigLen], msg, pub[0:32], leafAddress(idx)))
+	for i, pos := 0, idx; i < len(path)/32; i, pos = i+1, pos>>1 {
+		if pos&1 == 0 {
+			h = nodeHash(h, path[32*i:32*i+32])
+		} else {
+			h = nodeHash(path[32*i:32*i+32], h)
+		}
+	}
+	ok = bytes.Equal(h, pub[32:64])
+	return
+}
+
+// Remembers the leaves used with each public key, so that a captured
+// signature cannot be accepted for the second time.
+type ReplayGuard struct {
+	mutex sync.Mutex
+	used  map[string]map[uint32]bool
+}
+
+func NewReplayGuard() *ReplayGuard {
+	return &ReplayGuard{used: make(map[string]map[uint32]bool)}
+}
+
+// Marks the leaf as used. Returns false if it had already been used.
+func (g *ReplayGuard) Use(pub []byte, idx uint32) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	m := g.used[string(pub)]
+	if m == nil {
+		m = make(map[uint32]bool)
+		g.used[string(pub)] = m
+	}
+	if m[idx] {
+		return false
+	}
+	m[idx] = true
+	return true
+}
@@ -0,0 +1,100 @@
+package authtree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func testKey(t *testing.T, height uint8) *Key {
+	k, err := New(bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32), height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSignVerify(t *testing.T) {
+	k := testKey(t, 3)
+	pub := k.PublicKey()
+	for i := 0; i < k.Capacity(); i++ {
+		msg := sha256.Sum256([]byte{byte(i)})
+		sig, err := k.Sign(msg[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idx, ok := Verify(pub, msg[:], sig); !ok || idx != uint32(i) {
+			t.Error("Signature", i, "not verified", idx)
+		}
+		other := sha256.Sum256([]byte("other"))
+		if _, ok := Verify(pub, other[:], sig); ok {
+			t.Error("Signature verified for a different message")
+		}
+		sig[len(sig)-1] ^= 1
+		if _, ok := Verify(pub, msg[:], sig); ok {
+			t.Error("Signature with a broken auth path verified")
+		}
+	}
+	if !bytes.Equal(pub, k.PublicKey()) {
+		t.Error("Public key changed")
+	}
+	msg := sha256.Sum256(nil)
+	if _, err := k.Sign(msg[:]); err != ErrKeyExhausted {
+		t.Error("Expected key exhausted error, got", err)
+	}
+}
+
+func TestLoadKeepsState(t *testing.T) {
+	k := testKey(t, 2)
+	msg := sha256.Sum256(nil)
+	k.Sign(msg[:])
+
+	k2, err := Load(k.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(k.PublicKey(), k2.PublicKey()) || k2.Remaining() != 3 {
+		t.Error("Key state not restored")
+	}
+	sig, _ := k2.Sign(msg[:])
+	if idx, ok := Verify(k.PublicKey(), msg[:], sig); !ok || idx != 1 {
+		t.Error("Restored key reused a leaf")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	k := testKey(t, 2)
+	pub := k.PublicKey()
+	g := NewReplayGuard()
+
+	// Session challenge signed once and captured by an attacker
+	challenge := sha256.Sum256([]byte("session 1"))
+	sig, _ := k.Sign(challenge[:])
+	idx, ok := Verify(pub, challenge[:], sig)
+	if !ok || !g.Use(pub, idx) {
+		t.Fatal("Genuine signature not accepted")
+	}
+
+	// Replaying it for the same challenge must be rejected by the guard
+	if idx, ok = Verify(pub, challenge[:], sig); ok && g.Use(pub, idx) {
+		t.Error("Replayed signature accepted")
+	}
+
+	// ... and for a new challenge it does not even verify
+	challenge2 := sha256.Sum256([]byte("session 2"))
+	if _, ok = Verify(pub, challenge2[:], sig); ok {
+		t.Error("Captured signature verified for a new challenge")
+	}
+
+	// The next genuine signature uses a fresh leaf
+	sig2, _ := k.Sign(challenge2[:])
+	if idx, ok = Verify(pub, challenge2[:], sig2); !ok || !g.Use(pub, idx) {
+		t.Error("Next genuine signature not accepted")
+	}
+
+	// A guard is per public key
+	other := testKey(t, 1)
+	if !g.Use(other.PublicKey(), 0) {
+		t.Error("Leaf of another key reported as used")
+	}
+}
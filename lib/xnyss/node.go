@@ -8,7 +8,10 @@ import (
 	"bytes"
 )
 
-const nodeByteLen = 32 + 32 + 32 + 1
+const (
+	nodeByteLen      = 32 + 32 + 32 + 1
+	nodeBlockByteLen = nodeByteLen + 32 // nodes with the hash of the advertising block
+)
 
 var (
 	ErrNodeInvalidInput = errors.New("input is not a valid node")
@@ -20,19 +23,28 @@ type nyNode struct {
 	pubSeed  []byte
 	privSeed []byte
 	confirms uint8
+	block    []byte // block of the transaction that advertised the node (nil if unknown)
 }
 
-func loadNode(b []byte) (*nyNode, int, error) {
-	if len(b) < nodeByteLen {
+func loadNode(b []byte, withBlock bool) (*nyNode, int, error) {
+	if len(b) < nodeByteLen || withBlock && len(b) < nodeBlockByteLen {
 		return nil, 0, ErrNodeInvalidInput
 	}
 
-	return &nyNode{
+	node := &nyNode{
 		privSeed: b[0:32],
 		pubSeed:  b[32:64],
 		txid:     b[64:96],
 		confirms: b[96],
-	}, nodeByteLen, nil
+	}
+	if !withBlock {
+		return node, nodeByteLen, nil
+	}
+
+	if !bytes.Equal(b[97:129], make([]byte, 32)) {
+		node.block = b[97:129]
+	}
+	return node, nodeBlockByteLen, nil
 }
 
 // Generates child nodes of the current node.
@@ -116,12 +128,19 @@ func (n *nyNode) sign(msg, txid []byte, ots bool) (sig *Signature, childNodes []
 	return
 }
 
-func (n *nyNode) bytes() []byte {
+func (n *nyNode) bytes(withBlock bool) []byte {
 	buf := &bytes.Buffer{}
 	buf.Write(n.privSeed)
 	buf.Write(n.pubSeed)
 	buf.Write(n.txid)
 	buf.WriteByte(n.confirms)
+	if withBlock {
+		if n.block != nil {
+			buf.Write(n.block)
+		} else {
+			buf.Write(make([]byte, 32))
+		}
+	}
 
 	return buf.Bytes()
 }
@@ -15,6 +15,13 @@ const (
 	PubKeyLen = wotsp.PubKeyLen
 )
 
+// Flags in the first byte of a serialized tree
+const (
+	treeFlagOneTime = 0x01
+	treeFlagBlocks  = 0x02 // the nodes include the hash of their advertising block
+	treeFlagShard   = 0x04 // followed by the shard id and the number of shards
+)
+
 // Denotes the amount of confirmations (or block depth) that are required before
 // a node can be used to create new signatures.
 var ConfirmsRequired uint8 = 1
@@ -28,6 +35,10 @@ var (
 	ErrTreeNoneAvailable = errors.New("no signature nodes available")
 	ErrTreeBackupOneTime = errors.New("cannot create a backup of a one-time tree")
 	ErrTreeBackupFailed  = errors.New("more backup nodes requested than are available")
+	ErrTreeMergeOther    = errors.New("cannot merge the nodes of another key")
+	ErrTreeMergeOverlap  = errors.New("cannot merge trees that share nodes")
+	ErrTreeShardOneTime  = errors.New("cannot shard a one-time tree")
+	ErrTreeShardCount    = errors.New("invalid number of shards (must be 2 to 255)")
 )
 
 type NYTree struct {
@@ -35,6 +46,7 @@ type NYTree struct {
 	rootSeed    []byte
 	rootPubSeed []byte
 	ots         bool
+	shard       [2]byte // id and count, if the tree is a shard (see Split)
 }
 
 // Creates a new Naor-Yung chain tree using the given secret and public seeds.
@@ -96,6 +108,19 @@ func (t *NYTree) getSignNode(txid []byte) int {
 	return -1
 }
 
+// Returns the public key hash of the node that Sign would use for the given
+// txid, so that it can be checked (or recorded) before it signs. Returns an
+// ErrTreeNoneAvailable error if no nodes are available.
+func (t *NYTree) SignNode(txid []byte) ([]byte, error) {
+	index := t.getSignNode(txid)
+	if index < 0 {
+		return nil, ErrTreeNoneAvailable
+	}
+
+	pkh := sha256.Sum256(t.nodes[index].genPubKey())
+	return pkh[:], nil
+}
+
 // Creates a signature for the given message. The txid and input are used to
 // create new nodes in the tree. Returns an error if no nodes are available to
 // create new signatures, of if the input message is longer than 32 bytes.
@@ -180,6 +205,88 @@ func (t *NYTree) Confirm(pkh []byte, confirms uint8) {
 	}
 }
 
+// Sets the confirmation count of the node with the given public key hash, and
+// records the hash of the block that contains the transaction which advertised
+// the node (i.e. the block the confirmations depend on).
+//
+// Unlike Confirm, this also updates nodes that have already been confirmed. If
+// their advertising transaction is no longer on the best chain (e.g. because its
+// block got orphaned), pass zero confirmations and a nil block to revoke the
+// confirmation, so that the node is not used until it gets confirmed again.
+// Returns false if there is no such node.
+func (t *NYTree) ConfirmIn(pkh []byte, confirms uint8, block []byte) bool {
+	for _, node := range t.nodes {
+		nodePkh := sha256.Sum256(node.genPubKey())
+		if bytes.Equal(pkh, nodePkh[:]) {
+			node.confirms = confirms
+			node.block = nil
+			if block != nil {
+				node.block = make([]byte, 32)
+				copy(node.block, block)
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the public key hashes of the confirmed nodes that have been advertised
+// by a transaction (so all but the root node), along with the hashes of the
+// blocks their confirmations depend on (nil if not known). Use it to re-check
+// the confirmations after a chain reorganisation.
+func (t *NYTree) Confirmed() (pkhashes, blocks [][]byte) {
+	for _, node := range t.nodes {
+		if node.confirms < ConfirmsRequired || t.isRoot(node) {
+			continue
+		}
+
+		pkh := sha256.Sum256(node.genPubKey())
+		pkhashes = append(pkhashes, pkh[:])
+		blocks = append(blocks, node.block)
+	}
+
+	return
+}
+
+// Removes the unconfirmed node with the given public key hash from the tree t.
+// Use it for the nodes advertised by a signature of a transaction that will
+// never get mined (e.g. because it has been replaced by another one), so they
+// do not wait for confirmations forever. Returns false if there was no such node.
+func (t *NYTree) Retire(pkh []byte) bool {
+	for i, node := range t.nodes {
+		if node.confirms >= ConfirmsRequired {
+			continue
+		}
+
+		nodePkh := sha256.Sum256(node.genPubKey())
+		if bytes.Equal(pkh, nodePkh[:]) {
+			node.wipe()
+			t.nodes = append(t.nodes[:i], t.nodes[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Removes the node with the given public key hash from the tree t, whether it
+// is confirmed or not. Use it for a node that must never sign, e.g. because it
+// is known to have signed already from a copy of the tree. Returns false if
+// there was no such node.
+func (t *NYTree) Remove(pkh []byte) bool {
+	for i, node := range t.nodes {
+		nodePkh := sha256.Sum256(node.genPubKey())
+		if bytes.Equal(pkh, nodePkh[:]) {
+			node.wipe()
+			t.nodes = append(t.nodes[:i], t.nodes[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
 // Returns the amount of signatures that can be created with the tree t. If txid
 // is not nil, nodes with a matching txid are counted as valid even if they do
 // not have enough confirmations. This is useful when a transaction includes
@@ -239,6 +346,119 @@ func (t *NYTree) Backup(count int) (*NYTree, error) {
 	return backup, nil
 }
 
+// Returns the hashes of the seeds of all the nodes in the tree t. They tell the
+// nodes apart without revealing them, e.g. to make sure that a backup and the
+// tree it was taken from do not share any nodes.
+func (t *NYTree) SeedHashes() (res [][32]byte) {
+	res = make([][32]byte, len(t.nodes))
+	for i, node := range t.nodes {
+		s := sha256.New()
+		s.Write(node.privSeed)
+		s.Write(node.pubSeed)
+		copy(res[i][:], s.Sum(nil))
+	}
+
+	return
+}
+
+// Returns true if the tree t has not created any signatures yet (it only has
+// its root node).
+func (t *NYTree) Fresh() bool {
+	return len(t.nodes) == 1 && t.isRoot(t.nodes[0])
+}
+
+// Returns true if n is the root node of the tree t. It is told apart by its
+// seeds, since a child node can have an empty txid as well.
+func (t *NYTree) isRoot(n *nyNode) bool {
+	return bytes.Equal(n.privSeed, t.rootSeed) && bytes.Equal(n.pubSeed, t.rootPubSeed)
+}
+
+// Moves the nodes of the tree other (e.g. a backup) into the tree t. Both must
+// belong to the same key, and must not share any nodes, since a node in both
+// of them could be used to sign twice. The other tree is left empty.
+func (t *NYTree) Merge(other *NYTree) error {
+	if t.ots || other.ots {
+		return ErrTreeBackupOneTime
+	}
+	if len(other.nodes) == 0 {
+		return nil
+	}
+
+	// An empty backup (see Backup) has no root seeds
+	empty := make([]byte, 32)
+	if !bytes.Equal(t.rootPubSeed, empty) && (!bytes.Equal(t.rootSeed, other.rootSeed) ||
+		!bytes.Equal(t.rootPubSeed, other.rootPubSeed)) {
+		return ErrTreeMergeOther
+	}
+
+	have := make(map[[32]byte]bool, len(t.nodes))
+	for _, h := range t.SeedHashes() {
+		have[h] = true
+	}
+	for _, h := range other.SeedHashes() {
+		if have[h] {
+			return ErrTreeMergeOverlap
+		}
+	}
+
+	if bytes.Equal(t.rootPubSeed, empty) {
+		copy(t.rootSeed, other.rootSeed)
+		copy(t.rootPubSeed, other.rootPubSeed)
+	}
+	for _, node := range other.nodes {
+		t.nodes = append(t.nodes, node)
+	}
+	other.nodes = other.nodes[:0]
+
+	return nil
+}
+
+// Splits the confirmed nodes of the tree t (the ones that Backup could take)
+// into count new trees, called shards, dealing them out in turn. Each shard
+// signs on its own, and keeps the children of its nodes, so the shards never
+// share a node and can be used on different devices without coordination. The
+// unconfirmed nodes stay in t. A shard can get less nodes than others, or
+// none at all.
+func (t *NYTree) Split(count int) ([]*NYTree, error) {
+	if t.ots {
+		return nil, ErrTreeShardOneTime
+	}
+	if count < 2 || count > 255 {
+		return nil, ErrTreeShardCount
+	}
+
+	shards := make([]*NYTree, count)
+	for i := range shards {
+		shards[i] = &NYTree{
+			rootSeed:    make([]byte, 32),
+			rootPubSeed: make([]byte, 32),
+			shard:       [2]byte{byte(i + 1), byte(count)},
+		}
+		copy(shards[i].rootSeed, t.rootSeed)
+		copy(shards[i].rootPubSeed, t.rootPubSeed)
+	}
+
+	var dealt int
+	nodes := t.nodes[:0]
+	for _, node := range t.nodes {
+		if node.confirms >= ConfirmsRequired {
+			shards[dealt%count].nodes = append(shards[dealt%count].nodes, node)
+			dealt++
+		} else {
+			nodes = append(nodes, node)
+		}
+	}
+	t.nodes = nodes
+
+	return shards, nil
+}
+
+// Returns the id of the shard t and the number of shards it was split into,
+// or zeros if t is not a shard.
+func (t *NYTree) Shard() (id, count int) {
+	return int(t.shard[0]), int(t.shard[1])
+}
+
 // Wipes secret data.
 func (t *NYTree) Wipe() {
 	for _, node := range t.nodes {
@@ -254,17 +474,36 @@ func (t *NYTree) Wipe() {
 func (t *NYTree) Bytes() []byte {
 	buf := &bytes.Buffer{}
 
+	// The block hashes are only stored if there are any, so that trees without
+	// them keep the original format.
+	withBlock := false
+	for _, node := range t.nodes {
+		if node.block != nil {
+			withBlock = true
+			break
+		}
+	}
+
+	var flags byte
 	if t.ots {
-		buf.WriteByte(0x01)
-	} else {
-		buf.WriteByte(0x00)
+		flags |= treeFlagOneTime
+	}
+	if withBlock {
+		flags |= treeFlagBlocks
+	}
+	if t.shard[1] != 0 {
+		flags |= treeFlagShard
+	}
+	buf.WriteByte(flags)
+	if t.shard[1] != 0 {
+		buf.Write(t.shard[:])
 	}
 
 	buf.Write(t.rootSeed)
 	buf.Write(t.rootPubSeed)
 
 	for _, node := range t.nodes {
-		buf.Write(node.bytes())
+		buf.Write(node.bytes(withBlock))
 	}
 
 	return buf.Bytes()
@@ -282,12 +521,23 @@ func Load(b []byte) (*NYTree, error) {
 		rootPubSeed: make([]byte, 32),
 	}
 
-	tree.ots = b[0] == 0x01
+	if b[0]&^(treeFlagOneTime|treeFlagBlocks|treeFlagShard) != 0 {
+		return nil, ErrTreeInvalidInput
+	}
+	tree.ots = b[0]&treeFlagOneTime != 0
+	withBlock := b[0]&treeFlagBlocks != 0
+	if b[0]&treeFlagShard != 0 {
+		if len(b) < 67 {
+			return nil, ErrTreeInvalidInput
+		}
+		copy(tree.shard[:], b[1:3])
+		b = b[2:]
+	}
 	copy(tree.rootSeed, b[1:33])
 	copy(tree.rootPubSeed, b[33:65])
 
 	for offset := 65; offset < len(b); {
-		node, bytesRead, err := loadNode(b[offset:])
+		node, bytesRead, err := loadNode(b[offset:], withBlock)
 		if err != nil {
 			return nil, err
 		}
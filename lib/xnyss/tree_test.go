@@ -174,6 +174,292 @@ func TestNYTree_Unconfirmed(t *testing.T) {
 	}
 }
 
+func TestNYTree_Retire(t *testing.T) {
+	seed, pubSeed, err := genSeeds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := New(seed, pubSeed, false)
+
+	sig, _, err := signMessage("replaced transaction", tree)
+	if err != nil {
+		t.Fatal("Failed to sign msg with root -", err)
+	}
+
+	// 1 - a confirmed node cannot be retired
+	tree.Confirm(sig.ChildHashes[0], ConfirmsRequired)
+	if tree.Retire(sig.ChildHashes[0]) {
+		t.Fatal("Retired a confirmed node")
+	}
+
+	// 2 - the unconfirmed ones are removed
+	for _, pkh := range sig.ChildHashes[1:] {
+		if !tree.Retire(pkh) {
+			t.Fatal("Failed to retire an unconfirmed node")
+		}
+	}
+	if len(tree.Unconfirmed()) != 0 {
+		t.Fatal(len(tree.Unconfirmed()), "unconfirmed upkh(s), should be 0")
+	}
+	if tree.Retire(sig.ChildHashes[1]) {
+		t.Fatal("Retired the same node twice")
+	}
+	if tree.Available(nil) != 1 {
+		t.Fatal(tree.Available(nil), "available node(s), should be 1")
+	}
+}
+
+func TestNYTree_ConfirmIn(t *testing.T) {
+	seed, pubSeed, err := genSeeds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := New(seed, pubSeed, false)
+
+	// 1 - the root node is not advertised, so it is never re-checked
+	if pkhs, _ := tree.Confirmed(); len(pkhs) != 0 {
+		t.Fatal(len(pkhs), "confirmed upkh(s) before signing, should be 0")
+	}
+
+	sig, _, err := signMessage("advertising transaction", tree)
+	if err != nil {
+		t.Fatal("Failed to sign msg with root -", err)
+	}
+
+	// 2 - confirm two nodes, one of them in a known block
+	block := bytes.Repeat([]byte{0xbb}, 32)
+	if !tree.ConfirmIn(sig.ChildHashes[0], ConfirmsRequired, block) {
+		t.Fatal("Failed to confirm a node in a block")
+	}
+	tree.Confirm(sig.ChildHashes[1], ConfirmsRequired)
+	if tree.ConfirmIn(make([]byte, 32), ConfirmsRequired, block) {
+		t.Fatal("Confirmed a node that does not exist")
+	}
+
+	pkhs, blocks := tree.Confirmed()
+	if len(pkhs) != 2 || !bytes.Equal(pkhs[0], sig.ChildHashes[0]) ||
+		!bytes.Equal(blocks[0], block) || blocks[1] != nil {
+		t.Fatal("Invalid confirmed nodes", len(pkhs))
+	}
+
+	// 3 - the block survives serialisation
+	treeBytes := tree.Bytes()
+	if treeBytes[0] != treeFlagBlocks || len(treeBytes) != 65+Branches*nodeBlockByteLen {
+		t.Fatal("Invalid serialisation of a tree with blocks")
+	}
+	loaded, err := Load(treeBytes)
+	if err != nil {
+		t.Fatal("Failed to load tree with blocks -", err)
+	}
+	if _, blocks = loaded.Confirmed(); len(blocks) != 2 || !bytes.Equal(blocks[0], block) || blocks[1] != nil {
+		t.Fatal("Blocks not loaded")
+	}
+
+	// 4 - revoking a confirmation makes the node unavailable again
+	if !loaded.ConfirmIn(sig.ChildHashes[0], 0, nil) {
+		t.Fatal("Failed to revoke a confirmation")
+	}
+	if loaded.Available(nil) != 1 || len(loaded.Unconfirmed()) != Branches-1 {
+		t.Fatal(loaded.Available(nil), "available node(s) after revoking, should be 1")
+	}
+	if loaded.Bytes()[0] != 0x00 {
+		t.Fatal("Tree without blocks not serialised in the original format")
+	}
+
+	// 5 - the children of a signature with an empty txid are not taken for the root
+	msg := sha256.Sum256([]byte("empty txid"))
+	sig, err = loaded.Sign(msg[:], make([]byte, 32))
+	if err != nil {
+		t.Fatal("Failed to sign with an empty txid -", err)
+	}
+	loaded.Confirm(sig.ChildHashes[0], ConfirmsRequired)
+	if pkhs, _ = loaded.Confirmed(); len(pkhs) != 1 || !bytes.Equal(pkhs[0], sig.ChildHashes[0]) {
+		t.Fatal("Node advertised with an empty txid not re-checked")
+	}
+}
+
+func TestNYTree_SignNode(t *testing.T) {
+	seed, pubSeed, err := genSeeds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := New(seed, pubSeed, false)
+
+	// 1 - the node is the one whose public key makes the signature
+	txid := bytes.Repeat([]byte{1}, 32)
+	pkh, err := tree.SignNode(txid)
+	if err != nil {
+		t.Fatal("Failed to get the sign node -", err)
+	}
+	msg := sha256.Sum256([]byte("sign node"))
+	sig, err := tree.Sign(msg[:], txid)
+	if err != nil {
+		t.Fatal("Failed to sign -", err)
+	}
+	pub, err := sig.PublicKey()
+	if err != nil {
+		t.Fatal("Failed to get the public key -", err)
+	}
+	if h := sha256.Sum256(pub); !bytes.Equal(pkh, h[:]) {
+		t.Fatal("Sign node does not match the signature")
+	}
+
+	// 2 - the next one, for another txid, is not there until confirmed
+	if _, err = tree.SignNode(bytes.Repeat([]byte{2}, 32)); err != ErrTreeNoneAvailable {
+		t.Fatal("Got a sign node without confirmations -", err)
+	}
+	tree.Confirm(sig.ChildHashes[0], ConfirmsRequired)
+	if pkh, err = tree.SignNode(bytes.Repeat([]byte{2}, 32)); err != nil || !bytes.Equal(pkh, sig.ChildHashes[0]) {
+		t.Fatal("Wrong sign node after confirming -", err)
+	}
+
+	// 3 - a removed node cannot sign
+	if !tree.Remove(pkh) || tree.Remove(pkh) {
+		t.Fatal("Failed to remove the confirmed node once")
+	}
+	if tree.Available(nil) != 0 || len(tree.nodes) != Branches-1 {
+		t.Fatal(tree.Available(nil), "available node(s) after removing, should be 0")
+	}
+}
+
+func TestNYTree_Merge(t *testing.T) {
+	seed, pubSeed, err := genSeeds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := New(seed, pubSeed, false)
+	if !tree.Fresh() {
+		t.Fatal("New tree is not fresh")
+	}
+
+	// 1 - Sign and confirm the children, so there is something to backup
+	sig, _, err := signMessage("merge test", tree)
+	if err != nil {
+		t.Fatal("Failed to sign -", err)
+	}
+	for _, pkh := range sig.ChildHashes {
+		tree.Confirm(pkh, ConfirmsRequired)
+	}
+	if tree.Fresh() {
+		t.Fatal("Tree is fresh after signing")
+	}
+	backup, err := tree.Backup(1)
+	if err != nil {
+		t.Fatal("Failed to backup -", err)
+	}
+
+	// 2 - The backup and the tree have no nodes in common
+	have := make(map[[32]byte]bool)
+	for _, h := range tree.SeedHashes() {
+		have[h] = true
+	}
+	bh := backup.SeedHashes()
+	if len(bh) != 1 || have[bh[0]] {
+		t.Fatal("Backup shares nodes with the tree")
+	}
+
+	// 3 - Overlapping trees or trees of another key are refused
+	clone, _ := Load(backup.Bytes())
+	if err = clone.Merge(backup); err != ErrTreeMergeOverlap {
+		t.Fatal("Merged overlapping trees -", err)
+	}
+	seed2, pubSeed2, _ := genSeeds()
+	if err = New(seed2, pubSeed2, false).Merge(backup); err != ErrTreeMergeOther {
+		t.Fatal("Merged the tree of another key -", err)
+	}
+
+	// 4 - Merging moves the nodes back
+	if err = tree.Merge(backup); err != nil {
+		t.Fatal("Failed to merge -", err)
+	}
+	if len(backup.nodes) != 0 || len(tree.nodes) != Branches || tree.Available(nil) != Branches {
+		t.Fatal(tree.Available(nil), "nodes available after merging, should be", Branches)
+	}
+
+	// 5 - An empty backup tree takes the root seeds of the merged one
+	empty, err := tree.Backup(Branches)
+	if err != ErrTreeBackupFailed {
+		t.Fatal("Backup of all nodes did not fail -", err)
+	}
+	if err = empty.Merge(clone); err != nil || !bytes.Equal(empty.PublicKey(), tree.PublicKey()) {
+		t.Fatal("Failed to merge into an empty tree -", err)
+	}
+}
+
+func TestNYTree_Split(t *testing.T) {
+	seed, pubSeed, err := genSeeds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := New(seed, pubSeed, false)
+	if _, err = tree.Split(1); err != ErrTreeShardCount {
+		t.Fatal("Split into one shard -", err)
+	}
+
+	// 1 - Two signatures, the children of the second one left unconfirmed
+	sig, _, err := signMessage("split test", tree)
+	if err != nil {
+		t.Fatal("Failed to sign -", err)
+	}
+	for _, pkh := range sig.ChildHashes {
+		tree.Confirm(pkh, ConfirmsRequired)
+	}
+	if _, _, err = signMessage("split test 2", tree); err != nil {
+		t.Fatal("Failed to sign -", err)
+	}
+	confirmed := tree.Available(nil)
+
+	// 2 - The confirmed nodes are dealt out, the unconfirmed ones stay
+	shards, err := tree.Split(confirmed)
+	if err != nil {
+		t.Fatal("Failed to split -", err)
+	}
+	if tree.Available(nil) != 0 || len(tree.nodes) != Branches {
+		t.Fatal("Wrong nodes left in the tree after splitting")
+	}
+
+	seen := make(map[[32]byte]bool)
+	for _, h := range tree.SeedHashes() {
+		seen[h] = true
+	}
+	for i, shard := range shards {
+		if id, count := shard.Shard(); id != i+1 || count != confirmed {
+			t.Fatal("Wrong shard id", id, count)
+		}
+		if !bytes.Equal(shard.PublicKey(), tree.PublicKey()) || shard.Available(nil) != 1 {
+			t.Fatal("Bad shard", i+1)
+		}
+
+		// 3 - Each shard signs on its own, keeping the children of its nodes
+		loaded, err := Load(shard.Bytes())
+		if err != nil {
+			t.Fatal("Failed to load a shard -", err)
+		}
+		if id, count := loaded.Shard(); id != i+1 || count != confirmed {
+			t.Fatal("Shard id not loaded", id, count)
+		}
+		sig, _, err = signMessage("shard test", loaded)
+		if err != nil {
+			t.Fatal("Shard failed to sign -", err)
+		}
+		for _, pkh := range sig.ChildHashes {
+			loaded.Confirm(pkh, ConfirmsRequired)
+		}
+		if loaded.Available(nil) != Branches {
+			t.Fatal("Shard did not keep its children")
+		}
+		for _, h := range loaded.SeedHashes() {
+			if seen[h] {
+				t.Fatal("Shards share nodes")
+			}
+			seen[h] = true
+		}
+	}
+	if _, _, err = signMessage("tree test", tree); err != ErrTreeNoneAvailable {
+		t.Fatal("Signed with the nodes of the shards -", err)
+	}
+}
+
 func TestNYTree_Available(t *testing.T) {
 	seed, pubSeed, err := genSeeds()
 	if err != nil {
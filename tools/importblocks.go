@@ -18,7 +18,7 @@ var (
 	Magic [4]byte
 	GocoinHomeDir string
 	BtcRootDir string
-	GenesisBlock *btc.Uint256
+	Params *btc.ChainParams
 	prev_EcdsaVerifyCnt uint64
 )
 
@@ -39,7 +39,7 @@ func stat(totnsec, pernsec int64, totbytes, perbytes uint64, height uint32) {
 
 func import_blockchain(dir string) {
 	BlockDatabase := blockdb.NewBlockDB(dir, Magic)
-	chain := chain.NewChainExt(GocoinHomeDir, GenesisBlock, false, nil, nil)
+	chain := chain.NewChainExt(GocoinHomeDir, Params, false, nil, nil)
 
 	var bl *btc.Block
 	var er error
@@ -145,19 +145,12 @@ func main() {
 		GocoinHomeDir = sys.BitcoinHome()+"gocoin"+string(os.PathSeparator)
 	}
 
-	if Magic==[4]byte{0x0B,0x11,0x09,0x07} {
-		// testnet3
-		fmt.Println("There are Testnet3 blocks")
-		GenesisBlock = btc.NewUint256FromString("000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943")
-		GocoinHomeDir += "tstnet"+string(os.PathSeparator)
-	} else if Magic==[4]byte{0xF9,0xBE,0xB4,0xD9} {
-		fmt.Println("There are valid Bitcoin blocks")
-		GenesisBlock = btc.NewUint256FromString("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f")
-		GocoinHomeDir += "btcnet"+string(os.PathSeparator)
-	} else {
+	if Params = btc.GetChainParamsByMagic(Magic); Params == nil {
 		println("blk00000.dat has an unexpected magic")
 		os.Exit(1)
 	}
+	fmt.Println("There are", Params.Name, "blocks")
+	GocoinHomeDir += Params.DataSubdir+string(os.PathSeparator)
 
 	fmt.Println("Importing blockchain data into", GocoinHomeDir, "...")
 
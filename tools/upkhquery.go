@@ -0,0 +1,88 @@
+// This tool asks a wotscoin node (getupkh) about the keys listed in the wallet's
+// unconfirmed.txt and writes confirmed.txt, just like the client's "confirm" command
+package main
+
+import (
+	"fmt"
+	"flag"
+	"strings"
+	"io/ioutil"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/others/socks"
+	"github.com/lentus/wotscoin/lib/others/lightpeer"
+)
+
+
+var (
+	node = flag.String("n", "", "the node to ask (host:port, required)")
+	netw = flag.String("net", "wotscoin", "network name ("+strings.Join(btc.ChainParamsNames(), ", ")+")")
+	proxy = flag.String("proxy", "", "connect through this SOCKS5 proxy (host:port)")
+	infile = flag.String("i", "unconfirmed.txt", "file with the public key hashes (written by wallet -unconfirmed)")
+	outfile = flag.String("o", "confirmed.txt", "output file (to be used with wallet -confirm)")
+	help = flag.Bool("h", false, "print this help")
+)
+
+
+func main() {
+	flag.Parse()
+	if *help || *node == "" {
+		flag.PrintDefaults()
+		return
+	}
+
+	params := btc.GetChainParams(*netw)
+	if params == nil {
+		println("Unknown network", *netw)
+		return
+	}
+
+	d, er := ioutil.ReadFile(*infile)
+	if er != nil {
+		println(er.Error())
+		return
+	}
+	uf, er := btc.ReadUpkhFile(d, btc.UPKH_FILE_UNCONFIRMED)
+	if er != nil {
+		println("Bad format of", *infile, "-", er.Error())
+		return
+	}
+	if uf.Network != params.Name {
+		println(*infile, "is for", uf.Network, "network - use -net", uf.Network)
+		return
+	}
+	hashes := uf.Hashes
+
+	var p *lightpeer.Peer
+	if *proxy != "" {
+		con, er := (&socks.Dialer{Proxy: *proxy, Timeout: lightpeer.DialTimeout}).Dial(*node)
+		if er == nil {
+			if p, er = lightpeer.NewPeer(con, params.Magic); er != nil {
+				con.Close()
+			}
+		}
+	} else {
+		p, er = lightpeer.Dial(*node, params.Magic)
+	}
+	if er != nil {
+		println("Cannot connect to the node:", er.Error())
+		return
+	}
+	defer p.Close()
+	fmt.Println("Connected to", *node, p.Agent, "at block", p.Height)
+
+	res, er := p.GetUpkh(hashes)
+	if er != nil {
+		println("getupkh failed:", er.Error())
+		return
+	}
+
+	cf := &btc.UpkhFile{Kind: btc.UPKH_FILE_CONFIRMED, Network: params.Name, TipHash: res.TipHash,
+		TipHeight: res.TipHeight, Recs: res.Recs}
+	if er = ioutil.WriteFile(*outfile, cf.Text(), 0600); er != nil {
+		println(er.Error())
+		return
+	}
+
+	fmt.Println("Confirmed", cf.Found(), "of", len(hashes), "public key hashes at block", res.TipHeight, res.TipHash.String())
+	fmt.Println("Confirmation info was written to the file", *outfile)
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/lentus/wotscoin/lib/xnyss"
+)
+
+// A backup moves nodes out of the live key states (see write_backup), so the
+// live states and a backup must never share a node - it could sign twice.
+// Each backup gets a generation, written to its folder and recorded in the
+// state folder, along with the hashes of the seeds of its nodes. A backup that
+// gets merged back into the live states, or restored as them, is retired in
+// the record, and its nodes are refused from then on.
+
+const (
+	BackupInfoFile   = "generation.json" // in the backup folder
+	BackupRecordFile = "backups.json"    // in StateDirectory
+)
+
+type backupInfo struct {
+	Generation int                 `json:"generation"`
+	Time       int64               `json:"time"`
+	Network    string              `json:"network"`
+	Nodes      map[string][]string `json:"nodes"`             // hashes of the node seeds, by the state file name
+	Retired    int64               `json:"retired,omitempty"` // when it got merged or restored (only in the record)
+}
+
+type backupAudit struct {
+	trees    []*xnyss.NYTree     // by the index of the key, nil if it is not in the backup
+	info     *backupInfo         // nil if the backup has no generation file
+	hashes   map[string][]string // of the node seeds in the backup (see backup_nodes)
+	nodes    int
+	notes    []string
+	problems []string // any of them makes it unsafe to merge or restore the backup
+}
+
+
+func load_backup_record() (lst []*backupInfo, err error) {
+	d, err := ioutil.ReadFile(StateDirectory + "/" + BackupRecordFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	if err = json.Unmarshal(d, &lst); err != nil {
+		err = fmt.Errorf("%s/%s is corrupt - %s", StateDirectory, BackupRecordFile, err.Error())
+	}
+	return
+}
+
+func save_backup_record(lst []*backupInfo) error {
+	if err := os.MkdirAll(StateDirectory, os.ModePerm); err != nil {
+		return err
+	}
+	d, _ := json.MarshalIndent(lst, "", "\t")
+	return write_synced(StateDirectory+"/"+BackupRecordFile, d)
+}
+
+// the hashes of the node seeds of the trees (of the keys with the same index)
+func backup_nodes(trees []*xnyss.NYTree) (res map[string][]string) {
+	res = make(map[string][]string)
+	for i, t := range trees {
+		if t == nil {
+			continue
+		}
+		for _, h := range t.SeedHashes() {
+			res[keys[i].StateFn] = append(res[keys[i].StateFn], hex.EncodeToString(h[:]))
+		}
+	}
+	return
+}
+
+func same_nodes(a, b map[string][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for fn, hs := range a {
+		if len(hs) != len(b[fn]) {
+			return false
+		}
+		have := make(map[string]bool, len(hs))
+		for _, h := range hs {
+			have[h] = true
+		}
+		for _, h := range b[fn] {
+			if !have[h] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+
+// Records a new generation of backup trees (by the index of the key), returns its info
+func record_backup(trees []*xnyss.NYTree) (*backupInfo, error) {
+	lst, err := load_backup_record()
+	if err != nil {
+		return nil, err
+	}
+	info := &backupInfo{Generation: 1, Time: time.Now().Unix(), Network: chain_params().Name,
+		Nodes: backup_nodes(trees)}
+	for _, b := range lst {
+		if b.Generation >= info.Generation {
+			info.Generation = b.Generation + 1
+		}
+	}
+	return info, save_backup_record(append(lst, info))
+}
+
+// Marks the backup as merged or restored in the record (adding it, if it is not there)
+func retire_backup(bk *backupAudit) error {
+	lst, err := load_backup_record()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	if bk.info != nil {
+		for _, b := range lst {
+			if b.Generation == bk.info.Generation && b.Time == bk.info.Time {
+				b.Retired = now
+				return save_backup_record(lst)
+			}
+		}
+	}
+	// a backup of another device, or without a generation file
+	b := &backupInfo{Time: now, Network: chain_params().Name, Nodes: bk.hashes, Retired: now}
+	if bk.info != nil {
+		b.Generation, b.Time = bk.info.Generation, bk.info.Time
+	}
+	return save_backup_record(append(lst, b))
+}
+
+
+// Reads the backup trees of the keys from the folder and checks them against
+// the live states and the record of the backups
+func audit_backup(dir string) (bk *backupAudit, err error) {
+	bk = &backupAudit{trees: make([]*xnyss.NYTree, len(keys))}
+	var found int
+	for i, k := range keys {
+		d, er := ioutil.ReadFile(dir + "/" + k.StateFn)
+		if er != nil {
+			if !os.IsNotExist(er) {
+				return nil, er
+			}
+			continue
+		}
+		if len(d) > 0 && d[0]&0x01 != 0 {
+			return nil, errors.New("Backup of one-time keys in " + dir + "/" + k.StateFn)
+		}
+		if bk.trees[i], er = xnyss.Load(d); er != nil {
+			return nil, errors.New("Failed to load backup state " + dir + "/" + k.StateFn + " - " + er.Error())
+		}
+		found++
+	}
+	if found == 0 {
+		return nil, errors.New("There are no key states of this wallet in " + dir)
+	}
+
+	if d, er := ioutil.ReadFile(dir + "/" + BackupInfoFile); er == nil {
+		bk.info = new(backupInfo)
+		if er = json.Unmarshal(d, bk.info); er != nil {
+			return nil, errors.New(dir + "/" + BackupInfoFile + " is corrupt - " + er.Error())
+		}
+	} else if !os.IsNotExist(er) {
+		return nil, er
+	}
+	lst, err := load_backup_record()
+	if err != nil {
+		return nil, err
+	}
+	bk.hashes = backup_nodes(bk.trees)
+
+	// the nodes that the live states have, and the ones of the retired backups
+	retired := make(map[string]*backupInfo)
+	for _, b := range lst {
+		if b.Retired == 0 {
+			continue
+		}
+		for _, hs := range b.Nodes {
+			for _, h := range hs {
+				retired[h] = b
+			}
+		}
+	}
+	for i, t := range bk.trees {
+		if t == nil {
+			continue
+		}
+		addr := keys[i].BtcAddr.String()
+		hs := t.SeedHashes()
+		bk.nodes += len(hs)
+		if len(hs) > 0 && !bytes.Equal(t.PublicKey(), keys[i].Pubkey) {
+			bk.problems = append(bk.problems, "The backup state of "+addr+" belongs to another key")
+			continue
+		}
+		live := make(map[[32]byte]bool)
+		for _, h := range keys[i].TreeState.SeedHashes() {
+			live[h] = true
+		}
+		var shared int
+		var used *backupInfo
+		for _, h := range hs {
+			if live[h] {
+				shared++
+			}
+			if b := retired[hex.EncodeToString(h[:])]; b != nil {
+				used = b
+			}
+		}
+		if shared > 0 {
+			bk.problems = append(bk.problems, fmt.Sprint(shared, " node(s) of ", addr,
+				" are both in the backup and in the live state"))
+		}
+		if used != nil {
+			bk.problems = append(bk.problems, fmt.Sprint("The nodes of ", addr, " were merged or restored on ",
+				time.Unix(used.Retired, 0).Format("2006-01-02 15:04:05"), " already (generation ", used.Generation, ")"))
+		}
+	}
+
+	if bk.info == nil {
+		bk.notes = append(bk.notes, "The backup has no "+BackupInfoFile+" (made by an older wallet)")
+		return
+	}
+	if bk.info.Network != chain_params().Name {
+		bk.problems = append(bk.problems, "The backup is of another network ("+bk.info.Network+")")
+	}
+	if !same_nodes(bk.info.Nodes, bk.hashes) {
+		bk.problems = append(bk.problems, "The nodes do not match the ones of its "+BackupInfoFile+
+			" (the backup has been changed or mixed with another one)")
+	}
+	var known bool
+	for _, b := range lst {
+		if b.Generation == bk.info.Generation && b.Time == bk.info.Time {
+			known = true
+			if !same_nodes(b.Nodes, bk.info.Nodes) {
+				bk.problems = append(bk.problems, "The nodes do not match the ones recorded for its generation")
+			}
+		}
+	}
+	if !known {
+		bk.notes = append(bk.notes, "The generation is not in the record of this state folder (made on another device?)")
+	}
+	return
+}
+
+func (bk *backupAudit) print(dir string) {
+	if bk.info != nil {
+		fmt.Println("Backup", dir, "- generation", bk.info.Generation, "made",
+			time.Unix(bk.info.Time, 0).Format("2006-01-02 15:04:05"), "with", bk.nodes, "node(s)")
+	} else {
+		fmt.Println("Backup", dir, "with", bk.nodes, "node(s)")
+	}
+	for _, s := range bk.notes {
+		fmt.Println("  Note:", s)
+	}
+	for _, s := range bk.problems {
+		fmt.Println("  PROBLEM:", s)
+	}
+	if len(bk.problems) == 0 {
+		fmt.Println("  The backup shares no nodes with the live states, nor with the retired backups")
+	}
+}
+
+func (bk *backupAudit) wipe() {
+	for _, t := range bk.trees {
+		if t != nil {
+			t.Wipe()
+		}
+	}
+}
+
+
+// -auditbackup <dir>
+func audit_backups(dir string) {
+	if !longterm {
+		fmt.Println("Backups are only applicable to long-term addresses")
+		return
+	}
+	lst, err := load_backup_record()
+	if err != nil {
+		fmt.Println("ERROR:", err.Error())
+		return
+	}
+	for _, b := range lst {
+		var cnt int
+		for _, hs := range b.Nodes {
+			cnt += len(hs)
+		}
+		status := "active"
+		if b.Retired != 0 {
+			status = "retired " + time.Unix(b.Retired, 0).Format("2006-01-02 15:04:05")
+		}
+		fmt.Println("Generation", b.Generation, "made", time.Unix(b.Time, 0).Format("2006-01-02 15:04:05"),
+			"with", cnt, "node(s) -", status)
+	}
+	if len(lst) > 0 {
+		fmt.Println()
+	}
+
+	bk, err := audit_backup(dir)
+	if err != nil {
+		fmt.Println("ERROR:", err.Error())
+		return
+	}
+	bk.print(dir)
+	bk.wipe()
+}
+
+// -merge <dir>: moves the nodes of the backup back into the live states
+func merge_backup(dir string) {
+	if !longterm {
+		fmt.Println("Backups are only applicable to long-term addresses")
+		return
+	}
+	bk, err := audit_backup(dir)
+	if err != nil {
+		fmt.Println("ERROR:", err.Error())
+		return
+	}
+	defer bk.wipe()
+	merge_check(bk)
+	bk.print(dir)
+	if len(bk.problems) > 0 {
+		fmt.Println("Merge canceled")
+		return
+	}
+	if bk.nodes == 0 {
+		fmt.Println("Nothing to merge")
+		return
+	}
+	if !ask_yes_no("Merge the backup into the live key states?") {
+		fmt.Println("Merge canceled")
+		return
+	}
+
+	if err = merge_trees(bk); err != nil {
+		fmt.Println("ERROR:", err.Error())
+		return
+	}
+	fmt.Println("Merged", bk.nodes, "node(s). Delete the backup folder", dir, "- it must never be used again.")
+}
+
+// -restore <dir>: takes the backup as the states of the keys that have not signed yet
+func restore_backup(dir string) {
+	if !longterm {
+		fmt.Println("Backups are only applicable to long-term addresses")
+		return
+	}
+	bk, err := audit_backup(dir)
+	if err != nil {
+		fmt.Println("ERROR:", err.Error())
+		return
+	}
+	defer bk.wipe()
+	restore_check(bk)
+	bk.print(dir)
+	if len(bk.problems) > 0 {
+		fmt.Println("Restore canceled")
+		return
+	}
+	if !ask_yes_no("Replace the live key states with the backup?") {
+		fmt.Println("Restore canceled")
+		return
+	}
+
+	if err = restore_trees(bk); err != nil {
+		fmt.Println("ERROR:", err.Error())
+		return
+	}
+	fmt.Println("Restored", bk.nodes, "node(s). Delete the backup folder", dir, "- it must never be used again.")
+}
+
+// the keys whose live states have signed already cannot be restored
+func restore_check(bk *backupAudit) {
+	for i, t := range bk.trees {
+		if t != nil && !keys[i].TreeState.Fresh() {
+			bk.problems = append(bk.problems, "The live state of "+keys[i].BtcAddr.String()+
+				" has signed already - use -merge instead")
+		}
+	}
+}
+
+// the keys whose live states have not signed yet cannot be merged into: such a
+// state is made again from the seed, and its root node has signed already in
+// the state the backup was made of
+func merge_check(bk *backupAudit) {
+	for i, t := range bk.trees {
+		if t != nil && keys[i].TreeState.Fresh() {
+			bk.problems = append(bk.problems, "The live state of "+keys[i].BtcAddr.String()+
+				" has not signed yet - use -restore instead")
+		}
+	}
+}
+
+// Moves the nodes of the audited backup into the live states (see merge_check), and retires it
+func merge_trees(bk *backupAudit) error {
+	if len(bk.problems) > 0 {
+		return errors.New(bk.problems[0])
+	}
+	for i, t := range bk.trees {
+		if t == nil {
+			continue
+		}
+		// the audit checks the same, so it cannot fail with some of the keys merged
+		if err := keys[i].TreeState.Merge(t); err != nil {
+			return errors.New("Cannot merge the backup of " + keys[i].BtcAddr.String() + " - " + err.Error())
+		}
+	}
+	return finish_restore(bk)
+}
+
+// Replaces the live states with the audited backup (see restore_check), and retires it
+func restore_trees(bk *backupAudit) error {
+	if len(bk.problems) > 0 {
+		return errors.New(bk.problems[0])
+	}
+	for i, t := range bk.trees {
+		if t == nil {
+			continue
+		}
+		keys[i].TreeState.Wipe()
+		keys[i].TreeState = t
+		bk.trees[i] = nil
+	}
+	return finish_restore(bk)
+}
+
+// saves the live states and retires the backup
+func finish_restore(bk *backupAudit) error {
+	// Retired first: should the states not get saved, the nodes are lost, while
+	// the other way round the backup could be taken again
+	if err := retire_backup(bk); err != nil {
+		return errors.New("Failed to retire the backup in " + StateDirectory + "/" + BackupRecordFile + " - " + err.Error())
+	}
+	return save_states()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"bytes"
+	"testing"
+	"io/ioutil"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/xnyss"
+)
+
+func TestBackupMergeRestore(t *testing.T) {
+	defer func(lt bool, ks []*btc.PrivateAddr, sd, bd string) {
+		longterm, keys, StateDirectory, BackupDirectory = lt, ks, sd, bd
+	}(longterm, keys, StateDirectory, BackupDirectory)
+	dir, er := ioutil.TempDir("", "wallet")
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	defer os.RemoveAll(dir)
+	StateDirectory, BackupDirectory = dir+"/state", dir+"/backup"
+	os.Mkdir(StateDirectory, os.ModePerm)
+
+	// a key with enough confirmed nodes for a backup
+	longterm = true
+	seed := []byte{21, 22, 23, 24}
+	k := btc.NewPrivateAddr(seed, 0x80, true)
+	keys = []*btc.PrivateAddr{k}
+	for i := byte(1); k.TreeState.Available(nil) < backupMinNodes; i++ {
+		sig, er := k.TreeState.Sign(bytes.Repeat([]byte{i}, 32), bytes.Repeat([]byte{i}, 32))
+		if er != nil {
+			t.Fatal(er.Error())
+		}
+		for _, pkh := range sig.ChildHashes {
+			k.TreeState.Confirm(pkh, xnyss.ConfirmsRequired)
+		}
+	}
+	if er = write_backup(); er != nil {
+		t.Fatal(er.Error())
+	}
+	if state_files() != 1 {
+		t.Error("The backup record counted as a state file")
+	}
+
+	bk, er := audit_backup(BackupDirectory)
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	if len(bk.problems) != 0 || len(bk.notes) != 0 || bk.info == nil || bk.info.Generation != 1 || bk.nodes != backupCount {
+		t.Fatal("Bad audit of a new backup", bk.problems, bk.notes, bk.nodes)
+	}
+
+	// merged back, the backup gets retired and cannot be used again
+	avail := k.TreeState.Available(nil)
+	merge_check(bk)
+	if er = merge_trees(bk); er != nil {
+		t.Fatal(er.Error())
+	}
+	if k.TreeState.Available(nil) != avail+backupCount {
+		t.Error("Nodes not merged")
+	}
+	if bk, _ = audit_backup(BackupDirectory); len(bk.problems) != 2 {
+		t.Fatal("Merged backup not refused", bk.problems)
+	}
+	if merge_trees(bk) == nil {
+		t.Error("Merged twice")
+	}
+	lst, _ := load_backup_record()
+	if len(lst) != 1 || lst[0].Retired == 0 {
+		t.Fatal("Backup not retired in the record")
+	}
+
+	// the next backup is restored on another device, into a fresh state
+	os.RemoveAll(BackupDirectory)
+	if er = write_backup(); er != nil {
+		t.Fatal(er.Error())
+	}
+	StateDirectory = dir + "/state2"
+	os.Mkdir(StateDirectory, os.ModePerm)
+	k = btc.NewPrivateAddr(seed, 0x80, true)
+	keys = []*btc.PrivateAddr{k}
+	if bk, er = audit_backup(BackupDirectory); er != nil || len(bk.problems) != 0 || len(bk.notes) != 1 {
+		t.Fatal("Bad audit of a backup of another device", er, bk.problems, bk.notes)
+	}
+	// its root node has signed already, so it cannot take a merge
+	merge_check(bk)
+	if merge_trees(bk) == nil || !k.TreeState.Fresh() {
+		t.Fatal("Merged a backup into a fresh state")
+	}
+	bk, _ = audit_backup(BackupDirectory)
+	restore_check(bk)
+	if er = restore_trees(bk); er != nil {
+		t.Fatal(er.Error())
+	}
+	if k.TreeState.Available(nil) != backupCount {
+		t.Error("Backup not restored")
+	}
+	if lst, _ = load_backup_record(); len(lst) != 1 || lst[0].Generation != 2 || lst[0].Retired == 0 {
+		t.Error("Restored backup not recorded")
+	}
+
+	// once signed, the state can only take a backup by merging
+	bk, _ = audit_backup(BackupDirectory)
+	restore_check(bk)
+	if restore_trees(bk) == nil {
+		t.Error("Restored a backup over a used state")
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"bytes"
+	"sort"
+	"github.com/lentus/wotscoin/lib/btc"
+)
+
+
+// Each XNYSS signature takes a new node from the key's tree and advertises the
+// children of that node, which become usable once the tx gets mined. A replacement
+// cannot reuse the nodes of the original tx (a one-time key must not sign twice),
+// so it always costs one new node per signing key, while the children advertised
+// by the original will never get confirmed (they get retired when the ledger of
+// the pending txs sees the replacement mined). The replacement therefore keeps the
+// original inputs and outputs and only takes the extra fee from the change, adding
+// inputs of the keys that sign it anyway, before touching any other key.
+
+
+// the lowest fee rate that the nodes relay by default (sat/vB, TXPool.FeePerByte of the client)
+const MinRelayFeeRate = 1
+
+// the fee that a replacement must pay on top of the original one (BIP 125)
+func relay_fee(vsize int) uint64 {
+	return uint64(vsize) * MinRelayFeeRate
+}
+
+
+// load the tx to be replaced: a txid from the balance folder, or a file name
+func load_bumped_tx() (tx *btc.Tx) {
+	if txid := btc.NewUint256FromString(*bumptx); txid != nil {
+		if tx = tx_from_balance(txid, false); tx != nil {
+			tx.SetHash(tx.Serialize())
+			return
+		}
+	}
+	return raw_tx_from_file(*bumptx)
+}
+
+
+// return the input's scriptSig without the signatures
+func unsigned_script(scr []byte) []byte {
+	ms, _ := btc.NewMultiSigFromScript(scr)
+	if ms == nil {
+		return nil
+	}
+	ms.XnyssSignatures = nil
+	ms.Signatures = nil
+	return ms.Bytes()
+}
+
+
+func avail_nodes() (n int) {
+	for _, k := range keys {
+		n += k.TreeState.Available(nil)
+	}
+	return
+}
+
+
+// rebuild the transaction given by -bump at the fee given by -fee
+func bump_tx() {
+	orig := load_bumped_tx()
+	if orig == nil {
+		fmt.Println("ERROR: Cannot find transaction", *bumptx, "(give its txid from the balance/ folder or a file name)")
+		cleanExit(1)
+	}
+
+	var totin, totout uint64
+	for _, in := range orig.TxIn {
+		if in.Sequence >= 0xfffffffe {
+			fmt.Println("ERROR: The transaction has a final sequence - it cannot be replaced")
+			cleanExit(1)
+		}
+		totin += getUO(&in.Input).Value
+	}
+	for _, out := range orig.TxOut {
+		totout += out.Value
+	}
+	oldFee := totin - totout
+	oldSize := orig.VSize() // it is signed already
+
+	// the new one spends the same inputs...
+	tx := new(btc.Tx)
+	tx.Version = orig.Version
+	tx.Lock_time = orig.Lock_time
+	used := make(map[string]bool) // pkscripts of the multisigs that sign the tx
+	for _, in := range orig.TxIn {
+		scr := unsigned_script(in.ScriptSig)
+		if scr == nil {
+			fmt.Println("ERROR: Input", in.Input.String(), "does not spend a multisig")
+			cleanExit(1)
+		}
+		tx.TxIn = append(tx.TxIn, &btc.TxIn{Input: in.Input, ScriptSig: scr, Sequence: in.Sequence})
+		used[string(getUO(&in.Input).Pk_script)] = true
+	}
+
+	// ... to the same outputs
+	chg := -1
+	for i, out := range orig.TxOut {
+		tx.TxOut = append(tx.TxOut, &btc.TxOut{Value: out.Value, Pk_script: out.Pk_script})
+		if *change != "" {
+			if ad, _ := btc.NewAddrFromString(*change); ad != nil && bytes.Equal(ad.OutScript(), out.Pk_script) {
+				chg = i
+			}
+		} else {
+			for _, ms := range msAddresses {
+				if bytes.Equal(ms.PkScript(), out.Pk_script) {
+					chg = i
+				}
+			}
+		}
+	}
+
+	// With -feerate the fee follows the estimated size, as the inputs get added.
+	// The replacement must pay a higher rate than the original, and more in total
+	// by at least the relay fee of its own size (BIP 125).
+	newFee := tx_fee(tx)
+	check_fee := func() {
+		est := estimate_vsize(tx)
+		if newFee < oldFee+relay_fee(est) || newFee*uint64(oldSize) <= oldFee*uint64(est) {
+			fmt.Println("ERROR: The transaction pays", btc.UintToBtc(oldFee), "BTC for", oldSize, "bytes - the replacement needs at least",
+				btc.UintToBtc(oldFee+relay_fee(est)), "BTC for about", est, "bytes, at a higher rate. Use -fee or -feerate")
+			cleanExit(1)
+		}
+	}
+	check_fee()
+	need := func() uint64 {
+		if newFee = tx_fee(tx); newFee < oldFee {
+			check_fee() // does not return
+		}
+		return newFee - oldFee
+	}
+
+	if chg < 0 || tx.TxOut[chg].Value <= need() {
+		// Not enough change - add inputs, those of the keys that sign the tx first.
+		// Each of them takes a node, and a one-time address has no more of them than keys (see coinsel.go).
+		var added uint64
+		if chg >= 0 {
+			added = tx.TxOut[chg].Value
+		} else {
+			var pkscr []byte
+			if *change != "" {
+				pkscr = get_change_addr().OutScript()
+			} else if longterm {
+				pkscr = getUO(&tx.TxIn[0].Input).Pk_script // back to the first input
+			} else {
+				fmt.Println("ERROR: Cannot send change back to a one-time address. Add -change switch")
+				cleanExit(1)
+			}
+			tx.TxOut = append(tx.TxOut, &btc.TxOut{Pk_script: pkscr})
+			chg = len(tx.TxOut) - 1
+		}
+
+		var extra []*unspRec
+		for _, u := range unspentOuts {
+			if u.Hash != orig.Hash.Hash {
+				extra = append(extra, u)
+			}
+		}
+		groups := coin_groups(extra)
+		sort.SliceStable(groups, func(i, j int) bool {
+			ui, uj := used[string(groups[i].pkscr)], used[string(groups[j].pkscr)]
+			if ui != uj {
+				return ui
+			}
+			return groups[i].value > groups[j].value
+		})
+
+		for _, g := range groups {
+			if added > need() {
+				break
+			}
+			if !used[string(g.pkscr)] {
+				if g.nosig {
+					continue
+				}
+				fmt.Println("Adding inputs of", g.addr, "- it will take one more signature")
+				used[string(g.pkscr)] = true
+			}
+			outs, _ := g.spendable(!longterm)
+			for _, o := range outs {
+				if longterm && added > need() {
+					break // a one-time address gets spent whole
+				}
+				tin := &btc.TxIn{Input: o.u.TxPrevOut, Sequence: uint32(*sequence)}
+				for _, ms := range msAddresses {
+					if bytes.Equal(g.pkscr, ms.PkScript()) {
+						tin.ScriptSig = ms.Bytes()
+					}
+				}
+				tx.TxIn = append(tx.TxIn, tin)
+				added += o.v
+				o.u.spent = true
+			}
+		}
+		if added <= need() {
+			fmt.Println("ERROR: Not enough funds to pay", btc.UintToBtc(newFee), "BTC fee")
+			cleanExit(1)
+		}
+		tx.TxOut[chg].Value = added
+	}
+	tx.TxOut[chg].Value -= need()
+	check_fee() // the added inputs make it bigger
+	est := estimate_vsize(tx)
+
+	if *verbose {
+		fmt.Println("Old fee", btc.UintToBtc(oldFee), "BTC for", oldSize, "bytes, new fee",
+			btc.UintToBtc(newFee), "BTC for about", est, "bytes")
+	}
+
+	before := avail_nodes()
+	signed := sign_tx(tx)
+	fmt.Println("The replacement has used", before-avail_nodes(), "signature node(s)")
+	if !signed {
+		cleanExit(1)
+	}
+
+	write_tx_file(tx)
+	// the children advertised by the original get retired once the replacement is mined
+	if er := replace_pending(orig, tx); er != nil {
+		fmt.Println("WARNING:", er.Error())
+	}
+	report_size(est, tx, newFee)
+
+	if apply2bal {
+		// the outputs of the original are gone
+		for _, u := range unspentOuts {
+			if u.Hash == orig.Hash.Hash {
+				u.spent = true
+			}
+		}
+		apply_to_balance(tx)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"bytes"
+	"github.com/lentus/wotscoin/lib/btc"
+)
+
+
+// An XNYSS signature consumes a node of the signing key's tree, and every
+// input takes a signature of its own. The unspent outputs are therefore
+// selected with their addresses in mind:
+// * one-time address - each of its keys has a single node, so each of its
+//   outputs takes one more key (the first input the main one, the next ones
+//   the backup keys). Spending at an address is all-or-nothing: once it signs,
+//   all of its outputs that the keys left can sign go into the tx. The outputs
+//   beyond the keys left can not be spent.
+// * long-term address - the first input takes a confirmed node, while the next
+//   inputs of the same key sign with its children, tagged with the txid (see
+//   NYTree.getSignNode). So they cost no more confirmed nodes, only the size.
+
+const (
+	COINSEL_MINSIGS = "minsigs" // spend from as few addresses as possible (default)
+	COINSEL_MINSIZE = "minsize" // use as few inputs as possible
+	COINSEL_PRIVACY = "privacy" // spend from one address if possible, to not link the addresses together
+)
+
+type coinGroup struct {
+	pkscr []byte
+	outs  []*unspRec
+	vals  []uint64 // values of the outs
+	value uint64   // total of vals
+	addr  string
+
+	keys  int  // one-time address - the keys left to sign with, one input each
+	nosig bool // none of its keys has a node to sign with now
+	unconfirmed int // nodes waiting for confirmations (long-term)
+}
+
+
+// the multisig address and its signing keys, for the given output script
+func ms_keys(pkscr []byte) (ms *btc.MultiSig, ks []*btc.PrivateAddr) {
+	for _, m := range msAddresses {
+		if bytes.Equal(pkscr, m.PkScript()) {
+			ms = m
+			for _, pk := range m.PublicKeys {
+				if k := public_to_key(pk); k != nil {
+					ks = append(ks, k)
+				}
+			}
+			return
+		}
+	}
+	return
+}
+
+// groups the unspent outputs (not marked as spent) by their addresses
+func coin_groups(outs []*unspRec) (res []*coinGroup) {
+	idx := make(map[string]*coinGroup)
+	for _, u := range outs {
+		if u.spent {
+			continue
+		}
+		uo := getUO(&u.TxPrevOut)
+		g := idx[string(uo.Pk_script)]
+		if g == nil {
+			g = &coinGroup{pkscr: uo.Pk_script}
+			if ms, ks := ms_keys(uo.Pk_script); ms != nil {
+				g.addr = ms.AddrVer(ver_script()).String()
+				var avail int
+				for _, k := range ks {
+					avail += k.TreeState.Available(nil)
+					g.unconfirmed += len(k.TreeState.Unconfirmed())
+				}
+				g.nosig = avail == 0
+				g.keys = avail
+			} else if u.key != nil {
+				g.addr = u.key.BtcAddr.String()
+				g.keys = -1 // not an XNYSS key, so no limit
+			} else {
+				if *verbose {
+					fmt.Println("Skipping", u.TxPrevOut.String(), "- don't know how to sign it")
+				}
+				continue
+			}
+			idx[string(uo.Pk_script)] = g
+			res = append(res, g)
+		}
+		g.outs = append(g.outs, u)
+		g.vals = append(g.vals, uo.Value)
+		g.value += uo.Value
+	}
+	return
+}
+
+
+type oneOut struct {
+	u *unspRec
+	v uint64
+}
+
+// the outputs of the group that can be spent, the biggest first. In one-time
+// mode no more of them than the keys left to sign them.
+func (g *coinGroup) spendable(onetime bool) (outs []oneOut, value uint64) {
+	for i := range g.outs {
+		outs = append(outs, oneOut{u: g.outs[i], v: g.vals[i]})
+	}
+	sort.SliceStable(outs, func(i, j int) bool { return outs[i].v > outs[j].v })
+	if onetime && g.keys >= 0 && len(outs) > g.keys {
+		outs = outs[:g.keys]
+	}
+	for _, o := range outs {
+		value += o.v
+	}
+	return
+}
+
+// Returns the outputs to spend in order to get at least need, or nil if there
+// are not enough of them. In one-time mode the addresses are spent whole (as
+// far as their keys go).
+func select_coins(groups []*coinGroup, need uint64, how string, onetime bool) (sel []*unspRec) {
+	var cand []*coinGroup
+	for _, g := range groups {
+		if !g.nosig {
+			cand = append(cand, g)
+		}
+	}
+
+	var tot uint64
+	add_outs := func(outs []oneOut) {
+		for _, o := range outs {
+			if tot >= need {
+				break
+			}
+			sel = append(sel, o.u)
+			tot += o.v
+		}
+	}
+	// all the outputs of the group that it can sign
+	add_group := func(g *coinGroup) {
+		outs, v := g.spendable(onetime)
+		for _, o := range outs {
+			sel = append(sel, o.u)
+		}
+		tot += v
+	}
+	// the groups in the given order, until there is enough
+	add_groups := func(less func(a, b *coinGroup) bool) {
+		sort.SliceStable(cand, func(i, j int) bool { return less(cand[i], cand[j]) })
+		for _, g := range cand {
+			if tot >= need {
+				break
+			}
+			add_group(g)
+		}
+	}
+	// the biggest outputs first, whatever address they are at
+	add_biggest := func() {
+		var all []oneOut
+		for _, g := range cand {
+			outs, _ := g.spendable(onetime)
+			all = append(all, outs...)
+		}
+		sort.SliceStable(all, func(i, j int) bool { return all[i].v > all[j].v })
+		add_outs(all)
+	}
+
+	switch how {
+	case COINSEL_PRIVACY:
+		// the smallest single address that covers the amount
+		var best *coinGroup
+		var bestv uint64
+		for _, g := range cand {
+			if _, v := g.spendable(onetime); v >= need && (best == nil || v < bestv) {
+				best, bestv = g, v
+			}
+		}
+		if best == nil {
+			return select_coins(groups, need, COINSEL_MINSIGS, onetime)
+		}
+		if onetime {
+			add_group(best)
+			break
+		}
+		outs, _ := best.spendable(onetime)
+		add_outs(outs)
+
+	case COINSEL_MINSIZE:
+		if onetime {
+			// the addresses with the biggest outputs on average first
+			add_groups(func(a, b *coinGroup) bool {
+				oa, va := a.spendable(true)
+				ob, vb := b.spendable(true)
+				return va*uint64(len(ob)) > vb*uint64(len(oa))
+			})
+			break
+		}
+		add_biggest()
+
+	default: // COINSEL_MINSIGS
+		if onetime {
+			add_groups(func(a, b *coinGroup) bool {
+				_, va := a.spendable(true)
+				_, vb := b.spendable(true)
+				return va > vb
+			})
+			break
+		}
+		sort.SliceStable(cand, func(i, j int) bool { return cand[i].value > cand[j].value })
+		for _, g := range cand {
+			if tot >= need {
+				break
+			}
+			outs, v := g.spendable(onetime)
+			if tot+v < need {
+				sel = append(sel, g.outs...)
+				tot += v
+				continue
+			}
+			// the last address - only as many of its outputs as needed
+			add_outs(outs)
+		}
+	}
+	if tot < need {
+		return nil
+	}
+	return
+}
+
+func valid_coinsel(how string) bool {
+	return how == COINSEL_MINSIGS || how == COINSEL_MINSIZE || how == COINSEL_PRIVACY
+}
+
+
+// print warnings about the coins that cannot be spent (for -keystate)
+func warn_stranded() {
+	for _, g := range coin_groups(unspentOuts) {
+		if !longterm && g.keys >= 0 && len(g.outs) > g.keys {
+			_, v := g.spendable(true)
+			fmt.Printf("WARNING: %s BTC in %d output(s) at %s is stranded - there are no one-time keys left to sign them\n",
+				btc.UintToBtc(g.value-v), len(g.outs)-g.keys, g.addr)
+		} else if g.nosig && g.unconfirmed == 0 {
+			fmt.Printf("WARNING: %s BTC in %d output(s) at %s has no signature nodes left\n",
+				btc.UintToBtc(g.value), len(g.outs), g.addr)
+		} else if g.nosig {
+			fmt.Printf("%s BTC at %s waits for %d unconfirmed node(s) before it can be spent\n",
+				btc.UintToBtc(g.value), g.addr, g.unconfirmed)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"io/ioutil"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/xnyss"
+)
+
+// makes a group of outputs with the given values (the vouts count from first),
+// with as many one-time keys as outputs
+func test_group(first uint32, vals ...uint64) (g *coinGroup) {
+	g = &coinGroup{keys: len(vals)}
+	for i, v := range vals {
+		u := new(unspRec)
+		u.Vout = first + uint32(i)
+		g.outs = append(g.outs, u)
+		g.vals = append(g.vals, v)
+		g.value += v
+	}
+	return
+}
+
+func sel_vouts(sel []*unspRec) (res []uint32) {
+	for _, u := range sel {
+		res = append(res, u.Vout)
+	}
+	return
+}
+
+func check_sel(t *testing.T, name string, sel []*unspRec, exp ...uint32) {
+	res := sel_vouts(sel)
+	if len(res) != len(exp) {
+		t.Error(name, "- selected", res, "instead of", exp)
+		return
+	}
+	for i := range res {
+		if res[i] != exp[i] {
+			t.Error(name, "- selected", res, "instead of", exp)
+			return
+		}
+	}
+}
+
+func TestSelectCoins(t *testing.T) {
+	a := test_group(0, 10, 50, 10)   // 70 in 3 outputs
+	b := test_group(10, 60)          // 60 in 1 output
+	c := test_group(20, 30, 30, 30)  // 90 in 3 outputs
+	groups := []*coinGroup{a, b, c}
+
+	// one-time addresses are spent whole: the biggest first, or the biggest outputs on average
+	check_sel(t, "minsigs onetime", select_coins(groups, 80, COINSEL_MINSIGS, true), 20, 21, 22)
+	check_sel(t, "minsize onetime", select_coins(groups, 140, COINSEL_MINSIZE, true), 10, 20, 21, 22)
+	check_sel(t, "privacy onetime", select_coins(groups, 65, COINSEL_PRIVACY, true), 1, 0, 2)
+
+	// long-term ones only as much of the last address as needed
+	check_sel(t, "minsigs longterm", select_coins(groups, 100, COINSEL_MINSIGS, false), 20, 21, 22, 1)
+	check_sel(t, "minsize longterm", select_coins(groups, 100, COINSEL_MINSIZE, false), 10, 1)
+	check_sel(t, "privacy longterm", select_coins(groups, 55, COINSEL_PRIVACY, false), 10)
+
+	// privacy falls back to minsigs when no address has enough
+	check_sel(t, "privacy fallback", select_coins(groups, 150, COINSEL_PRIVACY, true), 20, 21, 22, 1, 0, 2)
+
+	if select_coins(groups, 221, COINSEL_MINSIGS, true) != nil {
+		t.Error("Selected more than there is")
+	}
+
+	// no more one-time outputs than keys left, none of the addresses that cannot sign
+	c.keys = 1
+	b.nosig = true
+	check_sel(t, "keys left", select_coins(groups, 90, COINSEL_MINSIGS, true), 1, 0, 2, 20)
+	check_sel(t, "privacy keys left", select_coins(groups, 65, COINSEL_PRIVACY, true), 1, 0, 2)
+	if select_coins(groups, 101, COINSEL_MINSIZE, true) != nil {
+		t.Error("Selected the outputs that cannot be signed")
+	}
+	check_sel(t, "keys left longterm", select_coins(groups, 160, COINSEL_MINSIGS, false), 20, 21, 22, 1, 0, 2)
+}
+
+// The selected outputs of one address are signed with one key (node) each in
+// one-time mode, and with a single confirmed node in long-term mode.
+func TestSignSelected(t *testing.T) {
+	defer func(lt bool, ks []*btc.PrivateAddr, ms []*btc.MultiSig, uo []*unspRec, sd, jf string) {
+		longterm, keys, msAddresses, unspentOuts, StateDirectory, JournalFile, journalUsed = lt, ks, ms, uo, sd, jf, nil
+	}(longterm, keys, msAddresses, unspentOuts, StateDirectory, JournalFile)
+	dir, er := ioutil.TempDir("", "wallet")
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	defer os.RemoveAll(dir)
+	defer os.Remove(dir + ".journal")
+	StateDirectory, JournalFile, journalUsed = dir, dir+".journal", nil
+
+	// funds the address with four outputs of 1000 each
+	fund := func(seed byte, lt bool, nkeys int) *btc.MultiSig {
+		longterm = lt
+		keys = nil
+		ms := btc.NewXNYSSMultiSig()
+		for i := 0; i < nkeys; i++ {
+			k := btc.NewPrivateAddr([]byte{seed, byte(i), 1, 2}, 0x80, lt)
+			keys = append(keys, k)
+			ms.PublicKeys = append(ms.PublicKeys, k.BtcAddr.Hash160[:])
+		}
+		msAddresses = []*btc.MultiSig{ms}
+		ftx := &btc.Tx{Version: 1, TxIn: []*btc.TxIn{&btc.TxIn{}}}
+		for i := 0; i < 4; i++ {
+			ftx.TxOut = append(ftx.TxOut, &btc.TxOut{Value: 1000, Pk_script: ms.PkScript()})
+		}
+		ftx.SetHash(ftx.Serialize())
+		loadedTxs[ftx.Hash.Hash] = ftx
+		unspentOuts = nil
+		for i := range ftx.TxOut {
+			u := new(unspRec)
+			u.Hash, u.Vout = ftx.Hash.Hash, uint32(i)
+			unspentOuts = append(unspentOuts, u)
+		}
+		return ms
+	}
+	spend := func(ms *btc.MultiSig, sel []*unspRec) *btc.Tx {
+		tx := &btc.Tx{Version: 1}
+		for _, u := range sel {
+			tx.TxIn = append(tx.TxIn, &btc.TxIn{Input: u.TxPrevOut, ScriptSig: ms.Bytes()})
+		}
+		tx.TxOut = []*btc.TxOut{&btc.TxOut{Value: 100, Pk_script: []byte{0x6a}}}
+		if !sign_tx(tx) {
+			t.Fatal("Not signed", longterm)
+		}
+		for i, in := range tx.TxIn {
+			if s, _ := btc.NewMultiSigFromScript(in.ScriptSig); s == nil || len(s.XnyssSignatures) != 1 {
+				t.Fatal("Input", i, "not signed", longterm)
+			}
+		}
+		return tx
+	}
+
+	// one-time - one output per key, the fourth one cannot be spent
+	ms := fund(40, false, 3)
+	if select_coins(coin_groups(unspentOuts), 3001, COINSEL_MINSIGS, true) != nil {
+		t.Error("Selected more outputs than keys")
+	}
+	// the address gets swept, even if one output would do
+	sel := select_coins(coin_groups(unspentOuts), 1000, COINSEL_MINSIGS, true)
+	if len(sel) != 3 {
+		t.Fatal("Selected", len(sel), "outputs")
+	}
+	spend(ms, sel)
+	for i, k := range keys {
+		if k.TreeState.Available(nil) != 0 {
+			t.Error("One-time key", i, "not used")
+		}
+	}
+
+	// long-term - the next inputs sign with the children of the first node
+	ms = fund(50, true, 1)
+	sel = select_coins(coin_groups(unspentOuts), 4000, COINSEL_MINSIGS, false)
+	if len(sel) != 4 {
+		t.Fatal("Selected", len(sel), "outputs")
+	}
+	spend(ms, sel)
+	if n := len(keys[0].TreeState.Unconfirmed()); n != 4*xnyss.Branches-3 {
+		t.Error("Got", n, "unconfirmed nodes")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/xnyss"
+)
+
+
+// An XNYSS multisig input carries a W-OTS+ signature (xnyss.SigLen bytes),
+// its public seed, the hashes of the child keys it advertises (long-term keys
+// only) and the redeem script with the hashes of all the keys. It is not a
+// witness, so it gets no discount - a few of these make the size of a tx.
+// The sizes are estimated by putting zero filled signatures of the right
+// length into a copy of the tx, before signing it for real.
+
+
+// the scriptSig of the multisig once signed
+func signed_script(ms *btc.MultiSig) []byte {
+	if longterm {
+		return ms.XnyssSignedScript(xnyss.Branches)
+	}
+	return ms.XnyssSignedScript(0)
+}
+
+
+// the virtual size of the tx once all its inputs get signed
+func estimate_vsize(tx *btc.Tx) int {
+	ecdsa_sig := make([]byte, 72)
+	tmp := new(btc.Tx)
+	tmp.Version = tx.Version
+	tmp.Lock_time = tx.Lock_time
+	tmp.TxOut = tx.TxOut
+	var witness bool
+	for i, in := range tx.TxIn {
+		tin := &btc.TxIn{Input: in.Input, ScriptSig: in.ScriptSig, Sequence: in.Sequence}
+		var wit [][]byte
+		if ms, _ := btc.NewMultiSigFromScript(in.ScriptSig); ms != nil {
+			tin.ScriptSig = signed_script(ms)
+		} else if uo := getUO(&in.Input); uo != nil {
+			if ver, prog := btc.IsWitnessProgram(uo.Pk_script); prog != nil && ver == 0 && len(prog) == 20 {
+				wit = [][]byte{ecdsa_sig, make([]byte, 33)} // P2WPKH
+			} else if btc.IsP2SH(uo.Pk_script) {
+				tin.ScriptSig = make([]byte, 23) // P2SH-P2WPKH
+				wit = [][]byte{ecdsa_sig, make([]byte, 33)}
+			} else {
+				tin.ScriptSig = make([]byte, 1+72+1+33) // P2PKH
+			}
+		}
+		tmp.TxIn = append(tmp.TxIn, tin)
+		if wit != nil {
+			if tmp.SegWit == nil {
+				tmp.SegWit = make([][][]byte, len(tx.TxIn))
+			}
+			tmp.SegWit[i] = wit
+			witness = true
+		}
+	}
+	if witness {
+		tmp.SetHash(tmp.SerializeNew())
+	} else {
+		tmp.SetHash(tmp.Serialize())
+	}
+	return tmp.VSize()
+}
+
+
+// the fee to pay for the given virtual size
+func rate_fee(vsize int) uint64 {
+	return uint64(math.Ceil(feerate * float64(vsize)))
+}
+
+// the fee for the tx: by -feerate if given, otherwise the fixed one
+func tx_fee(tx *btc.Tx) uint64 {
+	if feerate > 0 {
+		return rate_fee(estimate_vsize(tx))
+	}
+	return curFee
+}
+
+
+// print the estimated and the actual size of the signed tx, with the fee rates
+func report_size(est int, tx *btc.Tx, fee uint64) {
+	act := tx.VSize()
+	fmt.Printf("Size estimated %d vB, signed %d vB. Fee %s BTC (%.2f sat/vB)\n",
+		est, act, btc.UintToBtc(fee), float64(fee)/float64(act))
+	if act > est && feerate > 0 {
+		fmt.Println("WARNING: The signed tx is bigger than estimated - it pays less than", feerate, "sat/vB")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/xnyss"
+)
+
+func TestEstimateVSize(t *testing.T) {
+	defer func(lt bool) { longterm = lt }(longterm)
+	for _, longterm = range []bool{false, true} {
+		var ks []*btc.PrivateAddr
+		ms := btc.NewXNYSSMultiSig()
+		for i := 0; i < 3; i++ {
+			k := btc.NewPrivateAddr([]byte{byte(i), 1, 2, 3}, 0x80, longterm)
+			ks = append(ks, k)
+			ms.PublicKeys = append(ms.PublicKeys, k.Hash160[:])
+		}
+
+		tx := new(btc.Tx)
+		tx.Version = 1
+		for i := 0; i < 2; i++ {
+			tx.TxIn = append(tx.TxIn, &btc.TxIn{Input: btc.TxPrevOut{Vout: uint32(i)}, ScriptSig: ms.Bytes()})
+		}
+		tx.TxOut = append(tx.TxOut, &btc.TxOut{Value: 1e8, Pk_script: ms.PkScript()})
+		est := estimate_vsize(tx)
+
+		// sign it the way sign_tx does
+		tx.SetHash(tx.Serialize())
+		for i := range tx.TxIn {
+			sms := *ms
+			sig, er := ks[i].TreeState.Sign(tx.SignatureHash(ms.P2SH(), i, btc.SIGHASH_ALL), tx.Hash.Bytes())
+			if er != nil {
+				t.Fatal(er.Error())
+			}
+			sms.XnyssSignatures = []*xnyss.Signature{sig}
+			tx.TxIn[i].ScriptSig = sms.Bytes()
+		}
+		tx.SetHash(tx.Serialize())
+
+		if est != tx.VSize() {
+			t.Error("longterm", longterm, "- estimated", est, "instead of", tx.VSize())
+		}
+		if est < 2*xnyss.SigLen {
+			t.Error("The estimate does not include the signatures", est)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"errors"
+	"io/ioutil"
+	"encoding/hex"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/xnyss"
+)
+
+// Each W-OTS+ node must only sign once, but a key state restored from an older
+// backup (or a copied state folder) would sign with its nodes again. So each
+// signature is recorded in an append-only journal (see lib/btc/sign_journal.go)
+// before it leaves the wallet, and the nodes found in the journal are refused.
+// The client's "journal" command looks for the on-chain signatures of our
+// addresses that are missing from it.
+
+var JournalFile = "journal.txt" // next to the state folder, not in it, so it does not get copied with it
+
+var (
+	journalUsed map[[32]byte]bool // the nodes that have signed (nil until the journal is read)
+	journalLast [32]byte // the hash of the last line
+)
+
+
+// reads the journal (once), checking its chain of hashes
+func open_journal() error {
+	if journalUsed != nil {
+		return nil
+	}
+	d, er := ioutil.ReadFile(JournalFile)
+	if er != nil && !os.IsNotExist(er) {
+		return er
+	}
+	ents, er := btc.ParseJournal(d)
+	if er != nil {
+		return errors.New(JournalFile + ": " + er.Error())
+	}
+	journalUsed = make(map[[32]byte]bool, len(ents))
+	journalLast = [32]byte{} // a new journal starts the chain over
+	for _, e := range ents {
+		journalUsed[e.Node] = true
+		journalLast = e.Hash
+	}
+	return nil
+}
+
+// appends the entry to the journal, making sure it is on the disk
+func journal_append(e *btc.JournalEntry) error {
+	f, er := os.OpenFile(JournalFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if er != nil {
+		return er
+	}
+	line := e.Line(journalLast)
+	if st, _ := f.Stat(); st != nil && st.Size() == 0 {
+		line = btc.SIGN_JOURNAL_HEADER + line
+	}
+	if _, er = f.WriteString(line); er == nil {
+		er = f.Sync()
+	}
+	f.Close()
+	if er != nil {
+		return er
+	}
+	journalLast = e.Hash
+	journalUsed[e.Node] = true
+	return nil
+}
+
+
+// Signs the message with the key, recording the signature in the journal before
+// it is let out. A node that the journal has seen signing already is refused,
+// and removed from the key's state. A signature that cannot be journaled is not
+// returned, while its node is gone from the state anyway.
+func journal_sign(k *btc.PrivateAddr, addr string, msg, txid []byte) (*xnyss.Signature, error) {
+	if er := open_journal(); er != nil {
+		return nil, er
+	}
+	pkh, er := k.TreeState.SignNode(txid)
+	if er != nil {
+		return nil, er
+	}
+
+	e := &btc.JournalEntry{Time: time.Now().Unix(), Address: addr, Message: msg}
+	copy(e.Node[:], pkh)
+	copy(e.TxID.Hash[:], txid)
+	if journalUsed[e.Node] {
+		k.TreeState.Remove(pkh)
+		return nil, fmt.Errorf("node %s of %s has already signed according to %s - the key state must have "+
+			"been restored or copied. The node got removed from it, check the state before signing again",
+			hex.EncodeToString(pkh), addr, JournalFile)
+	}
+	sig, er := k.TreeState.Sign(msg, txid)
+	if er != nil {
+		return nil, er
+	}
+	if er = journal_append(e); er != nil {
+		return nil, errors.New("cannot write " + JournalFile + " - " + er.Error())
+	}
+	return sig, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"bytes"
+	"strings"
+	"testing"
+	"io/ioutil"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/xnyss"
+)
+
+func TestJournalSign(t *testing.T) {
+	defer func(jf string) { JournalFile, journalUsed = jf, nil }(JournalFile)
+	dir, er := ioutil.TempDir("", "wallet")
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	defer os.RemoveAll(dir)
+	JournalFile, journalUsed = dir+"/journal.txt", nil
+
+	k := btc.NewPrivateAddr([]byte{17, 18, 19, 20}, 0x80, true)
+	copied, _ := xnyss.Load(k.TreeState.Bytes()) // e.g. a copy of the state folder
+	msg := bytes.Repeat([]byte{7}, 32)
+	if _, er = journal_sign(k, "3Addr", msg, bytes.Repeat([]byte{1}, 32)); er != nil {
+		t.Fatal(er.Error())
+	}
+
+	// the journal survives a restart
+	journalUsed = nil
+	d, _ := ioutil.ReadFile(JournalFile)
+	ents, er := btc.ParseJournal(d)
+	if er != nil || len(ents) != 1 || ents[0].Address != "3Addr" || !bytes.Equal(ents[0].Message, msg) {
+		t.Fatal("Bad journal", len(ents), er)
+	}
+
+	// the copy cannot sign with the same node again
+	k.TreeState = copied
+	if _, er = journal_sign(k, "3Addr", msg, bytes.Repeat([]byte{2}, 32)); er == nil {
+		t.Fatal("Signed twice with one node")
+	}
+	if k.TreeState.Available(nil) != 0 {
+		t.Error("The used node is still in the state")
+	}
+
+	// a signature that cannot be made is not journaled
+	k = btc.NewPrivateAddr([]byte{21, 22, 23, 24}, 0x80, true)
+	if _, er = journal_sign(k, "3Addr2", make([]byte, 33), bytes.Repeat([]byte{3}, 32)); er == nil {
+		t.Fatal("Signed a too long message")
+	}
+	if d2, _ := ioutil.ReadFile(JournalFile); !bytes.Equal(d2, d) {
+		t.Error("Journaled a failed signature")
+	}
+
+	// a journal with its first line removed
+	if _, er = journal_sign(k, "3Addr2", msg, bytes.Repeat([]byte{3}, 32)); er != nil {
+		t.Fatal(er.Error())
+	}
+	d, _ = ioutil.ReadFile(JournalFile)
+	lines := strings.SplitAfter(string(d), "\n")
+	if len(lines) != 4 || lines[0] != btc.SIGN_JOURNAL_HEADER {
+		t.Fatal("Bad journal", lines)
+	}
+	ioutil.WriteFile(JournalFile, []byte(lines[0]+lines[2]), 0600)
+	journalUsed = nil
+	if open_journal() == nil {
+		t.Error("Broken journal not detected")
+	}
+}
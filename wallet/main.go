@@ -7,7 +7,7 @@ import (
 	"github.com/lentus/wotscoin/lib/btc"
 	"github.com/lentus/wotscoin"
 	"github.com/lentus/wotscoin/lib/others/sys"
-	"io/ioutil"
+	"github.com/lentus/wotscoin/lib/xnyss"
 )
 
 var (
@@ -61,6 +61,7 @@ var (
 	allowextramsigns *bool   = flag.Bool("xtramsigs", false, "Allow to put more signatures than needed (for multisig txs)")
 
 	sequence *int = flag.Int("seq", 0, "Use given RBF sequence number (-1 or -2 for final)")
+	bumptx   *string = flag.String("bump", "", "Replace the given transaction (txid from balance/ or file name) with one paying the fee given by -fee")
 
 	segwit_mode *bool = flag.Bool("segwit", false, "List SegWit deposit addresses (instead of P2KH)")
 	bech32_mode *bool = flag.Bool("bech32", false, "use with -segwit to see P2WPKH deposit addresses (instead of P2SH-WPKH)")
@@ -68,10 +69,23 @@ var (
 	// XNYSS confirmation options
 	writePkhs   *bool   = flag.Bool("unconfirmed", false, "Write unconfirmed pubkey hashes to a file")
 	confirmPkhs *string = flag.String("confirm", "", "Process key confirmations in the given file (use as -confirm <filename>)")
+	syncPkhs    *bool   = flag.Bool("sync", false, "Get the key confirmations from the client's RPC (see -rpc)")
+
+	// Signed transactions that have not been mined yet
+	listPending *bool   = flag.Bool("pending", false, "List the signed transactions that have not been mined yet")
+	rebroadTx   *string = flag.String("rebroadcast", "", "Broadcast again the given pending transaction (txid), sending it to -rpc or storing it in a file")
 
 	// Print XNYSS tree state for all keys
 	keyState *bool = flag.Bool("keystate", false, "Print XNYSS key state")
 	backup   *bool = flag.Bool("backup", false, "Create backup of XNYSS key state")
+
+	// Using the backups
+	restoreDir *string = flag.String("restore", "", "Take the backup in the given folder as the key state of the addresses that have not signed yet")
+	mergeDir   *string = flag.String("merge", "", "Move the nodes of the backup in the given folder back into the key state")
+	auditDir   *string = flag.String("auditbackup", "", "Check that the backup in the given folder shares no nodes with the key state")
+
+	// Signing from several devices
+	shardCnt *int = flag.Int("shard", 0, "Split the confirmed nodes of the key state into this many shards, one per device")
 )
 
 // exit after cleaning up private data from memory
@@ -82,8 +96,7 @@ func cleanExit(code int) {
 	for k := range keys {
 		sys.ClearBuffer(keys[k].Key)
 		// Save tree state to file
-		err := ioutil.WriteFile(StateDirectory+"/"+keys[k].StateFn,
-			keys[k].TreeState.Bytes(), 0666)
+		err := save_state(keys[k])
 		if err != nil {
 			fmt.Println("Error: Failed to write key state to file for key", k, ",", err)
 		}
@@ -109,6 +122,13 @@ func main() {
 
 	flag.Parse()
 
+	if chain_params() == nil {
+		println("Unknown network", network)
+		os.Exit(1)
+	}
+	xnyss.Branches = chain_params().XNYSSBranches
+	xnyss.ConfirmsRequired = chain_params().XNYSSConfirmsRequired
+
 	if uncompressed {
 		println("For SegWit address safety, uncompressed keys are disabled in this version")
 		os.Exit(1)
@@ -130,12 +150,43 @@ func main() {
 		curFee = val
 	}
 
+	if feerate < 0 {
+		println("Incorrect fee rate", feerate)
+		os.Exit(1)
+	}
+
+	if val, e := btc.StringToSatoshis(spendlimit); e != nil {
+		println("Incorrect spend limit", spendlimit)
+		os.Exit(1)
+	} else {
+		spendLimit = val
+	}
+
+	if !valid_coinsel(coinsel) {
+		println("Unknown coin selection strategy", coinsel)
+		os.Exit(1)
+	}
+
+	if srvaddr != "" {
+		run_server()
+	}
+
 	// decode raw transaction?
 	if *dumptxfn != "" {
 		dump_raw_tx()
 		return
 	}
 
+	// the ledger of the pending transactions does not need the keys
+	if *listPending {
+		list_pending()
+		return
+	}
+	if *rebroadTx != "" {
+		rebroadcast(*rebroadTx)
+		return
+	}
+
 	// dump public key or secret scan key?
 	if *pubkey != "" {
 		make_wallet()
@@ -161,9 +212,19 @@ func main() {
 		cleanExit(0)
 	}
 
+	if *syncPkhs {
+		make_wallet()
+		sync_with_client()
+		cleanExit(0)
+	}
+
 	if *keyState {
 		make_wallet()
+		auto_sync()
 		printKeyState()
+		if load_balance() == nil {
+			warn_stranded()
+		}
 		cleanExit(0)
 	}
 
@@ -173,6 +234,30 @@ func main() {
 		cleanExit(0)
 	}
 
+	if *shardCnt != 0 {
+		make_wallet()
+		make_shards(*shardCnt)
+		cleanExit(0)
+	}
+
+	if *auditDir != "" {
+		make_wallet()
+		audit_backups(*auditDir)
+		cleanExit(0)
+	}
+
+	if *mergeDir != "" {
+		make_wallet()
+		merge_backup(*mergeDir)
+		cleanExit(0)
+	}
+
+	if *restoreDir != "" {
+		make_wallet()
+		restore_backup(*restoreDir)
+		cleanExit(0)
+	}
+
 	// dump privete key?
 	if *dumppriv != "" {
 		make_wallet()
@@ -214,11 +299,19 @@ func main() {
 
 	// make the wallet nad print balance
 	make_wallet()
+	if *bumptx != "" || *send != "" || *batch != "" {
+		auto_sync()
+	}
 	if e := load_balance(); e != nil {
 		fmt.Println("ERROR:", e.Error())
 		cleanExit(1)
 	}
 
+	if *bumptx != "" {
+		bump_tx()
+		cleanExit(0)
+	}
+
 	// send command?
 	if send_request() {
 		make_signed_tx()
@@ -32,7 +32,7 @@ func make_p2sh() {
 		return
 	}
 
-	fmt.Println("The P2SH data points to address", ms.BtcAddr(testnet).String())
+	fmt.Println("The P2SH data points to address", ms.AddrVer(ver_script()).String())
 
 	sd := ms.Bytes()
 
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"strings"
+	"io/ioutil"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/lentus/wotscoin/lib/btc"
+)
+
+// A signature of a long-term key uses up its node at once, and the new nodes
+// that it advertises can only get confirmed when the transaction gets mined.
+// If it never does (dropped from the mempools, double spent or paying too
+// little), the nodes are stranded. The ledger of such transactions is kept in
+// the state folder, with the signed transaction, so it can be broadcast again
+// as it is (signing it again would use up another node).
+
+const (
+	PendingFile = "pending.json" // in StateDirectory
+
+	pendingStaleBlocks = 6 // not mined in that many blocks since it was first seen pending
+)
+
+type pendingTx struct {
+	TxID     string   `json:"txid"`
+	Time     int64    `json:"time"`           // when it was signed
+	Nodes    []string `json:"nodes"`          // public key hashes of the nodes used by its signatures
+	Children []string `json:"children"`       // the nodes it advertises, not confirmable until it is mined
+	Seen     uint32   `json:"seen,omitempty"` // the client's block height when it was first seen not mined
+	Raw      string   `json:"raw"`            // the signed transaction (hex)
+	Replaces []string `json:"replaces,omitempty"` // txids of the ones it has replaced (see -bump)
+}
+
+
+func load_pending() (lst []*pendingTx, err error) {
+	d, err := ioutil.ReadFile(StateDirectory + "/" + PendingFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	if err = json.Unmarshal(d, &lst); err != nil {
+		err = fmt.Errorf("%s/%s is corrupt - %s", StateDirectory, PendingFile, err.Error())
+	}
+	return
+}
+
+func save_pending(lst []*pendingTx) error {
+	if len(lst) == 0 {
+		if err := os.Remove(StateDirectory + "/" + PendingFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	d, _ := json.MarshalIndent(lst, "", "\t")
+	return write_synced(StateDirectory+"/"+PendingFile, d)
+}
+
+
+// Returns the ledger entry of the signed transaction (nil if it is not fully
+// signed, or none of its signatures advertise new nodes)
+func new_pending(tx *btc.Tx, raw []byte) (p *pendingTx) {
+	p = &pendingTx{TxID: tx.Hash.String(), Time: time.Now().Unix(), Raw: hex.EncodeToString(raw)}
+	used := make(map[string]bool)
+	for i := range tx.TxIn {
+		sig := tx.XnyssSignature(i)
+		if sig == nil {
+			return nil // the wallet only spends XNYSS multisigs, so the signing is incomplete
+		}
+		if len(sig.ChildHashes) == 0 {
+			continue // a one-time key, with no nodes to wait for
+		}
+		if pub, er := sig.PublicKey(); er == nil {
+			pkh := sha256.Sum256(pub)
+			if h := hex.EncodeToString(pkh[:]); !used[h] {
+				used[h] = true
+				p.Nodes = append(p.Nodes, h)
+			}
+		}
+		for _, ch := range sig.ChildHashes {
+			p.Children = append(p.Children, hex.EncodeToString(ch))
+		}
+	}
+	if len(p.Nodes) == 0 {
+		return nil
+	}
+
+	// the next inputs of a key sign with the children of its first node (tagged
+	// with the txid), so these are gone rather than waiting for confirmations
+	children := p.Children[:0]
+	for _, h := range p.Children {
+		if !used[h] {
+			children = append(children, h)
+		}
+	}
+	p.Children = children
+	return
+}
+
+// Records the signed transaction in the ledger (see new_pending for the ones it skips)
+func add_pending(tx *btc.Tx, raw []byte) error {
+	p := new_pending(tx, raw)
+	if p == nil {
+		return nil
+	}
+
+	lst, err := load_pending()
+	if err != nil {
+		return err
+	}
+	for i := range lst {
+		if lst[i].TxID == p.TxID {
+			lst = append(lst[:i], lst[i+1:]...)
+			break
+		}
+	}
+	return save_pending(append(lst, p))
+}
+
+// Records that the (already recorded) replacement has replaced orig. Whichever
+// of them gets mined, the nodes advertised by the others never get confirmed,
+// so they get retired then (see check_pending).
+func replace_pending(orig, replacement *btc.Tx) error {
+	lst, err := load_pending()
+	if err != nil {
+		return err
+	}
+	o, r := find_pending(lst, orig.Hash.String()), find_pending(lst, replacement.Hash.String())
+	if r == nil {
+		return nil
+	}
+	if o == nil {
+		// signed elsewhere, or before the ledger was kept
+		if o = new_pending(orig, orig.Serialize()); o == nil {
+			return nil
+		}
+		lst = append(lst, o)
+	}
+	r.Replaces = append([]string{o.TxID}, o.Replaces...)
+	return save_pending(lst)
+}
+
+// Whether one of the transactions has replaced the other one
+func (p *pendingTx) conflicts(q *pendingTx) bool {
+	for _, id := range p.Replaces {
+		if id == q.TxID {
+			return true
+		}
+	}
+	for _, id := range q.Replaces {
+		if id == p.TxID {
+			return true
+		}
+	}
+	return false
+}
+
+// Retires the nodes advertised by the transaction. Returns how many there were.
+func (p *pendingTx) retire() (cnt int) {
+	for _, h := range p.Children {
+		pkh, _ := hex.DecodeString(h)
+		for _, k := range keys {
+			if k.TreeState.Retire(pkh) {
+				cnt++
+				break
+			}
+		}
+	}
+	return
+}
+
+
+// Checks the ledger against the client's answers about the nodes, made at the
+// given block height. The transactions whose new nodes got confirmed are mined,
+// so they leave the ledger, together with the ones replaced by them or replacing
+// them, whose new nodes get retired. Returns the ones that were not mined for
+// pendingStaleBlocks, which are likely to be abandoned.
+func check_pending(recs []*btc.UpkhAnswer, height uint32) (stale []*pendingTx, err error) {
+	lst, err := load_pending()
+	if err != nil || len(lst) == 0 {
+		return
+	}
+	depths := make(map[string]uint32, len(recs))
+	for _, r := range recs {
+		depths[hex.EncodeToString(r.PubKeyHash[:])] = r.Depth
+	}
+
+	var changed bool
+	var mined []*pendingTx
+	left := lst[:0]
+	for _, p := range lst {
+		var ok bool
+		for _, h := range p.Children {
+			ok = ok || depths[h] > 0
+		}
+		if ok {
+			fmt.Println("Transaction", p.TxID, "got mined")
+			mined = append(mined, p)
+			changed = true
+		} else {
+			left = append(left, p)
+		}
+	}
+
+	lst, left = left, lst[:0]
+	for _, p := range lst {
+		var replaced bool
+		for _, m := range mined {
+			if m.conflicts(p) {
+				n := p.retire()
+				fmt.Println("Transaction", p.TxID, "got replaced by", m.TxID, "- retired its", n, "new node(s)")
+				replaced = true
+				changed = true
+				break
+			}
+		}
+		if replaced {
+			continue
+		}
+
+		answered := len(p.Children) > 0
+		for _, h := range p.Children {
+			_, ok := depths[h]
+			answered = answered && ok
+		}
+		left = append(left, p)
+		if !answered || height == 0 {
+			continue // older file formats do not list the nodes not found
+		}
+		if p.Seen == 0 || p.Seen > height {
+			p.Seen = height
+			changed = true
+		} else if height >= p.Seen+pendingStaleBlocks {
+			stale = append(stale, p)
+		}
+	}
+	if changed {
+		err = save_pending(left)
+	}
+	for _, p := range stale {
+		fmt.Println("WARNING: Transaction", p.TxID, "has not been mined in", height-p.Seen, "blocks.")
+		fmt.Println(" Its", len(p.Children), "new node(s) cannot get confirmed before it is, and the node(s) it used are gone.")
+		fmt.Println(" Broadcast the same signed transaction again with -rebroadcast", p.TxID[:16])
+		fmt.Println(" (do not sign it again, that would use up another node), or replace it using -bump.")
+	}
+	return
+}
+
+
+// finds the transaction in the ledger by its txid (or its beginning)
+func find_pending(lst []*pendingTx, txid string) (res *pendingTx) {
+	txid = strings.ToLower(txid)
+	for _, p := range lst {
+		if strings.HasPrefix(p.TxID, txid) {
+			if res != nil {
+				return nil // ambiguous
+			}
+			res = p
+		}
+	}
+	return
+}
+
+// -pending
+func list_pending() {
+	lst, err := load_pending()
+	if err != nil {
+		fmt.Println("ERROR:", err.Error())
+		return
+	}
+	if len(lst) == 0 {
+		fmt.Println("There are no signed transactions waiting to get mined")
+		return
+	}
+	for _, p := range lst {
+		fmt.Println("TxID", p.TxID, "signed", time.Unix(p.Time, 0).Format("2006-01-02 15:04:05"))
+		fmt.Println("  used nodes:", len(p.Nodes), "  new nodes:", len(p.Children))
+		if p.Seen > 0 {
+			fmt.Println("  not mined since block", p.Seen)
+		}
+	}
+	fmt.Println("Use -sync (or -unconfirmed and -confirm) to check if they got mined")
+}
+
+// -rebroadcast <txid>
+func rebroadcast(txid string) {
+	lst, err := load_pending()
+	if err != nil {
+		fmt.Println("ERROR:", err.Error())
+		return
+	}
+	p := find_pending(lst, txid)
+	if p == nil || txid == "" {
+		fmt.Println("ERROR: Cannot find a single transaction", txid, "in the ledger (see -pending)")
+		return
+	}
+
+	if rpcnode != "" {
+		var res string
+		if err = rpc_call("sendrawtransaction", &res, p.Raw); err != nil {
+			fmt.Println("ERROR:", err.Error())
+			return
+		}
+		fmt.Println("Transaction", res, "sent to", rpc_host())
+		return
+	}
+
+	fn := txfilename
+	if fn == "" {
+		fn = p.TxID[:8] + ".txt"
+	}
+	if err = ioutil.WriteFile(fn, []byte(p.Raw), 0600); err != nil {
+		fmt.Println("ERROR:", err.Error())
+		return
+	}
+	fmt.Println("The signed transaction", p.TxID, "stored in", fn)
+	fmt.Println("Broadcast it with the client (as it is, it does not need signing again)")
+}
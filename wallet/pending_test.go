@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"io/ioutil"
+	"encoding/hex"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/xnyss"
+)
+
+func TestPendingLedger(t *testing.T) {
+	defer func(lt bool, ks []*btc.PrivateAddr, sd, jf string) {
+		longterm, keys, StateDirectory, JournalFile, journalUsed = lt, ks, sd, jf, nil
+	}(longterm, keys, StateDirectory, JournalFile)
+	dir, er := ioutil.TempDir("", "wallet")
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	defer os.RemoveAll(dir)
+	defer os.Remove(dir + ".journal")
+	StateDirectory, JournalFile, journalUsed = dir, dir+".journal", nil
+
+	longterm = true
+	k := btc.NewPrivateAddr([]byte{13, 14, 15, 16}, 0x80, true)
+	keys = []*btc.PrivateAddr{k}
+	ms := btc.NewXNYSSMultiSig()
+	ms.PublicKeys = append(ms.PublicKeys, k.BtcAddr.Hash160[:])
+
+	// a tx spending two outputs of the key - the second input signs with a
+	// child of the first node, so there are two nodes and one child gone
+	tx := new(btc.Tx)
+	tx.Version = 1
+	for i := 0; i < 2; i++ {
+		tin := &btc.TxIn{ScriptSig: ms.Bytes()}
+		tin.Input.Vout = uint32(i)
+		tx.TxIn = append(tx.TxIn, tin)
+	}
+	tx.TxOut = append(tx.TxOut, &btc.TxOut{Value: 1000, Pk_script: []byte{0x6a}})
+	if !sign_tx(tx) {
+		t.Fatal("Not signed")
+	}
+	raw := tx.Serialize()
+	tx.SetHash(raw)
+	if er = add_pending(tx, raw); er != nil {
+		t.Fatal(er.Error())
+	}
+
+	lst, er := load_pending()
+	if er != nil || len(lst) != 1 {
+		t.Fatal("Ledger not stored", len(lst), er)
+	}
+	p := lst[0]
+	if p.TxID != tx.Hash.String() || p.Raw != hex.EncodeToString(raw) || len(p.Nodes) != 2 ||
+		len(p.Children) != 2*xnyss.Branches-1 {
+		t.Fatal("Bad ledger entry", p.TxID, len(p.Nodes), len(p.Children))
+	}
+	if state_files() != 0 {
+		t.Error("The ledger counted as a state file")
+	}
+	if find_pending(lst, p.TxID[:10]) != p || find_pending(lst, "ff"+p.TxID) != nil {
+		t.Error("find_pending")
+	}
+
+	// all the new nodes not found by the client
+	var recs []*btc.UpkhAnswer
+	for _, h := range p.Children {
+		r := new(btc.UpkhAnswer)
+		hex.Decode(r.PubKeyHash[:], []byte(h))
+		recs = append(recs, r)
+	}
+	if stale, _ := check_pending(recs, 100); len(stale) != 0 {
+		t.Error("Stale at once")
+	}
+	if stale, _ := check_pending(recs[1:], 200); len(stale) != 0 {
+		t.Error("Stale without all the nodes answered")
+	}
+	if stale, _ := check_pending(recs, 100+pendingStaleBlocks); len(stale) != 1 || stale[0].TxID != p.TxID {
+		t.Error("Not stale after", pendingStaleBlocks, "blocks")
+	}
+
+	// one of them confirmed - the tx is mined
+	recs[1].Depth = 1
+	check_pending(recs, 110)
+	if lst, _ = load_pending(); len(lst) != 0 {
+		t.Error("Mined tx still in the ledger")
+	}
+	if _, er = os.Stat(dir + "/" + PendingFile); !os.IsNotExist(er) {
+		t.Error("Empty ledger not removed")
+	}
+
+	// nor a tx with one of the inputs not signed
+	tx.TxIn[1].ScriptSig = ms.Bytes()
+	add_pending(tx, tx.Serialize())
+	if lst, _ = load_pending(); len(lst) != 0 {
+		t.Error("Recorded a tx with an input not signed")
+	}
+
+	// a tx without XNYSS signatures is not recorded
+	tx.TxIn[0].ScriptSig, tx.TxIn[1].ScriptSig = nil, nil
+	add_pending(tx, tx.Serialize())
+	if lst, _ = load_pending(); len(lst) != 0 {
+		t.Error("Recorded a tx without signatures")
+	}
+
+	// nor one signed with a one-time key, which advertises no nodes
+	longterm = false
+	k = btc.NewPrivateAddr([]byte{13, 14, 15, 17}, 0x80, false)
+	keys = []*btc.PrivateAddr{k}
+	ms.PublicKeys[0] = k.BtcAddr.Hash160[:]
+	tx.TxIn = tx.TxIn[:1]
+	tx.TxIn[0].ScriptSig = ms.Bytes()
+	if !sign_tx(tx) {
+		t.Fatal("Not signed with the one-time key")
+	}
+	add_pending(tx, tx.Serialize())
+	if lst, _ = load_pending(); len(lst) != 0 {
+		t.Error("Recorded a tx signed with a one-time key")
+	}
+}
+
+func TestPendingReplace(t *testing.T) {
+	defer func(lt bool, ks []*btc.PrivateAddr, sd, jf string) {
+		longterm, keys, StateDirectory, JournalFile, journalUsed = lt, ks, sd, jf, nil
+	}(longterm, keys, StateDirectory, JournalFile)
+	dir, er := ioutil.TempDir("", "wallet")
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	defer os.RemoveAll(dir)
+	defer os.Remove(dir + ".journal")
+	StateDirectory, JournalFile, journalUsed = dir, dir+".journal", nil
+
+	// the replacement signs with the other key of the 1-of-2 multisig
+	longterm = true
+	k1 := btc.NewPrivateAddr([]byte{17, 18, 19, 20}, 0x80, true)
+	k2 := btc.NewPrivateAddr([]byte{21, 22, 23, 24}, 0x80, true)
+	ms := btc.NewXNYSSMultiSig()
+	ms.PublicKeys = append(ms.PublicKeys, k1.BtcAddr.Hash160[:], k2.BtcAddr.Hash160[:])
+
+	signed := func(k *btc.PrivateAddr, fee uint64) *btc.Tx {
+		keys = []*btc.PrivateAddr{k}
+		tx := new(btc.Tx)
+		tx.Version = 1
+		tx.TxIn = []*btc.TxIn{&btc.TxIn{ScriptSig: ms.Bytes(), Sequence: 0xfffffffd}}
+		tx.TxOut = []*btc.TxOut{&btc.TxOut{Value: 10000 - fee, Pk_script: []byte{0x6a}}}
+		if !sign_tx(tx) {
+			t.Fatal("Not signed")
+		}
+		tx.SetHash(tx.Serialize())
+		return tx
+	}
+	orig := signed(k1, 1000)
+	tx := signed(k2, 2000)
+	keys = []*btc.PrivateAddr{k1, k2}
+
+	// the original has not been recorded (e.g. signed on another device)
+	if er = add_pending(tx, tx.Serialize()); er != nil {
+		t.Fatal(er.Error())
+	}
+	if er = replace_pending(orig, tx); er != nil {
+		t.Fatal(er.Error())
+	}
+	lst, _ := load_pending()
+	if len(lst) != 2 || find_pending(lst, tx.Hash.String()).Replaces[0] != orig.Hash.String() {
+		t.Fatal("Replacement not recorded")
+	}
+	if k1.TreeState.Available(nil) != 0 || len(k1.TreeState.Unconfirmed()) != xnyss.Branches {
+		t.Fatal("Nodes of the original retired before the replacement got mined")
+	}
+
+	// the replacement mined - the children of the original will never be
+	var recs []*btc.UpkhAnswer
+	for _, p := range lst {
+		for _, h := range p.Children {
+			r := new(btc.UpkhAnswer)
+			hex.Decode(r.PubKeyHash[:], []byte(h))
+			if p.TxID == tx.Hash.String() {
+				r.Depth = 1
+			}
+			recs = append(recs, r)
+		}
+	}
+	check_pending(recs, 100)
+	if lst, _ = load_pending(); len(lst) != 0 {
+		t.Error("Replaced tx still in the ledger")
+	}
+	if len(k1.TreeState.Unconfirmed()) != 0 || len(k2.TreeState.Unconfirmed()) != xnyss.Branches {
+		t.Error("Wrong nodes retired")
+	}
+}
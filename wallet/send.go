@@ -42,9 +42,6 @@ func parse_spend() {
 			println("Incorrect amount: ", tmp[1], er.Error())
 			cleanExit(1)
 		}
-		if *subfee && i==0 {
-			am -= curFee
-		}
 
 		sendTo = append(sendTo, oneSendTo{addr:a, amount:am})
 		spendBtc += am
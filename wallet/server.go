@@ -0,0 +1,489 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"net"
+	"sync"
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"syscall"
+	"io/ioutil"
+	"os/signal"
+	"crypto/rand"
+	"crypto/subtle"
+	"path/filepath"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/others/sys"
+)
+
+/*
+Server mode (-server) keeps the keys and their XNYSS states in memory, so they
+do not need to be derived and loaded for each operation. It listens at a unix
+socket ("unix:/path/to/socket") or at a TCP port of the loopback interface
+("127.0.0.1:port", or just the port). The socket is only accessible to its
+owner. Any local program (a web page too) can connect to the TCP port, so there
+each request must carry the "token" that the server writes to server.token
+(readable by its owner only) when it starts.
+
+Each request and each response is one line of JSON:
+	{"id":1, "cmd":"unlock", "pass":"<seed password>"}
+	{"id":1, "result":{...}}  or  {"id":1, "error":"<message>"}
+
+A request that is not JSON, or has a wrong token, gets its error and the
+connection gets closed.
+
+The id is copied from the request to its response. The commands:
+	unlock      - derive the keys with the given "pass" and load their states
+	              (returns the number of "addresses")
+	lock        - save the states and wipe the keys from memory
+	status      - whether the wallet is locked, its mode and number of addresses
+	addresses   - the addresses with their labels
+	keystate    - available and unconfirmed signature nodes of each address
+	sign        - sign the raw transaction given in "tx" (hex) - returns its
+	              "txid", signed "hex" and "complete" (if all inputs got signed)
+	unconfirmed - the content of unconfirmed.txt ("data") with its "count" (of
+	              which "recheck" are confirmed nodes, to re-check after a reorg)
+	confirm     - apply the content of confirmed.txt given in "data"
+	sync        - get the confirmations from the client's RPC (see -rpc)
+	Both confirm and sync return the number of nodes "revoked", because their
+	transactions are not in the best chain anymore.
+	pending     - the signed transactions that have not been mined yet, with
+	              their signed "raw" hex (see -pending)
+	backup      - move the backup nodes to the backup folder (see -backup)
+
+All but status and unlock need the wallet unlocked. The requests are processed
+one at a time. Any changes of the key states are written to the disk (and
+synced) before the response gets sent, so a signature never leaves the server
+before the state without its node is stored.
+
+A tx sending more than -spendlimit out of the wallet has to be confirmed at
+the server's console, and so does a tx sending nothing out of it (with no fee),
+as it uses up a node as well.
+*/
+
+type srvRequest struct {
+	ID    interface{} `json:"id"`
+	Cmd   string      `json:"cmd"`
+	Pass  string      `json:"pass,omitempty"`
+	Tx    string      `json:"tx,omitempty"`
+	Data  string      `json:"data,omitempty"`
+	Token string      `json:"token,omitempty"`
+}
+
+type srvResponse struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type srvAddress struct {
+	Addr  string `json:"addr"`
+	Label string `json:"label"`
+}
+
+const srvMaxRequest = 4 << 20
+
+var TokenFile = "server.token" // next to the state folder, written in the TCP mode
+
+var (
+	server_pass []byte // set for make_wallet(), while unlocking
+	srvMutex    sync.Mutex
+	srvUnlocked bool
+	spendLimit  uint64
+)
+
+// asks the operator at the server's console (no answer means no)
+func console_yes(msg string) bool {
+	fmt.Print(msg, " (y/n) : ")
+	return strings.ToLower(strings.TrimSpace(getline())) == "y"
+}
+
+// the names of the states in the state folder
+func state_names() (res []string) {
+	d, er := os.Open(StateDirectory)
+	if er != nil {
+		return
+	}
+	names, _ := d.Readdirnames(-1)
+	d.Close()
+	for _, n := range names {
+		// the ledger and the backup record are not key states
+		if !strings.HasSuffix(n, ".tmp") && !strings.HasSuffix(n, ".json") {
+			res = append(res, n)
+		}
+	}
+	return
+}
+
+func state_files() int {
+	return len(state_names())
+}
+
+// whether a state on the disk was loaded for a key made from the password, and
+// has the seeds of that key (so it tells a wrong password from a fresh wallet)
+func password_matches() bool {
+	names := state_names()
+	if len(names) == 0 {
+		return true // nothing to check against
+	}
+	for _, k := range keys[first_determ_idx:] {
+		if k.StateFn == names[0] {
+			_, loaded := savedStates[k.StateFn]
+			return loaded && bytes.Equal(k.TreeState.PublicKey(), k.BtcAddr.Pubkey)
+		}
+	}
+	return false
+}
+
+// wipe the keys from memory (without saving their states)
+func wipe_keys() {
+	for k := range keys {
+		sys.ClearBuffer(keys[k].Key)
+		keys[k].TreeState.Wipe()
+	}
+	keys, segwit, msAddresses = nil, nil, nil
+	savedStates = make(map[string][32]byte)
+	srvUnlocked = false
+}
+
+// save the states and wipe the keys
+func srv_lock() error {
+	err := save_states()
+	wipe_keys()
+	return err
+}
+
+func srv_unlock(pass string) error {
+	if srvUnlocked {
+		return errors.New("already unlocked")
+	}
+	if pass == "" {
+		return errors.New("pass missing")
+	}
+	server_pass = []byte(pass)
+	make_wallet()
+	sys.ClearBuffer(server_pass)
+	server_pass = nil
+
+	if len(keys) != first_determ_idx+int(keycnt*mskeycnt) {
+		wipe_keys()
+		return errors.New("failed to load the key states (see the server's console)")
+	}
+	if !password_matches() {
+		wipe_keys()
+		return errors.New("the key states do not match this password")
+	}
+	srvUnlocked = true
+	return nil
+}
+
+// puts the multisig scripts into the inputs that spend our outputs from the balance folder
+func fill_multisig(tx *btc.Tx) error {
+	for i, in := range tx.TxIn {
+		if len(in.ScriptSig) == 0 {
+			ptx := tx_from_balance(btc.NewUint256(in.Input.Hash[:]), false)
+			if ptx == nil || int(in.Input.Vout) >= len(ptx.TxOut) {
+				return fmt.Errorf("input %d: unknown output %s", i, in.Input.String())
+			}
+			if ms, _ := ms_keys(ptx.TxOut[in.Input.Vout].Pk_script); ms != nil {
+				in.ScriptSig = ms.Bytes()
+			}
+		}
+		ms, _ := btc.NewMultiSigFromScript(in.ScriptSig)
+		if ms == nil || !ms.XnyssMode {
+			return fmt.Errorf("input %d does not spend an XNYSS multisig", i)
+		}
+		if len(ms.XnyssSignatures) > 0 {
+			return fmt.Errorf("input %d is already signed", i)
+		}
+		if _, ks := ms_keys(ms.PkScript()); len(ks) == 0 {
+			return fmt.Errorf("input %d does not spend from this wallet", i)
+		}
+	}
+	return nil
+}
+
+// the value that the tx sends out of the wallet, with the fee (the inputs minus the outputs)
+func spend_value(tx *btc.Tx) (res uint64, er error) {
+	var tot_in, tot_out uint64
+	for i, in := range tx.TxIn {
+		ptx := tx_from_balance(btc.NewUint256(in.Input.Hash[:]), false)
+		if ptx == nil || int(in.Input.Vout) >= len(ptx.TxOut) {
+			return 0, fmt.Errorf("input %d: unknown output %s - cannot tell the fee", i, in.Input.String())
+		}
+		tot_in += ptx.TxOut[in.Input.Vout].Value
+	}
+	for _, out := range tx.TxOut {
+		tot_out += out.Value
+		if ms, _ := ms_keys(out.Pk_script); ms == nil && pkscr_to_key(out.Pk_script) == nil {
+			res += out.Value
+		}
+	}
+	if tot_in > tot_out {
+		res += tot_in - tot_out
+	}
+	return
+}
+
+func srv_sign(req *srvRequest) (interface{}, error) {
+	raw, er := hex.DecodeString(req.Tx)
+	if er != nil {
+		return nil, errors.New("tx: " + er.Error())
+	}
+	tx, le := btc.NewTx(raw)
+	if tx == nil || le != len(raw) {
+		return nil, errors.New("tx: cannot decode it")
+	}
+	if er = fill_multisig(tx); er != nil {
+		return nil, er
+	}
+
+	val, er := spend_value(tx)
+	if er != nil {
+		return nil, er
+	}
+	if val == 0 || val > spendLimit {
+		fmt.Println("Request", req.ID, "to sign a tx sending", btc.UintToBtc(val), "BTC out of the wallet (with the fee):")
+		for _, out := range tx.TxOut {
+			if ad := addr_from_pkscr(out.Pk_script); ad != nil {
+				fmt.Println(" ", btc.UintToBtc(out.Value), "BTC to", ad.String())
+			} else {
+				fmt.Println(" ", btc.UintToBtc(out.Value), "BTC to", hex.EncodeToString(out.Pk_script))
+			}
+		}
+		if !console_yes("Sign it?") {
+			return nil, errors.New("rejected at the server's console")
+		}
+	}
+
+	complete := sign_tx(tx)
+	if er = save_states(); er != nil {
+		return nil, er // do not let the signatures out
+	}
+	if tx.SegWit != nil {
+		raw = tx.SerializeNew()
+	} else {
+		raw = tx.Serialize()
+	}
+	tx.SetHash(raw)
+	if er = add_pending(tx, raw); er != nil {
+		fmt.Println("WARNING: Cannot record", tx.Hash.String(), "in the ledger of the pending txs -", er.Error())
+	}
+	return map[string]interface{}{"txid": tx.Hash.String(), "hex": hex.EncodeToString(raw), "complete": complete}, nil
+}
+
+func srv_process(req *srvRequest) (res interface{}, err error) {
+	srvMutex.Lock()
+	defer srvMutex.Unlock()
+
+	switch req.Cmd {
+	case "status":
+		return map[string]interface{}{"locked": !srvUnlocked, "longterm": longterm,
+			"network": chain_params().Name, "addresses": len(msAddresses)}, nil
+
+	case "unlock":
+		if err = srv_unlock(req.Pass); err == nil {
+			res = map[string]interface{}{"addresses": len(msAddresses)}
+		}
+		return
+	}
+
+	if !srvUnlocked {
+		return nil, errors.New("locked")
+	}
+
+	switch req.Cmd {
+	case "lock":
+		err = srv_lock()
+
+	case "addresses":
+		lst := make([]*srvAddress, len(msAddresses))
+		for i := range msAddresses {
+			lst[i] = &srvAddress{Addr: msAddresses[i].AddrVer(ver_script()).String(),
+				Label: keys[first_determ_idx+i*int(mskeycnt)].BtcAddr.Extra.Label}
+		}
+		res = lst
+
+	case "keystate":
+		res = key_states()
+
+	case "pending":
+		res, err = load_pending()
+
+	case "sign":
+		res, err = srv_sign(req)
+
+	case "unconfirmed":
+		f, recheck := unconfirmed_file()
+		res = map[string]interface{}{"count": len(f.Hashes), "recheck": recheck, "data": string(f.Text())}
+
+	case "confirm":
+		var cf *btc.UpkhFile
+		var applied, revoked int
+		cf, applied, revoked, err = apply_confirms([]byte(req.Data))
+		if er := save_states(); err == nil {
+			err = er
+		}
+		if err == nil {
+			res = map[string]interface{}{"entries": len(cf.Recs), "applied": applied, "revoked": revoked,
+				"tipheight": cf.TipHeight}
+		}
+
+	case "sync":
+		var confirmed, revoked, total int
+		var tip *upkhResult
+		confirmed, revoked, total, tip, err = sync_confirms()
+		if er := save_states(); err == nil {
+			err = er
+		}
+		if err == nil {
+			r := map[string]interface{}{"confirmed": confirmed, "revoked": revoked, "asked": total}
+			if tip != nil {
+				r["tiphash"], r["tipheight"] = tip.TipHash, tip.TipHeight
+			}
+			res = r
+		}
+
+	case "backup":
+		if _, total := backup_counts(); total == 0 {
+			return nil, errors.New("no nodes to backup - wait for more signatures to be confirmed")
+		}
+		if err = write_backup(); err == nil {
+			counts, _ := backup_counts()
+			res = map[string]interface{}{"folder": BackupDirectory, "addresses": len(counts)}
+		}
+
+	default:
+		err = errors.New("unknown command " + req.Cmd)
+	}
+	return
+}
+
+// serves one connection; token is required in each request if not empty
+func srv_conn(c net.Conn, token string) {
+	defer c.Close()
+	sc := bufio.NewScanner(c)
+	sc.Buffer(make([]byte, 4096), srvMaxRequest)
+	for sc.Scan() {
+		var req srvRequest
+		var resp srvResponse
+		bad := false
+		if er := json.Unmarshal(sc.Bytes(), &req); er != nil {
+			resp.Error, bad = "bad request: "+er.Error(), true
+		} else if token != "" && subtle.ConstantTimeCompare([]byte(req.Token), []byte(token)) != 1 {
+			resp.ID, resp.Error, bad = req.ID, "bad token", true
+		} else {
+			resp.ID = req.ID
+			res, er := srv_process(&req)
+			if er != nil {
+				resp.Error = er.Error()
+			} else {
+				resp.Result = res
+			}
+			if *verbose {
+				fmt.Println("Request", req.ID, req.Cmd, "-", resp.Error)
+			}
+		}
+		b, _ := json.Marshal(&resp)
+		if _, er := c.Write(append(b, '\n')); er != nil || bad {
+			return // not a client of ours, e.g. a browser posting to the port
+		}
+	}
+}
+
+func server_listen(addr string) (lis net.Listener, err error) {
+	if strings.HasPrefix(addr, "unix:") {
+		// made in a private folder and moved to its place only once it has its mode
+		path := addr[5:]
+		var dir string
+		if dir, err = ioutil.TempDir(filepath.Dir(path), ".wallet"); err != nil {
+			return
+		}
+		defer os.RemoveAll(dir)
+		tmp := filepath.Join(dir, "sock")
+		if lis, err = net.Listen("unix", tmp); err != nil {
+			return
+		}
+		if err = os.Chmod(tmp, 0600); err == nil {
+			os.Remove(path) // left by a previous instance
+			err = os.Rename(tmp, path)
+		}
+		if err != nil {
+			lis.Close()
+			lis = nil
+		}
+		return
+	}
+	if !strings.Contains(addr, ":") {
+		addr = "127.0.0.1:" + addr
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, errors.New("the server only listens at the loopback interface")
+	}
+	return net.Listen("tcp", addr)
+}
+
+// a new random token for the TCP clients, readable by the owner only
+func write_token() (string, error) {
+	var b [16]byte
+	if _, er := rand.Read(b[:]); er != nil {
+		return "", er
+	}
+	token := hex.EncodeToString(b[:])
+	os.Remove(TokenFile) // WriteFile would keep the mode of an old one
+	if er := ioutil.WriteFile(TokenFile, []byte(token), 0600); er != nil {
+		return "", er
+	}
+	return token, nil
+}
+
+// runs the server until it gets interrupted
+func run_server() {
+	lis, er := server_listen(srvaddr)
+	if er != nil {
+		fmt.Println("ERROR:", er.Error())
+		os.Exit(1)
+	}
+	var token string
+	if !strings.HasPrefix(srvaddr, "unix:") {
+		if token, er = write_token(); er != nil {
+			lis.Close()
+			fmt.Println("ERROR: Cannot write", TokenFile, "-", er.Error())
+			os.Exit(1)
+		}
+		fmt.Println("The requests need the token from", TokenFile)
+	}
+	fmt.Println("Wallet server listening at", srvaddr, "- locked")
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		srvMutex.Lock() // wait for the request being processed
+		if strings.HasPrefix(srvaddr, "unix:") {
+			os.Remove(srvaddr[5:])
+		} else {
+			os.Remove(TokenFile)
+		}
+		fmt.Println("\nWallet server stopped")
+		cleanExit(0)
+	}()
+
+	for {
+		c, er := lis.Accept()
+		if er != nil {
+			fmt.Println("ERROR:", er.Error())
+			continue
+		}
+		go srv_conn(c, token)
+	}
+}
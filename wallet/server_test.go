@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"net"
+	"bufio"
+	"strings"
+	"testing"
+	"io/ioutil"
+	"encoding/json"
+	"github.com/lentus/wotscoin/lib/btc"
+)
+
+func TestServerLocked(t *testing.T) {
+	for _, cmd := range []string{"lock", "keystate", "sign", "unconfirmed", "confirm", "sync", "backup"} {
+		if _, er := srv_process(&srvRequest{Cmd: cmd}); er == nil || er.Error() != "locked" {
+			t.Error(cmd, "processed while locked:", er)
+		}
+	}
+	res, er := srv_process(&srvRequest{Cmd: "status"})
+	if er != nil || !res.(map[string]interface{})["locked"].(bool) {
+		t.Error("status:", res, er)
+	}
+	if _, er = srv_process(&srvRequest{Cmd: "unlock"}); er == nil {
+		t.Error("Unlocked without a password")
+	}
+}
+
+func TestServerListen(t *testing.T) {
+	for _, a := range []string{"0.0.0.0:8000", "192.168.1.1:8000", "example.com:8000"} {
+		if lis, er := server_listen(a); er == nil {
+			lis.Close()
+			t.Error("Listening at", a)
+		}
+	}
+	lis, er := server_listen("0")
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	lis.Close()
+
+	dir, _ := ioutil.TempDir("", "wallet")
+	defer os.RemoveAll(dir)
+	if lis, er = server_listen("unix:" + dir + "/sock"); er != nil {
+		t.Fatal(er.Error())
+	}
+	defer lis.Close()
+	if fi, er := os.Stat(dir + "/sock"); er != nil || fi.Mode().Perm() != 0600 {
+		t.Error("Socket", fi, er)
+	}
+	if lst, _ := ioutil.ReadDir(dir); len(lst) != 1 {
+		t.Error("Temporary folder left", len(lst))
+	}
+}
+
+func TestServerConn(t *testing.T) {
+	for _, bad := range []string{`POST / HTTP/1.1`, `{"id":1, "cmd":"status", "token":"bad"}`, `{"id":1, "cmd":"status"}`} {
+		a, b := net.Pipe()
+		go srv_conn(a, "tok")
+		rd := bufio.NewReader(b)
+		b.Write([]byte(`{"id":1, "cmd":"status", "token":"tok"}` + "\n"))
+		var resp srvResponse
+		if l, er := rd.ReadBytes('\n'); er != nil || json.Unmarshal(l, &resp) != nil || resp.Error != "" {
+			t.Error("status:", string(l), er)
+		}
+		b.Write([]byte(bad + "\n"))
+		resp = srvResponse{}
+		if l, er := rd.ReadBytes('\n'); er != nil || json.Unmarshal(l, &resp) != nil || resp.Error == "" {
+			t.Error(bad, "-", string(l), er)
+		}
+		// the connection gets closed after the first bad request
+		if _, er := b.Write([]byte(`{"id":1, "cmd":"lock", "token":"tok"}` + "\n")); er == nil {
+			if l, er := rd.ReadBytes('\n'); er == nil || strings.Contains(string(l), "locked") {
+				t.Error("Processed after", bad, "-", string(l))
+			}
+		}
+		b.Close()
+	}
+}
+
+func TestSpendValue(t *testing.T) {
+	defer func(m []*btc.MultiSig) { msAddresses = m }(msAddresses)
+	ms := btc.NewXNYSSMultiSig()
+	ms.PublicKeys = append(ms.PublicKeys, make([]byte, 20))
+	msAddresses = []*btc.MultiSig{ms}
+
+	// the input of 1600 pays 100 fee
+	prev := &btc.Tx{Version: 1, TxIn: []*btc.TxIn{&btc.TxIn{}}}
+	prev.TxOut = append(prev.TxOut, &btc.TxOut{Value: 1600, Pk_script: ms.PkScript()})
+	prev.SetHash(prev.Serialize())
+	loadedTxs[prev.Hash.Hash] = prev
+	defer delete(loadedTxs, prev.Hash.Hash)
+
+	tx := new(btc.Tx)
+	tx.TxIn = append(tx.TxIn, &btc.TxIn{Input: btc.TxPrevOut{Hash: prev.Hash.Hash}})
+	tx.TxOut = append(tx.TxOut, &btc.TxOut{Value: 1000, Pk_script: ms.PkScript()}) // change
+	tx.TxOut = append(tx.TxOut, &btc.TxOut{Value: 300, Pk_script: []byte{0x6a}})
+	tx.TxOut = append(tx.TxOut, &btc.TxOut{Value: 200, Pk_script: make([]byte, 22)})
+	if v, er := spend_value(tx); er != nil || v != 600 {
+		t.Error("Spending", v, "instead of 600", er)
+	}
+
+	// most of the value going out as the fee
+	tx.TxOut[0].Value = 10
+	if v, _ := spend_value(tx); v != 1590 {
+		t.Error("Spending", v, "instead of 1590")
+	}
+
+	tx.TxIn[0].Input.Vout = 1
+	if _, er := spend_value(tx); er == nil {
+		t.Error("No error for an unknown input")
+	}
+}
+
+func TestPasswordMatches(t *testing.T) {
+	defer func(ks []*btc.PrivateAddr, sd string, fi int, ss map[string][32]byte) {
+		keys, StateDirectory, first_determ_idx, savedStates = ks, sd, fi, ss
+	}(keys, StateDirectory, first_determ_idx, savedStates)
+	dir, _ := ioutil.TempDir("", "wallet")
+	defer os.RemoveAll(dir)
+	StateDirectory, first_determ_idx = dir, 0
+
+	k := btc.NewPrivateAddr([]byte{1, 2, 3}, 0x80, true)
+	keys, savedStates = []*btc.PrivateAddr{k}, make(map[string][32]byte)
+	if !password_matches() {
+		t.Error("No states, and the password does not match")
+	}
+	ioutil.WriteFile(dir+"/pending.json", []byte("[]"), 0600)
+	if !password_matches() {
+		t.Error("The ledger taken for a key state")
+	}
+
+	// the state of a key made from another password
+	other := btc.NewPrivateAddr([]byte{4, 5, 6}, 0x80, true)
+	ioutil.WriteFile(dir+"/"+other.StateFn, other.TreeState.Bytes(), 0600)
+	if password_matches() {
+		t.Error("Matching the state of another key")
+	}
+	os.Remove(dir + "/" + other.StateFn)
+
+	ioutil.WriteFile(dir+"/"+k.StateFn, k.TreeState.Bytes(), 0600)
+	if password_matches() {
+		t.Error("Matching a state that was not loaded")
+	}
+	savedStates[k.StateFn] = [32]byte{1}
+	if !password_matches() {
+		t.Error("The state of the key does not match")
+	}
+	k.TreeState = other.TreeState // under the name of our key
+	if password_matches() {
+		t.Error("Matching the seeds of another key")
+	}
+}
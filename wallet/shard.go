@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"errors"
+	"strconv"
+	"strings"
+	"github.com/lentus/wotscoin/lib/xnyss"
+)
+
+// An XNYSS key state can only sign on one device. Sharding splits the confirmed
+// nodes of the long-term keys into shards (see NYTree.Split), each a state
+// folder for another device. A shard keeps the children of its own nodes, so
+// the devices never share a node, and each of them confirms its own.
+
+var ShardDirectory = "shards"
+
+func shard_check(count int) error {
+	if !longterm {
+		return errors.New("Sharding keys is only applicable to long-term addresses")
+	}
+	if count < 2 || count > 255 {
+		return errors.New("The number of shards must be 2 to 255")
+	}
+	if _, err := os.Stat(ShardDirectory); err == nil || !os.IsNotExist(err) {
+		return errors.New("You have other shards in the " + ShardDirectory + " folder. Move them to their devices, then try again")
+	}
+	return nil
+}
+
+// Splits the keys into the shard folders. The live state gets saved first, so
+// a failure in between may lose some nodes, but never leaves them in two places.
+// A shard that cannot be written gets removed, and its nodes go back to the
+// live state.
+func write_shards(count int) error {
+	if err := shard_check(count); err != nil {
+		return err
+	}
+
+	shards := make([][]*xnyss.NYTree, len(keys))
+	for i := range keys {
+		var err error
+		if shards[i], err = keys[i].TreeState.Split(count); err != nil {
+			return err
+		}
+	}
+	if err := save_states(); err != nil {
+		return err
+	}
+
+	// every key gets a state in every shard (even one without nodes), or the
+	// wallet would make a new state for it, with the root node that has signed
+	var failed []string
+	for id := 1; id <= count; id++ {
+		dir := ShardDirectory + "/" + strconv.Itoa(id)
+		err := os.MkdirAll(dir, os.ModePerm)
+		if err != nil {
+			err = errors.New("cannot create its folder - " + err.Error())
+		}
+		for i := 0; i < len(keys) && err == nil; i++ {
+			if er := write_synced(dir+"/"+keys[i].StateFn, shards[i][id-1].Bytes()); er != nil {
+				err = errors.New("cannot write the state of " + keys[i].BtcAddr.String() + " - " + er.Error())
+			}
+		}
+		if err != nil {
+			trees := make([]*xnyss.NYTree, len(keys))
+			for i := range keys {
+				trees[i] = shards[i][id-1]
+			}
+			failed = append(failed, fmt.Sprint("shard ", id, ": ", err.Error(), unwrite_shard(dir, trees)))
+		}
+		for i := range keys {
+			shards[i][id-1].Wipe()
+		}
+	}
+	if failed != nil {
+		err := "Failed to write " + strings.Join(failed, "\n")
+		if er := save_states(); er != nil {
+			err += "\n" + er.Error()
+		}
+		return errors.New(err)
+	}
+	return nil
+}
+
+// removes the shard that could not be written and merges its nodes (one tree
+// per key) back into the live state; returns what happened to them
+func unwrite_shard(dir string, trees []*xnyss.NYTree) string {
+	if err := os.RemoveAll(dir); err != nil {
+		return " - CANNOT REMOVE " + dir + " (" + err.Error() + "), DO NOT USE IT - its nodes are lost"
+	}
+	for i := range keys {
+		if err := keys[i].TreeState.Merge(trees[i]); err != nil {
+			return " - its nodes of " + keys[i].BtcAddr.String() + " are lost (" + err.Error() + ")"
+		}
+	}
+	return " - its nodes stay in the live state"
+}
+
+// -shard <count>
+func make_shards(count int) {
+	if err := shard_check(count); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("Signatures per shard for each address (the fewest among its keys):")
+	var empty bool
+	for a, ms := range msAddresses {
+		least := -1
+		for _, k := range keys[a*int(mskeycnt) : (a+1)*int(mskeycnt)] {
+			if n := k.TreeState.Available(nil) / count; least < 0 || n < least {
+				least = n
+			}
+		}
+		fmt.Println(ms.AddrVer(ver_script()).String(), ":", least)
+		empty = empty || least == 0
+	}
+	if empty {
+		fmt.Println()
+		fmt.Println("Some shards will not be able to sign for all the addresses.")
+		fmt.Println("Wait for more signatures to be confirmed, or use less shards.")
+	}
+
+	fmt.Println()
+	if !ask_yes_no("Are you sure you want to proceed?") {
+		fmt.Println("Sharding canceled")
+		return
+	}
+
+	if err := write_shards(count); err != nil {
+		fmt.Println("ERROR:", err)
+		fmt.Println("Sharding did not finish. The shards left in", ShardDirectory, "are complete, unless marked DO NOT USE above.")
+		return
+	}
+
+	fmt.Println()
+	fmt.Println("Finished creating", count, "shards in folder", ShardDirectory, ".")
+	fmt.Println("Copy each of its folders to a device, as its", StateDirectory, "folder, and use the same password there.")
+	fmt.Println("This state keeps the unconfirmed nodes only, and can sign once they get confirmed.")
+}
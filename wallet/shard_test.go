@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"bytes"
+	"strings"
+	"testing"
+	"io/ioutil"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/xnyss"
+)
+
+func TestShards(t *testing.T) {
+	defer func(lt bool, ks []*btc.PrivateAddr, sd, hd string) {
+		longterm, keys, StateDirectory, ShardDirectory = lt, ks, sd, hd
+	}(longterm, keys, StateDirectory, ShardDirectory)
+	dir, er := ioutil.TempDir("", "wallet")
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	defer os.RemoveAll(dir)
+	StateDirectory, ShardDirectory = dir+"/state", dir+"/shards"
+	os.Mkdir(StateDirectory, os.ModePerm)
+
+	// one key with confirmed nodes, and one that has not signed yet
+	longterm = true
+	k := btc.NewPrivateAddr([]byte{25, 26, 27, 28}, 0x80, true)
+	sig, er := k.TreeState.Sign(bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{1}, 32))
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	for _, pkh := range sig.ChildHashes {
+		k.TreeState.Confirm(pkh, xnyss.ConfirmsRequired)
+	}
+	keys = []*btc.PrivateAddr{k, btc.NewPrivateAddr([]byte{29, 30, 31, 32}, 0x80, true)}
+
+	if write_shards(1) == nil {
+		t.Error("Made one shard")
+	}
+
+	// the shards cannot be written (a dangling link in place of their folder),
+	// so they get removed and the nodes stay in the live state
+	avail := k.TreeState.Available(nil)
+	os.Symlink(dir+"/nowhere/shards", ShardDirectory)
+	if er = write_shards(2); er == nil || !strings.Contains(er.Error(), "shard 1:") ||
+		!strings.Contains(er.Error(), "shard 2:") || !strings.Contains(er.Error(), "stay in the live state") {
+		t.Error("Failed shards:", er)
+	}
+	if n := k.TreeState.Available(nil); n != avail {
+		t.Error("Got", n, "nodes back instead of", avail)
+	}
+	os.Remove(ShardDirectory)
+
+	if er = write_shards(2); er != nil {
+		t.Fatal(er.Error())
+	}
+	if write_shards(2) == nil {
+		t.Error("Overwrote the shards")
+	}
+	if k.TreeState.Available(nil) != 0 {
+		t.Error("The live state kept confirmed nodes")
+	}
+
+	seen := make(map[[32]byte]bool)
+	var nodes int
+	for _, id := range []string{"1", "2"} {
+		for i, key := range keys {
+			d, er := ioutil.ReadFile(ShardDirectory + "/" + id + "/" + key.StateFn)
+			if er != nil {
+				t.Fatal("Missing state of key", i, "in shard", id)
+			}
+			tr, er := xnyss.Load(d)
+			if er != nil {
+				t.Fatal(er.Error())
+			}
+			if sid, count := tr.Shard(); string('0'+byte(sid)) != id || count != 2 {
+				t.Error("Bad shard id", sid, count)
+			}
+			for _, h := range tr.SeedHashes() {
+				if seen[h] {
+					t.Fatal("Shards share nodes")
+				}
+				seen[h] = true
+				nodes++
+			}
+		}
+	}
+	// the root node of the second key is confirmed as well
+	if nodes != xnyss.Branches+1 {
+		t.Error("Got", nodes, "nodes in the shards, should be", xnyss.Branches+1)
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"encoding/hex"
 	"encoding/base64"
 	"github.com/lentus/wotscoin/lib/btc"
-	"github.com/lentus/wotscoin/lib/others/ltc"
 )
 
 
@@ -52,11 +51,7 @@ func sign_message() {
 	}
 
 	hash = make([]byte, 32)
-	if litecoin {
-		ltc.HashFromMessage(msg, hash)
-	} else {
-		btc.HashFromMessage(msg, hash)
-	}
+	chain_params().HashFromMessage(msg, hash)
 
 	btcsig := new(btc.Signature)
 	var sb [65]byte
@@ -14,29 +14,41 @@ func sign_tx(tx *btc.Tx) (all_signed bool) {
 	//var multisig_done bool
 	all_signed = true
 
+	// The child nodes get tagged with the txid, so that the next inputs of the same long-term
+	// key sign with them, rather than with more confirmed nodes (see NYTree.getSignNode).
+	// Still, each signed input takes a node of its own.
+	tx.SetHash(tx.Serialize())
+
 	// go through each input
 	for in := range tx.TxIn {
 		if ms, _ := btc.NewMultiSigFromScript(tx.TxIn[in].ScriptSig); ms != nil {
 			hash := tx.SignatureHash(ms.P2SH(), in, btc.SIGHASH_ALL)
 			// Loop over pubkey from last to first, because multisig script
 			// verification checks sig/pubkey matches in that order.
+			// A one-time key has a single node, so the next inputs of the same
+			// address get signed with the next keys.
+			var signed bool
 			for ki := len(ms.PublicKeys)-1; ki >= 0; ki-- {
 				k := public_to_key(ms.PublicKeys[ki])
-				if k != nil {
-					sig, e := k.TreeState.Sign(hash, tx.Hash.Bytes())
+				if k != nil && k.TreeState.Available(tx.Hash.Bytes()) > 0 {
+					sig, e := journal_sign(k, ms.AddrVer(ver_script()).String(), hash, tx.Hash.Bytes())
 					if e != nil {
 						println("ERROR in sign_tx:", e.Error())
-						all_signed = false
 					} else {
 						ms.XnyssSignatures = append(ms.XnyssSignatures, sig)
 						tx.TxIn[in].ScriptSig = ms.Bytes()
 						//multisig_done = true
 						// We must only create 1 signature to avoid using up all
 						// backup keys as well.
+						signed = true
 						break
 					}
 				}
 			}
+			if !signed {
+				println("ERROR in sign_tx: no key left to sign input", in)
+				all_signed = false
+			}
 		} else {
 			// This 'else'-case should not be used in wotscoin at all since we
 			// only use multisigs. For now it handles failures.
@@ -108,6 +120,9 @@ func write_tx_file(tx *btc.Tx) {
 
 	hs := tx.Hash.String()
 	fmt.Println("TxID", hs)
+	if er := add_pending(tx, signedrawtx); er != nil {
+		fmt.Println("WARNING: Cannot record the transaction in the ledger of the pending ones -", er.Error())
+	}
 
 	var fn string
 
@@ -126,23 +141,44 @@ func write_tx_file(tx *btc.Tx) {
 }
 
 
-// prepare a signed transaction
-func make_signed_tx() {
+// build the unsigned transaction, paying feeBtc
+func build_tx() (tx *btc.Tx) {
 	// Make an empty transaction
-	tx := new(btc.Tx)
+	tx = new(btc.Tx)
 	tx.Version = 1
 	tx.Lock_time = 0
 
+	// With -f the first output pays the fee
+	need := spendBtc + feeBtc
+	if *subfee {
+		if sendTo[0].amount <= feeBtc {
+			fmt.Println("ERROR: The first output is too small to pay", btc.UintToBtc(feeBtc), "BTC fee")
+			cleanExit(1)
+		}
+		need = spendBtc
+	}
+
 	// Select as many inputs as we need to pay the full amount (with the fee)
+	var sel []*unspRec
+	groups := coin_groups(unspentOuts)
+	if *useallinputs {
+		for _, g := range groups {
+			if !g.nosig {
+				outs, _ := g.spendable(!longterm)
+				for _, o := range outs {
+					sel = append(sel, o.u)
+				}
+			}
+		}
+	} else {
+		sel = select_coins(groups, need, coinsel, !longterm)
+	}
 	var btcsofar uint64
-	for i := range unspentOuts {
-		//if unspentOuts[i].key == nil {
-		//	continue
-		//}
-		uo := getUO(&unspentOuts[i].TxPrevOut)
+	for _, u := range sel {
+		uo := getUO(&u.TxPrevOut)
 		// add the input to our transaction:
 		tin := new(btc.TxIn)
-		tin.Input = unspentOuts[i].TxPrevOut
+		tin.Input = u.TxPrevOut
 		tin.Sequence = uint32(*sequence)
 
 		for _, ms := range msAddresses {
@@ -153,24 +189,32 @@ func make_signed_tx() {
 		tx.TxIn = append(tx.TxIn, tin)
 
 		btcsofar += uo.Value
-		unspentOuts[i].spent = true
-		if !*useallinputs && ( btcsofar >= spendBtc + feeBtc ) {
-			break
-		}
+		u.spent = true
 	}
-	if btcsofar < (spendBtc + feeBtc) {
-		fmt.Println("ERROR: You have", btc.UintToBtc(btcsofar), "BTC, but you need",
-			btc.UintToBtc(spendBtc + feeBtc), "BTC for the transaction")
+	if btcsofar < need {
+		var tot uint64
+		for _, g := range groups {
+			tot += g.value
+		}
+		fmt.Println("ERROR: You have", btc.UintToBtc(tot), "BTC, but you need",
+			btc.UintToBtc(need), "BTC for the transaction")
+		if tot >= need {
+			fmt.Println("Some of your coins are waiting for their signature nodes to get confirmed (see -keystate)")
+		}
 		cleanExit(1)
 	}
-	changeBtc = btcsofar - (spendBtc + feeBtc)
+	changeBtc = btcsofar - need
 	if *verbose {
 		fmt.Printf("Spending %d out of %d outputs...\n", len(tx.TxIn), len(unspentOuts))
 	}
 
 	// Build transaction outputs:
 	for o := range sendTo {
-		outs, er := btc.NewSpendOutputs(sendTo[o].addr, sendTo[o].amount, testnet)
+		am := sendTo[o].amount
+		if *subfee && o == 0 {
+			am -= feeBtc
+		}
+		outs, er := btc.NewSpendOutputs(sendTo[o].addr, am, chain_params().Testnet)
 		if er != nil {
 			fmt.Println("ERROR:", er.Error())
 			cleanExit(1)
@@ -184,7 +228,7 @@ func make_signed_tx() {
 		if *verbose {
 			fmt.Println("Sending change", changeBtc, "to", chad.String())
 		}
-		outs, er := btc.NewSpendOutputs(chad, changeBtc, testnet)
+		outs, er := btc.NewSpendOutputs(chad, changeBtc, chain_params().Testnet)
 		if er != nil {
 			fmt.Println("ERROR:", er.Error())
 			cleanExit(1)
@@ -200,9 +244,40 @@ func make_signed_tx() {
 		scr.Write([]byte(*message))
 		tx.TxOut = append(tx.TxOut, &btc.TxOut{Value: 0, Pk_script: scr.Bytes()})
 	}
+	return
+}
+
+
+// prepare a signed transaction
+func make_signed_tx() {
+	tx := build_tx()
+
+	// With -feerate, rebuild the tx until the fee matches its estimated size.
+	// More inputs may be needed for a higher fee, which makes it bigger again.
+	est := estimate_vsize(tx)
+	for i := 0; feerate > 0; i++ {
+		fee := rate_fee(est)
+		if fee == feeBtc || fee < feeBtc && i >= 5 {
+			break
+		}
+		if *verbose {
+			fmt.Println("Fee", btc.UintToBtc(feeBtc), "BTC for about", est, "vB - adjusting it to", btc.UintToBtc(fee))
+		}
+		for _, u := range unspentOuts {
+			for _, in := range tx.TxIn {
+				if u.TxPrevOut == in.Input {
+					u.spent = false
+				}
+			}
+		}
+		feeBtc = fee
+		tx = build_tx()
+		est = estimate_vsize(tx)
+	}
 
 	signed := sign_tx(tx)
 	write_tx_file(tx)
+	report_size(est, tx, feeBtc)
 
 	if apply2bal && signed {
 		apply_to_balance(tx)
@@ -8,7 +8,6 @@ import (
 	"strings"
 	"io/ioutil"
 	"github.com/lentus/wotscoin/lib/btc"
-	"github.com/lentus/wotscoin/lib/others/ltc"
 	"github.com/lentus/wotscoin/lib/others/sys"
 )
 
@@ -43,6 +42,12 @@ func getpass() []byte {
 	var e error
 	var f *os.File
 
+	if server_pass != nil {
+		// the server gets it with the unlock request
+		n = copy(pass[:], server_pass)
+		goto check_pass
+	}
+
 	if stdin {
 		if *ask4pass {
 			fmt.Println("ERROR: Both -p and -stdin switches are not allowed at the same time")
@@ -213,37 +218,28 @@ func getUO(pto *btc.TxPrevOut) *btc.TxOut {
 
 // version byte for P2KH addresses
 func ver_pubkey() byte {
-	if litecoin {
-		return ltc.AddrVerPubkey(testnet)
-	} else {
-		return btc.AddrVerPubkey(testnet)
-	}
+	return chain_params().AddrVerPubkey
 }
 
 // version byte for P2SH addresses
 func ver_script() byte {
-	// for litecoin the version is identical
-	return btc.AddrVerScript(testnet)
+	return chain_params().AddrVerScript
 }
 
 // version byte for private key addresses
 func ver_secret() byte {
-	return ver_pubkey() + 0x80
+	return chain_params().AddrVerSecret()
 }
 
 // get BtcAddr from pk_script
 func addr_from_pkscr(scr []byte) *btc.BtcAddr {
-	if litecoin {
-		return ltc.NewAddrFromPkScript(scr, testnet)
-	} else {
-		return btc.NewAddrFromPkScript(scr, testnet)
-	}
+	return chain_params().NewAddrFromPkScript(scr)
 }
 
 // make sure the version byte in the given address is what we expect
 func assert_address_version(a *btc.BtcAddr) {
 	if a.SegwitProg != nil {
-		if a.SegwitProg.HRP != btc.GetSegwitHRP(testnet) {
+		if a.SegwitProg.HRP != chain_params().Bech32HRP {
 			println("Sending address", a.String(), "has an incorrect HRP string", a.SegwitProg.HRP)
 			cleanExit(1)
 		}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"net/http"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"github.com/lentus/wotscoin/lib/btc"
+	"github.com/lentus/wotscoin/lib/xnyss"
+)
+
+func TestSyncConfirms(t *testing.T) {
+	defer func(lt bool, ks []*btc.PrivateAddr, rn string) { longterm, keys, rpcnode = lt, ks, rn }(longterm, keys, rpcnode)
+	longterm = true
+	k := btc.NewPrivateAddr([]byte{1, 2, 3, 4}, 0x80, true)
+	keys = []*btc.PrivateAddr{k}
+	if _, er := k.TreeState.Sign(make([]byte, 32), make([]byte, 32)); er != nil {
+		t.Fatal(er.Error())
+	}
+	unconf := len(k.TreeState.Unconfirmed())
+	if unconf < 2 {
+		t.Fatal("No nodes to confirm", unconf)
+	}
+
+	chain := chain_params().Name
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+			return // like the client
+		}
+		var req struct {
+			Method string
+			Params [][]string
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		res := &upkhResult{Chain: chain, TipHash: "00", TipHeight: 100}
+		for i, pkh := range req.Params[0] {
+			rec := &upkhKey{PubKeyHash: pkh}
+			if i == 0 {
+				rec.LongTermHash, rec.Depth = hex.EncodeToString(k.Hash160[:]), 6
+			}
+			res.Keys = append(res.Keys, rec)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": "wallet", "result": res, "error": nil})
+	}))
+	defer srv.Close()
+
+	rpcnode = "http://user:wrong@" + srv.Listener.Addr().String() + "/"
+	if _, _, _, _, er := sync_confirms(); er == nil {
+		t.Error("Synced with a wrong password")
+	}
+
+	rpcnode = "http://user:pass@" + srv.Listener.Addr().String() + "/"
+	confirmed, revoked, total, tip, er := sync_confirms()
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	if confirmed != 1 || revoked != 0 || total != unconf || tip == nil || tip.TipHeight != 100 {
+		t.Error("Confirmed", confirmed, "of", total, tip)
+	}
+	if len(k.TreeState.Unconfirmed()) != unconf-1 || k.TreeState.Available(nil) != 1 {
+		t.Error("The node did not get confirmed")
+	}
+
+	chain = "other"
+	if _, _, _, _, er = sync_confirms(); er == nil {
+		t.Error("Synced with another network")
+	}
+}
+
+func TestApplyConfirms(t *testing.T) {
+	defer func(lt bool, ks []*btc.PrivateAddr) { longterm, keys = lt, ks }(longterm, keys)
+	longterm = true
+	k := btc.NewPrivateAddr([]byte{5, 6, 7, 8}, 0x80, true)
+	keys = []*btc.PrivateAddr{k}
+	k.TreeState.Sign(make([]byte, 32), make([]byte, 32))
+
+	uf, _ := unconfirmed_file()
+	if len(uf.Hashes) == 0 || uf.Network != chain_params().Name {
+		t.Fatal("Bad unconfirmed file", len(uf.Hashes), uf.Network)
+	}
+	cf := &btc.UpkhFile{Kind: btc.UPKH_FILE_CONFIRMED, Network: "other", TipHeight: 10}
+	cf.Recs = []*btc.UpkhAnswer{{PubKeyHash: uf.Hashes[0], LongTermHash: k.Hash160, Depth: 3}}
+	if _, _, _, er := apply_confirms(cf.Text()); er == nil {
+		t.Error("Applied confirmations of another network")
+	}
+	if _, _, _, er := apply_confirms(cf.Text()[:len(cf.Text())/2]); er == nil {
+		t.Error("Applied a truncated file")
+	}
+
+	cf.Network = chain_params().Name
+	res, applied, _, er := apply_confirms(cf.Text())
+	if er != nil || applied != 1 || res.TipHeight != 10 {
+		t.Fatal("apply_confirms:", applied, er)
+	}
+	// still in the file, as a confirmed node to re-check
+	if f, recheck := unconfirmed_file(); len(f.Hashes) != len(uf.Hashes) || recheck != 1 {
+		t.Error("The node did not get confirmed")
+	}
+}
+
+func TestReorgRevoke(t *testing.T) {
+	defer func(lt bool, ks []*btc.PrivateAddr) { longterm, keys = lt, ks }(longterm, keys)
+	longterm = true
+	k := btc.NewPrivateAddr([]byte{9, 10, 11, 12}, 0x80, true)
+	keys = []*btc.PrivateAddr{k}
+	k.TreeState.Sign(make([]byte, 32), bytes.Repeat([]byte{1}, 32))
+
+	uf, recheck := unconfirmed_file()
+	if len(uf.Hashes) != xnyss.Branches || recheck != 0 {
+		t.Fatal("Bad unconfirmed file", len(uf.Hashes), recheck)
+	}
+	cf := &btc.UpkhFile{Kind: btc.UPKH_FILE_CONFIRMED, Network: chain_params().Name, TipHeight: 10}
+	for _, h := range uf.Hashes {
+		r := &btc.UpkhAnswer{PubKeyHash: h, LongTermHash: k.Hash160, Depth: 3}
+		r.BlockHash.Hash[0] = 0xaa
+		cf.Recs = append(cf.Recs, r)
+	}
+	if _, applied, revoked, er := apply_confirms(cf.Text()); er != nil || applied != len(uf.Hashes) || revoked != 0 {
+		t.Fatal("apply_confirms:", applied, revoked, er)
+	}
+
+	// the confirmed nodes go to unconfirmed.txt again, to be re-checked
+	if uf2, recheck := unconfirmed_file(); len(uf2.Hashes) != len(uf.Hashes) || recheck != len(uf.Hashes) {
+		t.Fatal("Confirmed nodes not re-checked", len(uf2.Hashes), recheck)
+	}
+
+	// after a reorg, the first node's tx is gone and the second one's got into another block
+	cf.TipHeight = 11
+	cf.Recs[0] = &btc.UpkhAnswer{PubKeyHash: cf.Recs[0].PubKeyHash}
+	cf.Recs[1].BlockHash.Hash[0] = 0xbb
+	_, applied, revoked, er := apply_confirms(cf.Text())
+	if er != nil || applied != len(uf.Hashes) || revoked != 1 {
+		t.Fatal("apply_confirms after a reorg:", applied, revoked, er)
+	}
+	if k.TreeState.Available(nil) != len(uf.Hashes)-1 {
+		t.Error("The orphaned node is still available")
+	}
+	pkhs, blocks := k.TreeState.Confirmed()
+	for i := range pkhs {
+		if bytes.Equal(pkhs[i], uf.Hashes[1][:]) && blocks[i][0] != 0xbb {
+			t.Error("The block of the re-confirmed node not updated")
+		}
+	}
+	if f, _ := unconfirmed_file(); f.Hashes[0] != uf.Hashes[0] {
+		t.Error("The revoked node is not unconfirmed")
+	}
+}
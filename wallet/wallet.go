@@ -5,13 +5,15 @@ import (
 	"fmt"
 	"bufio"
 	"bytes"
+	"errors"
 	"strings"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"github.com/lentus/wotscoin/lib/btc"
 	"github.com/lentus/wotscoin/lib/others/sys"
 	"io/ioutil"
 	"github.com/lentus/wotscoin/lib/xnyss"
-	"encoding/binary"
 )
 
 var (
@@ -22,6 +24,8 @@ var (
 	segwit      []*btc.BtcAddr
 	curFee      uint64
 	msAddresses []*btc.MultiSig
+
+	savedStates = make(map[string][32]byte) // hashes of the key states as they are on the disk
 )
 
 // load private keys fo .others file
@@ -116,7 +120,7 @@ func make_wallet() {
 		}
 	} else if waltype == 4 {
 		lab = "TypHD"
-		hdwal = btc.MasterKey(pass, testnet)
+		hdwal = btc.MasterKey(pass, chain_params().Testnet)
 		sys.ClearBuffer(pass)
 	} else {
 		sys.ClearBuffer(pass)
@@ -171,11 +175,12 @@ func make_wallet() {
 		}
 		if state != nil {
 			// Make sure that if we are loading existing state, the address mode
-			// matches the runtime address mode.
-			if state[0] == 0x01 && longterm {
+			// matches the runtime address mode (bit 0 of the first byte, the
+			// other bits tell the format of the nodes).
+			if state[0]&0x01 == 0x01 && longterm {
 				fmt.Println("Error: Trying to load one-time keys in long-term address mode")
 				return
-			} else if state[0] == 0x00 && !longterm {
+			} else if state[0]&0x01 == 0x00 && !longterm {
 				fmt.Println("Error: Trying to load long-term keys in one-time address mode")
 				return
 			}
@@ -185,6 +190,7 @@ func make_wallet() {
 				fmt.Println("Error: Failed to load state for address", rec.BtcAddr.String(), "-", err)
 				continue
 			}
+			savedStates[rec.StateFn] = sha256.Sum256(state)
 		}
 
 		rec.BtcAddr.Extra.Label = fmt.Sprint(lab, " ", (i+mskeycnt)/mskeycnt)
@@ -207,10 +213,10 @@ func make_wallet() {
 			continue
 		}
 		if *bech32_mode {
-			segwit[i] = btc.NewAddrFromPkScript(append([]byte{0, 20}, pk.Hash160[:]...), testnet)
+			segwit[i] = chain_params().NewAddrFromPkScript(append([]byte{0, 20}, pk.Hash160[:]...))
 		} else {
 			h160 := btc.Rimp160AfterSha256(append([]byte{0, 20}, pk.Hash160[:]...))
-			segwit[i] = btc.NewAddrFromHash160(h160[:], btc.AddrVerScript(testnet))
+			segwit[i] = btc.NewAddrFromHash160(h160[:], ver_script())
 		}
 	}
 }
@@ -250,7 +256,7 @@ func dump_addrs() {
 			}
 		}
 
-		pubaddr = msAddresses[i].BtcAddr(testnet).String()
+		pubaddr = msAddresses[i].AddrVer(ver_script()).String()
 		fmt.Println(pubaddr, keys[i*int(mskeycnt)].BtcAddr.Extra.Label)
 		if f != nil {
 			fmt.Fprintln(f, pubaddr, keys[i*int(mskeycnt)].BtcAddr.Extra.Label)
@@ -262,165 +268,316 @@ func dump_addrs() {
 	}
 }
 
-func printKeyState() {
-	fmt.Println("Printing key state for all address")
+type addrKeyState struct {
+	Addr        string `json:"addr"`
+	Available   int    `json:"available"`   // signatures that can be made now
+	Unconfirmed int    `json:"unconfirmed"` // nodes waiting for confirmations
+}
 
-	var unconfirmed, available, msIdx int
+// the state of the keys of each address
+func key_states() (res []*addrKeyState) {
+	var rec *addrKeyState
 	for i := range keys {
-		unconfirmed += len(keys[i].TreeState.Unconfirmed())
-		available += keys[i].TreeState.Available(nil)
+		if i%int(mskeycnt) == 0 {
+			rec = &addrKeyState{Addr: msAddresses[i/int(mskeycnt)].AddrVer(ver_script()).String()}
+			res = append(res, rec)
+		}
+		rec.Unconfirmed += len(keys[i].TreeState.Unconfirmed())
+		rec.Available += keys[i].TreeState.Available(nil)
+	}
+	return
+}
 
-		if (i+1)%int(mskeycnt) == 0 {
-			if longterm {
-				fmt.Printf("\n%s    %d sigs available (%d unconfirmed)",
-					msAddresses[msIdx].BtcAddr(testnet).String(), available, unconfirmed)
-			} else {
-				var backups int
-				var status string
-				if available == int(mskeycnt) {
-					backups = available - 1
-					status = "AVAILABLE"
-				} else {
-					backups = available
-					status = "USED"
-				}
+func printKeyState() {
+	fmt.Println("Printing key state for all address")
 
-				fmt.Printf("\n%s    %s (%d backups left)",
-					msAddresses[msIdx].BtcAddr(testnet).String(), status, backups)
+	for _, ks := range key_states() {
+		if longterm {
+			fmt.Printf("\n%s    %d sigs available (%d unconfirmed)", ks.Addr, ks.Available, ks.Unconfirmed)
+		} else {
+			var backups int
+			var status string
+			if ks.Available == int(mskeycnt) {
+				backups = ks.Available - 1
+				status = "AVAILABLE"
+			} else {
+				backups = ks.Available
+				status = "USED"
 			}
 
-			unconfirmed = 0
-			available = 0
-			msIdx++
+			fmt.Printf("\n%s    %s (%d backups left)", ks.Addr, status, backups)
 		}
 	}
 	fmt.Println()
 
 	if longterm {
 		fmt.Println("\nNote that you can sign multiple inputs in one transaction with just 1 signature slot")
+		for _, k := range keys {
+			if id, count := k.TreeState.Shard(); count > 0 {
+				fmt.Println("This key state is shard", id, "of", count, "(see -shard)")
+				break
+			}
+		}
+	}
+	if lst, _ := load_pending(); len(lst) > 0 {
+		fmt.Println(len(lst), "signed transaction(s) have not been seen mined yet (see -pending)")
 	}
 }
 
-func write_unconfirmed() {
-	f, err := os.Create("unconfirmed.txt")
-	if err != nil {
-		fmt.Println("Failed to create file unconfirmed.txt -", err)
-		return
+// a confirmed node of our keys, whose confirmation can be taken back by the chain
+type confirmedNode struct {
+	key   *btc.PrivateAddr
+	block []byte // the block that advertised the node (nil if not known)
+}
+
+// the confirmed nodes that were advertised by our transactions
+func confirmed_nodes() (pkhs [][32]byte, nodes map[[32]byte]*confirmedNode) {
+	nodes = make(map[[32]byte]*confirmedNode)
+	for _, key := range keys {
+		hashes, blocks := key.TreeState.Confirmed()
+		for i := range hashes {
+			var h [32]byte
+			copy(h[:], hashes[i])
+			pkhs = append(pkhs, h)
+			nodes[h] = &confirmedNode{key: key, block: blocks[i]}
+		}
 	}
-	defer f.Close()
+	return
+}
 
-	var ctr uint32
-	buf := new(bytes.Buffer)
+// the nodes to ask the client about: the unconfirmed ones, followed by the
+// confirmed ones (to re-check them, in case of a chain reorganisation)
+func nodes_to_check() (pkhs [][32]byte, confirmed map[[32]byte]*confirmedNode) {
 	for _, key := range keys {
 		for _, pkh := range key.TreeState.Unconfirmed() {
-			buf.Write(pkh)
-			ctr++
+			var h [32]byte
+			copy(h[:], pkh)
+			pkhs = append(pkhs, h)
 		}
 	}
+	conf, confirmed := confirmed_nodes()
+	pkhs = append(pkhs, conf...)
+	return
+}
 
-	binary.Write(f, binary.LittleEndian, ctr)
-	f.Write(buf.Bytes())
+// the content of unconfirmed.txt, and how many of its nodes are confirmed ones to re-check
+func unconfirmed_file() (f *btc.UpkhFile, recheck int) {
+	f = &btc.UpkhFile{Kind: btc.UPKH_FILE_UNCONFIRMED, Network: chain_params().Name}
+	var confirmed map[[32]byte]*confirmedNode
+	f.Hashes, confirmed = nodes_to_check()
+	recheck = len(confirmed)
+	return
+}
+
+func write_unconfirmed() {
+	f, recheck := unconfirmed_file()
+	if err := ioutil.WriteFile("unconfirmed.txt", f.Text(), 0600); err != nil {
+		fmt.Println("Failed to create file unconfirmed.txt -", err)
+		return
+	}
 
 	fmt.Println()
-	fmt.Println("Wrote", ctr, "unconfirmed pubkey hashes to unconfirmed.txt")
-	fmt.Println("Transfer it to a wotscoin client and use the 'confirm' command to create a confirm.txt file.")
-	fmt.Println("Transfer confirm.txt back to this wallet, and use the 'confirm' flag to apply it.")
+	fmt.Println("Wrote", len(f.Hashes)-recheck, "unconfirmed pubkey hashes to unconfirmed.txt")
+	if recheck > 0 {
+		fmt.Println("Also", recheck, "confirmed ones, to revoke them if their transactions got orphaned.")
+	}
+	fmt.Println("Transfer it to a wotscoin client and use the 'confirm' command to create a confirmed.txt file.")
+	fmt.Println("Each of its", len(f.Frames(btc.UPKH_FRAME_SIZE)), "line(s) can also be shown as a QR code, in any order.")
+	fmt.Println("Alternatively, the upkhquery tool can fetch the confirmations from a node over the network.")
+	fmt.Println("Transfer confirmed.txt back to this wallet, and use the 'confirm' flag to apply it.")
 }
 
-func confirm() {
-	f, err := os.Open(*confirmPkhs)
-	if err != nil {
-		fmt.Println("Error: failed to open confirmation file ", *confirmPkhs, " -", err)
+// applies the confirmations from the content of confirmed.txt
+func apply_confirms(d []byte) (cf *btc.UpkhFile, applied, revoked int, err error) {
+	if cf, err = btc.ReadUpkhFile(d, btc.UPKH_FILE_CONFIRMED); err != nil {
 		return
 	}
-	defer f.Close()
-
-	var amount uint32
-	rd := bufio.NewReader(f)
-	err = binary.Read(rd, binary.LittleEndian, &amount)
-	if err != nil {
-		fmt.Println("Error: failed to read amount of confirmation entries -", err)
+	if cf.Network != chain_params().Name {
+		err = errors.New("the confirmations are for " + cf.Network + " network, not for " + chain_params().Name)
 		return
 	}
 
-	lth := make([]byte, 20)
-	pkh := make([]byte, 32)
-	successCount := 0
-	fmt.Println("Processing confirmations...")
-	for i := uint32(0); i < amount; i++ {
-		_, err := rd.Read(lth)
-		if err != nil {
-			fmt.Println("Error: failed to read pubkey hash -", err)
-			return
+	_, confirmed := confirmed_nodes()
+	for _, r := range cf.Recs {
+		ok, rev := apply_answer(r, confirmed)
+		if ok {
+			applied++
 		}
-
-		_, err = rd.Read(pkh)
-		if err != nil {
-			fmt.Println("Error: failed to read pubkey hash -", err)
-			return
+		if rev {
+			revoked++
 		}
+	}
+	if _, er := check_pending(cf.Recs, cf.TipHeight); er != nil {
+		fmt.Println("WARNING:", er.Error())
+	}
+	return
+}
 
-		var confirms uint32
-		err = binary.Read(rd, binary.LittleEndian, &confirms)
-		if err != nil {
-			fmt.Println("Error: failed to read confirmation count -", err)
-			return
+// Applies the client's answer about one of our nodes. A confirmed node that the
+// client does not know anymore (zero depth), or knows from another block, had
+// its advertising transaction reorganised out of the best chain, so it loses
+// its confirmation (until the transaction gets deep enough in the new chain).
+// Returns whether the answer was applied and whether the node got revoked.
+func apply_answer(r *btc.UpkhAnswer, confirmed map[[32]byte]*confirmedNode) (applied, revoked bool) {
+	var block []byte
+	if r.BlockHash.Hash != ([32]byte{}) {
+		block = r.BlockHash.Hash[:]
+	}
+	cn := confirmed[r.PubKeyHash]
+
+	if r.Depth == 0 {
+		if cn == nil {
+			return // still not mined
 		}
+		cn.key.TreeState.ConfirmIn(r.PubKeyHash[:], 0, nil)
+		fmt.Println("WARNING: Revoked the confirmation of node", hex.EncodeToString(r.PubKeyHash[:]),
+			"- its transaction is not in the best chain anymore")
+		return true, true
+	}
 
-		key := public_to_key(lth)
-		if key == nil {
-			fmt.Println("No key state found for long-term hash ", hex.EncodeToString(lth))
-			continue
-		}
+	if cn != nil && block == nil {
+		return true, false // answered without the block (e.g. by upkhquery), so nothing to re-check
+	}
+	if !apply_confirm(r.LongTermHash[:], r.PubKeyHash[:], r.Depth, block) {
+		return
+	}
+	if cn != nil && cn.block != nil && !bytes.Equal(cn.block, block) {
+		fmt.Println("Node", hex.EncodeToString(r.PubKeyHash[:]), "got re-confirmed in block", r.BlockHash.String())
+		revoked = r.Depth < uint32(xnyss.ConfirmsRequired)
+	}
+	return true, revoked
+}
 
-		successCount++
-		if confirms > uint32(xnyss.ConfirmsRequired) {
-			key.TreeState.Confirm(pkh, xnyss.ConfirmsRequired)
-		} else {
-			key.TreeState.Confirm(pkh, uint8(confirms))
-		}
+// Sets the confirmations of the node pkh of the key lth, with the block they
+// depend on (if known). Returns false if we do not have the key.
+func apply_confirm(lth, pkh []byte, confirms uint32, block []byte) bool {
+	key := public_to_key(lth)
+	if key == nil {
+		fmt.Println("No key state found for long-term hash ", hex.EncodeToString(lth))
+		return false
 	}
 
-	fmt.Println()
-	fmt.Println("Processed", amount, "confirmations,", successCount, "successfull")
+	if confirms > uint32(xnyss.ConfirmsRequired) {
+		confirms = uint32(xnyss.ConfirmsRequired)
+	}
+	if block != nil {
+		key.TreeState.ConfirmIn(pkh, uint8(confirms), block)
+	} else {
+		key.TreeState.Confirm(pkh, uint8(confirms))
+	}
+	return true
 }
 
-func make_backup() {
-	if !longterm {
-		fmt.Println("Backing up keys is only applicable to long-term addresses")
+func confirm() {
+	d, err := ioutil.ReadFile(*confirmPkhs)
+	if err != nil {
+		fmt.Println("Error: failed to open confirmation file ", *confirmPkhs, " -", err)
 		return
 	}
 
-	if _, err := os.Stat(BackupDirectory); err == nil || !os.IsNotExist(err) {
-		fmt.Println("You have another backup in the ", BackupDirectory, " folder.")
-		fmt.Println("Move it to a safe location, then try again")
+	fmt.Println("Processing confirmations...")
+	cf, successCount, revoked, err := apply_confirms(d)
+	if err != nil {
+		fmt.Println("Error:", err)
 		return
 	}
 
-	if err := os.Mkdir(BackupDirectory, os.ModePerm); err != nil {
-		fmt.Println("Failed to create backup directory -", err)
-		return
+	fmt.Println()
+	fmt.Println("Confirmations made at block", cf.TipHeight, cf.TipHash.String())
+	fmt.Println("Processed", len(cf.Recs), "confirmations,", successCount, "successfull")
+	if revoked > 0 {
+		fmt.Println("WARNING:", revoked, "node(s) lost their confirmations after a chain reorganisation")
 	}
+}
 
-	const minNodes = 5    // Must have created at least two signatures with a chain
-	const backupCount = 2 // Take this many nodes from the original chain
+const (
+	backupMinNodes = 5 // Must have created at least two signatures with a chain
+	backupCount    = 2 // Take this many nodes from the original chain
+)
 
-	var addrCount, totalCount, msIdx int
-	fmt.Println("Amount of available signatures per address to include in backup:")
+// the number of nodes that a backup would take from each address
+func backup_counts() (counts []int, totalCount int) {
+	var addrCount int
 	for i := range keys {
-		if keys[i].TreeState.Available(nil) >= minNodes {
+		if keys[i].TreeState.Available(nil) >= backupMinNodes {
 			addrCount += backupCount
 			totalCount += backupCount
 		}
 
 		if (i+1)%int(mskeycnt) == 0 {
-			fmt.Println(msAddresses[msIdx].BtcAddr(testnet).String(), ":", addrCount)
-
+			counts = append(counts, addrCount)
 			addrCount = 0
-			msIdx++
+		}
+	}
+	return
+}
+
+func backup_check() error {
+	if !longterm {
+		return errors.New("Backing up keys is only applicable to long-term addresses")
+	}
+	if _, err := os.Stat(BackupDirectory); err == nil || !os.IsNotExist(err) {
+		return errors.New("You have another backup in the " + BackupDirectory + " folder. Move it to a safe location, then try again")
+	}
+	return nil
+}
+
+// Moves the nodes to the backup folder. The live state gets saved first, so
+// a failure in between may lose some nodes, but never leaves them in both.
+func write_backup() error {
+	if err := backup_check(); err != nil {
+		return err
+	}
+	if err := os.Mkdir(BackupDirectory, os.ModePerm); err != nil {
+		return errors.New("Failed to create backup directory - " + err.Error())
+	}
+
+	backups := make([]*xnyss.NYTree, len(keys))
+	for i := range keys {
+		if keys[i].TreeState.Available(nil) >= backupMinNodes {
+			backups[i], _ = keys[i].TreeState.Backup(backupCount)
+		} else {
+			backups[i], _ = keys[i].TreeState.Backup(backupMinNodes)
+		}
+	}
+	if err := save_states(); err != nil {
+		return err
+	}
+
+	for i := range keys {
+		err := ioutil.WriteFile(BackupDirectory+"/"+keys[i].StateFn,
+			backups[i].Bytes(), 0666)
+		if err != nil {
+			fmt.Println("Error: Failed to write backup state to file for key", i, ",", err)
 		}
 	}
 
+	// the generation of the backup, so it can be audited and retired (see backup.go)
+	info, err := record_backup(backups)
+	if err != nil {
+		fmt.Println("Error: Failed to record the backup generation -", err)
+	}
+	if info != nil {
+		d, _ := json.MarshalIndent(info, "", "\t")
+		if err = ioutil.WriteFile(BackupDirectory+"/"+BackupInfoFile, d, 0666); err != nil {
+			fmt.Println("Error: Failed to write", BackupInfoFile, "-", err)
+		}
+	}
+	return nil
+}
+
+func make_backup() {
+	if err := backup_check(); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("Amount of available signatures per address to include in backup:")
+	counts, totalCount := backup_counts()
+	for i := range counts {
+		fmt.Println(msAddresses[i].AddrVer(ver_script()).String(), ":", counts[i])
+	}
+
 	if totalCount == 0 {
 		fmt.Println()
 		fmt.Println("No nodes to backup. Wait for more signatures to be confirmed.")
@@ -434,19 +591,9 @@ func make_backup() {
 		return
 	}
 
-	for i := range keys {
-		var backupTree *xnyss.NYTree
-		if keys[i].TreeState.Available(nil) >= minNodes {
-			backupTree, _ = keys[i].TreeState.Backup(backupCount)
-		} else {
-			backupTree, _ = keys[i].TreeState.Backup(minNodes)
-		}
-
-		err := ioutil.WriteFile(BackupDirectory+"/"+keys[i].StateFn,
-			backupTree.Bytes(), 0666)
-		if err != nil {
-			fmt.Println("Error: Failed to write backup state to file for key", i, ",", err)
-		}
+	if err := write_backup(); err != nil {
+		fmt.Println(err)
+		return
 	}
 
 	fmt.Println()
@@ -455,6 +602,45 @@ func make_backup() {
 	fmt.Println("When using it as key state for a different device, remember to use the same password!")
 }
 
+// Writes the tree state of the key, making sure that it is on the disk
+// before returning (a state that goes back could sign with a used node).
+func save_state(k *btc.PrivateAddr) error {
+	return write_synced(StateDirectory+"/"+k.StateFn, k.TreeState.Bytes())
+}
+
+// replaces the file with the data, which is on the disk when it returns
+func write_synced(fn string, data []byte) error {
+	f, err := os.Create(fn + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(fn + ".tmp")
+		return err
+	}
+	return os.Rename(fn+".tmp", fn)
+}
+
+// Saves the states of all the keys that have changed since they were saved the last time
+func save_states() error {
+	for _, k := range keys {
+		b := k.TreeState.Bytes()
+		h := sha256.Sum256(b)
+		if savedStates[k.StateFn] == h {
+			continue
+		}
+		if err := save_state(k); err != nil {
+			return errors.New("Failed to write key state to file for " + k.BtcAddr.String() + " - " + err.Error())
+		}
+		savedStates[k.StateFn] = h
+	}
+	return nil
+}
+
 func public_to_key(pubkey []byte) *btc.PrivateAddr {
 	for i := range keys {
 		if bytes.Equal(pubkey, keys[i].BtcAddr.Hash160[:]) {